@@ -1,9 +1,20 @@
 package main
 
 import (
+	"log"
+	"os"
+
 	"github.com/sk000f/metrix/pkg/metrix"
 )
 
 func main() {
-	metrix.Start()
+
+	if len(os.Args) > 1 && os.Args[1] == "tokens" {
+		if err := metrix.Tokens(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	log.Fatal(metrix.Serve(os.Args[1:]))
 }
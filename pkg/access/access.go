@@ -0,0 +1,236 @@
+package access
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/sync/singleflight"
+)
+
+// ProjectsTTL is how long the projects a user can access are cached before
+// they are looked up again
+const ProjectsTTL = 10 * time.Minute
+
+// SessionTTL is how long a user stays logged in
+const SessionTTL = 24 * time.Hour
+
+// ErrInvalidSession is returned for sessions which do not exist or have expired
+var ErrInvalidSession = errors.New("session is invalid or has expired")
+
+// Service provides functionality for logging users in with their CI server
+// account and finding the projects they can access
+type Service struct {
+	r Repository
+	p Projects
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+	projects map[int]*projects
+	lookups  singleflight.Group
+}
+
+// Repository looks up users and the projects they are a member of on the CI
+// server with the user's own access token
+type Repository interface {
+	CurrentUser(token string) (*User, error)
+	MemberProjectIDs(token string) ([]int, error)
+}
+
+// Projects looks up the stored projects every logged in user can see, which
+// are the public and internal projects
+type Projects interface {
+	PublicProjectIDs() ([]int, error)
+}
+
+// User represents a user of the CI server
+type User struct {
+	ID       int
+	Username string
+	Name     string
+}
+
+// Session represents a logged in user
+type Session struct {
+	ID        string
+	User      *User
+	CreatedAt time.Time
+	ExpiresAt time.Time
+
+	// tokens refreshes the user's CI server access token when it expires
+	tokens oauth2.TokenSource
+}
+
+// projects are the cached project IDs a user can access
+type projects struct {
+	ids       []int
+	expiresAt time.Time
+}
+
+// NewService creates an access service with required dependencies
+func NewService(r Repository, p Projects) *Service {
+	return &Service{
+		r:        r,
+		p:        p,
+		sessions: map[string]*Session{},
+		projects: map[int]*projects{},
+	}
+}
+
+// Login starts a session for the user the CI server access tokens belong to.
+// Sessions end when the access token expires if it cannot be refreshed.
+func (s *Service) Login(ts oauth2.TokenSource) (*Session, error) {
+
+	t, err := ts.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := s.r.CurrentUser(t.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	ss := &Session{
+		ID:        hex.EncodeToString(b),
+		User:      u,
+		CreatedAt: now,
+		ExpiresAt: now.Add(SessionTTL),
+		tokens:    ts,
+	}
+
+	if t.RefreshToken == "" && !t.Expiry.IsZero() && t.Expiry.Before(ss.ExpiresAt) {
+		ss.ExpiresAt = t.Expiry
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// drop expired sessions so they do not build up
+	for id, old := range s.sessions {
+		if now.After(old.ExpiresAt) {
+			delete(s.sessions, id)
+		}
+	}
+
+	s.sessions[ss.ID] = ss
+
+	// a new login picks up projects the user was added to since the last one
+	delete(s.projects, u.ID)
+
+	return ss, nil
+}
+
+// Session returns the session with the ID
+func (s *Service) Session(id string) (*Session, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ss, ok := s.sessions[id]
+	if !ok {
+		return nil, ErrInvalidSession
+	}
+
+	if time.Now().After(ss.ExpiresAt) {
+		delete(s.sessions, id)
+		return nil, ErrInvalidSession
+	}
+
+	return ss, nil
+}
+
+// Logout ends the session with the ID
+func (s *Service) Logout(id string) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, id)
+}
+
+// ProjectIDs returns the GitLab project IDs the user of the session can
+// access, cached for each user for ProjectsTTL. Concurrent requests from a
+// user share a single lookup.
+func (s *Service) ProjectIDs(ss *Session) ([]int, error) {
+
+	s.mu.Lock()
+	p, ok := s.projects[ss.User.ID]
+	s.mu.Unlock()
+
+	if ok && time.Now().Before(p.expiresAt) {
+		return p.ids, nil
+	}
+
+	ids, err, _ := s.lookups.Do(strconv.Itoa(ss.User.ID), func() (interface{}, error) {
+		return s.lookup(ss)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ids.([]int), nil
+}
+
+// lookup finds the projects the user is a member of and the projects every
+// user can see, and caches them for the user
+func (s *Service) lookup(ss *Session) ([]int, error) {
+
+	t, err := ss.tokens.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	member, err := s.r.MemberProjectIDs(t.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	public, err := s.p.PublicProjectIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[int]bool{}
+	ids := []int{}
+	for _, id := range append(member, public...) {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+
+	s.mu.Lock()
+	s.projects[ss.User.ID] = &projects{ids: ids, expiresAt: time.Now().Add(ProjectsTTL)}
+	s.mu.Unlock()
+
+	return ids, nil
+}
+
+type projectsKey struct{}
+
+// NewContext returns a context restricted to the projects with the GitLab
+// project IDs
+func NewContext(ctx context.Context, ids []int) context.Context {
+	return context.WithValue(ctx, projectsKey{}, ids)
+}
+
+// FromContext returns the GitLab project IDs the context is restricted to,
+// and false if it can access every project
+func FromContext(ctx context.Context) ([]int, bool) {
+	ids, ok := ctx.Value(projectsKey{}).([]int)
+	return ids, ok
+}
@@ -0,0 +1,266 @@
+package access_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/sk000f/metrix/pkg/access"
+)
+
+func TestAccess(t *testing.T) {
+
+	t.Run("log users in and out", func(t *testing.T) {
+
+		s := access.NewService(newMockRepo(), newMockRepo())
+
+		ss, err := s.Login(token("alice-token"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ss.User.Username != "alice" || ss.ID == "" || !ss.ExpiresAt.After(ss.CreatedAt) {
+			t.Errorf("got %+v; wanted a session for alice", ss)
+		}
+
+		got, err := s.Session(ss.ID)
+		if err != nil || got != ss {
+			t.Errorf("got %+v, %v; wanted the session", got, err)
+		}
+
+		s.Logout(ss.ID)
+
+		if _, err := s.Session(ss.ID); err != access.ErrInvalidSession {
+			t.Errorf("got %v; wanted %v", err, access.ErrInvalidSession)
+		}
+	})
+
+	t.Run("end sessions with access tokens which cannot be refreshed", func(t *testing.T) {
+
+		s := access.NewService(newMockRepo(), newMockRepo())
+
+		expiry := time.Now().Add(2 * time.Hour)
+		ss, err := s.Login(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "alice-token", Expiry: expiry}))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !ss.ExpiresAt.Equal(expiry) {
+			t.Errorf("got session expiring at %v; wanted %v", ss.ExpiresAt, expiry)
+		}
+
+		ss, err = s.Login(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "alice-token", RefreshToken: "refresh", Expiry: expiry}))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !ss.ExpiresAt.After(expiry) {
+			t.Errorf("got session expiring at %v; wanted it to outlive the refreshable token", ss.ExpiresAt)
+		}
+	})
+
+	t.Run("look projects up with the refreshed access token", func(t *testing.T) {
+
+		r := newMockRepo()
+		r.projects["refreshed-token"] = []int{5}
+		s := access.NewService(r, r)
+
+		ts := &refreshingTokens{tokens: []string{"alice-token", "refreshed-token"}}
+		ss, err := s.Login(ts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := s.ProjectIDs(ss)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(got, []int{5}) {
+			t.Errorf("got %v; wanted the projects of the refreshed token [5]", got)
+		}
+	})
+
+	t.Run("reject unknown users", func(t *testing.T) {
+
+		s := access.NewService(newMockRepo(), newMockRepo())
+
+		if _, err := s.Login(token("unknown")); err == nil {
+			t.Errorf("got no error; wanted an error for an unknown token")
+		}
+
+		if _, err := s.Session("unknown"); err != access.ErrInvalidSession {
+			t.Errorf("got %v; wanted %v", err, access.ErrInvalidSession)
+		}
+	})
+
+	t.Run("cache the projects each user can access", func(t *testing.T) {
+
+		r := newMockRepo()
+		s := access.NewService(r, r)
+
+		ss, err := s.Login(token("alice-token"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 2; i++ {
+			got, err := s.ProjectIDs(ss)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, []int{1, 2}) {
+				t.Errorf("got %v; wanted [1 2]", got)
+			}
+		}
+
+		if r.lookups != 1 {
+			t.Errorf("got %v lookups; wanted 1", r.lookups)
+		}
+
+		// logging in again looks the projects up again
+		r.projects["alice-token"] = []int{1, 2, 3}
+
+		ss, err = s.Login(token("alice-token"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := s.ProjectIDs(ss)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(got, []int{1, 2, 3}) || r.lookups != 2 {
+			t.Errorf("got %v after %v lookups; wanted [1 2 3] after 2", got, r.lookups)
+		}
+	})
+
+	t.Run("add the projects every user can see", func(t *testing.T) {
+
+		r := newMockRepo()
+		r.public = []int{2, 7}
+		s := access.NewService(r, r)
+
+		ss, err := s.Login(token("alice-token"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := s.ProjectIDs(ss)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(got, []int{1, 2, 7}) {
+			t.Errorf("got %v; wanted [1 2 7]", got)
+		}
+	})
+
+	t.Run("share a lookup between concurrent requests", func(t *testing.T) {
+
+		r := newMockRepo()
+		r.delay = 50 * time.Millisecond
+		s := access.NewService(r, r)
+
+		ss, err := s.Login(token("alice-token"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := s.ProjectIDs(ss); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if got := r.lookupCount(); got != 1 {
+			t.Errorf("got %v lookups; wanted 1", got)
+		}
+	})
+
+	t.Run("restrict contexts to projects", func(t *testing.T) {
+
+		if _, ok := access.FromContext(context.Background()); ok {
+			t.Errorf("got a restricted context; wanted every project")
+		}
+
+		ids, ok := access.FromContext(access.NewContext(context.Background(), []int{}))
+		if !ok || len(ids) != 0 {
+			t.Errorf("got %v, %v; wanted no projects", ids, ok)
+		}
+	})
+}
+
+func token(t string) oauth2.TokenSource {
+	return oauth2.StaticTokenSource(&oauth2.Token{AccessToken: t})
+}
+
+// refreshingTokens returns the next token each time one is requested, as if
+// the previous one had expired
+type refreshingTokens struct {
+	tokens []string
+}
+
+func (r *refreshingTokens) Token() (*oauth2.Token, error) {
+	t := r.tokens[0]
+	if len(r.tokens) > 1 {
+		r.tokens = r.tokens[1:]
+	}
+	return &oauth2.Token{AccessToken: t}, nil
+}
+
+type mockRepo struct {
+	users    map[string]*access.User
+	projects map[string][]int
+	public   []int
+	delay    time.Duration
+
+	mu      sync.Mutex
+	lookups int
+}
+
+func newMockRepo() *mockRepo {
+	return &mockRepo{
+		users:    map[string]*access.User{"alice-token": {ID: 1, Username: "alice", Name: "Alice"}},
+		projects: map[string][]int{"alice-token": {1, 2}},
+	}
+}
+
+func (m *mockRepo) CurrentUser(token string) (*access.User, error) {
+	u, ok := m.users[token]
+	if !ok {
+		return nil, errors.New("401 Unauthorized")
+	}
+	return u, nil
+}
+
+func (m *mockRepo) MemberProjectIDs(token string) ([]int, error) {
+	m.mu.Lock()
+	m.lookups++
+	m.mu.Unlock()
+
+	time.Sleep(m.delay)
+	return m.projects[token], nil
+}
+
+func (m *mockRepo) PublicProjectIDs() ([]int, error) {
+	return m.public, nil
+}
+
+func (m *mockRepo) lookupCount() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lookups
+}
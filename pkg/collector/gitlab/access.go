@@ -0,0 +1,79 @@
+package gitlab
+
+import (
+	"fmt"
+
+	"github.com/sk000f/metrix/pkg/access"
+	gl "github.com/xanzy/go-gitlab"
+)
+
+// CurrentUser gets the user an OAuth access token belongs to
+func (g *GitLab) CurrentUser(token string) (*access.User, error) {
+
+	c, err := g.SetupOAuthClient(token, g.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	u, _, err := c.Users.CurrentUser()
+	if err != nil {
+		fmt.Printf("Error: %v", err.Error())
+		return nil, err
+	}
+
+	return &access.User{ID: u.ID, Username: u.Username, Name: u.Name}, nil
+}
+
+// MemberProjectIDs lists the IDs of the projects the user an OAuth access token
+// belongs to is a member of, directly or through a group. Public and internal
+// projects are not listed, as GitLab would page through every one of them.
+func (g *GitLab) MemberProjectIDs(token string) ([]int, error) {
+
+	c, err := g.SetupOAuthClient(token, g.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	opt := &gl.ListProjectsOptions{
+		ListOptions: gl.ListOptions{Page: 1, PerPage: 100},
+		Membership:  gl.Bool(true),
+		Simple:      gl.Bool(true),
+	}
+
+	ids := []int{}
+
+	for {
+
+		projects, resp, err := c.Projects.ListProjects(opt)
+		if err != nil {
+			fmt.Printf("Error: %v", err.Error())
+			return nil, err
+		}
+
+		for _, pr := range projects {
+			ids = append(ids, pr.ID)
+		}
+
+		// Exit the loop when we've seen all pages.
+		if resp.NextPage == 0 {
+			break
+		}
+
+		opt.Page = resp.NextPage
+	}
+
+	return ids, nil
+}
+
+// SetupOAuthClient returns a GitLab client with the specified base URL which
+// authenticates as a user with their OAuth access token, which GitLab does
+// not accept in place of a private token
+func (g *GitLab) SetupOAuthClient(token, baseURL string) (*gl.Client, error) {
+	client, err := gl.NewOAuthClient(token, gl.WithBaseURL(baseURL))
+	if err != nil {
+		fmt.Printf("Error: %v", err.Error())
+		return nil, err
+	}
+
+	return client, nil
+}
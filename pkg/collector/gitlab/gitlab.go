@@ -2,6 +2,8 @@ package gitlab
 
 import (
 	"fmt"
+	"sort"
+	"time"
 
 	"github.com/sk000f/metrix/pkg/collector"
 	gl "github.com/xanzy/go-gitlab"
@@ -13,8 +15,9 @@ type GitLab struct {
 	URL   string
 }
 
-// RefreshData gets latest deployment data from CI server and saves to repository
-func (g *GitLab) RefreshData(r collector.Repository) error {
+// Refresh gets the deployment data restricted by the options from CI server,
+// saves it to repository and reports progress for each project
+func (g *GitLab) Refresh(r collector.Repository, opt collector.Options, pr collector.Progress) error {
 
 	c, err := g.SetupClient(g.Token, g.URL)
 	if err != nil {
@@ -22,38 +25,143 @@ func (g *GitLab) RefreshData(r collector.Repository) error {
 		return err
 	}
 
-	p := g.UpdateProjects(c, r)
+	var p []*collector.Project
 
-	g.UpdateDeployments(p, c, r)
+	if opt.ProjectID != 0 {
+		proj, err := g.GetProject(c, opt.ProjectID)
+		if err != nil {
+			fmt.Printf("Error: %v", err.Error())
+			return err
+		}
+		p = []*collector.Project{proj}
+		if err := r.SaveProjects(p); err != nil {
+			fmt.Printf("Error: %v", err.Error())
+			return err
+		}
+	} else {
+		// the run fails when the projects cannot be listed, so it is not
+		// reported as a successful run of no projects
+		if p, err = g.UpdateProjects(c, r); err != nil {
+			return err
+		}
+	}
+
+	if pr != nil {
+		pr.ProjectsFound(len(p))
+	}
+
+	for _, proj := range p {
+		n, err := g.syncProject(proj, c, r, opt)
+		if pr != nil {
+			pr.ProjectDone(proj, n, err)
+		}
+	}
 
 	return nil
 }
 
 // UpdateProjects gets all projects from GitLab and stores them in the repository
-func (g *GitLab) UpdateProjects(c *gl.Client, r collector.Repository) []*collector.Project {
+func (g *GitLab) UpdateProjects(c *gl.Client, r collector.Repository) ([]*collector.Project, error) {
 
 	// get all projects
 	p, err := g.GetProjects(c, getProjectListOptions())
 	if err != nil {
-		fmt.Printf("Error: %v", err.Error())
+		return nil, err
 	}
 
 	// save projects to repository
-	r.SaveProjects(p)
+	if err := r.SaveProjects(p); err != nil {
+		fmt.Printf("Error: %v", err.Error())
+		return nil, err
+	}
 
-	return p
+	return p, nil
 }
 
-// UpdateDeployments gets deployments for all projects from GitLab and stores them in the repository
-func (g *GitLab) UpdateDeployments(p []*collector.Project, c *gl.Client, r collector.Repository) {
+// syncProject saves the deployments of a project updated since its sync cursor,
+// or since the options, moves the cursor on and returns how many were saved
+func (g *GitLab) syncProject(p *collector.Project, c *gl.Client, r collector.Repository, opt collector.Options) (int, error) {
 
-	// get and update deployments for projects
-	for _, proj := range p {
-		d, _ := g.GetDeployments(proj, c, getDeploymentListOptions())
-		for _, dep := range d {
-			r.SaveDeployment(dep)
+	var sc *collector.SyncCursor
+	if !opt.FullResync {
+		var err error
+		if sc, err = r.GetSyncCursor(p.ID); err != nil {
+			return 0, err
 		}
 	}
+
+	since := opt.Since
+	if since.IsZero() && sc != nil {
+		since = sc.UpdatedAt
+	}
+
+	// GitLab only filters by updated_after when ordering by updated_at
+	lo := getDeploymentListOptions()
+	if !since.IsZero() {
+		lo.UpdatedAfter = gl.Time(since)
+		lo.OrderBy = gl.String("updated_at")
+	}
+
+	// deployments updated at the cursor are listed again, so those the cursor
+	// collected are skipped
+	var collected []int
+	if sc != nil && since.Equal(sc.UpdatedAt) {
+		collected = sc.UpdatedIDs
+	}
+
+	d, updated, updatedIDs, err := g.listDeployments(p, c, lo, collected)
+	if err != nil {
+		return 0, err
+	}
+
+	// deployments whose commits could not be listed are still saved, and the
+	// project is reported as failed once they are
+	commitErr := g.addCommits(p, c, d, sc)
+
+	for i, dep := range d {
+		if err := r.SaveDeployment(dep); err != nil {
+			return i, err
+		}
+	}
+
+	// deployments updated between the cursor and a later date were skipped, so
+	// the cursor only moves on when the run continued from it
+	if !opt.Since.IsZero() && (sc == nil || opt.Since.After(sc.UpdatedAt)) {
+		return len(d), commitErr
+	}
+
+	if err := r.SaveSyncCursor(nextSyncCursor(p, sc, d, updated, updatedIDs)); err != nil {
+		return len(d), err
+	}
+
+	return len(d), commitErr
+}
+
+// nextSyncCursor returns the sync cursor of a project once the deployments
+// updated until updated have been saved, with updatedIDs updated at updated
+func nextSyncCursor(p *collector.Project, sc *collector.SyncCursor, d []*collector.Deployment, updated time.Time, updatedIDs []int) *collector.SyncCursor {
+
+	next := &collector.SyncCursor{ProjectID: p.ID}
+	if sc != nil {
+		*next = *sc
+	}
+
+	switch {
+	case updated.After(next.UpdatedAt):
+		next.UpdatedAt = updated
+		next.UpdatedIDs = updatedIDs
+	case updated.Equal(next.UpdatedAt) && len(updatedIDs) > 0:
+		next.UpdatedIDs = append(append([]int{}, next.UpdatedIDs...), updatedIDs...)
+	}
+
+	for _, dep := range d {
+		if dep.Status == "success" && dep.SHA != "" && dep.ID > next.LastSuccessID {
+			next.LastSuccessID = dep.ID
+			next.LastSuccessSHA = dep.SHA
+		}
+	}
+
+	return next
 }
 
 // GetProjects lists all projects from specified GitLab server
@@ -71,14 +179,7 @@ func (g *GitLab) GetProjects(client *gl.Client, opt *gl.ListProjectsOptions) ([]
 
 		// iterate over projects and convert to metrix representation
 		for _, pr := range projects {
-			p = append(p, &collector.Project{
-				ID:                pr.ID,
-				Name:              pr.Name,
-				Path:              pr.Path,
-				PathWithNamespace: pr.PathWithNamespace,
-				Namespace:         pr.Namespace.FullPath,
-				WebURL:            pr.WebURL,
-			})
+			p = append(p, project(pr))
 		}
 
 		// Exit the loop when we've seen all pages.
@@ -92,22 +193,89 @@ func (g *GitLab) GetProjects(client *gl.Client, opt *gl.ListProjectsOptions) ([]
 	return p, nil
 }
 
+// GetProject gets a single project from specified GitLab server
+func (g *GitLab) GetProject(client *gl.Client, id int) (*collector.Project, error) {
+
+	pr, _, err := client.Projects.GetProject(id, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return project(pr), nil
+}
+
+func project(pr *gl.Project) *collector.Project {
+
+	p := &collector.Project{
+		ID:                pr.ID,
+		Name:              pr.Name,
+		Path:              pr.Path,
+		PathWithNamespace: pr.PathWithNamespace,
+		WebURL:            pr.WebURL,
+		Visibility:        string(pr.Visibility),
+	}
+	if pr.Namespace != nil {
+		p.Namespace = pr.Namespace.FullPath
+	}
+
+	return p
+}
+
 // GetDeployments lists all Deployments for the specified Project
 func (g *GitLab) GetDeployments(p *collector.Project, client *gl.Client, opt *gl.ListProjectDeploymentsOptions) ([]*collector.Deployment, error) {
 
+	d, _, _, err := g.listDeployments(p, client, opt, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return d, g.AddCommits(p, client, d)
+}
+
+// listDeployments lists the finished production deployments for the specified
+// Project, skipping the collected deployments updated at updated_after. It
+// also returns when the most recently updated deployment listed was updated,
+// and the IDs of the deployments updated then.
+func (g *GitLab) listDeployments(p *collector.Project, client *gl.Client, opt *gl.ListProjectDeploymentsOptions, collected []int) ([]*collector.Deployment, time.Time, []int, error) {
+
 	d := []*collector.Deployment{}
+	var updated time.Time
+	var updatedIDs []int
+
+	skip := map[int]bool{}
+	for _, id := range collected {
+		skip[id] = true
+	}
 
 	for {
 
 		deployments, resp, err := client.Deployments.ListProjectDeployments(p.ID, opt)
 		if err != nil {
 			fmt.Printf("Error: %v", err.Error())
-			return nil, err
+			return nil, updated, nil, err
 		}
 
 		// iterate over deployments and convert to metrix representation
 		for _, dep := range deployments {
 
+			// GitLab includes deployments updated at updated_after, which may
+			// have been saved by the previous run
+			if dep.UpdatedAt != nil && opt.UpdatedAfter != nil &&
+				(dep.UpdatedAt.Before(*opt.UpdatedAfter) || (dep.UpdatedAt.Equal(*opt.UpdatedAfter) && skip[dep.ID])) {
+				continue
+			}
+
+			// unfinished deployments are listed again once they are updated
+			if dep.UpdatedAt != nil {
+				switch {
+				case dep.UpdatedAt.After(updated):
+					updated = *dep.UpdatedAt
+					updatedIDs = []int{dep.ID}
+				case dep.UpdatedAt.Equal(updated):
+					updatedIDs = append(updatedIDs, dep.ID)
+				}
+			}
+
 			if dep.Environment.Name == "production" &&
 				(dep.Status == "success" || dep.Status == "failed") {
 
@@ -120,6 +288,7 @@ func (g *GitLab) GetDeployments(p *collector.Project, client *gl.Client, opt *gl
 					ProjectPath:      p.Path,
 					ProjectNamespace: p.Namespace,
 					PipelineID:       dep.Deployable.Pipeline.ID,
+					SHA:              dep.SHA,
 					FinishedAt:       dep.Deployable.FinishedAt,
 					Duration:         dep.Deployable.Duration,
 				})
@@ -135,7 +304,97 @@ func (g *GitLab) GetDeployments(p *collector.Project, client *gl.Client, opt *gl
 		opt.Page = resp.NextPage
 	}
 
-	return d, nil
+	return d, updated, updatedIDs, nil
+}
+
+// AddCommits records the commits each deployment introduced since the previous
+// successful production deployment of the project
+func (g *GitLab) AddCommits(p *collector.Project, client *gl.Client, d []*collector.Deployment) error {
+	return g.addCommits(p, client, d, nil)
+}
+
+// addCommits records the commits each deployment introduced, comparing the
+// first with the latest successful deployment of the sync cursor, if set.
+// Deployments created before that deployment cannot be compared with it, so
+// they are left without commits and keep the commits they were saved with,
+// as are deployments whose commits could not be listed, which are reported in
+// the error.
+func (g *GitLab) addCommits(p *collector.Project, client *gl.Client, d []*collector.Deployment, sc *collector.SyncCursor) error {
+
+	// commits are compared between deployments in the order they were created
+	sort.SliceStable(d, func(i, j int) bool {
+		return d[i].ID < d[j].ID
+	})
+
+	prev, prevID := "", 0
+	if sc != nil {
+		prev, prevID = sc.LastSuccessSHA, sc.LastSuccessID
+	}
+
+	var firstErr error
+	failed := 0
+
+	for _, dep := range d {
+		if dep.SHA == "" || dep.ID <= prevID {
+			continue
+		}
+
+		c, err := g.GetCommits(p, client, prev, dep.SHA)
+		if err != nil {
+			fmt.Printf("Error: %v", err.Error())
+			if firstErr == nil {
+				firstErr = fmt.Errorf("listing commits of deployment %v: %v", dep.ID, err)
+			}
+			failed++
+		}
+		dep.Commits = c
+
+		if dep.Status == "success" {
+			prev, prevID = dep.SHA, dep.ID
+		}
+	}
+
+	if failed > 1 {
+		return fmt.Errorf("%v, and of %v more deployments", firstErr, failed-1)
+	}
+
+	return firstErr
+}
+
+// GetCommits lists the commits between two SHAs for the specified Project.
+// When from is empty only the commit at to is returned.
+func (g *GitLab) GetCommits(p *collector.Project, client *gl.Client, from, to string) ([]*collector.Commit, error) {
+
+	commits := []*gl.Commit{}
+
+	if from == "" {
+		commit, _, err := client.Commits.GetCommit(p.ID, to)
+		if err != nil {
+			return nil, err
+		}
+		commits = append(commits, commit)
+	} else {
+		cmp, _, err := client.Repositories.Compare(p.ID, &gl.CompareOptions{
+			From: gl.String(from),
+			To:   gl.String(to),
+		})
+		if err != nil {
+			return nil, err
+		}
+		commits = cmp.Commits
+	}
+
+	// iterate over commits and convert to metrix representation
+	c := []*collector.Commit{}
+	for _, commit := range commits {
+		c = append(c, &collector.Commit{
+			SHA:         commit.ID,
+			Title:       commit.Title,
+			CommittedAt: commit.CommittedDate,
+		})
+	}
+
+	return c, nil
 }
 
 // SetupClient returns a GitLab client with the specified base URL
@@ -151,14 +410,14 @@ func (g *GitLab) SetupClient(token, baseURL string) (*gl.Client, error) {
 
 func getProjectListOptions() *gl.ListProjectsOptions {
 	return &gl.ListProjectsOptions{
-		ListOptions: gl.ListOptions{Page: 1, PerPage: 20},
+		ListOptions: gl.ListOptions{Page: 1, PerPage: 100},
 		Simple:      gl.Bool(false),
 	}
 }
 
 func getDeploymentListOptions() *gl.ListProjectDeploymentsOptions {
 	return &gl.ListProjectDeploymentsOptions{
-		ListOptions: gl.ListOptions{Page: 1, PerPage: 20},
+		ListOptions: gl.ListOptions{Page: 1, PerPage: 100},
 		Environment: gl.String("production"),
 	}
 }
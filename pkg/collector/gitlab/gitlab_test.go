@@ -1,13 +1,17 @@
 package gitlab_test
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/sk000f/metrix/pkg/access"
 	"github.com/sk000f/metrix/pkg/collector"
 	"github.com/sk000f/metrix/pkg/collector/gitlab"
 	gl "github.com/xanzy/go-gitlab"
@@ -547,11 +551,113 @@ func TestGitLabDeployments(t *testing.T) {
 		}
 	})
 
+	t.Run("record commits introduced by each deployment", func(t *testing.T) {
+		mux, server, client, g := setupMockGitLabClient(t)
+		defer teardown(server)
+
+		mux.HandleFunc("/api/v4/projects/1/deployments", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `[
+					{
+						"id": 1,
+						"sha": "aaa",
+						"status": "success",
+						"environment": {
+							"name": "production"
+						},
+						"deployable": {
+							"finished_at": "2020-10-06T15:30:53.355Z",
+							"duration": 123.45,
+							"pipeline": {
+								"id": 1
+							}
+						}
+					},
+					{
+						"id": 2,
+						"sha": "ccc",
+						"status": "success",
+						"environment": {
+							"name": "production"
+						},
+						"deployable": {
+							"finished_at": "2020-10-06T15:30:53.355Z",
+							"duration": 123.45,
+							"pipeline": {
+								"id": 2
+							}
+						}
+					}
+					]`)
+		})
+
+		mux.HandleFunc("/api/v4/projects/1/repository/commits/aaa", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{
+					"id": "aaa",
+					"title": "first",
+					"committed_date": "2020-10-06T10:00:00Z"
+				}`)
+		})
+
+		mux.HandleFunc("/api/v4/projects/1/repository/compare", func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("from") != "aaa" || r.URL.Query().Get("to") != "ccc" {
+				t.Errorf("unexpected compare query: %v", r.URL.RawQuery)
+			}
+			fmt.Fprint(w, `{
+					"commits": [
+						{
+							"id": "bbb",
+							"title": "second",
+							"committed_date": "2020-10-06T11:00:00Z"
+						},
+						{
+							"id": "ccc",
+							"title": "third",
+							"committed_date": "2020-10-06T12:00:00Z"
+						}
+					]
+				}`)
+		})
+
+		p := &collector.Project{
+			ID:                1,
+			Name:              "test",
+			Path:              "test",
+			PathWithNamespace: "test/test",
+			Namespace:         "test/test",
+			WebURL:            "http://test.com/test/test",
+		}
+
+		got, err := g.GetDeployments(p, client, getDeploymentListOptions())
+		if err != nil {
+			t.Errorf("Error getting Deployments: %v", err)
+		}
+
+		want := [][]string{{"aaa"}, {"bbb", "ccc"}}
+
+		if len(got) != len(want) {
+			t.Fatalf("got %v deployments; wanted %v", len(got), len(want))
+		}
+
+		for i, dep := range got {
+			shas := []string{}
+			for _, c := range dep.Commits {
+				shas = append(shas, c.SHA)
+			}
+			if !reflect.DeepEqual(shas, want[i]) {
+				t.Errorf("got commits %v for deployment %v; wanted %v", shas, dep.ID, want[i])
+			}
+		}
+	})
+
 	t.Run("update deployment in repository", func(t *testing.T) {
 
-		mux, server, client, g := setupMockGitLabClient(t)
+		mux, server, _, g := setupMockGitLabClient(t)
 		defer teardown(server)
 
+		mux.HandleFunc("/api/v4/projects/1", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"id": 1, "name": "test", "path": "test", "namespace": {"full_path": "test/test"}}`)
+		})
+
 		mux.HandleFunc("/api/v4/projects/1/deployments", func(w http.ResponseWriter, r *http.Request) {
 			fmt.Fprint(w, `[{
 					"id": 1,
@@ -571,16 +677,6 @@ func TestGitLabDeployments(t *testing.T) {
 
 		mockRepository := new(mockRepo)
 
-		p := []*collector.Project{
-			{
-				ID:                1,
-				Name:              "test",
-				Path:              "test",
-				PathWithNamespace: "test/test",
-				Namespace:         "test/test",
-				WebURL:            "http://test.com/test/test",
-			}}
-
 		timestamp, e := time.Parse(time.RFC3339, "2020-10-06T15:30:53.355Z")
 		if e != nil {
 			t.Errorf(e.Error())
@@ -599,7 +695,9 @@ func TestGitLabDeployments(t *testing.T) {
 			Duration:         123.45,
 		}}
 
-		g.UpdateDeployments(p, client, mockRepository)
+		if err := g.Refresh(mockRepository, collector.Options{ProjectID: 1}, nil); err != nil {
+			t.Fatalf("Unexpected error: %v", err.Error())
+		}
 
 		if !reflect.DeepEqual(mockRepository.DeploymentData, want) {
 			t.Errorf("got %+v; wanted %+v", mockRepository.DeploymentData, want)
@@ -607,20 +705,423 @@ func TestGitLabDeployments(t *testing.T) {
 	})
 }
 
+func TestGitLabAccess(t *testing.T) {
+	t.Run("get the current user with their OAuth token", func(t *testing.T) {
+		mux, server, _, g := setupMockGitLabClient(t)
+		defer teardown(server)
+
+		mux.HandleFunc("/api/v4/user", func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer user-token" {
+				http.Error(w, `{"message":"401 Unauthorized"}`, http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"id": 7, "username": "alice", "name": "Alice"}`)
+		})
+
+		got, err := g.CurrentUser("user-token")
+		if err != nil {
+			t.Fatalf("Error getting user: %v", err)
+		}
+
+		want := &access.User{ID: 7, Username: "alice", Name: "Alice"}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v; wanted %+v", got, want)
+		}
+
+		if _, err := g.CurrentUser("wrong-token"); err == nil {
+			t.Errorf("got no error; wanted an error for an invalid token")
+		}
+	})
+
+	t.Run("get every page of projects the user is a member of", func(t *testing.T) {
+		mux, server, _, g := setupMockGitLabClient(t)
+		defer teardown(server)
+
+		mux.HandleFunc("/api/v4/projects", func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer user-token" {
+				http.Error(w, `{"message":"401 Unauthorized"}`, http.StatusUnauthorized)
+				return
+			}
+
+			if r.URL.Query().Get("membership") != "true" {
+				t.Errorf("got query %v; wanted only member projects", r.URL.RawQuery)
+			}
+
+			if r.URL.Query().Get("page") == "1" {
+				w.Header().Set("X-Page", "1")
+				w.Header().Set("X-Next-Page", "2")
+				fmt.Fprint(w, `[{"id": 1}, {"id": 4}]`)
+				return
+			}
+
+			w.Header().Set("X-Page", "2")
+			fmt.Fprint(w, `[{"id": 9}]`)
+		})
+
+		got, err := g.MemberProjectIDs("user-token")
+		if err != nil {
+			t.Fatalf("Error getting project IDs: %v", err)
+		}
+
+		if want := []int{1, 4, 9}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+	})
+}
+
 func TestRefreshData(t *testing.T) {
 	t.Run("refresh data successfully", func(t *testing.T) {
 
-		_, _, _, g := setupMockGitLabClient(t)
+		mux, server, _, g := setupMockGitLabClient(t)
+		defer teardown(server)
+
+		mux.HandleFunc("/api/v4/projects", func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("per_page") != "100" {
+				t.Errorf("got query %v; wanted pages of 100 projects", r.URL.RawQuery)
+			}
+			fmt.Fprint(w, `[]`)
+		})
+
+		r := new(mockRepo)
+
+		err := g.Refresh(r, collector.Options{}, nil)
+
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+	})
+
+	t.Run("fail the run when projects cannot be listed", func(t *testing.T) {
+
+		mux, server, _, g := setupMockGitLabClient(t)
+		defer teardown(server)
+
+		mux.HandleFunc("/api/v4/projects", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, `{"message": "401 Unauthorized"}`, http.StatusUnauthorized)
+		})
+
+		pr := new(mockProgress)
+
+		if err := g.Refresh(new(mockRepo), collector.Options{}, pr); err == nil {
+			t.Errorf("got no error; wanted the run to fail")
+		}
+
+		if pr.projects != 0 || len(pr.done) != 0 {
+			t.Errorf("got %+v; wanted no projects collected", pr)
+		}
+	})
+
+	t.Run("fail a project when its deployments cannot be saved", func(t *testing.T) {
+
+		mux, server, _, g := setupMockGitLabClient(t)
+		defer teardown(server)
+
+		mux.HandleFunc("/api/v4/projects/1", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"id": 1, "name": "test", "namespace": {"full_path": "test"}}`)
+		})
+
+		mux.HandleFunc("/api/v4/projects/1/deployments", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `[{"id": 1, "status": "failed", "environment": {"name": "production"}, "deployable": {"pipeline": {"id": 1}}}]`)
+		})
+
+		r := &mockRepo{SaveErr: errors.New("connection refused")}
+		pr := new(mockProgress)
+
+		if err := g.Refresh(r, collector.Options{ProjectID: 1}, pr); err != nil {
+			t.Fatalf("Unexpected error: %v", err.Error())
+		}
+
+		if len(pr.errors) != 1 || pr.errors[0] != r.SaveErr || r.Cursors[1] != nil {
+			t.Errorf("got errors %v and cursor %+v; wanted the project failed without moving its cursor", pr.errors, r.Cursors[1])
+		}
+	})
+
+	t.Run("report a project whose commits cannot be listed", func(t *testing.T) {
+
+		mux, server, _, g := setupMockGitLabClient(t)
+		defer teardown(server)
+
+		mux.HandleFunc("/api/v4/projects/1", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"id": 1, "name": "test", "namespace": {"full_path": "test"}}`)
+		})
+
+		mux.HandleFunc("/api/v4/projects/1/deployments", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `[{"id": 1, "sha": "aaa", "status": "success", "environment": {"name": "production"}, "deployable": {"pipeline": {"id": 1}}}]`)
+		})
+
+		mux.HandleFunc("/api/v4/projects/1/repository/commits/aaa", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, `{"message": "404 Commit Not Found"}`, http.StatusNotFound)
+		})
+
+		r := new(mockRepo)
+		pr := new(mockProgress)
+
+		if err := g.Refresh(r, collector.Options{ProjectID: 1}, pr); err != nil {
+			t.Fatalf("Unexpected error: %v", err.Error())
+		}
+
+		if len(pr.errors) != 1 || !strings.Contains(pr.errors[0].Error(), "listing commits of deployment 1") {
+			t.Errorf("got errors %v; wanted the commits of deployment 1 reported", pr.errors)
+		}
+
+		if len(r.DeploymentData) != 1 || r.Cursors[1] == nil {
+			t.Errorf("got %+v; wanted the deployment saved and the cursor moved on", r)
+		}
+	})
+
+	t.Run("refresh single project since date and report progress", func(t *testing.T) {
+
+		mux, server, _, g := setupMockGitLabClient(t)
+		defer teardown(server)
+
+		mux.HandleFunc("/api/v4/projects/1", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"id": 1, "name": "test", "namespace": {"full_path": "test"}}`)
+		})
+
+		var query url.Values
+		mux.HandleFunc("/api/v4/projects/1/deployments", func(w http.ResponseWriter, r *http.Request) {
+			query = r.URL.Query()
+			fmt.Fprint(w, `[{
+					"id": 1,
+					"status": "success",
+					"environment": {"name": "production"},
+					"deployable": {"finished_at": "2020-10-06T15:30:53.355Z", "pipeline": {"id": 1}}
+				}]`)
+		})
 
 		r := new(mockRepo)
+		pr := new(mockProgress)
 
-		err := g.RefreshData(r)
+		since := time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC)
 
+		err := g.Refresh(r, collector.Options{ProjectID: 1, Since: since}, pr)
 		if err != nil {
 			t.Errorf("Unexpected error: %v", err.Error())
 		}
 
+		if query.Get("updated_after") != "2020-10-01T00:00:00Z" || query.Get("order_by") != "updated_at" {
+			t.Errorf("got query %v; wanted deployments updated after %v", query, since)
+		}
+
+		if len(r.ProjectData) != 1 || len(r.DeploymentData) != 1 {
+			t.Errorf("got %+v; wanted one project and deployment saved", r)
+		}
+
+		want := &mockProgress{projects: 1, done: []int{1}, deployments: 1}
+		if !reflect.DeepEqual(pr, want) {
+			t.Errorf("got %+v; wanted %+v", pr, want)
+		}
 	})
+
+	t.Run("only collect deployments updated since the sync cursor", func(t *testing.T) {
+
+		mux, server, _, g := setupMockGitLabClient(t)
+		defer teardown(server)
+
+		mux.HandleFunc("/api/v4/projects/1", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"id": 1, "name": "test", "namespace": {"full_path": "test"}}`)
+		})
+
+		// deployment 2 is still running on the first run and succeeds before the second
+		var query url.Values
+		mux.HandleFunc("/api/v4/projects/1/deployments", func(w http.ResponseWriter, r *http.Request) {
+			query = r.URL.Query()
+			if query.Get("updated_after") == "" {
+				fmt.Fprint(w, `[
+					{"id": 1, "sha": "aaa", "status": "success", "updated_at": "2020-10-06T16:00:00Z", "environment": {"name": "production"}, "deployable": {"pipeline": {"id": 1}}},
+					{"id": 2, "sha": "ccc", "status": "running", "updated_at": "2020-10-06T15:00:00Z", "environment": {"name": "production"}, "deployable": {"pipeline": {"id": 2}}}
+				]`)
+				return
+			}
+			fmt.Fprint(w, `[
+					{"id": 1, "sha": "aaa", "status": "success", "updated_at": "2020-10-06T16:00:00Z", "environment": {"name": "production"}, "deployable": {"pipeline": {"id": 1}}},
+					{"id": 2, "sha": "ccc", "status": "success", "updated_at": "2020-10-06T17:00:00Z", "environment": {"name": "production"}, "deployable": {"pipeline": {"id": 2}}}
+				]`)
+		})
+
+		mux.HandleFunc("/api/v4/projects/1/repository/commits/aaa", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"id": "aaa", "title": "first"}`)
+		})
+
+		mux.HandleFunc("/api/v4/projects/1/repository/compare", func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("from") != "aaa" || r.URL.Query().Get("to") != "ccc" {
+				t.Errorf("unexpected compare query: %v", r.URL.RawQuery)
+			}
+			fmt.Fprint(w, `{"commits": [{"id": "bbb", "title": "second"}, {"id": "ccc", "title": "third"}]}`)
+		})
+
+		r := new(mockRepo)
+
+		if err := g.Refresh(r, collector.Options{ProjectID: 1}, nil); err != nil {
+			t.Fatalf("Unexpected error: %v", err.Error())
+		}
+
+		want := &collector.SyncCursor{
+			ProjectID:      1,
+			UpdatedAt:      time.Date(2020, 10, 6, 16, 0, 0, 0, time.UTC),
+			UpdatedIDs:     []int{1},
+			LastSuccessID:  1,
+			LastSuccessSHA: "aaa",
+		}
+		if !reflect.DeepEqual(r.Cursors[1], want) {
+			t.Errorf("got cursor %+v; wanted %+v", r.Cursors[1], want)
+		}
+
+		r.DeploymentData = nil
+
+		if err := g.Refresh(r, collector.Options{ProjectID: 1}, nil); err != nil {
+			t.Fatalf("Unexpected error: %v", err.Error())
+		}
+
+		if query.Get("updated_after") != "2020-10-06T16:00:00Z" || query.Get("order_by") != "updated_at" || query.Get("per_page") != "100" {
+			t.Errorf("got query %v; wanted 100 deployments updated after the cursor", query)
+		}
+
+		// deployment 1 was updated at the cursor, so it was saved by the first run
+		if len(r.DeploymentData) != 1 || r.DeploymentData[0].ID != 2 || len(r.DeploymentData[0].Commits) != 2 {
+			t.Fatalf("got %+v; wanted deployment 2 with the commits since deployment 1", r.DeploymentData)
+		}
+
+		want = &collector.SyncCursor{
+			ProjectID:      1,
+			UpdatedAt:      time.Date(2020, 10, 6, 17, 0, 0, 0, time.UTC),
+			UpdatedIDs:     []int{2},
+			LastSuccessID:  2,
+			LastSuccessSHA: "ccc",
+		}
+		if !reflect.DeepEqual(r.Cursors[1], want) {
+			t.Errorf("got cursor %+v; wanted %+v", r.Cursors[1], want)
+		}
+
+		r.DeploymentData = nil
+
+		if err := g.Refresh(r, collector.Options{ProjectID: 1, FullResync: true}, nil); err != nil {
+			t.Fatalf("Unexpected error: %v", err.Error())
+		}
+
+		if query.Get("updated_after") != "" || len(r.DeploymentData) != 1 {
+			t.Errorf("got query %v and %v deployments; wanted every deployment", query, len(r.DeploymentData))
+		}
+
+		// the running deployment listed by the resync was updated before the cursor
+		if r.Cursors[1].UpdatedAt != time.Date(2020, 10, 6, 16, 0, 0, 0, time.UTC) || r.Cursors[1].LastSuccessID != 1 {
+			t.Errorf("got cursor %+v; wanted it reset by the resync", r.Cursors[1])
+		}
+	})
+
+	t.Run("collect deployments updated at the cursor which it did not collect", func(t *testing.T) {
+
+		mux, server, _, g := setupMockGitLabClient(t)
+		defer teardown(server)
+
+		mux.HandleFunc("/api/v4/projects/1", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"id": 1, "name": "test", "namespace": {"full_path": "test"}}`)
+		})
+
+		// deployment 3 was updated at the same time as deployment 2, but was
+		// not listed by the previous run
+		mux.HandleFunc("/api/v4/projects/1/deployments", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `[
+					{"id": 2, "status": "failed", "updated_at": "2020-10-06T16:00:00Z", "environment": {"name": "production"}, "deployable": {"pipeline": {"id": 2}}},
+					{"id": 3, "status": "failed", "updated_at": "2020-10-06T16:00:00Z", "environment": {"name": "production"}, "deployable": {"pipeline": {"id": 3}}}
+				]`)
+		})
+
+		updated := time.Date(2020, 10, 6, 16, 0, 0, 0, time.UTC)
+		cursor := &collector.SyncCursor{ProjectID: 1, UpdatedAt: updated, UpdatedIDs: []int{2}}
+		r := &mockRepo{Cursors: map[int]*collector.SyncCursor{1: cursor}}
+
+		if err := g.Refresh(r, collector.Options{ProjectID: 1}, nil); err != nil {
+			t.Fatalf("Unexpected error: %v", err.Error())
+		}
+
+		if len(r.DeploymentData) != 1 || r.DeploymentData[0].ID != 3 {
+			t.Errorf("got %+v; wanted only deployment 3 saved", r.DeploymentData)
+		}
+
+		if got := r.Cursors[1]; !got.UpdatedAt.Equal(updated) || !reflect.DeepEqual(got.UpdatedIDs, []int{2, 3}) {
+			t.Errorf("got cursor %+v; wanted deployments 2 and 3 collected at %v", got, updated)
+		}
+	})
+
+	t.Run("keep the commits of deployments created before the cursor", func(t *testing.T) {
+
+		mux, server, _, g := setupMockGitLabClient(t)
+		defer teardown(server)
+
+		mux.HandleFunc("/api/v4/projects/1", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"id": 1, "name": "test", "namespace": {"full_path": "test"}}`)
+		})
+
+		// deployment 1 is updated again after deployment 2 was collected
+		mux.HandleFunc("/api/v4/projects/1/deployments", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `[{"id": 1, "sha": "aaa", "status": "failed", "updated_at": "2020-10-07T00:00:00Z", "environment": {"name": "production"}, "deployable": {"pipeline": {"id": 1}}}]`)
+		})
+
+		mux.HandleFunc("/api/v4/projects/1/repository/commits/aaa", func(w http.ResponseWriter, r *http.Request) {
+			t.Errorf("got commits requested for a deployment which was already collected")
+			fmt.Fprint(w, `{"id": "aaa"}`)
+		})
+
+		cursor := &collector.SyncCursor{ProjectID: 1, UpdatedAt: time.Date(2020, 10, 6, 0, 0, 0, 0, time.UTC), LastSuccessID: 2, LastSuccessSHA: "bbb"}
+		r := &mockRepo{Cursors: map[int]*collector.SyncCursor{1: cursor}}
+
+		if err := g.Refresh(r, collector.Options{ProjectID: 1}, nil); err != nil {
+			t.Fatalf("Unexpected error: %v", err.Error())
+		}
+
+		// deployments without commits keep the commits they were saved with
+		if len(r.DeploymentData) != 1 || r.DeploymentData[0].Commits != nil {
+			t.Errorf("got %+v; wanted deployment 1 saved without commits", r.DeploymentData)
+		}
+	})
+
+	t.Run("keep the sync cursor when refreshing since a later date", func(t *testing.T) {
+
+		mux, server, _, g := setupMockGitLabClient(t)
+		defer teardown(server)
+
+		mux.HandleFunc("/api/v4/projects/1", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"id": 1, "name": "test", "namespace": {"full_path": "test"}}`)
+		})
+
+		mux.HandleFunc("/api/v4/projects/1/deployments", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `[{"id": 3, "status": "failed", "updated_at": "2020-10-09T00:00:00Z", "environment": {"name": "production"}, "deployable": {"pipeline": {"id": 3}}}]`)
+		})
+
+		cursor := &collector.SyncCursor{ProjectID: 1, UpdatedAt: time.Date(2020, 10, 6, 0, 0, 0, 0, time.UTC)}
+		r := &mockRepo{Cursors: map[int]*collector.SyncCursor{1: cursor}}
+
+		since := time.Date(2020, 10, 8, 0, 0, 0, 0, time.UTC)
+		if err := g.Refresh(r, collector.Options{ProjectID: 1, Since: since}, nil); err != nil {
+			t.Fatalf("Unexpected error: %v", err.Error())
+		}
+
+		if len(r.DeploymentData) != 1 || r.Cursors[1] != cursor {
+			t.Errorf("got %+v; wanted the deployment saved and the cursor kept", r)
+		}
+	})
+}
+
+type mockProgress struct {
+	projects    int
+	done        []int
+	deployments int
+	errors      []error
+}
+
+func (m *mockProgress) ProjectsFound(n int) {
+	m.projects = n
+}
+
+func (m *mockProgress) ProjectDone(p *collector.Project, deployments int, err error) {
+	m.done = append(m.done, p.ID)
+	m.deployments += deployments
+	if err != nil {
+		m.errors = append(m.errors, err)
+	}
 }
 
 func getProjectListOptions() *gl.ListProjectsOptions {
@@ -661,16 +1162,35 @@ func setupMockGitLabClient(t *testing.T) (*http.ServeMux, *httptest.Server, *gl.
 type mockRepo struct {
 	ProjectData    []*collector.Project
 	DeploymentData []*collector.Deployment
+	Cursors        map[int]*collector.SyncCursor
+	SaveErr        error
 }
 
-func (m *mockRepo) SaveProjects(p []*collector.Project) {
+func (m *mockRepo) SaveProjects(p []*collector.Project) error {
 	for _, proj := range p {
 		m.ProjectData = append(m.ProjectData, proj)
 	}
+	return nil
 }
 
-func (m *mockRepo) SaveDeployment(d *collector.Deployment) {
+func (m *mockRepo) SaveDeployment(d *collector.Deployment) error {
+	if m.SaveErr != nil {
+		return m.SaveErr
+	}
 	m.DeploymentData = append(m.DeploymentData, d)
+	return nil
+}
+
+func (m *mockRepo) GetSyncCursor(projectID int) (*collector.SyncCursor, error) {
+	return m.Cursors[projectID], nil
+}
+
+func (m *mockRepo) SaveSyncCursor(c *collector.SyncCursor) error {
+	if m.Cursors == nil {
+		m.Cursors = map[int]*collector.SyncCursor{}
+	}
+	m.Cursors[c.ProjectID] = c
+	return nil
 }
 
 func teardown(server *httptest.Server) {
@@ -1,25 +1,77 @@
 package collector
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"sort"
+	"sync"
 	"time"
 )
 
+// maxRuns is the number of collection runs kept for reporting progress
+const maxRuns = 100
+
+// maxRecentErrors is the number of errors reported in the status
+const maxRecentErrors = 20
+
+// ErrRunInProgress is returned when a run is started while a different run
+// collecting the same projects is in progress
+var ErrRunInProgress = errors.New("a collection run of the same projects is in progress")
+
 // Service provides functionality for updating CI data
 type Service struct {
-	ci CIServer
-	r  Repository
+	ci       CIServer
+	r        Repository
+	mu       sync.Mutex
+	runs     map[string]*run
+	finished int
 }
 
 // CIServer provides functionality for getting data from CI server
 type CIServer interface {
-	RefreshData(r Repository) error
+	Refresh(r Repository, opt Options, p Progress) error
+}
+
+// Options restricts the data collected by a run
+type Options struct {
+	// ProjectID limits the run to a single project, or all projects if 0
+	ProjectID int
+	// Since limits the run to deployments updated after it. When zero, only
+	// deployments updated since the project's sync cursor are collected.
+	Since time.Time
+	// FullResync ignores the sync cursors and collects every deployment
+	FullResync bool
+}
+
+// Progress is notified as a run collects data. A nil Progress is ignored.
+type Progress interface {
+	ProjectsFound(n int)
+	ProjectDone(p *Project, deployments int, err error)
 }
 
 // Repository provides access to data storage
 type Repository interface {
-	SaveProjects(p []*Project)
-	SaveDeployment(d *Deployment)
+	SaveProjects(p []*Project) error
+	SaveDeployment(d *Deployment) error
+	GetSyncCursor(projectID int) (*SyncCursor, error)
+	SaveSyncCursor(c *SyncCursor) error
+}
+
+// SyncCursor records how far the deployments of a project have been collected,
+// so later runs only collect the deployments updated since
+type SyncCursor struct {
+	ProjectID int
+	// UpdatedAt is when the most recently updated deployment collected was updated
+	UpdatedAt time.Time
+	// UpdatedIDs are the deployments collected which were updated at UpdatedAt,
+	// so deployments updated at the same time are not skipped
+	UpdatedIDs []int
+	// LastSuccessID and LastSuccessSHA identify the latest successful deployment
+	// collected, which the commits of the next deployment are compared with
+	LastSuccessID  int
+	LastSuccessSHA string
 }
 
 // Project represents metrix view of a GitLab project object
@@ -30,6 +82,7 @@ type Project struct {
 	PathWithNamespace string
 	Namespace         string
 	WebURL            string
+	Visibility        string
 }
 
 // Deployment represents metrix view of a GitLab deployment object
@@ -42,21 +95,355 @@ type Deployment struct {
 	ProjectPath      string
 	ProjectNamespace string
 	PipelineID       int
+	SHA              string
 	FinishedAt       *time.Time
 	Duration         float64
+	Commits          []*Commit
+}
+
+// Commit represents metrix view of a GitLab commit introduced by a deployment
+type Commit struct {
+	SHA         string
+	Title       string
+	CommittedAt *time.Time
+}
+
+// Run represents the progress of a collection run
+type Run struct {
+	ID               string
+	Options          Options
+	StartedAt        time.Time
+	FinishedAt       *time.Time
+	Projects         int
+	ProjectsDone     int
+	DeploymentsSaved int
+	ProjectErrors    []*ProjectError
+	Error            string
+}
+
+// ProjectError represents a failure to collect the data for a project
+type ProjectError struct {
+	ProjectID   int
+	ProjectName string
+	Message     string
+}
+
+// Status summarises the recent collection runs of a CI server
+type Status struct {
+	// LastRun is the most recently started run which has finished, or nil
+	LastRun *Run
+	// Running is the number of runs in progress
+	Running int
+	// RecentErrors are the errors of the kept runs, newest first
+	RecentErrors []*RunError
+}
+
+// RunError represents a failure of a run, or of a project within it if
+// ProjectID is set
+type RunError struct {
+	RunID       string
+	At          time.Time
+	ProjectID   int
+	ProjectName string
+	Message     string
+}
+
+// Duration returns how long the run took, or has taken so far
+func (r *Run) Duration() time.Duration {
+	if r.FinishedAt == nil {
+		return time.Since(r.StartedAt)
+	}
+	return r.FinishedAt.Sub(r.StartedAt)
+}
+
+// run tracks a Run as it is updated by the CI server
+type run struct {
+	mu sync.Mutex
+	Run
+	// done is closed when the run finishes
+	done chan struct{}
 }
 
 // NewService creates a collector with required dependencies
 func NewService(ci CIServer, r Repository) *Service {
-	return &Service{ci, r}
+	return &Service{ci: ci, r: r, runs: map[string]*run{}}
+}
+
+// Start begins a collection run in the background and returns its ID. While a
+// run collecting the same projects is in progress, the ID of that run is
+// returned if it has the same options, or ErrRunInProgress if it does not.
+func (s *Service) Start(opt Options) (string, error) {
+
+	rn, ok := s.newRun(opt)
+	if !ok {
+		if sameOptions(rn.Options, opt) {
+			return rn.ID, nil
+		}
+		return "", ErrRunInProgress
+	}
+
+	go func() {
+		err := s.ci.Refresh(s.r, opt, rn)
+		if err != nil {
+			fmt.Printf("Error: %v", err.Error())
+		}
+		s.finish(rn, err)
+	}()
+
+	return rn.ID, nil
+}
+
+// newRun records a run starting now and returns true, unless a run collecting
+// the same projects is in progress, which is returned instead
+func (s *Service) newRun(opt Options) (*run, bool) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, rn := range s.runs {
+		rn.mu.Lock()
+		running := rn.FinishedAt == nil
+		rn.mu.Unlock()
+
+		if running && overlaps(rn.Options, opt) {
+			return rn, false
+		}
+	}
+
+	rn := &run{
+		Run: Run{
+			ID:            newRunID(),
+			Options:       opt,
+			StartedAt:     time.Now(),
+			ProjectErrors: []*ProjectError{},
+		},
+		done: make(chan struct{}),
+	}
+
+	s.runs[rn.ID] = rn
+	s.prune()
+
+	return rn, true
+}
+
+// overlaps reports whether runs with the options collect any of the same
+// projects, as runs of all projects collect every project
+func overlaps(a, b Options) bool {
+	return a.ProjectID == 0 || b.ProjectID == 0 || a.ProjectID == b.ProjectID
+}
+
+func sameOptions(a, b Options) bool {
+	return a.ProjectID == b.ProjectID && a.Since.Equal(b.Since) && a.FullResync == b.FullResync
+}
+
+// finish records the end of a run, counting it before anyone waiting for the
+// run is released
+func (s *Service) finish(rn *run, err error) {
+
+	s.mu.Lock()
+	s.finished++
+	s.mu.Unlock()
+
+	rn.finish(err)
+}
+
+// Finished returns the number of runs which have finished, so data derived
+// from the repository can be kept until another run may have changed it
+func (s *Service) Finished() int {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.finished
+}
+
+// Run returns the progress of the collection run, or nil if there is no run
+// with the ID
+func (s *Service) Run(id string) *Run {
+
+	s.mu.Lock()
+	rn, ok := s.runs[id]
+	s.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	return rn.snapshot()
+}
+
+// Wait waits for the collection run to finish and returns it, or nil if there
+// is no run with the ID
+func (s *Service) Wait(id string) *Run {
+
+	s.mu.Lock()
+	rn, ok := s.runs[id]
+	s.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	<-rn.done
+	return rn.snapshot()
+}
+
+// Schedule starts a run of every project each interval until the returned
+// function is called. The run is skipped while another run is in progress.
+func (s *Service) Schedule(interval time.Duration) func() {
+
+	t := time.NewTicker(interval)
+	stop := make(chan struct{})
+
+	go func() {
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				if _, err := s.Start(Options{}); err != nil {
+					fmt.Printf("Error: scheduled run skipped: %v", err.Error())
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(stop) })
+	}
 }
 
-// RefreshData collects data from CI server and saves in data repository
-func (s *Service) RefreshData() error {
-	err := s.ci.RefreshData(s.r)
+// Status summarises the recent collection runs
+func (s *Service) Status() *Status {
+
+	s.mu.Lock()
+	runs := []*Run{}
+	for _, rn := range s.runs {
+		runs = append(runs, rn.snapshot())
+	}
+	s.mu.Unlock()
+
+	// newest first
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].StartedAt.After(runs[j].StartedAt)
+	})
+
+	st := &Status{RecentErrors: []*RunError{}}
+
+	for _, r := range runs {
+		if r.FinishedAt == nil {
+			st.Running++
+			continue
+		}
+
+		if st.LastRun == nil {
+			st.LastRun = r
+		}
+
+		if r.Error != "" {
+			st.RecentErrors = append(st.RecentErrors, &RunError{RunID: r.ID, At: *r.FinishedAt, Message: r.Error})
+		}
+		for _, pe := range r.ProjectErrors {
+			st.RecentErrors = append(st.RecentErrors, &RunError{
+				RunID:       r.ID,
+				At:          *r.FinishedAt,
+				ProjectID:   pe.ProjectID,
+				ProjectName: pe.ProjectName,
+				Message:     pe.Message,
+			})
+		}
+	}
+
+	if len(st.RecentErrors) > maxRecentErrors {
+		st.RecentErrors = st.RecentErrors[:maxRecentErrors]
+	}
+
+	return st
+}
+
+// prune removes the oldest finished runs once more than maxRuns are kept
+func (s *Service) prune() {
+
+	if len(s.runs) <= maxRuns {
+		return
+	}
+
+	finished := []*run{}
+	for _, rn := range s.runs {
+		rn.mu.Lock()
+		if rn.FinishedAt != nil {
+			finished = append(finished, rn)
+		}
+		rn.mu.Unlock()
+	}
+
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].StartedAt.Before(finished[j].StartedAt)
+	})
+
+	for _, rn := range finished {
+		if len(s.runs) <= maxRuns {
+			return
+		}
+		delete(s.runs, rn.ID)
+	}
+}
+
+// snapshot copies the run so it can be read while the run continues
+func (rn *run) snapshot() *Run {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	r := rn.Run
+	r.ProjectErrors = append([]*ProjectError{}, rn.ProjectErrors...)
+
+	return &r
+}
+
+// finish records the end of the run and why it failed, if it did
+func (rn *run) finish(err error) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
 	if err != nil {
-		fmt.Printf("Error: %v", err.Error())
-		return err
+		rn.Error = err.Error()
+	}
+	now := time.Now()
+	rn.FinishedAt = &now
+	close(rn.done)
+}
+
+// ProjectsFound records the number of projects the run will collect
+func (rn *run) ProjectsFound(n int) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.Projects = n
+}
+
+// ProjectDone records the deployments saved for a project, or why it failed
+func (rn *run) ProjectDone(p *Project, deployments int, err error) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.ProjectsDone++
+	rn.DeploymentsSaved += deployments
+
+	if err != nil {
+		rn.ProjectErrors = append(rn.ProjectErrors, &ProjectError{
+			ProjectID:   p.ID,
+			ProjectName: p.Name,
+			Message:     err.Error(),
+		})
+	}
+}
+
+func newRunID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
 	}
-	return nil
+	return hex.EncodeToString(b)
 }
@@ -0,0 +1,298 @@
+package collector_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sk000f/metrix/pkg/collector"
+)
+
+func TestCollectionRuns(t *testing.T) {
+
+	t.Run("report progress of a background run", func(t *testing.T) {
+
+		ci := &mockCI{step: make(chan bool)}
+		s := collector.NewService(ci, new(mockRepo))
+
+		since := time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC)
+		id := start(t, s, collector.Options{ProjectID: 2, Since: since})
+
+		// pause the run after the first project
+		<-ci.step
+		if got := s.Run(id); got.FinishedAt != nil || got.ProjectsDone != 1 {
+			t.Errorf("got %+v; wanted a running run with one project done", got)
+		}
+
+		ci.step <- true
+		got := waitForRun(t, s, id)
+
+		want := &collector.Run{
+			ID:               id,
+			Options:          collector.Options{ProjectID: 2, Since: since},
+			StartedAt:        got.StartedAt,
+			FinishedAt:       got.FinishedAt,
+			Projects:         2,
+			ProjectsDone:     2,
+			DeploymentsSaved: 3,
+			ProjectErrors: []*collector.ProjectError{
+				{ProjectID: 2, ProjectName: "site", Message: "not found"},
+			},
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v; wanted %+v", got, want)
+		}
+
+		if !reflect.DeepEqual(ci.opt, want.Options) {
+			t.Errorf("got options %+v; wanted %+v", ci.opt, want.Options)
+		}
+	})
+
+	t.Run("record run failure", func(t *testing.T) {
+
+		s := collector.NewService(&mockCI{err: errors.New("unauthorized")}, new(mockRepo))
+
+		got := waitForRun(t, s, start(t, s, collector.Options{}))
+
+		if got.Error != "unauthorized" {
+			t.Errorf("got error %q; wanted %q", got.Error, "unauthorized")
+		}
+	})
+
+	t.Run("join or reject runs of projects which are being collected", func(t *testing.T) {
+
+		ci := &mockCI{step: make(chan bool)}
+		s := collector.NewService(ci, new(mockRepo))
+
+		id := start(t, s, collector.Options{ProjectID: 2})
+		<-ci.step
+
+		if got := start(t, s, collector.Options{ProjectID: 2}); got != id {
+			t.Errorf("got run %v; wanted the run in progress %v", got, id)
+		}
+
+		for _, opt := range []collector.Options{{}, {ProjectID: 2, FullResync: true}} {
+			if _, err := s.Start(opt); err != collector.ErrRunInProgress {
+				t.Errorf("got %v for %+v; wanted %v", err, opt, collector.ErrRunInProgress)
+			}
+		}
+
+		ci.step <- true
+		waitForRun(t, s, id)
+
+		next := start(t, s, collector.Options{ProjectID: 2})
+		if next == id {
+			t.Errorf("got the finished run %v; wanted a new run", next)
+		}
+		<-ci.step
+		ci.step <- true
+		waitForRun(t, s, next)
+	})
+
+	t.Run("wait for a run to finish", func(t *testing.T) {
+
+		ci := &mockCI{step: make(chan bool)}
+		s := collector.NewService(ci, new(mockRepo))
+
+		id := start(t, s, collector.Options{})
+		<-ci.step
+
+		done := make(chan *collector.Run)
+		go func() {
+			done <- s.Wait(id)
+		}()
+
+		if got := s.Finished(); got != 0 {
+			t.Errorf("got %v finished runs; wanted 0", got)
+		}
+
+		ci.step <- true
+		if got := <-done; got.FinishedAt == nil || got.ProjectsDone != 2 {
+			t.Errorf("got %+v; wanted the finished run", got)
+		}
+
+		if got := s.Finished(); got != 1 {
+			t.Errorf("got %v finished runs; wanted 1", got)
+		}
+
+		if got := s.Wait("unknown"); got != nil {
+			t.Errorf("got %+v; wanted nil", got)
+		}
+	})
+
+	t.Run("start runs on a schedule", func(t *testing.T) {
+
+		s := collector.NewService(new(mockCI), new(mockRepo))
+
+		stop := s.Schedule(10 * time.Millisecond)
+		time.Sleep(100 * time.Millisecond)
+		stop()
+		time.Sleep(20 * time.Millisecond)
+
+		if got := len(s.Status().RecentErrors); got < 2 {
+			t.Errorf("got errors of %v runs; wanted a run every interval", got)
+		}
+	})
+
+	t.Run("return nil for unknown run", func(t *testing.T) {
+
+		s := collector.NewService(new(mockCI), new(mockRepo))
+
+		if got := s.Run("unknown"); got != nil {
+			t.Errorf("got %+v; wanted nil", got)
+		}
+	})
+}
+
+func TestStatus(t *testing.T) {
+
+	t.Run("report the last run and recent errors", func(t *testing.T) {
+
+		ci := new(mockCI)
+		s := collector.NewService(ci, new(mockRepo))
+
+		waitForRun(t, s, start(t, s, collector.Options{}))
+
+		ci.err = errors.New("unauthorized")
+		if r := waitForRun(t, s, start(t, s, collector.Options{})); r.Error == "" {
+			t.Fatal("got no error; wanted unauthorized")
+		}
+
+		got := s.Status()
+
+		if got.LastRun == nil || got.LastRun.Error != "unauthorized" || got.Running != 0 {
+			t.Fatalf("got %+v; wanted the failed run last", got)
+		}
+
+		want := []string{"unauthorized", "not found"}
+		if len(got.RecentErrors) != len(want) {
+			t.Fatalf("got %v errors; wanted %v", len(got.RecentErrors), len(want))
+		}
+		for i, e := range got.RecentErrors {
+			if e.Message != want[i] {
+				t.Errorf("got error %q; wanted %q", e.Message, want[i])
+			}
+		}
+		if got.RecentErrors[1].ProjectName != "site" {
+			t.Errorf("got project %q; wanted site", got.RecentErrors[1].ProjectName)
+		}
+	})
+
+	t.Run("only show errors of visible projects", func(t *testing.T) {
+
+		ci := new(mockCI)
+		s := collector.NewService(ci, new(mockRepo))
+
+		waitForRun(t, s, start(t, s, collector.Options{}))
+
+		ci.err = errors.New("listing projects of group secret: unauthorized")
+		if r := waitForRun(t, s, start(t, s, collector.Options{})); r.Error == "" {
+			t.Fatal("got no error; wanted unauthorized")
+		}
+
+		got := s.Status().Visible([]int{1})
+
+		if len(got.RecentErrors) != 1 || got.RecentErrors[0].Message != "collection failed" {
+			t.Errorf("got errors %+v; wanted only the run failure without its message", got.RecentErrors)
+		}
+
+		if got.LastRun == nil || got.LastRun.Error != "collection failed" {
+			t.Errorf("got last run %+v; wanted the failure without its message", got.LastRun)
+		}
+
+		ci.err = nil
+		id := start(t, s, collector.Options{ProjectID: 2})
+		if r := waitForRun(t, s, id).Visible([]int{1}); r != nil {
+			t.Errorf("got %+v; wanted nil for a run of a hidden project", r)
+		}
+		if r := waitForRun(t, s, id).Visible([]int{2}); r == nil || len(r.ProjectErrors) != 1 {
+			t.Errorf("got %+v; wanted the run with the error of its project", r)
+		}
+	})
+
+	t.Run("count runs in progress", func(t *testing.T) {
+
+		ci := &mockCI{step: make(chan bool)}
+		s := collector.NewService(ci, new(mockRepo))
+
+		id := start(t, s, collector.Options{})
+		<-ci.step
+
+		if got := s.Status(); got.Running != 1 || got.LastRun != nil {
+			t.Errorf("got %+v; wanted one run in progress", got)
+		}
+
+		ci.step <- true
+		waitForRun(t, s, id)
+	})
+}
+
+func start(t *testing.T, s *collector.Service, opt collector.Options) string {
+
+	id, err := s.Start(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
+
+func waitForRun(t *testing.T, s *collector.Service, id string) *collector.Run {
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if r := s.Run(id); r.FinishedAt != nil {
+			return r
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatal("run did not finish")
+	return nil
+}
+
+type mockCI struct {
+	opt  collector.Options
+	err  error
+	step chan bool
+}
+
+func (m *mockCI) Refresh(r collector.Repository, opt collector.Options, p collector.Progress) error {
+
+	m.opt = opt
+	if m.err != nil {
+		return m.err
+	}
+
+	p.ProjectsFound(2)
+	p.ProjectDone(&collector.Project{ID: 1, Name: "api"}, 3, nil)
+
+	if m.step != nil {
+		m.step <- true
+		<-m.step
+	}
+
+	p.ProjectDone(&collector.Project{ID: 2, Name: "site"}, 0, errors.New("not found"))
+
+	return nil
+}
+
+type mockRepo struct{}
+
+func (m *mockRepo) SaveProjects(p []*collector.Project) error {
+	return nil
+}
+
+func (m *mockRepo) SaveDeployment(d *collector.Deployment) error {
+	return nil
+}
+
+func (m *mockRepo) GetSyncCursor(projectID int) (*collector.SyncCursor, error) {
+	return nil, nil
+}
+
+func (m *mockRepo) SaveSyncCursor(c *collector.SyncCursor) error {
+	return nil
+}
@@ -0,0 +1,64 @@
+package collector
+
+// hiddenError replaces the message of run errors which are not about a single
+// project, as they may name projects the user cannot access
+const hiddenError = "collection failed"
+
+// Visible returns a copy of the run with only the errors of the projects with
+// the GitLab project IDs, or nil if the run only collected another project
+func (r *Run) Visible(ids []int) *Run {
+
+	visible := visibleIDs(ids)
+
+	if r.Options.ProjectID != 0 && !visible[r.Options.ProjectID] {
+		return nil
+	}
+
+	v := *r
+	v.ProjectErrors = []*ProjectError{}
+	for _, e := range r.ProjectErrors {
+		if visible[e.ProjectID] {
+			v.ProjectErrors = append(v.ProjectErrors, e)
+		}
+	}
+	if v.Error != "" {
+		v.Error = hiddenError
+	}
+
+	return &v
+}
+
+// Visible returns a copy of the status with only the errors of the projects
+// with the GitLab project IDs
+func (st *Status) Visible(ids []int) *Status {
+
+	visible := visibleIDs(ids)
+
+	v := &Status{Running: st.Running, RecentErrors: []*RunError{}}
+	if st.LastRun != nil {
+		v.LastRun = st.LastRun.Visible(ids)
+	}
+
+	for _, e := range st.RecentErrors {
+		switch {
+		case e.ProjectID == 0:
+			re := *e
+			re.Message = hiddenError
+			v.RecentErrors = append(v.RecentErrors, &re)
+		case visible[e.ProjectID]:
+			v.RecentErrors = append(v.RecentErrors, e)
+		}
+	}
+
+	return v
+}
+
+func visibleIDs(ids []int) map[int]bool {
+
+	visible := map[int]bool{}
+	for _, id := range ids {
+		visible[id] = true
+	}
+
+	return visible
+}
@@ -0,0 +1,168 @@
+package events
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/sk000f/metrix/pkg/collector"
+)
+
+// subscriberBuffer is the number of deployments held for a slow subscriber
+// before further deployments are dropped
+const subscriberBuffer = 64
+
+// maxSeen is the number of saved deployments remembered to tell whether a
+// deployment has changed. Once more are saved the least recently added are
+// forgotten, so they are published again if they are saved again.
+const maxSeen = 10000
+
+// Broker delivers saved deployments to subscribers
+type Broker struct {
+	mu   sync.Mutex
+	subs map[int]chan *collector.Deployment
+	next int
+}
+
+// NewBroker creates a broker with no subscribers
+func NewBroker() *Broker {
+	return &Broker{subs: map[int]chan *collector.Deployment{}}
+}
+
+// Subscribe returns a channel of published deployments and a function which
+// unsubscribes and closes the channel
+func (b *Broker) Subscribe() (<-chan *collector.Deployment, func()) {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.next
+	b.next++
+
+	c := make(chan *collector.Deployment, subscriberBuffer)
+	b.subs[id] = c
+
+	return c, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if _, ok := b.subs[id]; ok {
+			delete(b.subs, id)
+			close(c)
+		}
+	}
+}
+
+// Subscribers returns the number of current subscribers
+func (b *Broker) Subscribers() int {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.subs)
+}
+
+// Publish sends the deployment to every subscriber without blocking the caller
+func (b *Broker) Publish(d *collector.Deployment) {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, c := range b.subs {
+		select {
+		case c <- d:
+		default:
+			fmt.Printf("Error: dropped deployment %v for slow subscriber", d.ID)
+		}
+	}
+}
+
+// Repository wraps a collector repository and publishes deployments which are
+// new or have changed since they were last saved. Deployments are tracked in
+// memory, so nothing is published until StartPublishing is called, which lets
+// the first run after a restart save deployments without publishing them.
+type Repository struct {
+	r          collector.Repository
+	b          *Broker
+	mu         sync.Mutex
+	seen       map[int]string
+	order      []int
+	publishing bool
+}
+
+// NewRepository creates a repository which publishes saved deployments to the
+// broker once StartPublishing is called
+func NewRepository(r collector.Repository, b *Broker) *Repository {
+	return &Repository{r: r, b: b, seen: map[int]string{}}
+}
+
+// StartPublishing publishes deployments which are saved from now on if they
+// are new or have changed
+func (p *Repository) StartPublishing() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.publishing = true
+}
+
+// SaveProjects saves projects in the wrapped repository
+func (p *Repository) SaveProjects(pr []*collector.Project) error {
+	return p.r.SaveProjects(pr)
+}
+
+// GetSyncCursor gets the sync cursor of a project from the wrapped repository
+func (p *Repository) GetSyncCursor(projectID int) (*collector.SyncCursor, error) {
+	return p.r.GetSyncCursor(projectID)
+}
+
+// SaveSyncCursor saves a sync cursor in the wrapped repository
+func (p *Repository) SaveSyncCursor(c *collector.SyncCursor) error {
+	return p.r.SaveSyncCursor(c)
+}
+
+// SaveDeployment saves a deployment in the wrapped repository and publishes it
+// if it is new or has changed
+func (p *Repository) SaveDeployment(d *collector.Deployment) error {
+
+	if err := p.r.SaveDeployment(d); err != nil {
+		return err
+	}
+
+	var finishedAt string
+	if d.FinishedAt != nil {
+		finishedAt = d.FinishedAt.String()
+	}
+	f := fmt.Sprintf("%v|%v|%v|%v|%v", d.Status, finishedAt, d.Duration, d.SHA, len(d.Commits))
+
+	p.mu.Lock()
+	prev, ok := p.seen[d.ID]
+	if !ok {
+		p.order = append(p.order, d.ID)
+		if len(p.order) > maxSeen {
+			delete(p.seen, p.order[0])
+			p.order = p.order[1:]
+		}
+	}
+	p.seen[d.ID] = f
+	publish := p.publishing && prev != f
+	p.mu.Unlock()
+
+	if publish {
+		p.b.Publish(d)
+	}
+
+	return nil
+}
+
+// Matches reports whether the deployment belongs to the project, if set, and
+// the group or one of its subgroups, if set
+func Matches(d *collector.Deployment, projectName, groupName string) bool {
+	if projectName != "" && d.ProjectName != projectName {
+		return false
+	}
+	if groupName != "" && d.ProjectNamespace != groupName &&
+		!strings.HasPrefix(d.ProjectNamespace, groupName+"/") {
+		return false
+	}
+	return true
+}
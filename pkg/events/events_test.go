@@ -0,0 +1,162 @@
+package events_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sk000f/metrix/pkg/collector"
+	"github.com/sk000f/metrix/pkg/events"
+)
+
+func TestEvents(t *testing.T) {
+
+	t.Run("deliver published deployments to each subscriber", func(t *testing.T) {
+
+		b := events.NewBroker()
+
+		c1, unsubscribe1 := b.Subscribe()
+		defer unsubscribe1()
+		c2, unsubscribe2 := b.Subscribe()
+
+		d := &collector.Deployment{ID: 1}
+		b.Publish(d)
+
+		if got := <-c1; got != d {
+			t.Errorf("got %+v; wanted %+v", got, d)
+		}
+		if got := <-c2; got != d {
+			t.Errorf("got %+v; wanted %+v", got, d)
+		}
+
+		unsubscribe2()
+
+		if _, ok := <-c2; ok {
+			t.Errorf("expected channel to be closed after unsubscribing")
+		}
+
+		if got := b.Subscribers(); got != 1 {
+			t.Errorf("got %v subscribers; wanted 1", got)
+		}
+	})
+
+	t.Run("publish deployments which are new or have changed", func(t *testing.T) {
+
+		r := &mockRepo{}
+		b := events.NewBroker()
+		p := events.NewRepository(r, b)
+
+		c, unsubscribe := b.Subscribe()
+		defer unsubscribe()
+
+		ts := time.Now()
+		running := &collector.Deployment{ID: 1, Status: "running"}
+		finished := &collector.Deployment{ID: 1, Status: "success", FinishedAt: &ts}
+
+		// deployments saved before publishing starts are only remembered
+		p.SaveDeployment(&collector.Deployment{ID: 2, Status: "success"})
+		p.SaveDeployment(&collector.Deployment{ID: 1, Status: "created"})
+		p.StartPublishing()
+
+		p.SaveDeployment(&collector.Deployment{ID: 2, Status: "success"})
+		p.SaveDeployment(running)
+		p.SaveDeployment(running)
+		p.SaveDeployment(finished)
+
+		want := []*collector.Deployment{running, running, finished}
+		if !reflect.DeepEqual(r.DeploymentData[3:], want) {
+			t.Errorf("got %+v; wanted %+v", r.DeploymentData, want)
+		}
+
+		if got := <-c; got != running {
+			t.Errorf("got %+v; wanted %+v", got, running)
+		}
+		if got := <-c; got != finished {
+			t.Errorf("got %+v; wanted %+v", got, finished)
+		}
+
+		select {
+		case d := <-c:
+			t.Errorf("got %+v; wanted no further deployments", d)
+		default:
+		}
+	})
+
+	t.Run("forget the oldest deployments once too many are remembered", func(t *testing.T) {
+
+		b := events.NewBroker()
+		p := events.NewRepository(&mockRepo{}, b)
+		p.StartPublishing()
+
+		c, unsubscribe := b.Subscribe()
+		defer unsubscribe()
+
+		go func() {
+			for range c {
+			}
+		}()
+
+		for id := 1; id <= 10001; id++ {
+			p.SaveDeployment(&collector.Deployment{ID: id, Status: "success"})
+		}
+		unsubscribe()
+
+		c, unsubscribe = b.Subscribe()
+		defer unsubscribe()
+
+		// deployment 1 was forgotten, so it is published again
+		p.SaveDeployment(&collector.Deployment{ID: 10001, Status: "success"})
+		p.SaveDeployment(&collector.Deployment{ID: 1, Status: "success"})
+
+		if got := <-c; got.ID != 1 {
+			t.Errorf("got deployment %v; wanted 1", got.ID)
+		}
+	})
+
+	t.Run("match deployments by project and group including subgroups", func(t *testing.T) {
+
+		d := &collector.Deployment{ProjectName: "api", ProjectNamespace: "org/platform"}
+
+		tests := []struct {
+			project, group string
+			want           bool
+		}{
+			{"", "", true},
+			{"api", "", true},
+			{"site", "", false},
+			{"", "org", true},
+			{"", "org/platform", true},
+			{"", "or", false},
+			{"api", "org/marketing", false},
+		}
+
+		for _, tc := range tests {
+			if got := events.Matches(d, tc.project, tc.group); got != tc.want {
+				t.Errorf("Matches(%q, %q) got %v; wanted %v", tc.project, tc.group, got, tc.want)
+			}
+		}
+	})
+}
+
+type mockRepo struct {
+	ProjectData    []*collector.Project
+	DeploymentData []*collector.Deployment
+}
+
+func (m *mockRepo) SaveProjects(p []*collector.Project) error {
+	m.ProjectData = p
+	return nil
+}
+
+func (m *mockRepo) SaveDeployment(d *collector.Deployment) error {
+	m.DeploymentData = append(m.DeploymentData, d)
+	return nil
+}
+
+func (m *mockRepo) GetSyncCursor(projectID int) (*collector.SyncCursor, error) {
+	return nil, nil
+}
+
+func (m *mockRepo) SaveSyncCursor(c *collector.SyncCursor) error {
+	return nil
+}
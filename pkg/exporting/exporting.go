@@ -0,0 +1,205 @@
+package exporting
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Service provides functionality for exporting raw deployment data
+type Service struct {
+	r Repository
+}
+
+// Repository provides access to stored deployments one at a time, so exports
+// never hold every deployment in memory
+type Repository interface {
+	// ExportDeployments calls fn for each deployment matching the filter, most
+	// recently finished first, stopping at the first error fn returns
+	ExportDeployments(f Filter, fn func(*Row) error) error
+}
+
+// Filter restricts the deployments which are exported
+type Filter struct {
+	Start       time.Time
+	End         time.Time
+	Status      string
+	Environment string
+	ProjectID   int
+	ProjectName string
+	GroupName   string
+	// ProjectIDs restricts the deployments to the projects, unless empty
+	ProjectIDs []int
+}
+
+// Row represents an exported deployment with its project joined in
+type Row struct {
+	DeploymentID             int        `json:"deployment_id"`
+	Status                   string     `json:"status"`
+	EnvironmentName          string     `json:"environment"`
+	ProjectID                int        `json:"project_id"`
+	ProjectName              string     `json:"project_name"`
+	ProjectPath              string     `json:"project_path"`
+	ProjectNamespace         string     `json:"project_namespace"`
+	ProjectPathWithNamespace string     `json:"project_path_with_namespace"`
+	ProjectWebURL            string     `json:"project_web_url"`
+	PipelineID               int        `json:"pipeline_id"`
+	SHA                      string     `json:"sha"`
+	FinishedAt               *time.Time `json:"finished_at"`
+	Duration                 float64    `json:"duration_seconds"`
+	Commits                  int        `json:"commits"`
+}
+
+// header is the first line of a CSV export, in the order of the Row fields
+var header = []string{
+	"deployment_id", "status", "environment", "project_id", "project_name",
+	"project_path", "project_namespace", "project_path_with_namespace",
+	"project_web_url", "pipeline_id", "sha", "finished_at", "duration_seconds", "commits",
+}
+
+// Format is the encoding of an export
+type Format int
+
+const (
+	// CSV writes a header line then a line per deployment
+	CSV Format = iota
+	// JSONLines writes a JSON object per line
+	JSONLines
+	// JSON writes a single array of objects
+	JSON
+)
+
+// ParseFormat converts a file extension into a Format
+func ParseFormat(s string) (Format, error) {
+	switch s {
+	case "csv":
+		return CSV, nil
+	case "jsonl":
+		return JSONLines, nil
+	case "json":
+		return JSON, nil
+	}
+	return CSV, fmt.Errorf("unknown export format %q", s)
+}
+
+// String returns the file extension for the Format
+func (f Format) String() string {
+	switch f {
+	case JSONLines:
+		return "jsonl"
+	case JSON:
+		return "json"
+	}
+	return "csv"
+}
+
+// ContentType returns the media type of the Format
+func (f Format) ContentType() string {
+	switch f {
+	case JSONLines:
+		return "application/x-ndjson"
+	case JSON:
+		return "application/json"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// NewService creates an export service with required dependencies
+func NewService(r Repository) *Service {
+	return &Service{r}
+}
+
+// Write streams the deployments matching the filter to w in the format
+func (s *Service) Write(w io.Writer, format Format, f Filter) error {
+	switch format {
+	case JSONLines:
+		return s.writeJSONLines(w, f)
+	case JSON:
+		return s.writeJSON(w, f)
+	}
+	return s.writeCSV(w, f)
+}
+
+func (s *Service) writeCSV(w io.Writer, f Filter) error {
+
+	cw := csv.NewWriter(w)
+
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	err := s.r.ExportDeployments(f, func(r *Row) error {
+		return cw.Write(record(r))
+	})
+	if err != nil {
+		return err
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func (s *Service) writeJSONLines(w io.Writer, f Filter) error {
+
+	enc := json.NewEncoder(w)
+
+	return s.r.ExportDeployments(f, func(r *Row) error {
+		return enc.Encode(r)
+	})
+}
+
+func (s *Service) writeJSON(w io.Writer, f Filter) error {
+
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+
+	sep := "\n"
+	err := s.r.ExportDeployments(f, func(r *Row) error {
+		b, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, sep); err != nil {
+			return err
+		}
+		sep = ",\n"
+		_, err = w.Write(b)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "\n]\n")
+	return err
+}
+
+// record converts a row into CSV fields in the order of the header
+func record(r *Row) []string {
+
+	finishedAt := ""
+	if r.FinishedAt != nil {
+		finishedAt = r.FinishedAt.UTC().Format(time.RFC3339)
+	}
+
+	return []string{
+		strconv.Itoa(r.DeploymentID),
+		r.Status,
+		r.EnvironmentName,
+		strconv.Itoa(r.ProjectID),
+		r.ProjectName,
+		r.ProjectPath,
+		r.ProjectNamespace,
+		r.ProjectPathWithNamespace,
+		r.ProjectWebURL,
+		strconv.Itoa(r.PipelineID),
+		r.SHA,
+		finishedAt,
+		strconv.FormatFloat(r.Duration, 'f', -1, 64),
+		strconv.Itoa(r.Commits),
+	}
+}
@@ -0,0 +1,206 @@
+package exporting_test
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sk000f/metrix/pkg/exporting"
+)
+
+func TestWrite(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		format exporting.Format
+		want   string
+	}{
+		{
+			name:   "write deployments as CSV with a header",
+			format: exporting.CSV,
+			want: "deployment_id,status,environment,project_id,project_name,project_path,project_namespace," +
+				"project_path_with_namespace,project_web_url,pipeline_id,sha,finished_at,duration_seconds,commits\n" +
+				"2,failed,production,1,api,api,org/platform,org/platform/api,https://gitlab.example.com/org/platform/api,12,b,2020-10-01T10:00:00Z,90.5,0\n" +
+				"1,success,production,1,api,api,org/platform,org/platform/api,https://gitlab.example.com/org/platform/api,11,a,2020-10-01T09:00:00Z,60,2\n",
+		},
+		{
+			name:   "write deployments as JSON Lines",
+			format: exporting.JSONLines,
+			want: `{"deployment_id":2,"status":"failed","environment":"production","project_id":1,"project_name":"api","project_path":"api","project_namespace":"org/platform","project_path_with_namespace":"org/platform/api","project_web_url":"https://gitlab.example.com/org/platform/api","pipeline_id":12,"sha":"b","finished_at":"2020-10-01T10:00:00Z","duration_seconds":90.5,"commits":0}` + "\n" +
+				`{"deployment_id":1,"status":"success","environment":"production","project_id":1,"project_name":"api","project_path":"api","project_namespace":"org/platform","project_path_with_namespace":"org/platform/api","project_web_url":"https://gitlab.example.com/org/platform/api","pipeline_id":11,"sha":"a","finished_at":"2020-10-01T09:00:00Z","duration_seconds":60,"commits":2}` + "\n",
+		},
+		{
+			name:   "write deployments as a JSON array",
+			format: exporting.JSON,
+			want: "[\n" +
+				`{"deployment_id":2,"status":"failed","environment":"production","project_id":1,"project_name":"api","project_path":"api","project_namespace":"org/platform","project_path_with_namespace":"org/platform/api","project_web_url":"https://gitlab.example.com/org/platform/api","pipeline_id":12,"sha":"b","finished_at":"2020-10-01T10:00:00Z","duration_seconds":90.5,"commits":0}` + ",\n" +
+				`{"deployment_id":1,"status":"success","environment":"production","project_id":1,"project_name":"api","project_path":"api","project_namespace":"org/platform","project_path_with_namespace":"org/platform/api","project_web_url":"https://gitlab.example.com/org/platform/api","pipeline_id":11,"sha":"a","finished_at":"2020-10-01T09:00:00Z","duration_seconds":60,"commits":2}` +
+				"\n]\n",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+
+			r := newMockRepo(t)
+			s := exporting.NewService(r)
+
+			f := exporting.Filter{GroupName: "org/platform"}
+
+			var b bytes.Buffer
+			if err := s.Write(&b, tc.format, f); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := b.String(); got != tc.want {
+				t.Errorf("got %v; wanted %v", got, tc.want)
+			}
+
+			if !reflect.DeepEqual(r.Filter, f) {
+				t.Errorf("got filter %+v; wanted %+v", r.Filter, f)
+			}
+		})
+	}
+
+	t.Run("write an empty JSON array when nothing matches", func(t *testing.T) {
+
+		s := exporting.NewService(&mockRepo{})
+
+		var b bytes.Buffer
+		if err := s.Write(&b, exporting.JSON, exporting.Filter{}); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := b.String(), "[\n]\n"; got != want {
+			t.Errorf("got %q; wanted %q", got, want)
+		}
+	})
+
+	t.Run("return storage errors", func(t *testing.T) {
+
+		s := exporting.NewService(&mockRepo{Err: errors.New("connection lost")})
+
+		var b bytes.Buffer
+		if err := s.Write(&b, exporting.JSONLines, exporting.Filter{}); err == nil {
+			t.Errorf("got no error; wanted connection lost")
+		}
+	})
+}
+
+func TestVisible(t *testing.T) {
+	t.Run("only export deployments of visible projects", func(t *testing.T) {
+
+		r := newMockRepo(t)
+		r.Rows[0].ProjectID = 2
+
+		s := exporting.NewService(r).Visible([]int{2})
+
+		var b bytes.Buffer
+		if err := s.Write(&b, exporting.JSONLines, exporting.Filter{}); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := strings.Count(b.String(), "\n"); got != 1 || !strings.Contains(b.String(), `"deployment_id":2,`) {
+			t.Errorf("got %v; wanted only deployment 2", b.String())
+		}
+
+		if !reflect.DeepEqual(r.Filter.ProjectIDs, []int{2}) {
+			t.Errorf("got project IDs %v; wanted [2] in the filter", r.Filter.ProjectIDs)
+		}
+
+		b.Reset()
+		r.Filter = exporting.Filter{}
+		if err := s.Write(&b, exporting.JSONLines, exporting.Filter{ProjectID: 1}); err != nil {
+			t.Fatal(err)
+		}
+
+		if b.Len() != 0 || r.Filter.ProjectID != 0 {
+			t.Errorf("got %v; wanted nothing for a hidden project", b.String())
+		}
+	})
+}
+
+func TestParseFormat(t *testing.T) {
+
+	for _, f := range []exporting.Format{exporting.CSV, exporting.JSONLines, exporting.JSON} {
+		got, err := exporting.ParseFormat(f.String())
+		if err != nil || got != f {
+			t.Errorf("got %v, %v; wanted %v", got, err, f)
+		}
+	}
+
+	if _, err := exporting.ParseFormat("xlsx"); err == nil {
+		t.Errorf("got no error; wanted unknown format")
+	}
+}
+
+func newMockRepo(t *testing.T) *mockRepo {
+
+	ts, err := time.Parse(time.RFC3339, "2020-10-01T09:00:00Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := ts.Add(time.Hour)
+
+	row := func(id, pipeline int, status, sha string, finishedAt *time.Time, duration float64, commits int) *exporting.Row {
+		return &exporting.Row{
+			DeploymentID:             id,
+			Status:                   status,
+			EnvironmentName:          "production",
+			ProjectID:                1,
+			ProjectName:              "api",
+			ProjectPath:              "api",
+			ProjectNamespace:         "org/platform",
+			ProjectPathWithNamespace: "org/platform/api",
+			ProjectWebURL:            "https://gitlab.example.com/org/platform/api",
+			PipelineID:               pipeline,
+			SHA:                      sha,
+			FinishedAt:               finishedAt,
+			Duration:                 duration,
+			Commits:                  commits,
+		}
+	}
+
+	return &mockRepo{Rows: []*exporting.Row{
+		row(2, 12, "failed", "b", &failed, 90.5, 0),
+		row(1, 11, "success", "a", &ts, 60, 2),
+	}}
+}
+
+type mockRepo struct {
+	Filter exporting.Filter
+	Rows   []*exporting.Row
+	Err    error
+}
+
+func (m *mockRepo) ExportDeployments(f exporting.Filter, fn func(*exporting.Row) error) error {
+
+	m.Filter = f
+
+	if m.Err != nil {
+		return m.Err
+	}
+
+	for _, r := range m.Rows {
+		if len(f.ProjectIDs) > 0 && !contains(f.ProjectIDs, r.ProjectID) {
+			continue
+		}
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func contains(ids []int, id int) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+package exporting
+
+import "sort"
+
+// visibleRepository only exports the deployments of projects with one of a
+// set of GitLab project IDs
+type visibleRepository struct {
+	r   Repository
+	ids map[int]bool
+}
+
+// Visible returns a service which only exports deployments of the projects
+// with the GitLab project IDs
+func (s *Service) Visible(ids []int) *Service {
+
+	v := &visibleRepository{r: s.r, ids: map[int]bool{}}
+	for _, id := range ids {
+		v.ids[id] = true
+	}
+
+	return NewService(v)
+}
+
+func (v *visibleRepository) ExportDeployments(f Filter, fn func(*Row) error) error {
+
+	if f.ProjectID != 0 && !v.ids[f.ProjectID] {
+		return nil
+	}
+
+	// an empty list of project IDs matches every project, so the visible
+	// projects are always listed explicitly
+	visible := []int{}
+	for _, id := range f.ProjectIDs {
+		if v.ids[id] {
+			visible = append(visible, id)
+		}
+	}
+	if len(f.ProjectIDs) == 0 {
+		for id := range v.ids {
+			visible = append(visible, id)
+		}
+		sort.Ints(visible)
+	}
+
+	if len(visible) == 0 {
+		return nil
+	}
+	f.ProjectIDs = visible
+
+	return v.r.ExportDeployments(f, fn)
+}
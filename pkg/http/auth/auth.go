@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/sk000f/metrix/pkg/access"
+	"github.com/sk000f/metrix/pkg/tokens"
+)
+
+// realm is reported to clients which fail authentication
+const realm = "metrix"
+
+// SessionCookie holds the session of a user logged in with their GitLab account
+const SessionCookie = "metrix_session"
+
+// Authenticator checks the API token sent with each request, in an
+// Authorization bearer header or, for clients which cannot set headers such as
+// image proxies and browser websockets, an access_token query parameter.
+// Requests without a token may instead send the session cookie of a logged
+// in user, which only grants the read scope for the projects they can access.
+type Authenticator struct {
+	s *tokens.Service
+	a *access.Service
+}
+
+// NewAuthenticator creates an authenticator checking tokens with the service,
+// and sessions with the access service if it is not nil
+func NewAuthenticator(s *tokens.Service, a *access.Service) *Authenticator {
+	return &Authenticator{s, a}
+}
+
+// Require only passes requests with a valid token granted the scope to next,
+// with the token added to the request context
+func (a *Authenticator) Require(scope tokens.Scope, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		secret := bearer(r)
+		if secret == "" {
+			if c, err := r.Cookie(SessionCookie); err == nil && a.a != nil {
+				a.session(w, r, c.Value, scope, next)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s"`, realm))
+			http.Error(w, "an API token is required", http.StatusUnauthorized)
+			return
+		}
+
+		t, err := a.s.Authenticate(secret)
+		switch err {
+		case nil:
+		case tokens.ErrInvalidToken, tokens.ErrExpiredToken:
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s", error="invalid_token", error_description="%s"`, realm, err))
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		default:
+			fmt.Printf("Error: %v", err.Error())
+			http.Error(w, "could not check API token", http.StatusInternalServerError)
+			return
+		}
+
+		if !t.Allows(scope) {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s", error="insufficient_scope", scope="%s"`, realm, scope))
+			http.Error(w, fmt.Sprintf("API token is missing the %s scope", scope), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(tokens.NewContext(r.Context(), t)))
+	})
+}
+
+// session only passes requests from a logged in user to next, with the
+// request context restricted to the projects the user can access
+func (a *Authenticator) session(w http.ResponseWriter, r *http.Request, id string, scope tokens.Scope, next http.Handler) {
+
+	ss, err := a.a.Session(id)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s", error="invalid_token", error_description="%s"`, realm, err))
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if scope != tokens.ReadMetrics {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s", error="insufficient_scope", scope="%s"`, realm, scope))
+		http.Error(w, fmt.Sprintf("an API token with the %s scope is required", scope), http.StatusForbidden)
+		return
+	}
+
+	ids, err := a.a.ProjectIDs(ss)
+	if err != nil {
+		fmt.Printf("Error: %v", err.Error())
+		http.Error(w, "could not find the projects you can access", http.StatusBadGateway)
+		return
+	}
+
+	next.ServeHTTP(w, r.WithContext(access.NewContext(r.Context(), ids)))
+}
+
+// bearer returns the token sent with the request, or an empty string
+func bearer(r *http.Request) string {
+
+	h := r.Header.Get("Authorization")
+	if len(h) > len("bearer ") && strings.EqualFold(h[:len("bearer ")], "bearer ") {
+		return strings.TrimSpace(h[len("bearer "):])
+	}
+
+	return r.URL.Query().Get("access_token")
+}
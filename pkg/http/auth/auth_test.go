@@ -0,0 +1,235 @@
+package auth_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/sk000f/metrix/pkg/access"
+	"github.com/sk000f/metrix/pkg/http/auth"
+	"github.com/sk000f/metrix/pkg/tokens"
+)
+
+func TestRequire(t *testing.T) {
+
+	s := tokens.NewService(newMockRepo())
+
+	read, _, err := s.Create("dashboard", []tokens.Scope{tokens.ReadMetrics}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, _, err := s.Create("ops", []tokens.Scope{tokens.Admin}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	soon := time.Now().Add(time.Hour)
+	expiring, expiringToken, err := s.Create("old", []tokens.Scope{tokens.ReadMetrics}, &soon)
+	if err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Hour)
+	expiringToken.ExpiresAt = &past
+
+	// the handler reports the name of the token it was called with
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(tokens.FromContext(r.Context()).Name))
+	})
+
+	tests := []struct {
+		name   string
+		scope  tokens.Scope
+		header string
+		query  string
+		code   int
+		body   string
+		error  string
+	}{
+		{
+			name: "pass requests with a bearer token", scope: tokens.ReadMetrics,
+			header: "Bearer " + read, code: http.StatusOK, body: "dashboard",
+		},
+		{
+			name: "pass requests with an access_token parameter", scope: tokens.ReadMetrics,
+			query: "?access_token=" + read, code: http.StatusOK, body: "dashboard",
+		},
+		{
+			name: "pass admin tokens for any scope", scope: tokens.TriggerRefresh,
+			header: "bearer " + admin, code: http.StatusOK, body: "ops",
+		},
+		{
+			name: "reject requests without a token", scope: tokens.ReadMetrics,
+			code: http.StatusUnauthorized,
+		},
+		{
+			name: "reject unknown tokens", scope: tokens.ReadMetrics,
+			header: "Bearer mtx_unknown", code: http.StatusUnauthorized, error: `error="invalid_token"`,
+		},
+		{
+			name: "reject expired tokens", scope: tokens.ReadMetrics,
+			header: "Bearer " + expiring, code: http.StatusUnauthorized, error: `error="invalid_token"`,
+		},
+		{
+			name: "reject tokens without the scope", scope: tokens.TriggerRefresh,
+			header: "Bearer " + read, code: http.StatusForbidden, error: `error="insufficient_scope"`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+
+			h := auth.NewAuthenticator(s, nil).Require(tc.scope, next)
+
+			req := httptest.NewRequest(http.MethodGet, "/metrics"+tc.query, nil)
+			if tc.header != "" {
+				req.Header.Set("Authorization", tc.header)
+			}
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tc.code {
+				t.Errorf("got status %v; wanted %v", rec.Code, tc.code)
+			}
+			if tc.body != "" && rec.Body.String() != tc.body {
+				t.Errorf("got %v; wanted %v", rec.Body.String(), tc.body)
+			}
+			if got := rec.Header().Get("WWW-Authenticate"); !strings.Contains(got, tc.error) {
+				t.Errorf("got WWW-Authenticate %v; wanted it to contain %v", got, tc.error)
+			}
+		})
+	}
+}
+
+func TestRequireSession(t *testing.T) {
+
+	s := tokens.NewService(newMockRepo())
+	read, _, err := s.Create("dashboard", []tokens.Scope{tokens.ReadMetrics}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := access.NewService(new(mockAccessRepo), new(mockAccessRepo))
+	ss, err := a.Login(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "alice-token"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the handler reports the projects the request is restricted to
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ids, ok := access.FromContext(r.Context())
+		fmt.Fprintf(w, "%v %v", ids, ok)
+	})
+
+	tests := []struct {
+		name    string
+		scope   tokens.Scope
+		header  string
+		session string
+		code    int
+		body    string
+	}{
+		{
+			name: "restrict logged in users to their projects", scope: tokens.ReadMetrics,
+			session: ss.ID, code: http.StatusOK, body: "[1 2] true",
+		},
+		{
+			name: "prefer API tokens to sessions", scope: tokens.ReadMetrics,
+			header: "Bearer " + read, session: ss.ID, code: http.StatusOK, body: "[] false",
+		},
+		{
+			name: "only grant logged in users the read scope", scope: tokens.TriggerRefresh,
+			session: ss.ID, code: http.StatusForbidden,
+		},
+		{
+			name: "reject unknown sessions", scope: tokens.ReadMetrics,
+			session: "unknown", code: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+
+			h := auth.NewAuthenticator(s, a).Require(tc.scope, next)
+
+			req := httptest.NewRequest(http.MethodGet, "/graphql", nil)
+			if tc.header != "" {
+				req.Header.Set("Authorization", tc.header)
+			}
+			req.AddCookie(&http.Cookie{Name: auth.SessionCookie, Value: tc.session})
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tc.code {
+				t.Errorf("got status %v; wanted %v", rec.Code, tc.code)
+			}
+			if tc.body != "" && rec.Body.String() != tc.body {
+				t.Errorf("got %v; wanted %v", rec.Body.String(), tc.body)
+			}
+		})
+	}
+
+	t.Run("ignore sessions when GitLab login is disabled", func(t *testing.T) {
+
+		h := auth.NewAuthenticator(s, nil).Require(tokens.ReadMetrics, next)
+
+		req := httptest.NewRequest(http.MethodGet, "/graphql", nil)
+		req.AddCookie(&http.Cookie{Name: auth.SessionCookie, Value: ss.ID})
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("got status %v; wanted %v", rec.Code, http.StatusUnauthorized)
+		}
+	})
+}
+
+type mockAccessRepo struct{}
+
+func (m *mockAccessRepo) CurrentUser(token string) (*access.User, error) {
+	return &access.User{ID: 1, Username: "alice"}, nil
+}
+
+func (m *mockAccessRepo) MemberProjectIDs(token string) ([]int, error) {
+	return []int{2}, nil
+}
+
+func (m *mockAccessRepo) PublicProjectIDs() ([]int, error) {
+	return []int{1}, nil
+}
+
+type mockRepo struct {
+	tokens []*tokens.Token
+}
+
+func newMockRepo() *mockRepo {
+	return &mockRepo{}
+}
+
+func (m *mockRepo) SaveToken(t *tokens.Token) error {
+	m.tokens = append(m.tokens, t)
+	return nil
+}
+
+func (m *mockRepo) GetTokenByHash(hash string) (*tokens.Token, error) {
+	for _, t := range m.tokens {
+		if t.Hash == hash {
+			return t, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *mockRepo) ListTokens() ([]*tokens.Token, error) {
+	return m.tokens, nil
+}
+
+func (m *mockRepo) DeleteToken(id string) (bool, error) {
+	return false, nil
+}
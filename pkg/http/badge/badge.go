@@ -0,0 +1,252 @@
+package badge
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sk000f/metrix/pkg/metrics"
+)
+
+// defaultDays is the number of days a badge is calculated over unless the
+// days query parameter is set
+const defaultDays = 30
+
+// maxAge is how long clients and proxies may cache a badge
+const maxAge = 5 * time.Minute
+
+// colours of each DORA band, matching shields.io
+var colours = map[metrics.Band]string{
+	metrics.Unknown: "#9f9f9f",
+	metrics.Low:     "#e05d44",
+	metrics.Medium:  "#dfb317",
+	metrics.High:    "#97ca00",
+	metrics.Elite:   "#4c1",
+}
+
+// Handler serves SVG badges showing a DORA metric of a project at
+// /badge/{namespace}/{project}/{metric}.svg. Badges are public, so they are
+// only served for projects which are public in GitLab.
+type Handler struct {
+	s *metrics.Service
+	m metrics.FailureMode
+	t *metrics.Thresholds
+}
+
+// badge describes how a metric is shown
+type badge struct {
+	label string
+	value func(s *metrics.Summary) (string, bool)
+	band  func(c *metrics.Classification) metrics.Band
+}
+
+var badges = map[string]badge{
+	"deployment-frequency": {
+		label: "deployments",
+		value: func(s *metrics.Summary) (string, bool) {
+			return frequency(s.DeploymentsPerDay), s.Deployments > 0
+		},
+		band: func(c *metrics.Classification) metrics.Band { return c.DeploymentFrequency },
+	},
+	"lead-time": {
+		label: "lead time",
+		value: func(s *metrics.Summary) (string, bool) {
+			return duration(s.MeanLeadTime), s.LeadTimeDeployments > 0
+		},
+		band: func(c *metrics.Classification) metrics.Band { return c.LeadTime },
+	},
+	"time-to-recover": {
+		label: "time to recover",
+		value: func(s *metrics.Summary) (string, bool) {
+			return duration(s.MeanTimeToRecover), s.Recoveries > 0
+		},
+		band: func(c *metrics.Classification) metrics.Band { return c.TimeToRecover },
+	},
+	"change-failure-rate": {
+		label: "change failure rate",
+		value: func(s *metrics.Summary) (string, bool) {
+			return fmt.Sprintf("%.0f%%", s.ChangeFailureRate*100), s.Deployments > 0
+		},
+		band: func(c *metrics.Classification) metrics.Band { return c.ChangeFailureRate },
+	},
+	"dora": {
+		label: "DORA",
+		value: func(s *metrics.Summary) (string, bool) {
+			return "", s.Deployments > 0
+		},
+		band: func(c *metrics.Classification) metrics.Band { return c.Overall },
+	},
+}
+
+// NewHandler creates a handler serving badges for the metrics calculated by
+// the service, coloured by the band of the thresholds
+func NewHandler(s *metrics.Service, m metrics.FailureMode, t *metrics.Thresholds) *Handler {
+	return &Handler{s, m, t}
+}
+
+// ServeHTTP renders the badge for the metric of the project in the path, over
+// the number of days in the days query parameter
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	namespace, project, metric, ok := parsePath(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	b, ok := badges[metric]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	days := defaultDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "days must be a number of 1 or more", http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+
+	p, err := h.s.PublicProject(namespace, project)
+	if err != nil {
+		fmt.Printf("Error: %v", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// private and internal projects are not found, so their names are not
+	// revealed either
+	if p == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	end := time.Now()
+	f := metrics.Filter{
+		DateRange:   metrics.DateRange{Start: end.AddDate(0, 0, -days), End: end},
+		Namespace:   namespace,
+		ProjectPath: project,
+		ProjectIDs:  []int{p.ID},
+	}
+
+	s, err := h.s.Summarise(f, h.m)
+	if err != nil {
+		fmt.Printf("Error: %v", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	c, err := h.s.Classify(f, h.m, h.t)
+	if err != nil {
+		fmt.Printf("Error: %v", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	value, known := b.value(s)
+	band := b.band(c)
+
+	switch {
+	case !known:
+		value, band = "no data", metrics.Unknown
+	case value == "":
+		value = band.String()
+	case band != metrics.Unknown:
+		value += " " + strings.ToLower(band.String())
+	}
+
+	svg := render(b.label, value, colours[band])
+
+	sum := sha256.Sum256(svg)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds())))
+	w.Header().Set("ETag", etag)
+
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Write(svg)
+}
+
+// parsePath splits /badge/{namespace}/{project}/{metric}.svg, where the
+// namespace may contain subgroups
+func parsePath(p string) (string, string, string, bool) {
+
+	p = strings.TrimPrefix(p, "/badge/")
+	if !strings.HasSuffix(p, ".svg") {
+		return "", "", "", false
+	}
+
+	parts := strings.Split(strings.TrimSuffix(p, ".svg"), "/")
+	if len(parts) < 3 {
+		return "", "", "", false
+	}
+	for _, part := range parts {
+		if part == "" {
+			return "", "", "", false
+		}
+	}
+
+	n := len(parts)
+	return strings.Join(parts[:n-2], "/"), parts[n-2], parts[n-1], true
+}
+
+func frequency(perDay float64) string {
+	switch {
+	case perDay >= 1:
+		return fmt.Sprintf("%.1f/day", perDay)
+	case perDay*7 >= 1:
+		return fmt.Sprintf("%.1f/week", perDay*7)
+	}
+	return fmt.Sprintf("%.1f/month", perDay*30)
+}
+
+func duration(d time.Duration) string {
+	switch {
+	case d < time.Hour:
+		return fmt.Sprintf("%.0fm", d.Minutes())
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%.1fh", d.Hours())
+	}
+	return fmt.Sprintf("%.1fd", d.Hours()/24)
+}
+
+// render draws a flat shields style badge. Text widths are estimated from the
+// average width of Verdana at 11px.
+func render(label, value, colour string) []byte {
+
+	lw := textWidth(label)
+	vw := textWidth(value)
+	w := lw + vw
+
+	label = html.EscapeString(label)
+	value = html.EscapeString(value)
+
+	return []byte(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[4]s: %[5]s">
+<title>%[4]s: %[5]s</title>
+<linearGradient id="s" x2="0" y2="100%%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+<clipPath id="r"><rect width="%[1]d" height="20" rx="3" fill="#fff"/></clipPath>
+<g clip-path="url(#r)"><rect width="%[2]d" height="20" fill="#555"/><rect x="%[2]d" width="%[3]d" height="20" fill="%[6]s"/><rect width="%[1]d" height="20" fill="url(#s)"/></g>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="%[7]d" y="15" fill="#010101" fill-opacity=".3">%[4]s</text><text x="%[7]d" y="14">%[4]s</text>
+<text x="%[8]d" y="15" fill="#010101" fill-opacity=".3">%[5]s</text><text x="%[8]d" y="14">%[5]s</text>
+</g>
+</svg>
+`, w, lw, vw, label, value, colour, lw/2, lw+vw/2))
+}
+
+func textWidth(s string) int {
+	return len([]rune(s))*7 + 10
+}
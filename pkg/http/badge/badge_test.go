@@ -0,0 +1,185 @@
+package badge_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sk000f/metrix/pkg/http/badge"
+	"github.com/sk000f/metrix/pkg/metrics"
+)
+
+func TestBadge(t *testing.T) {
+
+	t.Run("render the change failure rate of a project", func(t *testing.T) {
+
+		r, h := setupHandler(t)
+
+		rec := get(h, "/badge/org/platform/api/change-failure-rate.svg", "")
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("got status %v; wanted %v", rec.Code, http.StatusOK)
+		}
+
+		got := rec.Body.String()
+		for _, want := range []string{"change failure rate", "50% low", "#e05d44"} {
+			if !strings.Contains(got, want) {
+				t.Errorf("got %v; wanted it to contain %q", got, want)
+			}
+		}
+
+		if r.Filter.Namespace != "org/platform" || r.Filter.ProjectPath != "api" {
+			t.Errorf("got filter %+v; wanted namespace org/platform and project api", r.Filter)
+		}
+
+		if got := rec.Header().Get("Content-Type"); got != "image/svg+xml" {
+			t.Errorf("got content type %v", got)
+		}
+		if got := rec.Header().Get("Cache-Control"); got != "public, max-age=300" {
+			t.Errorf("got cache control %v", got)
+		}
+	})
+
+	t.Run("render the overall band", func(t *testing.T) {
+
+		_, h := setupHandler(t)
+
+		rec := get(h, "/badge/org/platform/api/dora.svg", "")
+
+		if got := rec.Body.String(); !strings.Contains(got, ">DORA<") {
+			t.Errorf("got %v; wanted a DORA label", got)
+		}
+	})
+
+	t.Run("calculate over the days requested", func(t *testing.T) {
+
+		r, h := setupHandler(t)
+
+		get(h, "/badge/org/platform/api/lead-time.svg?days=7", "")
+
+		if got := r.Filter.DateRange.End.Sub(r.Filter.DateRange.Start); got != 7*24*time.Hour {
+			t.Errorf("got %v; wanted %v", got, 7*24*time.Hour)
+		}
+	})
+
+	t.Run("render no data in grey", func(t *testing.T) {
+
+		r, h := setupHandler(t)
+		r.DeploymentData = nil
+
+		got := get(h, "/badge/org/api/time-to-recover.svg", "").Body.String()
+
+		for _, want := range []string{"no data", "#9f9f9f"} {
+			if !strings.Contains(got, want) {
+				t.Errorf("got %v; wanted it to contain %q", got, want)
+			}
+		}
+	})
+
+	t.Run("return not modified for a matching etag", func(t *testing.T) {
+
+		_, h := setupHandler(t)
+
+		etag := get(h, "/badge/org/platform/api/deployment-frequency.svg", "").Header().Get("ETag")
+		rec := get(h, "/badge/org/platform/api/deployment-frequency.svg", etag)
+
+		if rec.Code != http.StatusNotModified {
+			t.Errorf("got status %v; wanted %v", rec.Code, http.StatusNotModified)
+		}
+	})
+
+	t.Run("return not found for projects which are not public", func(t *testing.T) {
+
+		r, h := setupHandler(t)
+
+		for _, p := range []string{
+			"/badge/org/platform/billing/lead-time.svg",
+			"/badge/org/platform/wiki/lead-time.svg",
+			"/badge/org/platform/unknown/lead-time.svg",
+		} {
+			if rec := get(h, p, ""); rec.Code != http.StatusNotFound {
+				t.Errorf("got status %v for %v; wanted %v", rec.Code, p, http.StatusNotFound)
+			}
+		}
+
+		if r.Filter.Namespace != "" {
+			t.Errorf("got filter %+v; wanted no deployments to be read", r.Filter)
+		}
+	})
+
+	t.Run("return not found for an unknown metric or path", func(t *testing.T) {
+
+		_, h := setupHandler(t)
+
+		for _, p := range []string{
+			"/badge/org/platform/api/uptime.svg",
+			"/badge/api/lead-time.svg",
+			"/badge/org/platform/api/lead-time.png",
+		} {
+			if rec := get(h, p, ""); rec.Code != http.StatusNotFound {
+				t.Errorf("got status %v for %v; wanted %v", rec.Code, p, http.StatusNotFound)
+			}
+		}
+	})
+}
+
+func setupHandler(t *testing.T) (*mockRepo, *badge.Handler) {
+
+	th, err := metrics.LookupThresholds(metrics.DefaultThresholdsVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failed := time.Now().Add(-48 * time.Hour)
+	recovered := failed.Add(time.Hour)
+
+	r := &mockRepo{ProjectData: []*metrics.Project{
+		{ID: 1, Name: "api", Path: "api", Namespace: "org/platform", Visibility: "public"},
+		{ID: 2, Name: "api", Path: "api", Namespace: "org", Visibility: "public"},
+		{ID: 3, Name: "billing", Path: "billing", Namespace: "org/platform", Visibility: "private"},
+		{ID: 4, Name: "wiki", Path: "wiki", Namespace: "org/platform", Visibility: "internal"},
+	}}
+	r.DeploymentData = []*metrics.Deployment{
+		{
+			ID: 1, Status: "failed", EnvironmentName: "production", ProjectID: 1, ProjectName: "api",
+			ProjectPath: "api", ProjectNamespace: "org/platform", PipelineID: 1, FinishedAt: &failed,
+		},
+		{
+			ID: 2, Status: "success", EnvironmentName: "production", ProjectID: 1, ProjectName: "api",
+			ProjectPath: "api", ProjectNamespace: "org/platform", PipelineID: 2, FinishedAt: &recovered,
+			Commits: []*metrics.Commit{{SHA: "a", CommittedAt: recovered.Add(-30 * time.Minute)}},
+		},
+	}
+
+	return r, badge.NewHandler(metrics.NewService(r), metrics.CountEveryDeployment, th)
+}
+
+func get(h http.Handler, path, etag string) *httptest.ResponseRecorder {
+
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	return rec
+}
+
+type mockRepo struct {
+	Filter         metrics.Filter
+	ProjectData    []*metrics.Project
+	DeploymentData []*metrics.Deployment
+}
+
+func (m *mockRepo) GetProjects() ([]*metrics.Project, error) {
+	return m.ProjectData, nil
+}
+
+func (m *mockRepo) GetDeployments(f metrics.Filter) ([]*metrics.Deployment, error) {
+	m.Filter = f
+	return m.DeploymentData, nil
+}
@@ -0,0 +1,89 @@
+package dashboard
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"strings"
+
+	"github.com/sk000f/metrix/pkg/http/graphql"
+)
+
+// queries are the registered GraphQL operations the dashboard sends by hash,
+// so it also works when only registered queries are allowed
+var queries = []string{"Names", "Metrics", "TimeSeries", "Timeline"}
+
+//go:embed index.html static
+var assets embed.FS
+
+// Handler serves the dashboard page at / and its scripts and styles from
+// /static/. Every asset is embedded so the dashboard works offline.
+type Handler struct {
+	page  []byte
+	files http.Handler
+}
+
+// NewHandler creates a dashboard which queries the GraphQL API with the hashes
+// of the registered queries. When login is set, users without an API token
+// are sent to log in with GitLab.
+func NewHandler(q *graphql.PersistedQueries, login bool) (*Handler, error) {
+
+	hashes := map[string]string{}
+	for _, name := range queries {
+		h, ok := q.Hash(name)
+		if !ok {
+			return nil, fmt.Errorf("dashboard query %v is not registered", name)
+		}
+		hashes[name] = h
+	}
+
+	b, err := json.Marshal(hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := template.ParseFS(assets, "index.html")
+	if err != nil {
+		return nil, err
+	}
+
+	var page bytes.Buffer
+	data := struct {
+		Queries string
+		Login   bool
+	}{string(b), login}
+
+	if err := t.Execute(&page, data); err != nil {
+		return nil, err
+	}
+
+	files, err := fs.Sub(assets, "static")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Handler{
+		page:  page.Bytes(),
+		files: http.StripPrefix("/static/", http.FileServer(http.FS(files))),
+	}, nil
+}
+
+// ServeHTTP serves the page or one of its assets
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	switch {
+	case r.URL.Path == "/" || r.URL.Path == "/index.html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(h.page)
+
+	case strings.HasPrefix(r.URL.Path, "/static/"):
+		h.files.ServeHTTP(w, r)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
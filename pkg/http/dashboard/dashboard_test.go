@@ -0,0 +1,95 @@
+package dashboard_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sk000f/metrix/pkg/http/dashboard"
+	"github.com/sk000f/metrix/pkg/http/graphql"
+)
+
+func TestDashboard(t *testing.T) {
+
+	q, err := graphql.NewPersistedQueries()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := dashboard.NewHandler(q, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("serve the page with the hashes of its queries", func(t *testing.T) {
+
+		rec := get(h, "/")
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("got status %v; wanted %v", rec.Code, http.StatusOK)
+		}
+
+		hash, _ := q.Hash("TimeSeries")
+		if got := rec.Body.String(); !strings.Contains(got, hash) {
+			t.Errorf("got %v; wanted it to contain the TimeSeries hash %v", got, hash)
+		}
+	})
+
+	t.Run("serve embedded assets", func(t *testing.T) {
+
+		for _, p := range []string{"/static/app.js", "/static/style.css"} {
+			if rec := get(h, p); rec.Code != http.StatusOK || rec.Body.Len() == 0 {
+				t.Errorf("got status %v for %v; wanted %v", rec.Code, p, http.StatusOK)
+			}
+		}
+	})
+
+	t.Run("load nothing from outside the binary", func(t *testing.T) {
+
+		for _, p := range []string{"/", "/static/app.js", "/static/style.css"} {
+			if got := get(h, p).Body.String(); strings.Contains(got, "https://") {
+				t.Errorf("got an external URL in %v", p)
+			}
+		}
+	})
+
+	t.Run("return not found for other paths", func(t *testing.T) {
+
+		if rec := get(h, "/favicon.ico"); rec.Code != http.StatusNotFound {
+			t.Errorf("got status %v; wanted %v", rec.Code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("offer GitLab login only when it is enabled", func(t *testing.T) {
+
+		if got := get(h, "/").Body.String(); strings.Contains(got, "data-login") || strings.Contains(got, "/logout") {
+			t.Errorf("got login links without login enabled")
+		}
+
+		lh, err := dashboard.NewHandler(q, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := get(lh, "/").Body.String()
+		if !strings.Contains(got, `data-login="/login"`) || !strings.Contains(got, `action="/logout"`) {
+			t.Errorf("got %v; wanted login and logout links", got)
+		}
+	})
+
+	t.Run("fail without the registered queries", func(t *testing.T) {
+
+		if _, err := dashboard.NewHandler(&graphql.PersistedQueries{}, false); err == nil {
+			t.Errorf("got no error; wanted an error for missing queries")
+		}
+	})
+}
+
+func get(h http.Handler, path string) *httptest.ResponseRecorder {
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+	return rec
+}
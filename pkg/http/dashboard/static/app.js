@@ -0,0 +1,354 @@
+// metrix dashboard. Queries the GraphQL API with the hashes of its registered
+// queries and draws the charts as inline SVG, so nothing is loaded from
+// outside the binary.
+(function () {
+  "use strict";
+
+  var SVG = "http://www.w3.org/2000/svg";
+  var DAY = 24 * 60 * 60 * 1000;
+
+  // most deployments drawn on the timeline
+  var TIMELINE_LIMIT = 500;
+  // deployments listed below the timeline
+  var TABLE_ROWS = 20;
+  // where the API token is saved in the browser
+  var TOKEN_KEY = "metrix.token";
+
+  var queries = JSON.parse(document.body.dataset.queries);
+  // where users log in with GitLab, if they can
+  var login = document.body.dataset.login;
+
+  var group = document.getElementById("group");
+  var project = document.getElementById("project");
+  var days = document.getElementById("days");
+  var error = document.getElementById("error");
+
+  // query sends a registered operation by its hash, with the API token saved
+  // in the browser. When the API rejects it the user is sent to log in with
+  // GitLab, or asked for a token if they cannot.
+  function query(operationName, variables) {
+    var headers = { "Content-Type": "application/json" };
+    var token = localStorage.getItem(TOKEN_KEY);
+    if (token) {
+      headers.Authorization = "Bearer " + token;
+    }
+
+    return fetch("/graphql", {
+      method: "POST",
+      headers: headers,
+      body: JSON.stringify({
+        operationName: operationName,
+        variables: variables || {},
+        extensions: { persistedQuery: { version: 1, sha256Hash: queries[operationName] } },
+      }),
+    })
+      .then(function (res) {
+        if (res.status === 401 && login) {
+          // a saved token would be sent instead of the session cookie
+          localStorage.removeItem(TOKEN_KEY);
+          window.location.href = login;
+          return new Promise(function () {});
+        }
+        if (res.status === 401 || res.status === 403) {
+          return askForToken(res).then(function () {
+            return query(operationName, variables).then(function (data) {
+              return { data: data };
+            });
+          });
+        }
+        return res.json();
+      })
+      .then(function (res) {
+        if (res.errors && res.errors.length) {
+          throw new Error(res.errors.map(function (e) { return e.message; }).join("; "));
+        }
+        return res.data;
+      });
+  }
+
+  // askForToken prompts for a new API token once, however many queries
+  // were rejected at the same time
+  var asking = null;
+  function askForToken(res) {
+    if (!asking) {
+      asking = res.text().then(function (message) {
+        var t = window.prompt(message.trim() + ". Enter an API token with the read scope:");
+        asking = null;
+        if (!t) {
+          throw new Error(message.trim());
+        }
+        localStorage.setItem(TOKEN_KEY, t.trim());
+      });
+    }
+    return asking;
+  }
+
+  function showError(err) {
+    error.textContent = err.message;
+    error.hidden = false;
+  }
+
+  function fillSelect(select, values) {
+    (values || []).filter(Boolean).sort().forEach(function (v) {
+      var o = document.createElement("option");
+      o.value = v;
+      o.textContent = v;
+      select.appendChild(o);
+    });
+  }
+
+  // formatDuration shows seconds in the largest sensible unit
+  function formatDuration(seconds) {
+    if (!seconds) {
+      return "–";
+    }
+    if (seconds < 3600) {
+      return Math.round(seconds / 60) + "m";
+    }
+    if (seconds < 48 * 3600) {
+      return (seconds / 3600).toFixed(1) + "h";
+    }
+    return (seconds / 86400).toFixed(1) + "d";
+  }
+
+  function formatFrequency(perDay) {
+    if (perDay >= 1) {
+      return perDay.toFixed(1) + " / day";
+    }
+    if (perDay * 7 >= 1) {
+      return (perDay * 7).toFixed(1) + " / week";
+    }
+    return (perDay * 30).toFixed(1) + " / month";
+  }
+
+  function formatDate(d) {
+    return d.toISOString().slice(0, 10);
+  }
+
+  function el(name, attrs, text) {
+    var e = document.createElementNS(SVG, name);
+    Object.keys(attrs || {}).forEach(function (k) {
+      e.setAttribute(k, attrs[k]);
+    });
+    if (text !== undefined) {
+      e.textContent = text;
+    }
+    return e;
+  }
+
+  function empty(container) {
+    container.innerHTML = "";
+    var p = document.createElement("p");
+    p.className = "empty";
+    p.textContent = "No deployments in this period";
+    container.appendChild(p);
+  }
+
+  // chart draws the buckets as bars or a line, scaled to the largest value
+  function chart(container, buckets, value, format, bars) {
+
+    if (!buckets.length) {
+      empty(container);
+      return;
+    }
+
+    var w = 300, h = 120, left = 40, bottom = 18, top = 6;
+    var values = buckets.map(value);
+    var max = Math.max.apply(null, values) || 1;
+    var step = (w - left) / buckets.length;
+
+    var svg = el("svg", { viewBox: "0 0 " + w + " " + h, role: "img" });
+
+    [0, 0.5, 1].forEach(function (f) {
+      var y = top + (h - top - bottom) * (1 - f);
+      svg.appendChild(el("line", { class: "grid", x1: left, x2: w, y1: y, y2: y }));
+      svg.appendChild(el("text", { class: "axis", x: left - 4, y: y + 3, "text-anchor": "end" }, format(max * f)));
+    });
+
+    var points = [];
+    buckets.forEach(function (b, i) {
+      var x = left + step * i + step / 2;
+      var y = top + (h - top - bottom) * (1 - values[i] / max);
+      var label = formatDate(new Date(b.start)) + ": " + format(values[i]);
+
+      if (bars) {
+        var bar = el("rect", {
+          class: "bar",
+          x: left + step * i + step * 0.15,
+          y: y,
+          width: step * 0.7,
+          height: h - bottom - y,
+        });
+        bar.appendChild(el("title", {}, label));
+        svg.appendChild(bar);
+      } else {
+        points.push(x + "," + y);
+        var point = el("circle", { class: "point", cx: x, cy: y, r: 2.5 });
+        point.appendChild(el("title", {}, label));
+        svg.appendChild(point);
+      }
+    });
+
+    if (points.length > 1) {
+      svg.insertBefore(el("polyline", { class: "line", points: points.join(" ") }), svg.querySelector("circle"));
+    }
+
+    var first = new Date(buckets[0].start);
+    var last = new Date(buckets[buckets.length - 1].start);
+    svg.appendChild(el("text", { class: "axis", x: left, y: h - 4 }, formatDate(first)));
+    svg.appendChild(el("text", { class: "axis", x: w, y: h - 4, "text-anchor": "end" }, formatDate(last)));
+
+    container.innerHTML = "";
+    container.appendChild(svg);
+  }
+
+  // timeline draws each deployment as a point on a row for its environment,
+  // coloured by status
+  function timeline(container, deployments, start, end) {
+
+    if (!deployments.length) {
+      empty(container);
+      return;
+    }
+
+    var environments = [];
+    deployments.forEach(function (d) {
+      if (environments.indexOf(d.environmentName) < 0) {
+        environments.push(d.environmentName);
+      }
+    });
+    environments.sort();
+
+    var row = 24, left = 110, w = 960, bottom = 18;
+    var h = environments.length * row + bottom;
+    var span = end - start || 1;
+
+    var svg = el("svg", { viewBox: "0 0 " + w + " " + h, role: "img" });
+
+    environments.forEach(function (env, i) {
+      var y = i * row + row / 2;
+      svg.appendChild(el("line", { class: "grid", x1: left, x2: w, y1: y, y2: y }));
+      svg.appendChild(el("text", { class: "axis", x: left - 8, y: y + 3, "text-anchor": "end" }, env));
+    });
+
+    deployments.forEach(function (d) {
+      var t = new Date(d.finishedAt);
+      var x = left + (w - left - 6) * (t - start) / span + 3;
+      var y = environments.indexOf(d.environmentName) * row + row / 2;
+      var status = d.status === "success" || d.status === "failed" ? d.status : "other";
+
+      var point = el("circle", { class: status, cx: x, cy: y, r: 4 });
+      point.appendChild(el("title", {}, d.projectGroupName + "/" + d.projectName + " " + d.status + " " + t.toLocaleString()));
+      svg.appendChild(point);
+    });
+
+    svg.appendChild(el("text", { class: "axis", x: left, y: h - 4 }, formatDate(start)));
+    svg.appendChild(el("text", { class: "axis", x: w, y: h - 4, "text-anchor": "end" }, formatDate(end)));
+
+    container.innerHTML = "";
+    container.appendChild(svg);
+  }
+
+  function table(tbody, deployments) {
+
+    tbody.innerHTML = "";
+
+    deployments.slice(0, TABLE_ROWS).forEach(function (d) {
+      var tr = document.createElement("tr");
+      [
+        new Date(d.finishedAt).toLocaleString(),
+        d.projectGroupName + "/" + d.projectName,
+        d.environmentName,
+        d.status,
+        formatDuration(d.duration),
+      ].forEach(function (v, i) {
+        var td = document.createElement("td");
+        td.textContent = v;
+        if (i === 3) {
+          td.className = d.status;
+        }
+        tr.appendChild(td);
+      });
+      tbody.appendChild(tr);
+    });
+  }
+
+  // deployments pages through the deployments in the filter, newest first
+  function deployments(filter, after, found) {
+    return query("Timeline", { first: 100, after: after, filter: filter }).then(function (data) {
+      var c = data.deployments;
+      found = found.concat(c.edges.map(function (e) { return e.node; }));
+      if (c.pageInfo.hasNextPage && found.length < TIMELINE_LIMIT) {
+        return deployments(filter, c.pageInfo.endCursor, found);
+      }
+      return found;
+    });
+  }
+
+  function interval(n) {
+    if (n <= 31) {
+      return "DAY";
+    }
+    if (n <= 180) {
+      return "WEEK";
+    }
+    return "MONTH";
+  }
+
+  function refresh() {
+
+    error.hidden = true;
+
+    var n = parseInt(days.value, 10);
+    var end = new Date();
+    var start = new Date(end.getTime() - n * DAY);
+
+    var vars = { dateRange: { start: start.toISOString(), end: end.toISOString() } };
+    if (group.value) {
+      vars.groupName = group.value;
+    }
+    if (project.value) {
+      vars.projectName = project.value;
+    }
+
+    Promise.all([
+      query("Metrics", vars),
+      query("TimeSeries", Object.assign({ interval: interval(n) }, vars)),
+      deployments(vars, null, []),
+    ])
+      .then(function (res) {
+        var m = res[0], buckets = res[1].timeSeries, d = res[2];
+
+        document.getElementById("deploymentFrequency").textContent = formatFrequency(m.deploymentFrequency / n);
+        document.getElementById("changeLeadTime").textContent = formatDuration(m.changeLeadTime);
+        document.getElementById("meanTimeToRecover").textContent = formatDuration(m.meanTimeToRecover);
+        document.getElementById("changeFailRate").textContent = m.changeFailRate + "%";
+
+        chart(document.getElementById("deploymentFrequencyChart"), buckets,
+          function (b) { return b.deployments; }, function (v) { return String(Math.round(v)); }, true);
+        chart(document.getElementById("changeLeadTimeChart"), buckets,
+          function (b) { return b.changeLeadTime; }, formatDuration, false);
+        chart(document.getElementById("meanTimeToRecoverChart"), buckets,
+          function (b) { return b.meanTimeToRecover; }, formatDuration, false);
+        chart(document.getElementById("changeFailRateChart"), buckets,
+          function (b) { return b.changeFailRate; }, function (v) { return Math.round(v) + "%"; }, false);
+
+        timeline(document.getElementById("timelineChart"), d, start, end);
+        table(document.getElementById("timelineRows"), d);
+      })
+      .catch(showError);
+  }
+
+  [group, project, days].forEach(function (s) {
+    s.addEventListener("change", refresh);
+  });
+
+  query("Names")
+    .then(function (data) {
+      fillSelect(group, data.allProjectGroupNames);
+      fillSelect(project, data.allProjectNames);
+    })
+    .catch(showError);
+
+  refresh();
+})();
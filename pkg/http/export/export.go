@@ -0,0 +1,132 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sk000f/metrix/pkg/access"
+	"github.com/sk000f/metrix/pkg/exporting"
+)
+
+// Handler streams raw deployments at /export/deployments.{csv,jsonl,json},
+// filtered by the start, end, status, environment, projectID, project and
+// group query parameters
+type Handler struct {
+	s *exporting.Service
+}
+
+// NewHandler creates a handler exporting deployments with the service
+func NewHandler(s *exporting.Service) *Handler {
+	return &Handler{s}
+}
+
+// ServeHTTP writes the matching deployments as they are read from storage
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	name := path.Base(r.URL.Path)
+	ext := path.Ext(name)
+	if strings.TrimSuffix(name, ext) != "deployments" {
+		http.NotFound(w, r)
+		return
+	}
+
+	format, err := exporting.ParseFormat(strings.TrimPrefix(ext, "."))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	f, err := filter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="deployments.%v"`, format))
+
+	s := h.s
+	if ids, ok := access.FromContext(r.Context()); ok {
+		s = s.Visible(ids)
+	}
+
+	cw := &countingWriter{w: w}
+	if err := s.Write(cw, format, f); err != nil {
+		fmt.Printf("Error: %v", err.Error())
+
+		// once rows have been sent the status can no longer change, so the
+		// truncated export is the only sign of the error
+		if cw.n == 0 {
+			w.Header().Del("Content-Disposition")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// filter converts the query parameters into an export filter
+func filter(r *http.Request) (exporting.Filter, error) {
+
+	q := r.URL.Query()
+
+	f := exporting.Filter{
+		Status:      q.Get("status"),
+		Environment: q.Get("environment"),
+		ProjectName: q.Get("project"),
+		GroupName:   q.Get("group"),
+	}
+
+	var err error
+	if f.Start, err = parseDate(q.Get("start")); err != nil {
+		return f, err
+	}
+	if f.End, err = parseDate(q.Get("end")); err != nil {
+		return f, err
+	}
+	if !f.Start.IsZero() && !f.End.IsZero() && f.End.Before(f.Start) {
+		return f, fmt.Errorf("end must not be before start")
+	}
+
+	if v := q.Get("projectID"); v != "" {
+		if f.ProjectID, err = strconv.Atoi(v); err != nil {
+			return f, fmt.Errorf("invalid projectID %q", v)
+		}
+	}
+
+	return f, nil
+}
+
+// parseDate converts a date in RFC 3339 or YYYY-MM-DD format into a time
+func parseDate(s string) (time.Time, error) {
+
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q, expected RFC 3339 or YYYY-MM-DD", s)
+	}
+
+	return t, nil
+}
+
+// countingWriter records how many bytes have been written
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += n
+	return n, err
+}
@@ -0,0 +1,114 @@
+package export_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sk000f/metrix/pkg/exporting"
+	"github.com/sk000f/metrix/pkg/http/export"
+)
+
+func TestExport(t *testing.T) {
+
+	t.Run("stream deployments in the format of the extension", func(t *testing.T) {
+
+		r := &mockRepo{Rows: []*exporting.Row{{DeploymentID: 1, Status: "success", ProjectName: "api"}}}
+
+		rec := get(r, "/export/deployments.jsonl?start=2020-10-01&end=2020-10-31T12:00:00Z&group=org/platform&projectID=1&status=success")
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("got status %v; wanted %v", rec.Code, http.StatusOK)
+		}
+
+		if got := rec.Header().Get("Content-Type"); got != "application/x-ndjson" {
+			t.Errorf("got content type %v", got)
+		}
+		if got := rec.Header().Get("Content-Disposition"); got != `attachment; filename="deployments.jsonl"` {
+			t.Errorf("got content disposition %v", got)
+		}
+
+		if got := rec.Body.String(); !strings.HasPrefix(got, `{"deployment_id":1,"status":"success"`) {
+			t.Errorf("got %v; wanted a JSON line for deployment 1", got)
+		}
+
+		want := exporting.Filter{
+			Start:     time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC),
+			End:       time.Date(2020, 10, 31, 12, 0, 0, 0, time.UTC),
+			Status:    "success",
+			ProjectID: 1,
+			GroupName: "org/platform",
+		}
+		if !reflect.DeepEqual(r.Filter, want) {
+			t.Errorf("got filter %+v; wanted %+v", r.Filter, want)
+		}
+	})
+
+	t.Run("reject invalid filters", func(t *testing.T) {
+
+		for _, p := range []string{
+			"/export/deployments.csv?start=yesterday",
+			"/export/deployments.csv?start=2020-10-31&end=2020-10-01",
+			"/export/deployments.csv?projectID=api",
+		} {
+			if rec := get(&mockRepo{}, p); rec.Code != http.StatusBadRequest {
+				t.Errorf("got status %v for %v; wanted %v", rec.Code, p, http.StatusBadRequest)
+			}
+		}
+	})
+
+	t.Run("return not found for unknown exports", func(t *testing.T) {
+
+		for _, p := range []string{"/export/deployments.xlsx", "/export/projects.csv"} {
+			if rec := get(&mockRepo{}, p); rec.Code != http.StatusNotFound {
+				t.Errorf("got status %v for %v; wanted %v", rec.Code, p, http.StatusNotFound)
+			}
+		}
+	})
+
+	t.Run("return an error before any rows are written", func(t *testing.T) {
+
+		rec := get(&mockRepo{Err: errors.New("connection lost")}, "/export/deployments.jsonl")
+
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("got status %v; wanted %v", rec.Code, http.StatusInternalServerError)
+		}
+	})
+}
+
+func get(r *mockRepo, path string) *httptest.ResponseRecorder {
+
+	h := export.NewHandler(exporting.NewService(r))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+	return rec
+}
+
+type mockRepo struct {
+	Filter exporting.Filter
+	Rows   []*exporting.Row
+	Err    error
+}
+
+func (m *mockRepo) ExportDeployments(f exporting.Filter, fn func(*exporting.Row) error) error {
+
+	m.Filter = f
+
+	if m.Err != nil {
+		return m.Err
+	}
+
+	for _, r := range m.Rows {
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,608 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"github.com/vektah/gqlparser/v2/validator"
+)
+
+// FieldFunc resolves the value of a field on the parent object obj
+type FieldFunc func(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error)
+
+// SubscribeFunc starts a subscription to a field, returning a channel of field
+// values which is closed once ctx is done
+type SubscribeFunc func(ctx context.Context, args map[string]interface{}) (<-chan interface{}, error)
+
+// Executor executes GraphQL requests against a schema. Fields without a
+// registered FieldFunc are resolved from the parent object, either by map key
+// or by case insensitive struct field name.
+type Executor struct {
+	Limits Limits
+
+	schema        *ast.Schema
+	resolvers     map[string]map[string]FieldFunc
+	subscriptions map[string]SubscribeFunc
+}
+
+// Request represents a GraphQL request
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+	Extensions    Extensions             `json:"extensions"`
+
+	// queryOnly rejects mutations, as for requests which must not change data
+	queryOnly bool
+}
+
+// Response represents a GraphQL response
+type Response struct {
+	Data   interface{}   `json:"data"`
+	Errors gqlerror.List `json:"errors,omitempty"`
+}
+
+// NewExecutor creates an executor for the schema, which supports introspection
+func NewExecutor(s *ast.Schema) *Executor {
+
+	e := &Executor{
+		schema:        s,
+		resolvers:     map[string]map[string]FieldFunc{},
+		subscriptions: map[string]SubscribeFunc{},
+	}
+	e.registerIntrospection()
+
+	return e
+}
+
+// Resolve registers the FieldFunc for a field of a type in the schema
+func (e *Executor) Resolve(typeName, fieldName string, f FieldFunc) {
+	if e.resolvers[typeName] == nil {
+		e.resolvers[typeName] = map[string]FieldFunc{}
+	}
+	e.resolvers[typeName][fieldName] = f
+}
+
+// Subscribe registers the SubscribeFunc for a field of the Subscription type
+func (e *Executor) Subscribe(fieldName string, f SubscribeFunc) {
+	e.subscriptions[fieldName] = f
+}
+
+// Execute parses, validates and executes a GraphQL query or mutation
+func (e *Executor) Execute(ctx context.Context, req *Request) *Response {
+
+	ex, errs := e.prepare(req)
+	if errs != nil {
+		return &Response{Errors: errs}
+	}
+
+	var root *ast.Definition
+	switch ex.op.Operation {
+	case ast.Query:
+		root = e.schema.Query
+	case ast.Mutation:
+		root = e.schema.Mutation
+	}
+	if root == nil {
+		return &Response{Errors: gqlerror.List{gqlerror.Errorf("%s operations are not supported", ex.op.Operation)}}
+	}
+	if req.queryOnly && ex.op.Operation != ast.Query {
+		return &Response{Errors: gqlerror.List{gqlerror.Errorf("%s operations must be sent in a POST request", ex.op.Operation)}}
+	}
+
+	if err := ex.checkLimits(root); err != nil {
+		return &Response{Errors: gqlerror.List{err}}
+	}
+
+	data := ex.selectionSet(ctx, ex.op.SelectionSet, root, nil, ast.Path{})
+	if ex.limit != nil {
+		return &Response{Errors: gqlerror.List{ex.limit}}
+	}
+	if data == nil {
+		return &Response{Errors: ex.errors}
+	}
+
+	return &Response{Data: data, Errors: ex.errors}
+}
+
+// ExecuteSubscription parses and validates a GraphQL subscription and returns a
+// channel with a response for every event, which is closed once ctx is done
+func (e *Executor) ExecuteSubscription(ctx context.Context, req *Request) (<-chan *Response, gqlerror.List) {
+
+	ex, errs := e.prepare(req)
+	if errs != nil {
+		return nil, errs
+	}
+
+	root := e.schema.Subscription
+	if ex.op.Operation != ast.Subscription || root == nil {
+		return nil, gqlerror.List{gqlerror.Errorf("%s is not a subscription", ex.op.Operation)}
+	}
+
+	if err := ex.checkLimits(root); err != nil {
+		return nil, gqlerror.List{err}
+	}
+
+	// validation ensures a subscription has exactly one root field
+	cf := ex.collectFields(ex.op.SelectionSet, root, nil, map[string]bool{})[0]
+	f := cf.fields[0]
+
+	sub, ok := e.subscriptions[f.Name]
+	if !ok {
+		return nil, gqlerror.List{gqlerror.Errorf("subscription %s is not supported", f.Name)}
+	}
+
+	events, err := sub(ctx, f.ArgumentMap(ex.vars))
+	if err != nil {
+		return nil, gqlerror.List{gqlerror.WrapPath(ast.Path{ast.PathName(cf.key)}, err)}
+	}
+
+	out := make(chan *Response)
+
+	go func() {
+		defer close(out)
+
+		for ev := range events {
+			run := &execution{e: e, doc: ex.doc, op: ex.op, vars: ex.vars}
+			path := ast.Path{ast.PathName(cf.key)}
+
+			resp := &Response{}
+			if v, ok := run.complete(ctx, f.Definition.Type, cf.fields, ev, path); ok {
+				data := &object{values: map[string]interface{}{}}
+				data.set(cf.key, v)
+				resp.Data = data
+			}
+			resp.Errors = run.errors
+			if run.limit != nil {
+				resp = &Response{Errors: gqlerror.List{run.limit}}
+			}
+
+			select {
+			case out <- resp:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// prepare parses and validates a request, ready for execution
+func (e *Executor) prepare(req *Request) (*execution, gqlerror.List) {
+
+	doc, errs := gqlparser.LoadQuery(e.schema, req.Query)
+	if errs != nil {
+		return nil, errs
+	}
+
+	op := doc.Operations.ForName(req.OperationName)
+	if op == nil {
+		return nil, gqlerror.List{gqlerror.Errorf("operation %q not found", req.OperationName)}
+	}
+
+	vars, err := validator.VariableValues(e.schema, op, req.Variables)
+	if err != nil {
+		return nil, gqlerror.List{err}
+	}
+
+	return &execution{e: e, doc: doc, op: op, vars: vars}, nil
+}
+
+// execution holds the state of a single operation being executed
+type execution struct {
+	e    *Executor
+	doc  *ast.QueryDocument
+	op   *ast.OperationDefinition
+	vars map[string]interface{}
+
+	// guards the fields below, as list items are completed concurrently
+	mu     sync.Mutex
+	errors gqlerror.List
+	items  int
+	limit  *gqlerror.Error
+}
+
+// object is a JSON object which keeps its fields in the order they were selected
+type object struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (o *object) set(k string, v interface{}) {
+	if _, ok := o.values[k]; !ok {
+		o.keys = append(o.keys, k)
+	}
+	o.values[k] = v
+}
+
+// MarshalJSON writes the object fields in selection order
+func (o *object) MarshalJSON() ([]byte, error) {
+
+	var b bytes.Buffer
+	b.WriteByte('{')
+
+	for i, k := range o.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+
+		key, err := json.Marshal(k)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+
+		val, err := json.Marshal(o.values[k])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(val)
+	}
+
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// collectedField groups the fields in a selection set with the same response key
+type collectedField struct {
+	key    string
+	fields []*ast.Field
+}
+
+// selectionSet executes the selection set against obj. It returns nil when a
+// non-null field could not be resolved, so the null propagates to the parent.
+func (ex *execution) selectionSet(ctx context.Context, set ast.SelectionSet, def *ast.Definition, obj interface{}, path ast.Path) *object {
+
+	res := &object{values: map[string]interface{}{}}
+
+	for _, cf := range ex.collectFields(set, def, nil, map[string]bool{}) {
+		fpath := append(append(ast.Path{}, path...), ast.PathName(cf.key))
+
+		v, ok := ex.field(ctx, def, obj, cf.fields, fpath)
+		if !ok {
+			return nil
+		}
+
+		res.set(cf.key, v)
+	}
+
+	return res
+}
+
+// collectFields flattens fragments and skipped fields into the fields to resolve
+func (ex *execution) collectFields(set ast.SelectionSet, def *ast.Definition, fields []*collectedField, visited map[string]bool) []*collectedField {
+
+	for _, sel := range set {
+		switch s := sel.(type) {
+		case *ast.Field:
+			if !ex.included(s.Directives) {
+				continue
+			}
+
+			key := s.Alias
+			if key == "" {
+				key = s.Name
+			}
+
+			found := false
+			for _, cf := range fields {
+				if cf.key == key {
+					cf.fields = append(cf.fields, s)
+					found = true
+				}
+			}
+			if !found {
+				fields = append(fields, &collectedField{key: key, fields: []*ast.Field{s}})
+			}
+
+		case *ast.InlineFragment:
+			if !ex.included(s.Directives) || !applies(s.TypeCondition, def) {
+				continue
+			}
+			fields = ex.collectFields(s.SelectionSet, def, fields, visited)
+
+		case *ast.FragmentSpread:
+			if !ex.included(s.Directives) || visited[s.Name] {
+				continue
+			}
+			visited[s.Name] = true
+
+			f := ex.doc.Fragments.ForName(s.Name)
+			if f == nil || !applies(f.TypeCondition, def) {
+				continue
+			}
+			fields = ex.collectFields(f.SelectionSet, def, fields, visited)
+		}
+	}
+
+	return fields
+}
+
+// included evaluates the @skip and @include directives
+func (ex *execution) included(d ast.DirectiveList) bool {
+
+	if skip := d.ForName("skip"); skip != nil {
+		if v, _ := skip.ArgumentMap(ex.vars)["if"].(bool); v {
+			return false
+		}
+	}
+
+	if include := d.ForName("include"); include != nil {
+		if v, _ := include.ArgumentMap(ex.vars)["if"].(bool); !v {
+			return false
+		}
+	}
+
+	return true
+}
+
+func applies(typeCondition string, def *ast.Definition) bool {
+	return typeCondition == "" || typeCondition == def.Name
+}
+
+// field resolves and completes a single field. It returns false when the field
+// is non-null and could not be resolved.
+func (ex *execution) field(ctx context.Context, def *ast.Definition, obj interface{}, fields []*ast.Field, path ast.Path) (interface{}, bool) {
+
+	f := fields[0]
+
+	// stop resolving once a limit fails the whole execution
+	if ex.limited() {
+		return nil, false
+	}
+
+	if f.Name == "__typename" {
+		return def.Name, true
+	}
+
+	if f.Definition == nil {
+		ex.error(f, path, fmt.Errorf("unknown field %s", f.Name))
+		return nil, false
+	}
+
+	val, err := ex.resolve(ctx, def, f, obj)
+	if err != nil {
+		ex.error(f, path, err)
+		return nil, !f.Definition.Type.NonNull
+	}
+
+	return ex.complete(ctx, f.Definition.Type, fields, val, path)
+}
+
+func (ex *execution) resolve(ctx context.Context, def *ast.Definition, f *ast.Field, obj interface{}) (val interface{}, err error) {
+
+	// a panicking resolver fails the field rather than the server
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("internal error resolving %s.%s: %v", def.Name, f.Name, r)
+		}
+	}()
+
+	if r, ok := ex.e.resolvers[def.Name][f.Name]; ok {
+		return r(ctx, obj, f.ArgumentMap(ex.vars))
+	}
+
+	return defaultResolver(obj, f.Name)
+}
+
+// defaultResolver returns the value of a map key or struct field matching the field name
+func defaultResolver(obj interface{}, name string) (interface{}, error) {
+
+	if m, ok := obj.(map[string]interface{}); ok {
+		return m[name], nil
+	}
+
+	v := reflect.ValueOf(obj)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil, nil
+		}
+		v = v.Elem()
+	}
+
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot resolve %s on %T", name, obj)
+	}
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		if sf.Tag.Get("graphql") == name || strings.EqualFold(sf.Name, name) {
+			return v.Field(i).Interface(), nil
+		}
+	}
+
+	return nil, fmt.Errorf("cannot resolve %s on %T", name, obj)
+}
+
+// complete converts a resolved value into the JSON value for the field type.
+// It returns false when a non-null value is null, so the parent becomes null.
+func (ex *execution) complete(ctx context.Context, typ *ast.Type, fields []*ast.Field, val interface{}, path ast.Path) (interface{}, bool) {
+
+	if typ.NonNull {
+		nullable := *typ
+		nullable.NonNull = false
+
+		v, ok := ex.complete(ctx, &nullable, fields, val, path)
+		if ok && v == nil {
+			ex.error(fields[0], path, fmt.Errorf("must not be null"))
+		}
+		return v, ok && v != nil
+	}
+
+	if isNil(val) {
+		return nil, true
+	}
+
+	if typ.Elem != nil {
+		rv := reflect.ValueOf(val)
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			ex.error(fields[0], path, fmt.Errorf("expected a list but got %T", val))
+			return nil, true
+		}
+
+		if !ex.countItems(rv.Len()) {
+			return nil, false
+		}
+
+		list := make([]interface{}, rv.Len())
+		oks := make([]bool, rv.Len())
+
+		// objects are completed concurrently so their fields can be loaded in batches
+		var wg sync.WaitGroup
+		for i := 0; i < rv.Len(); i++ {
+			ipath := append(append(ast.Path{}, path...), ast.PathIndex(i))
+			item := rv.Index(i).Interface()
+
+			if ex.e.schema.Types[typ.Elem.Name()].Kind != ast.Object {
+				list[i], oks[i] = ex.complete(ctx, typ.Elem, fields, item, ipath)
+				continue
+			}
+
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				list[i], oks[i] = ex.complete(ctx, typ.Elem, fields, item, ipath)
+			}(i)
+		}
+		wg.Wait()
+
+		for _, ok := range oks {
+			if !ok {
+				return nil, true
+			}
+		}
+
+		return list, true
+	}
+
+	def := ex.e.schema.Types[typ.NamedType]
+
+	switch def.Kind {
+	case ast.Scalar:
+		v, err := serializeScalar(def.Name, val)
+		if err != nil {
+			ex.error(fields[0], path, err)
+			return nil, true
+		}
+		return v, true
+
+	case ast.Enum:
+		if s, ok := val.(fmt.Stringer); ok {
+			return strings.ToUpper(s.String()), true
+		}
+		return fmt.Sprint(val), true
+
+	case ast.Object:
+		set := ast.SelectionSet{}
+		for _, f := range fields {
+			set = append(set, f.SelectionSet...)
+		}
+
+		o := ex.selectionSet(ctx, set, def, val, path)
+		if o == nil {
+			return nil, true
+		}
+		return o, true
+	}
+
+	ex.error(fields[0], path, fmt.Errorf("unsupported type %s", def.Name))
+	return nil, true
+}
+
+func (ex *execution) error(f *ast.Field, path ast.Path, err error) {
+
+	e := gqlerror.WrapPath(path, err)
+	if f.Position != nil {
+		e.Locations = []gqlerror.Location{{Line: f.Position.Line, Column: f.Position.Column}}
+	}
+
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+
+	ex.errors = append(ex.errors, e)
+}
+
+func isNil(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface:
+		return rv.IsNil()
+	}
+	return false
+}
+
+// serializeScalar converts a resolved value into the JSON value of a scalar type
+func serializeScalar(name string, val interface{}) (interface{}, error) {
+
+	rv := reflect.ValueOf(val)
+	for rv.Kind() == reflect.Ptr {
+		rv = rv.Elem()
+	}
+
+	switch name {
+	case "Int":
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return rv.Int(), nil
+		case reflect.Float32, reflect.Float64:
+			return int64(math.Round(rv.Float())), nil
+		}
+
+	case "Float":
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return float64(rv.Int()), nil
+		case reflect.Float32, reflect.Float64:
+			return rv.Float(), nil
+		}
+
+	case "String", "ID":
+		if s, ok := val.(fmt.Stringer); ok {
+			return s.String(), nil
+		}
+		switch rv.Kind() {
+		case reflect.String:
+			return rv.String(), nil
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return strconv.FormatInt(rv.Int(), 10), nil
+		}
+
+	case "Boolean":
+		if rv.Kind() == reflect.Bool {
+			return rv.Bool(), nil
+		}
+
+	case "DateTime":
+		if t, ok := rv.Interface().(time.Time); ok {
+			return t.UTC().Format(time.RFC3339Nano), nil
+		}
+		if rv.Kind() == reflect.String {
+			return rv.String(), nil
+		}
+
+	default:
+		return val, nil
+	}
+
+	return nil, fmt.Errorf("cannot use %T as %s", val, name)
+}
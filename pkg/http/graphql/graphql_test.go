@@ -0,0 +1,1232 @@
+package graphql_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/sk000f/metrix/pkg/access"
+	"github.com/sk000f/metrix/pkg/collector"
+	"github.com/sk000f/metrix/pkg/events"
+	"github.com/sk000f/metrix/pkg/http/auth"
+	"github.com/sk000f/metrix/pkg/http/graphql"
+	"github.com/sk000f/metrix/pkg/listing"
+	"github.com/sk000f/metrix/pkg/metrics"
+	"github.com/sk000f/metrix/pkg/tokens"
+)
+
+func TestGraphQLQueries(t *testing.T) {
+	t.Run("list project and group names", func(t *testing.T) {
+
+		server := setupServer(t, newMockRepo(t))
+		defer server.Close()
+
+		got := post(t, server, `{ allProjectNames allProjectGroupNames }`, nil)
+
+		want := `{"data":{"allProjectNames":["api","site"],"allProjectGroupNames":["org","org/marketing","org/platform"]}}`
+
+		if got != want {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+	})
+
+	t.Run("only use projects the user can access", func(t *testing.T) {
+
+		r := newMockRepo(t)
+		s := newServer(t, &graphql.Resolver{
+			Metrics: metrics.NewService(r),
+			Listing: listing.NewService(r),
+		}, graphql.Limits{})
+
+		// the user logged in with GitLab can only access the site project
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			s.ServeHTTP(w, req.WithContext(access.NewContext(req.Context(), []int{2})))
+		}))
+		defer server.Close()
+
+		got := post(t, server, `{
+			allProjectNames
+			deploymentFrequency(dateRange: {start: "2020-10-01", end: "2020-10-31"})
+			deployments(first: 10) { pageInfo { hasNextPage } }
+		}`, nil)
+
+		want := `{"data":{"allProjectNames":["site"],"deploymentFrequency":0,"deployments":{"pageInfo":{"hasNextPage":false}}}}`
+
+		if got != want {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+	})
+
+	t.Run("calculate metrics with variables", func(t *testing.T) {
+
+		server := setupServer(t, newMockRepo(t))
+		defer server.Close()
+
+		query := `query Metrics($range: DateRange) {
+			deploymentFrequency(dateRange: $range, projectName: "api")
+			changeFailRate(dateRange: $range)
+			meanTimeToRecover(dateRange: $range)
+		}`
+
+		vars := map[string]interface{}{
+			"range": map[string]interface{}{"start": "2020-10-01", "end": "2020-10-31T00:00:00Z"},
+		}
+
+		got := post(t, server, query, vars)
+
+		want := `{"data":{"deploymentFrequency":3,"changeFailRate":33,"meanTimeToRecover":3600}}`
+
+		if got != want {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+	})
+
+	t.Run("list deployments using fragments and aliases over GET", func(t *testing.T) {
+
+		server := setupServer(t, newMockRepo(t))
+		defer server.Close()
+
+		query := `{
+			latest: deployments(filter: {groupName: "org/platform"}) { edges { node { ...fields } } }
+		}
+		fragment fields on Deployment { deploymentID status projectGroupName finishedAt }`
+
+		resp, err := http.Get(server.URL + "/graphql?query=" + url.QueryEscape(query))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		var got struct {
+			Data struct {
+				Latest struct {
+					Edges []struct {
+						Node map[string]interface{}
+					}
+				}
+			}
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+
+		want := map[string]interface{}{
+			"deploymentID":     float64(3),
+			"status":           "success",
+			"projectGroupName": "org/platform",
+			"finishedAt":       "2020-10-01T11:00:00Z",
+		}
+
+		edges := got.Data.Latest.Edges
+		if len(edges) != 3 || !reflect.DeepEqual(edges[0].Node, want) {
+			t.Errorf("got %+v; wanted first deployment %+v", edges, want)
+		}
+	})
+
+	t.Run("page through deployments with cursors", func(t *testing.T) {
+
+		server := setupServer(t, newMockRepo(t))
+		defer server.Close()
+
+		query := `query Page($after: String) {
+			deployments(first: 2, after: $after) {
+				edges { node { deploymentID } }
+				pageInfo { hasNextPage hasPreviousPage endCursor }
+			}
+		}`
+
+		type page struct {
+			Data struct {
+				Deployments struct {
+					Edges []struct {
+						Node struct{ DeploymentID int }
+					}
+					PageInfo struct {
+						HasNextPage     bool
+						HasPreviousPage bool
+						EndCursor       string
+					}
+				}
+			}
+		}
+
+		ids := []int{}
+		after := ""
+		for i := 0; i < 2; i++ {
+			var got page
+			vars := map[string]interface{}{}
+			if after != "" {
+				vars["after"] = after
+			}
+			if err := json.Unmarshal([]byte(post(t, server, query, vars)), &got); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, e := range got.Data.Deployments.Edges {
+				ids = append(ids, e.Node.DeploymentID)
+			}
+
+			info := got.Data.Deployments.PageInfo
+			if info.HasNextPage != (i == 0) || info.HasPreviousPage != (i == 1) {
+				t.Errorf("got page info %+v for page %v", info, i+1)
+			}
+			after = info.EndCursor
+		}
+
+		if !reflect.DeepEqual(ids, []int{3, 2, 1}) {
+			t.Errorf("got deployments %v; wanted %v", ids, []int{3, 2, 1})
+		}
+	})
+
+	t.Run("reject invalid cursors", func(t *testing.T) {
+
+		server := setupServer(t, newMockRepo(t))
+		defer server.Close()
+
+		got := post(t, server, `{ projects(after: "bogus") { edges { cursor } } }`, nil)
+
+		want := `{"data":null,"errors":[{"message":"invalid cursor","path":["projects"],"locations":[{"line":1,"column":3}]}]}`
+
+		if got != want {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+	})
+
+	t.Run("compare metrics with previous period", func(t *testing.T) {
+
+		server := setupServer(t, newMockRepo(t))
+		defer server.Close()
+
+		query := `{
+			compareMetrics(dateRange: {start: "2020-10-01", end: "2020-10-31"}) {
+				previous { start }
+				groups { groupName comparison { deploymentFrequency { direction percent } } }
+			}
+		}`
+
+		got := post(t, server, query, nil)
+
+		want := `{"data":{"compareMetrics":{"previous":{"start":"2020-08-31T00:00:00Z"},` +
+			`"groups":[{"groupName":"org/marketing","comparison":{"deploymentFrequency":{"direction":"UNCHANGED","percent":null}}},` +
+			`{"groupName":"org/platform","comparison":{"deploymentFrequency":{"direction":"IMPROVED","percent":null}}}]}}}`
+
+		if got != want {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+	})
+
+	t.Run("calculate metrics for each week", func(t *testing.T) {
+
+		server := setupServer(t, newMockRepo(t))
+		defer server.Close()
+
+		query := `{
+			timeSeries(dateRange: {start: "2020-10-01", end: "2020-10-07"}, interval: WEEK) {
+				start deployments failures recoveries meanTimeToRecover
+			}
+		}`
+
+		got := post(t, server, query, nil)
+
+		want := `{"data":{"timeSeries":[` +
+			`{"start":"2020-09-28T00:00:00Z","deployments":3,"failures":1,"recoveries":1,"meanTimeToRecover":3600},` +
+			`{"start":"2020-10-05T00:00:00Z","deployments":0,"failures":0,"recoveries":0,"meanTimeToRecover":0}]}}`
+
+		if got != want {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+	})
+
+	t.Run("classify metrics into DORA bands", func(t *testing.T) {
+
+		server := setupServer(t, newMockRepo(t))
+		defer server.Close()
+
+		query := `{
+			classification(dateRange: {start: "2020-10-01", end: "2020-10-31"}) { version changeFailRate overall }
+			classifications(dateRange: {start: "2020-10-01", end: "2020-10-31"}) {
+				projects { name classification { deploymentFrequency } }
+				groups { groupName }
+			}
+		}`
+
+		got := post(t, server, query, nil)
+
+		want := `{"data":{"classification":{"version":"2021","changeFailRate":"LOW","overall":"LOW"},` +
+			`"classifications":{"projects":[{"name":"api","classification":{"deploymentFrequency":"MEDIUM"}}],` +
+			`"groups":[{"groupName":"org/platform"}]}}}`
+
+		if got != want {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+	})
+
+	t.Run("return validation errors", func(t *testing.T) {
+
+		server := setupServer(t, newMockRepo(t))
+		defer server.Close()
+
+		got := post(t, server, `{ unknownField }`, nil)
+
+		want := `{"data":null,"errors":[{"message":"Cannot query field \"unknownField\" on type \"Query\".",` +
+			`"locations":[{"line":1,"column":3}]}]}`
+
+		if got != want {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+	})
+
+	t.Run("introspect the schema", func(t *testing.T) {
+
+		server := setupServer(t, newMockRepo(t))
+		defer server.Close()
+
+		query := `{
+			__schema { queryType { name } subscriptionType { name } }
+			__type(name: "MetricDelta") { kind fields { name type { kind name ofType { kind name } } } }
+			direction: __type(name: "Direction") { enumValues { name } }
+			unknown: __type(name: "Unknown") { name }
+		}`
+
+		got := post(t, server, query, nil)
+
+		want := `{"data":{"__schema":{"queryType":{"name":"Query"},"subscriptionType":{"name":"Subscription"}},` +
+			`"__type":{"kind":"OBJECT","fields":[` +
+			`{"name":"current","type":{"kind":"NON_NULL","name":null,"ofType":{"kind":"SCALAR","name":"Float"}}},` +
+			`{"name":"previous","type":{"kind":"NON_NULL","name":null,"ofType":{"kind":"SCALAR","name":"Float"}}},` +
+			`{"name":"absolute","type":{"kind":"NON_NULL","name":null,"ofType":{"kind":"SCALAR","name":"Float"}}},` +
+			`{"name":"percent","type":{"kind":"SCALAR","name":"Float","ofType":null}},` +
+			`{"name":"direction","type":{"kind":"NON_NULL","name":null,"ofType":{"kind":"ENUM","name":"Direction"}}}]},` +
+			`"direction":{"enumValues":[{"name":"IMPROVED"},{"name":"WORSENED"},{"name":"UNCHANGED"},{"name":"UNKNOWN"}]},` +
+			`"unknown":null}}`
+
+		if got != want {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+	})
+
+	t.Run("reject mutations over GET", func(t *testing.T) {
+
+		server := setupServer(t, newMockRepo(t))
+		defer server.Close()
+
+		resp, err := http.Get(server.URL + "/graphql?query=" + url.QueryEscape(`mutation { revokeAPIToken(id: "1") }`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := `{"data":null,"errors":[{"message":"mutation operations must be sent in a POST request"}]}`
+
+		if got := strings.TrimSpace(string(b)); got != want {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+	})
+
+	t.Run("null non-null fields when resolver fails", func(t *testing.T) {
+
+		r := newMockRepo(t)
+		r.err = errors.New("database unavailable")
+
+		server := setupServer(t, r)
+		defer server.Close()
+
+		got := post(t, server, `{ deploymentFrequency }`, nil)
+
+		want := `{"data":null,"errors":[{"message":"database unavailable","path":["deploymentFrequency"],` +
+			`"locations":[{"line":1,"column":3}]}]}`
+
+		if got != want {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+	})
+}
+
+func TestGraphQLRelations(t *testing.T) {
+
+	t.Run("load project deployments and deployment projects in batches", func(t *testing.T) {
+
+		r := newMockRepo(t)
+
+		server := setupServer(t, r)
+		defer server.Close()
+
+		got := post(t, server, `{ projects { edges { node {
+			name deployments(first: 2) { deploymentID project { name } }
+		} } } }`, nil)
+
+		want := `{"data":{"projects":{"edges":[` +
+			`{"node":{"name":"api","deployments":[{"deploymentID":3,"project":{"name":"api"}},{"deploymentID":2,"project":{"name":"api"}}]}},` +
+			`{"node":{"name":"site","deployments":[]}}]}}}`
+
+		if got != want {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+
+		wantCalls := map[string]int{"ListDeployments": 1, "ListProjectsByID": 1}
+		if !reflect.DeepEqual(r.calls, wantCalls) {
+			t.Errorf("got calls %v; wanted %v", r.calls, wantCalls)
+		}
+	})
+
+	t.Run("look up a deployment by ID", func(t *testing.T) {
+
+		server := setupServer(t, newMockRepo(t))
+		defer server.Close()
+
+		got := post(t, server, `{ found: deployment(deploymentID: 2) { status } missing: deployment(deploymentID: 9) { status } }`, nil)
+		want := `{"data":{"found":{"status":"failed"},"missing":null}}`
+
+		if got != want {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+	})
+}
+
+func TestLoader(t *testing.T) {
+
+	t.Run("fetch concurrent loads in one batch and cache the values", func(t *testing.T) {
+
+		var mu sync.Mutex
+		batches := [][]int{}
+
+		l := graphql.NewLoader(func(keys []int) (map[int]interface{}, error) {
+			mu.Lock()
+			batches = append(batches, append([]int{}, keys...))
+			mu.Unlock()
+
+			v := map[int]interface{}{}
+			for _, k := range keys {
+				if k != 3 {
+					v[k] = k * 10
+				}
+			}
+			return v, nil
+		})
+
+		got := make([]interface{}, 4)
+
+		var wg sync.WaitGroup
+		for i, k := range []int{1, 2, 3, 1} {
+			wg.Add(1)
+			go func(i, k int) {
+				defer wg.Done()
+				v, err := l.Load(k)
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err.Error())
+				}
+				got[i] = v
+			}(i, k)
+		}
+		wg.Wait()
+
+		if v, _ := l.Load(2); v != 20 {
+			t.Errorf("got cached value %v; wanted 20", v)
+		}
+
+		want := []interface{}{10, 20, nil, 10}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+
+		if len(batches) != 1 || len(batches[0]) != 3 {
+			t.Errorf("got batches %v; wanted one batch of three keys", batches)
+		}
+	})
+}
+
+func TestGraphQLLimits(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		limits graphql.Limits
+		query  string
+		want   string
+	}{
+		{
+			name:   "reject queries nested too deeply",
+			limits: graphql.Limits{MaxDepth: 3},
+			query:  `{ namespaceRollup { groups { groups { name } } } }`,
+			want: `{"data":null,"errors":[{"message":"query depth 4 exceeds the limit of 3",` +
+				`"extensions":{"code":"MAX_DEPTH_EXCEEDED","limit":3,"value":4}}]}`,
+		},
+		{
+			name:   "reject queries which cost too much",
+			limits: graphql.Limits{MaxCost: 100},
+			query:  `{ deployments(first: 50) { edges { node { deploymentID } } pageInfo { hasNextPage } } }`,
+			want: `{"data":null,"errors":[{"message":"query cost 153 exceeds the limit of 100",` +
+				`"extensions":{"code":"MAX_COST_EXCEEDED","limit":100,"value":153}}]}`,
+		},
+		{
+			name:   "reject responses which are too large",
+			limits: graphql.Limits{MaxResultSize: 2},
+			query:  `{ deployments(first: 3) { edges { node { deploymentID } } } }`,
+			want: `{"data":null,"errors":[{"message":"query result size 3 exceeds the limit of 2",` +
+				`"extensions":{"code":"MAX_RESULT_SIZE_EXCEEDED","limit":2,"value":3}}]}`,
+		},
+		{
+			name:   "introspect without limits",
+			limits: graphql.Limits{MaxDepth: 2, MaxCost: 1},
+			query:  `{ __type(name: "Interval") { fields { type { ofType { ofType { name } } } } enumValues { name } } }`,
+			want:   `{"data":{"__type":{"fields":null,"enumValues":[{"name":"DAY"},{"name":"WEEK"},{"name":"MONTH"}]}}}`,
+		},
+		{
+			name:   "execute queries within limits",
+			limits: graphql.Limits{MaxDepth: 4, MaxCost: 153, MaxResultSize: 3},
+			query:  `{ deployments(first: 3) { edges { node { deploymentID } } } }`,
+			want: `{"data":{"deployments":{"edges":[{"node":{"deploymentID":3}},{"node":{"deploymentID":2}},` +
+				`{"node":{"deploymentID":1}}]}}}`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+
+			r := newMockRepo(t)
+
+			server := setupLimitedServer(t, &graphql.Resolver{
+				Metrics: metrics.NewService(r),
+				Listing: listing.NewService(r),
+			}, tc.limits)
+			defer server.Close()
+
+			if got := post(t, server, tc.query, nil); got != tc.want {
+				t.Errorf("got %v; wanted %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestGraphQLPersistedQueries(t *testing.T) {
+
+	notFound := `{"data":null,"errors":[{"message":"PersistedQueryNotFound","extensions":{"code":"PERSISTED_QUERY_NOT_FOUND"}}]}`
+
+	t.Run("cache queries sent with their hash", func(t *testing.T) {
+
+		server := setupServer(t, newMockRepo(t))
+		defer server.Close()
+
+		query := `{ allProjectNames }`
+		want := `{"data":{"allProjectNames":["api","site"]}}`
+
+		if got := postRequest(t, server, map[string]interface{}{"extensions": persisted(sha(query))}); got != notFound {
+			t.Errorf("got %v; wanted %v", got, notFound)
+		}
+
+		if got := postRequest(t, server, map[string]interface{}{"query": query, "extensions": persisted(sha(query))}); got != want {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+
+		if got := postRequest(t, server, map[string]interface{}{"extensions": persisted(sha(query))}); got != want {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+	})
+
+	t.Run("reject queries which do not match their hash", func(t *testing.T) {
+
+		server := setupServer(t, newMockRepo(t))
+		defer server.Close()
+
+		got := postRequest(t, server, map[string]interface{}{"query": `{ allProjectNames }`, "extensions": persisted(sha("{}"))})
+		want := `{"data":null,"errors":[{"message":"provided sha256Hash does not match query",` +
+			`"extensions":{"code":"PERSISTED_QUERY_HASH_MISMATCH"}}]}`
+
+		if got != want {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+	})
+
+	t.Run("only run registered queries in strict mode", func(t *testing.T) {
+
+		r := newMockRepo(t)
+		s := newServer(t, &graphql.Resolver{Metrics: metrics.NewService(r), Listing: listing.NewService(r)}, graphql.Limits{})
+		s.Queries.Strict = true
+
+		registered := "query Registered { allProjectGroupNames }\n"
+		s.Queries.Register(registered)
+
+		server := serve(s)
+		defer server.Close()
+
+		want := `{"data":{"allProjectGroupNames":["org","org/marketing","org/platform"]}}`
+
+		if got := postRequest(t, server, map[string]interface{}{"extensions": persisted(sha(registered))}); got != want {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+
+		if got := post(t, server, "query Registered { allProjectGroupNames }", nil); got != want {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+
+		query := `{ allProjectNames }`
+		rejected := `{"data":null,"errors":[{"message":"query is not registered","extensions":{"code":"QUERY_NOT_ALLOWED"}}]}`
+
+		if got := postRequest(t, server, map[string]interface{}{"query": query, "extensions": persisted(sha(query))}); got != rejected {
+			t.Errorf("got %v; wanted %v", got, rejected)
+		}
+
+		if got := postRequest(t, server, map[string]interface{}{"extensions": persisted(sha(query))}); got != notFound {
+			t.Errorf("got %v; wanted %v", got, notFound)
+		}
+	})
+
+	t.Run("register valid queries from the queries directory", func(t *testing.T) {
+
+		server := setupServer(t, newMockRepo(t))
+		defer server.Close()
+
+		files, err := filepath.Glob("queries/*.graphql")
+		if err != nil || len(files) == 0 {
+			t.Fatalf("expected registered queries: %v", err)
+		}
+
+		for _, f := range files {
+			b, err := ioutil.ReadFile(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := postRequest(t, server, map[string]interface{}{"extensions": persisted(sha(string(b)))})
+			if strings.Contains(got, `"errors"`) {
+				t.Errorf("got %v for %v; wanted no errors", got, f)
+			}
+		}
+	})
+}
+
+func TestGraphQLCollectionRuns(t *testing.T) {
+
+	t.Run("start a collection run and report its progress", func(t *testing.T) {
+
+		r := newMockRepo(t)
+		ci := new(mockCI)
+
+		server := setupResolverServer(t, &graphql.Resolver{
+			Metrics:   metrics.NewService(r),
+			Listing:   listing.NewService(r),
+			Collector: collector.NewService(ci, new(mockCollectorRepo)),
+		})
+		defer server.Close()
+
+		var started struct {
+			Data struct{ RefreshData string }
+		}
+		got := post(t, server, `mutation { refreshData(projectID: 1, since: "2020-10-01", fullResync: true) }`, nil)
+		if err := json.Unmarshal([]byte(got), &started); err != nil || started.Data.RefreshData == "" {
+			t.Fatalf("got %v; wanted a run ID", got)
+		}
+
+		query := `query ($id: ID!) { collectionRun(id: $id) {
+			projectID since fullResync running projects projectsDone deploymentsSaved errors { projectID projectName message } error
+		} }`
+
+		want := `{"data":{"collectionRun":{"projectID":1,"since":"2020-10-01T00:00:00Z","fullResync":true,"running":false,"projects":1,` +
+			`"projectsDone":1,"deploymentsSaved":0,"errors":[{"projectID":1,"projectName":"api","message":"not found"}],"error":null}}}`
+
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if got = post(t, server, query, map[string]interface{}{"id": started.Data.RefreshData}); got == want {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		if got != want {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+	})
+
+	t.Run("return null for unknown run", func(t *testing.T) {
+
+		server := setupResolverServer(t, &graphql.Resolver{
+			Collector: collector.NewService(new(mockCI), new(mockCollectorRepo)),
+		})
+		defer server.Close()
+
+		got := post(t, server, `{ collectionRun(id: "unknown") { id } }`, nil)
+		want := `{"data":{"collectionRun":null}}`
+
+		if got != want {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+	})
+}
+
+func TestGraphQLTokens(t *testing.T) {
+
+	tk := tokens.NewService(new(mockTokenRepo))
+
+	admin, _, err := tk.Create("ops", []tokens.Scope{tokens.Admin}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, _, err := tk.Create("dashboard", []tokens.Scope{tokens.ReadMetrics}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newServer(t, &graphql.Resolver{
+		Collector: collector.NewService(new(mockCI), new(mockCollectorRepo)),
+		Tokens:    tk,
+	}, graphql.Limits{})
+
+	mux := http.NewServeMux()
+	mux.Handle("/graphql", auth.NewAuthenticator(tk, nil).Require(tokens.ReadMetrics, s))
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	t.Run("create, list and revoke tokens with an admin token", func(t *testing.T) {
+
+		var created struct {
+			Data struct {
+				CreateAPIToken struct {
+					Token    string
+					APIToken struct {
+						ID     string
+						Name   string
+						Scopes []string
+					}
+				}
+			}
+		}
+		got := postAs(t, server, admin, `mutation { createAPIToken(name: "ci", scopes: [READ, REFRESH]) { token apiToken { id name scopes } } }`)
+		if err := json.Unmarshal([]byte(got), &created); err != nil {
+			t.Fatal(err)
+		}
+
+		c := created.Data.CreateAPIToken
+		if !strings.HasPrefix(c.Token, "mtx_") || c.APIToken.Name != "ci" || !reflect.DeepEqual(c.APIToken.Scopes, []string{"READ", "REFRESH"}) {
+			t.Fatalf("got %v; wanted a new READ and REFRESH token", got)
+		}
+
+		got = postAs(t, server, c.Token, `{ apiTokens { name } }`)
+		if !strings.Contains(got, "API token is missing the ADMIN scope") {
+			t.Errorf("got %v; wanted a scope error", got)
+		}
+
+		got = postAs(t, server, admin, `{ apiTokens { name scopes expiresAt } }`)
+		want := `{"data":{"apiTokens":[{"name":"ops","scopes":["ADMIN"],"expiresAt":null},` +
+			`{"name":"dashboard","scopes":["READ"],"expiresAt":null},{"name":"ci","scopes":["READ","REFRESH"],"expiresAt":null}]}}`
+		if got != want {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+
+		got = postAs(t, server, admin, fmt.Sprintf(`mutation { revokeAPIToken(id: %q) }`, c.APIToken.ID))
+		want = `{"data":{"revokeAPIToken":true}}`
+		if got != want {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+
+		if _, err := tk.Authenticate(c.Token); err != tokens.ErrInvalidToken {
+			t.Errorf("got %v; wanted %v", err, tokens.ErrInvalidToken)
+		}
+	})
+
+	t.Run("require the refresh scope to refresh data", func(t *testing.T) {
+
+		got := postAs(t, server, read, `mutation { refreshData }`)
+		if !strings.Contains(got, "API token is missing the REFRESH scope") {
+			t.Errorf("got %v; wanted a scope error", got)
+		}
+
+		got = postAs(t, server, admin, `mutation { refreshData }`)
+		if strings.Contains(got, "errors") {
+			t.Errorf("got %v; wanted a run ID", got)
+		}
+	})
+}
+
+func TestGraphQLSubscriptions(t *testing.T) {
+
+	t.Run("push saved deployments matching the subscription", func(t *testing.T) {
+
+		r := newMockRepo(t)
+		b := events.NewBroker()
+
+		server := setupSubscriptionServer(t, r, b)
+		defer server.Close()
+
+		ws := dial(t, server)
+		defer ws.Close()
+
+		send(t, ws, map[string]interface{}{"type": "connection_init"})
+		if got := receive(t, ws); got != `{"type":"connection_ack"}` {
+			t.Errorf("got %v; wanted connection_ack", got)
+		}
+
+		send(t, ws, map[string]interface{}{
+			"id":      "1",
+			"type":    "start",
+			"payload": map[string]interface{}{"query": `subscription { deploymentSaved(groupName: "org") { deploymentID status } }`},
+		})
+
+		// wait for the subscription to start before publishing
+		waitForSubscriber(t, b)
+
+		b.Publish(&collector.Deployment{ID: 3, ProjectName: "other", ProjectNamespace: "elsewhere"})
+		b.Publish(&collector.Deployment{ID: 2, ProjectName: "api", ProjectNamespace: "org/platform"})
+
+		got := receive(t, ws)
+		want := `{"id":"1","type":"data","payload":{"data":{"deploymentSaved":{"deploymentID":2,"status":"failed"}}}}`
+
+		if got != want {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+	})
+
+	t.Run("send current metrics then recalculate on each deployment", func(t *testing.T) {
+
+		r := newMockRepo(t)
+		b := events.NewBroker()
+
+		server := setupSubscriptionServer(t, r, b)
+		defer server.Close()
+
+		ws := dial(t, server)
+		defer ws.Close()
+
+		send(t, ws, map[string]interface{}{"type": "connection_init"})
+		receive(t, ws)
+
+		send(t, ws, map[string]interface{}{
+			"id":      "1",
+			"type":    "start",
+			"payload": map[string]interface{}{"query": `subscription { metricsUpdated(projectName: "api") { deployments } }`},
+		})
+
+		// the existing deployments are older than the default 30 days
+		want := `{"id":"1","type":"data","payload":{"data":{"metricsUpdated":{"deployments":0}}}}`
+		if got := receive(t, ws); got != want {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+
+		ts := time.Now()
+		r.deployments = append(r.deployments, &listing.Deployment{
+			ID: "e", DeploymentID: 4, Status: "success", EnvironmentName: "production",
+			ProjectID: 1, ProjectName: "api", ProjectNamespace: "org/platform", FinishedAt: &ts,
+		})
+		b.Publish(&collector.Deployment{ID: 4, ProjectName: "api", ProjectNamespace: "org/platform"})
+
+		want = `{"id":"1","type":"data","payload":{"data":{"metricsUpdated":{"deployments":1}}}}`
+		if got := receive(t, ws); got != want {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+
+		send(t, ws, map[string]interface{}{"id": "1", "type": "stop"})
+	})
+
+	t.Run("reject queries sent as subscriptions", func(t *testing.T) {
+
+		server := setupSubscriptionServer(t, newMockRepo(t), events.NewBroker())
+		defer server.Close()
+
+		ws := dial(t, server)
+		defer ws.Close()
+
+		send(t, ws, map[string]interface{}{
+			"id":      "1",
+			"type":    "start",
+			"payload": map[string]interface{}{"query": `{ allProjectNames }`},
+		})
+
+		want := `{"id":"1","type":"error","payload":[{"message":"query is not a subscription"}]}`
+		if got := receive(t, ws); got != want {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+	})
+}
+
+func setupServer(t *testing.T, r *mockRepo) *httptest.Server {
+	return setupResolverServer(t, &graphql.Resolver{
+		Metrics: metrics.NewService(r),
+		Listing: listing.NewService(r),
+	})
+}
+
+func setupSubscriptionServer(t *testing.T, r *mockRepo, b *events.Broker) *httptest.Server {
+	return setupResolverServer(t, &graphql.Resolver{
+		Metrics: metrics.NewService(r),
+		Listing: listing.NewService(r),
+		Events:  b,
+	})
+}
+
+func setupResolverServer(t *testing.T, res *graphql.Resolver) *httptest.Server {
+	return setupLimitedServer(t, res, graphql.Limits{})
+}
+
+func setupLimitedServer(t *testing.T, res *graphql.Resolver, l graphql.Limits) *httptest.Server {
+	return serve(newServer(t, res, l))
+}
+
+func newServer(t *testing.T, res *graphql.Resolver, l graphql.Limits) *graphql.Server {
+
+	s, err := graphql.NewServer(res, l)
+	if err != nil {
+		t.Fatalf("Error creating GraphQL server: %v", err)
+	}
+
+	return s
+}
+
+func serve(s *graphql.Server) *httptest.Server {
+
+	mux := http.NewServeMux()
+	mux.Handle("/graphql", s)
+
+	return httptest.NewServer(mux)
+}
+
+func post(t *testing.T, server *httptest.Server, query string, vars map[string]interface{}) string {
+	return postRequest(t, server, map[string]interface{}{"query": query, "variables": vars})
+}
+
+// persisted returns the extensions of a request for the persisted query with the hash
+func persisted(hash string) map[string]interface{} {
+	return map[string]interface{}{
+		"persistedQuery": map[string]interface{}{"version": 1, "sha256Hash": hash},
+	}
+}
+
+func sha(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:])
+}
+
+// postAs posts the query authenticated with the token
+func postAs(t *testing.T, server *httptest.Server, token string, query string) string {
+
+	body, err := json.Marshal(map[string]interface{}{"query": query})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/graphql", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var b bytes.Buffer
+	if _, err := b.ReadFrom(resp.Body); err != nil {
+		t.Fatal(err)
+	}
+
+	return string(bytes.TrimSpace(b.Bytes()))
+}
+
+func postRequest(t *testing.T, server *httptest.Server, req map[string]interface{}) string {
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Post(server.URL+"/graphql", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var b bytes.Buffer
+	if _, err := b.ReadFrom(resp.Body); err != nil {
+		t.Fatal(err)
+	}
+
+	return string(bytes.TrimSpace(b.Bytes()))
+}
+
+func dial(t *testing.T, server *httptest.Server) *websocket.Conn {
+
+	cfg, err := websocket.NewConfig("ws"+strings.TrimPrefix(server.URL, "http")+"/graphql", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Protocol = []string{"graphql-ws"}
+
+	ws, err := websocket.DialConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ws
+}
+
+func send(t *testing.T, ws *websocket.Conn, msg map[string]interface{}) {
+	if err := websocket.JSON.Send(ws, msg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func receive(t *testing.T, ws *websocket.Conn) string {
+
+	if err := ws.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	var msg string
+	if err := websocket.Message.Receive(ws, &msg); err != nil {
+		t.Fatal(err)
+	}
+
+	return strings.TrimSpace(msg)
+}
+
+func waitForSubscriber(t *testing.T, b *events.Broker) {
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if b.Subscribers() > 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatal("subscription did not start")
+}
+
+type mockCI struct{}
+
+func (m *mockCI) Refresh(r collector.Repository, opt collector.Options, p collector.Progress) error {
+	p.ProjectsFound(1)
+	p.ProjectDone(&collector.Project{ID: opt.ProjectID, Name: "api"}, 0, errors.New("not found"))
+	return nil
+}
+
+type mockCollectorRepo struct{}
+
+func (m *mockCollectorRepo) SaveProjects(p []*collector.Project) error {
+	return nil
+}
+
+func (m *mockCollectorRepo) SaveDeployment(d *collector.Deployment) error {
+	return nil
+}
+
+func (m *mockCollectorRepo) GetSyncCursor(projectID int) (*collector.SyncCursor, error) {
+	return nil, nil
+}
+
+func (m *mockCollectorRepo) SaveSyncCursor(c *collector.SyncCursor) error {
+	return nil
+}
+
+type mockTokenRepo struct {
+	tokens []*tokens.Token
+}
+
+func (m *mockTokenRepo) SaveToken(t *tokens.Token) error {
+	m.tokens = append(m.tokens, t)
+	return nil
+}
+
+func (m *mockTokenRepo) GetTokenByHash(hash string) (*tokens.Token, error) {
+	for _, t := range m.tokens {
+		if t.Hash == hash {
+			return t, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *mockTokenRepo) ListTokens() ([]*tokens.Token, error) {
+	return m.tokens, nil
+}
+
+func (m *mockTokenRepo) DeleteToken(id string) (bool, error) {
+	for i, t := range m.tokens {
+		if t.ID == id {
+			m.tokens = append(m.tokens[:i], m.tokens[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type mockRepo struct {
+	projects    []*listing.Project
+	deployments []*listing.Deployment
+	err         error
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (m *mockRepo) called(method string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.calls == nil {
+		m.calls = map[string]int{}
+	}
+	m.calls[method]++
+}
+
+func contains(ids []int, id int) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+func newMockRepo(t *testing.T) *mockRepo {
+
+	r := &mockRepo{
+		projects: []*listing.Project{
+			{ID: "a", ProjectID: 1, Name: "api", Namespace: "org/platform"},
+			{ID: "b", ProjectID: 2, Name: "site", Namespace: "org/marketing"},
+		},
+	}
+
+	for i, s := range []string{"success", "failed", "success"} {
+		ts, err := time.Parse(time.RFC3339, "2020-10-01T09:00:00Z")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts = ts.Add(time.Duration(i) * time.Hour)
+
+		r.deployments = append(r.deployments, &listing.Deployment{
+			ID:               "d",
+			DeploymentID:     i + 1,
+			Status:           s,
+			EnvironmentName:  "production",
+			ProjectID:        1,
+			ProjectName:      "api",
+			ProjectNamespace: "org/platform",
+			PipelineID:       i + 1,
+			FinishedAt:       &ts,
+			Duration:         60,
+		})
+	}
+
+	return r
+}
+
+func (m *mockRepo) ListProjects() ([]*listing.Project, error) {
+	return m.projects, m.err
+}
+
+func (m *mockRepo) ListProjectsPage(groupName string, afterID, limit int) ([]*listing.Project, error) {
+	p := []*listing.Project{}
+	for _, proj := range m.projects {
+		if proj.ProjectID > afterID && len(p) < limit {
+			p = append(p, proj)
+		}
+	}
+	return p, m.err
+}
+
+func (m *mockRepo) ListProjectsByID(ids []int) ([]*listing.Project, error) {
+	m.called("ListProjectsByID")
+
+	p := []*listing.Project{}
+	for _, proj := range m.projects {
+		if contains(ids, proj.ProjectID) {
+			p = append(p, proj)
+		}
+	}
+	return p, m.err
+}
+
+func (m *mockRepo) ListDeployments(f listing.Filter, after *listing.DeploymentCursor, limit int) ([]*listing.Deployment, error) {
+	m.called("ListDeployments")
+
+	d := append([]*listing.Deployment{}, m.deployments...)
+	sort.Slice(d, func(i, j int) bool {
+		if d[i].FinishedAt.Equal(*d[j].FinishedAt) {
+			return d[i].DeploymentID > d[j].DeploymentID
+		}
+		return d[i].FinishedAt.After(*d[j].FinishedAt)
+	})
+
+	res := []*listing.Deployment{}
+	perProject := map[int]int{}
+	for _, dep := range d {
+		if f.Status != "" && dep.Status != f.Status {
+			continue
+		}
+		if len(f.DeploymentIDs) > 0 && !contains(f.DeploymentIDs, dep.DeploymentID) {
+			continue
+		}
+		if len(f.ProjectIDs) > 0 && !contains(f.ProjectIDs, dep.ProjectID) {
+			continue
+		}
+		if f.ProjectID != 0 && dep.ProjectID != f.ProjectID {
+			continue
+		}
+		if after != nil && !dep.FinishedAt.Before(after.FinishedAt) &&
+			!(dep.FinishedAt.Equal(after.FinishedAt) && dep.DeploymentID < after.DeploymentID) {
+			continue
+		}
+		n := len(res)
+		if f.LimitPerProject {
+			n = perProject[dep.ProjectID]
+		}
+		if limit == 0 || n < limit {
+			res = append(res, dep)
+			perProject[dep.ProjectID]++
+		}
+	}
+
+	return res, m.err
+}
+
+func (m *mockRepo) GetProjects() ([]*metrics.Project, error) {
+	p := []*metrics.Project{}
+	for _, proj := range m.projects {
+		p = append(p, &metrics.Project{ID: proj.ProjectID, Name: proj.Name, Namespace: proj.Namespace})
+	}
+	return p, m.err
+}
+
+func (m *mockRepo) GetDeployments(f metrics.Filter) ([]*metrics.Deployment, error) {
+	d := []*metrics.Deployment{}
+	for _, dep := range m.deployments {
+		if len(f.ProjectIDs) > 0 && !contains(f.ProjectIDs, dep.ProjectID) {
+			continue
+		}
+		d = append(d, &metrics.Deployment{
+			ID:               dep.DeploymentID,
+			Status:           dep.Status,
+			EnvironmentName:  dep.EnvironmentName,
+			ProjectID:        dep.ProjectID,
+			ProjectName:      dep.ProjectName,
+			ProjectNamespace: dep.ProjectNamespace,
+			PipelineID:       dep.PipelineID,
+			FinishedAt:       dep.FinishedAt,
+			Duration:         dep.Duration,
+		})
+	}
+	return d, m.err
+}
@@ -0,0 +1,287 @@
+package graphql
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// inputValue represents an argument or input object field for introspection
+type inputValue struct {
+	Name         string
+	Description  *string
+	Type         *ast.Type
+	DefaultValue *string
+}
+
+// registerIntrospection resolves the __schema and __type fields of the query
+// type and the fields of the introspection types from the schema. Types are
+// resolved as *ast.Type, so the NON_NULL and LIST wrappers of a type are its
+// ofType chain.
+func (e *Executor) registerIntrospection() {
+
+	s := e.schema
+	if s.Query == nil {
+		return
+	}
+
+	e.Resolve(s.Query.Name, "__schema", func(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+		return s, nil
+	})
+
+	e.Resolve(s.Query.Name, "__type", func(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+		name, _ := args["name"].(string)
+		if s.Types[name] == nil {
+			return nil, nil
+		}
+		return ast.NamedType(name, nil), nil
+	})
+
+	e.Resolve("__Schema", "types", func(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+		names := []string{}
+		for name := range s.Types {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		types := []*ast.Type{}
+		for _, name := range names {
+			types = append(types, ast.NamedType(name, nil))
+		}
+		return types, nil
+	})
+
+	e.Resolve("__Schema", "queryType", func(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+		return namedType(s.Query), nil
+	})
+
+	e.Resolve("__Schema", "mutationType", func(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+		return namedType(s.Mutation), nil
+	})
+
+	e.Resolve("__Schema", "subscriptionType", func(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+		return namedType(s.Subscription), nil
+	})
+
+	e.Resolve("__Schema", "directives", func(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+		d := []*ast.DirectiveDefinition{}
+		for _, dir := range s.Directives {
+			d = append(d, dir)
+		}
+		sort.Slice(d, func(i, j int) bool {
+			return d[i].Name < d[j].Name
+		})
+		return d, nil
+	})
+
+	e.Resolve("__Type", "kind", func(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+		t := obj.(*ast.Type)
+		switch {
+		case t.NonNull:
+			return "NON_NULL", nil
+		case t.Elem != nil:
+			return "LIST", nil
+		}
+		return string(s.Types[t.NamedType].Kind), nil
+	})
+
+	e.Resolve("__Type", "name", func(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+		if def := e.definition(obj); def != nil {
+			return def.Name, nil
+		}
+		return nil, nil
+	})
+
+	e.Resolve("__Type", "description", func(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+		if def := e.definition(obj); def != nil {
+			return description(def.Description), nil
+		}
+		return nil, nil
+	})
+
+	e.Resolve("__Type", "fields", func(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+		def := e.definition(obj)
+		if def == nil || (def.Kind != ast.Object && def.Kind != ast.Interface) {
+			return nil, nil
+		}
+
+		includeDeprecated, _ := args["includeDeprecated"].(bool)
+
+		fields := []*ast.FieldDefinition{}
+		for _, f := range def.Fields {
+			// the __schema and __type fields are implicit on the query type
+			if strings.HasPrefix(f.Name, "__") {
+				continue
+			}
+			if !includeDeprecated && f.Directives.ForName("deprecated") != nil {
+				continue
+			}
+			fields = append(fields, f)
+		}
+		return fields, nil
+	})
+
+	e.Resolve("__Type", "interfaces", func(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+		def := e.definition(obj)
+		if def == nil || def.Kind != ast.Object {
+			return nil, nil
+		}
+
+		types := []*ast.Type{}
+		for _, name := range def.Interfaces {
+			types = append(types, ast.NamedType(name, nil))
+		}
+		return types, nil
+	})
+
+	e.Resolve("__Type", "possibleTypes", func(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+		def := e.definition(obj)
+		if def == nil || (def.Kind != ast.Interface && def.Kind != ast.Union) {
+			return nil, nil
+		}
+
+		types := []*ast.Type{}
+		for _, p := range s.GetPossibleTypes(def) {
+			types = append(types, ast.NamedType(p.Name, nil))
+		}
+		return types, nil
+	})
+
+	e.Resolve("__Type", "enumValues", func(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+		def := e.definition(obj)
+		if def == nil || def.Kind != ast.Enum {
+			return nil, nil
+		}
+
+		includeDeprecated, _ := args["includeDeprecated"].(bool)
+
+		values := []*ast.EnumValueDefinition{}
+		for _, v := range def.EnumValues {
+			if !includeDeprecated && v.Directives.ForName("deprecated") != nil {
+				continue
+			}
+			values = append(values, v)
+		}
+		return values, nil
+	})
+
+	e.Resolve("__Type", "inputFields", func(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+		def := e.definition(obj)
+		if def == nil || def.Kind != ast.InputObject {
+			return nil, nil
+		}
+
+		fields := []*inputValue{}
+		for _, f := range def.Fields {
+			fields = append(fields, newInputValue(f.Name, f.Description, f.Type, f.DefaultValue))
+		}
+		return fields, nil
+	})
+
+	e.Resolve("__Type", "ofType", func(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+		t := obj.(*ast.Type)
+		switch {
+		case t.NonNull:
+			nullable := *t
+			nullable.NonNull = false
+			return &nullable, nil
+		case t.Elem != nil:
+			return t.Elem, nil
+		}
+		return nil, nil
+	})
+
+	e.Resolve("__Field", "description", func(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+		return description(obj.(*ast.FieldDefinition).Description), nil
+	})
+
+	e.Resolve("__Field", "args", func(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+		return arguments(obj.(*ast.FieldDefinition).Arguments), nil
+	})
+
+	e.Resolve("__Field", "isDeprecated", func(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+		return obj.(*ast.FieldDefinition).Directives.ForName("deprecated") != nil, nil
+	})
+
+	e.Resolve("__Field", "deprecationReason", func(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+		return deprecationReason(obj.(*ast.FieldDefinition).Directives), nil
+	})
+
+	e.Resolve("__EnumValue", "description", func(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+		return description(obj.(*ast.EnumValueDefinition).Description), nil
+	})
+
+	e.Resolve("__EnumValue", "isDeprecated", func(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+		return obj.(*ast.EnumValueDefinition).Directives.ForName("deprecated") != nil, nil
+	})
+
+	e.Resolve("__EnumValue", "deprecationReason", func(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+		return deprecationReason(obj.(*ast.EnumValueDefinition).Directives), nil
+	})
+
+	e.Resolve("__Directive", "description", func(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+		return description(obj.(*ast.DirectiveDefinition).Description), nil
+	})
+
+	e.Resolve("__Directive", "args", func(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+		return arguments(obj.(*ast.DirectiveDefinition).Arguments), nil
+	})
+}
+
+// definition returns the definition of a named type, or nil for a wrapper type
+func (e *Executor) definition(obj interface{}) *ast.Definition {
+	t := obj.(*ast.Type)
+	if t.NonNull || t.Elem != nil {
+		return nil
+	}
+	return e.schema.Types[t.NamedType]
+}
+
+// namedType returns the type with the definition, or nil if it is not defined
+func namedType(def *ast.Definition) *ast.Type {
+	if def == nil {
+		return nil
+	}
+	return ast.NamedType(def.Name, nil)
+}
+
+func arguments(args ast.ArgumentDefinitionList) []*inputValue {
+	values := []*inputValue{}
+	for _, a := range args {
+		values = append(values, newInputValue(a.Name, a.Description, a.Type, a.DefaultValue))
+	}
+	return values
+}
+
+func newInputValue(name, desc string, typ *ast.Type, def *ast.Value) *inputValue {
+	v := &inputValue{Name: name, Type: typ}
+	if desc != "" {
+		v.Description = &desc
+	}
+	if def != nil {
+		s := def.String()
+		v.DefaultValue = &s
+	}
+	return v
+}
+
+// description returns nil rather than an empty description
+func description(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func deprecationReason(d ast.DirectiveList) interface{} {
+	dep := d.ForName("deprecated")
+	if dep == nil {
+		return nil
+	}
+	if reason := dep.Arguments.ForName("reason"); reason != nil {
+		return reason.Value.Raw
+	}
+	return "No longer supported"
+}
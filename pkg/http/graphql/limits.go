@@ -0,0 +1,160 @@
+package graphql
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+// defaultListSize is the number of items assumed for lists when estimating
+// cost, matching the default page size
+const defaultListSize = 20
+
+// Limits restricts the queries the executor will run. A zero limit is unlimited.
+type Limits struct {
+	// MaxDepth is the deepest nesting of fields in a query
+	MaxDepth int
+	// MaxCost is the highest estimated cost of a query. Each field costs one,
+	// multiplied by the number of items a list field may return: its first
+	// argument, the first argument of a paginated parent field, or ListSize.
+	MaxCost int
+	// MaxResultSize is the most list items a response may contain
+	MaxResultSize int
+	// ListSize is the number of items assumed for lists without a first
+	// argument, 20 if zero
+	ListSize int
+}
+
+// Error codes reported in the extensions of errors for exceeded limits
+const (
+	MaxDepthExceeded      = "MAX_DEPTH_EXCEEDED"
+	MaxCostExceeded       = "MAX_COST_EXCEEDED"
+	MaxResultSizeExceeded = "MAX_RESULT_SIZE_EXCEEDED"
+)
+
+// limitError reports which limit a query exceeded
+func limitError(code, name string, value, limit int) *gqlerror.Error {
+	return &gqlerror.Error{
+		Message: fmt.Sprintf("query %s %d exceeds the limit of %d", name, value, limit),
+		Extensions: map[string]interface{}{
+			"code":  code,
+			"limit": limit,
+			"value": value,
+		},
+	}
+}
+
+// checkLimits rejects an operation which is too deep or too costly to execute
+func (ex *execution) checkLimits(root *ast.Definition) *gqlerror.Error {
+
+	l := ex.e.Limits
+
+	cost, depth := ex.measure(ex.op.SelectionSet, root, 1, 0)
+
+	if l.MaxDepth > 0 && depth > l.MaxDepth {
+		return limitError(MaxDepthExceeded, "depth", depth, l.MaxDepth)
+	}
+
+	if l.MaxCost > 0 && cost > l.MaxCost {
+		return limitError(MaxCostExceeded, "cost", cost, l.MaxCost)
+	}
+
+	return nil
+}
+
+// measure returns the estimated cost and the depth of a selection set at depth.
+// Lists in the selection set return page items if it belongs to a paginated field.
+func (ex *execution) measure(set ast.SelectionSet, def *ast.Definition, depth, page int) (int, int) {
+
+	cost, maxDepth := 0, depth
+
+	for _, cf := range ex.collectFields(set, def, nil, map[string]bool{}) {
+		f := cf.fields[0]
+
+		// introspection is bounded by the size of the schema, so it is not
+		// measured against the limits
+		if f.Definition == nil || strings.HasPrefix(f.Name, "__") {
+			cost++
+			continue
+		}
+
+		first := 0
+		if f.Definition.Arguments.ForName("first") != nil {
+			first = ex.listSize(f.ArgumentMap(ex.vars)["first"])
+		}
+
+		// the first argument sets the size of a list field, or of the lists
+		// returned by a paginated field
+		items, childPage := 1, 0
+		switch {
+		case f.Definition.Type.Elem != nil && first > 0:
+			items = first
+		case f.Definition.Type.Elem != nil && page > 0:
+			items = page
+		case f.Definition.Type.Elem != nil:
+			items = ex.listSize(nil)
+		default:
+			childPage = first
+		}
+
+		sub := ast.SelectionSet{}
+		for _, ff := range cf.fields {
+			sub = append(sub, ff.SelectionSet...)
+		}
+
+		c := 0
+		if len(sub) > 0 {
+			var d int
+			c, d = ex.measure(sub, ex.e.schema.Types[f.Definition.Type.Name()], depth+1, childPage)
+			if d > maxDepth {
+				maxDepth = d
+			}
+		}
+
+		cost += items * (1 + c)
+	}
+
+	return cost, maxDepth
+}
+
+// listSize returns the number of items requested by a first argument, or the
+// assumed list size if it is not set
+func (ex *execution) listSize(first interface{}) int {
+
+	if n, err := intArg(first); err == nil && n > 0 {
+		return n
+	}
+
+	if ex.e.Limits.ListSize > 0 {
+		return ex.e.Limits.ListSize
+	}
+
+	return defaultListSize
+}
+
+// countItems adds list items to the size of the response, failing the
+// execution once the response is too large
+func (ex *execution) countItems(n int) bool {
+
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+
+	ex.items += n
+
+	if max := ex.e.Limits.MaxResultSize; max > 0 && ex.items > max && ex.limit == nil {
+		ex.limit = limitError(MaxResultSizeExceeded, "result size", ex.items, max)
+	}
+
+	return ex.limit == nil
+}
+
+// limited reports whether a limit has failed the execution
+func (ex *execution) limited() bool {
+
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+
+	return ex.limit != nil
+}
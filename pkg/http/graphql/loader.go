@@ -0,0 +1,91 @@
+package graphql
+
+import (
+	"sync"
+	"time"
+)
+
+// loaderWait is how long a loader collects keys before fetching a batch
+const loaderWait = 2 * time.Millisecond
+
+// loaderMaxBatch is the most keys a loader fetches at once
+const loaderMaxBatch = 100
+
+// FetchFunc fetches the values for a batch of keys. Keys without a value are
+// left out of the result.
+type FetchFunc func(keys []int) (map[int]interface{}, error)
+
+// Loader batches the keys loaded by concurrent resolvers into a single fetch
+// and caches the values for the rest of the request
+type Loader struct {
+	fetch FetchFunc
+
+	mu    sync.Mutex
+	cache map[int]*batch
+	next  *batch
+}
+
+// batch is a set of keys fetched together
+type batch struct {
+	keys   []int
+	closed bool
+	done   chan struct{}
+	values map[int]interface{}
+	err    error
+}
+
+// NewLoader creates a loader which fetches batches of keys with f
+func NewLoader(f FetchFunc) *Loader {
+	return &Loader{fetch: f, cache: map[int]*batch{}}
+}
+
+// Load returns the value for the key, or nil if it has none, waiting for the
+// batch containing the key to be fetched
+func (l *Loader) Load(key int) (interface{}, error) {
+
+	l.mu.Lock()
+
+	b, ok := l.cache[key]
+	if !ok {
+		if l.next == nil {
+			l.next = &batch{done: make(chan struct{})}
+			go func(b *batch) {
+				time.Sleep(loaderWait)
+				l.dispatch(b)
+			}(l.next)
+		}
+
+		b = l.next
+		b.keys = append(b.keys, key)
+		l.cache[key] = b
+
+		if len(b.keys) >= loaderMaxBatch {
+			go l.dispatch(b)
+			l.next = nil
+		}
+	}
+
+	l.mu.Unlock()
+
+	<-b.done
+
+	return b.values[key], b.err
+}
+
+// dispatch fetches a batch unless it has already been fetched
+func (l *Loader) dispatch(b *batch) {
+
+	l.mu.Lock()
+	if b.closed {
+		l.mu.Unlock()
+		return
+	}
+	b.closed = true
+	if l.next == b {
+		l.next = nil
+	}
+	l.mu.Unlock()
+
+	b.values, b.err = l.fetch(b.keys)
+	close(b.done)
+}
@@ -0,0 +1,110 @@
+package graphql
+
+import (
+	"context"
+	"sync"
+
+	"github.com/sk000f/metrix/pkg/listing"
+	"github.com/sk000f/metrix/pkg/metrics"
+)
+
+type loadersKey struct{}
+
+// loaders batch the project and deployment lookups made while resolving a
+// single request, so each relation is fetched with one query per batch
+type loaders struct {
+	l *listing.Service
+
+	projects    *Loader
+	deployments *Loader
+
+	mu                 sync.Mutex
+	projectDeployments map[projectDeploymentsKey]*Loader
+}
+
+// projectDeploymentsKey identifies the deployments of projects batched together
+type projectDeploymentsKey struct {
+	dr    metrics.DateRange
+	first int
+}
+
+func newLoaders(l *listing.Service) *loaders {
+
+	ld := &loaders{l: l, projectDeployments: map[projectDeploymentsKey]*Loader{}}
+
+	ld.projects = NewLoader(func(ids []int) (map[int]interface{}, error) {
+		p, err := l.ProjectsByID(ids)
+		if err != nil {
+			return nil, err
+		}
+
+		v := map[int]interface{}{}
+		for _, proj := range p {
+			v[proj.ProjectID] = proj
+		}
+		return v, nil
+	})
+
+	ld.deployments = NewLoader(func(ids []int) (map[int]interface{}, error) {
+		d, err := l.DeploymentsByID(ids)
+		if err != nil {
+			return nil, err
+		}
+
+		v := map[int]interface{}{}
+		for _, dep := range d {
+			v[dep.DeploymentID] = dep
+		}
+		return v, nil
+	})
+
+	return ld
+}
+
+// withLoaders returns a context holding new loaders for a request
+func (r *Resolver) withLoaders(ctx context.Context) context.Context {
+	return context.WithValue(ctx, loadersKey{}, newLoaders(r.listing(ctx)))
+}
+
+// loaders returns the loaders for the request, or new loaders if the context
+// has none
+func (r *Resolver) loaders(ctx context.Context) *loaders {
+	if ld, ok := ctx.Value(loadersKey{}).(*loaders); ok {
+		return ld
+	}
+	return newLoaders(r.listing(ctx))
+}
+
+// projectDeploymentsLoader returns the loader for the first deployments of
+// projects in the date range, most recently finished first
+func (ld *loaders) projectDeploymentsLoader(dr metrics.DateRange, first int) *Loader {
+
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+
+	key := projectDeploymentsKey{dr, first}
+	if l, ok := ld.projectDeployments[key]; ok {
+		return l
+	}
+
+	l := NewLoader(func(ids []int) (map[int]interface{}, error) {
+		d, err := ld.l.ProjectDeployments(ids, listing.Filter{Start: dr.Start, End: dr.End}, first)
+		if err != nil {
+			return nil, err
+		}
+
+		byProject := map[int][]*listing.Deployment{}
+		for _, dep := range d {
+			byProject[dep.ProjectID] = append(byProject[dep.ProjectID], dep)
+		}
+
+		v := map[int]interface{}{}
+		for id, deps := range byProject {
+			v[id] = deps
+		}
+		return v, nil
+	})
+	ld.projectDeployments[key] = l
+
+	return l
+}
@@ -0,0 +1,487 @@
+package graphql
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sk000f/metrix/pkg/collector"
+	"github.com/sk000f/metrix/pkg/listing"
+	"github.com/sk000f/metrix/pkg/metrics"
+	"github.com/sk000f/metrix/pkg/tokens"
+)
+
+// The GraphQL API reports durations in seconds and rates as percentages.
+
+// Project represents the GraphQL Project type
+type Project struct {
+	ID        string
+	ProjectID string
+	Name      string
+	GroupName string
+}
+
+// Deployment represents the GraphQL Deployment type
+type Deployment struct {
+	ID               string
+	DeploymentID     int
+	Status           string
+	EnvironmentName  string
+	ProjectID        int
+	ProjectName      string
+	ProjectGroupName string
+	FinishedAt       *time.Time
+	Duration         int
+}
+
+// PageInfo represents the GraphQL PageInfo type
+type PageInfo struct {
+	HasNextPage     bool
+	HasPreviousPage bool
+	StartCursor     *string
+	EndCursor       *string
+}
+
+// Edge represents the GraphQL edge types of a connection
+type Edge struct {
+	Cursor string
+	Node   interface{}
+}
+
+// Connection represents the GraphQL connection types
+type Connection struct {
+	Edges    []*Edge
+	PageInfo *PageInfo
+}
+
+// Distribution represents the GraphQL Distribution type
+type Distribution struct {
+	Count     int
+	Min       float64
+	Max       float64
+	Mean      float64
+	P50       float64
+	P75       float64
+	P90       float64
+	P95       float64
+	Histogram []*HistogramBucket
+}
+
+// HistogramBucket represents the GraphQL HistogramBucket type
+type HistogramBucket struct {
+	LowerBound float64
+	UpperBound *float64
+	Count      int
+}
+
+// Period represents the GraphQL Period type
+type Period struct {
+	Start time.Time
+	End   time.Time
+}
+
+// MetricDelta represents the GraphQL MetricDelta type
+type MetricDelta struct {
+	Current   float64
+	Previous  float64
+	Absolute  float64
+	Percent   *float64
+	Direction metrics.Direction
+}
+
+// MetricComparison represents the GraphQL MetricComparison type
+type MetricComparison struct {
+	DeploymentFrequency *MetricDelta
+	ChangeFailRate      *MetricDelta
+	MeanTimeToRecover   *MetricDelta
+	ChangeLeadTime      *MetricDelta
+}
+
+// ProjectComparison represents the GraphQL ProjectComparison type
+type ProjectComparison struct {
+	ProjectID  int
+	Name       string
+	GroupName  string
+	Comparison *MetricComparison
+}
+
+// GroupComparison represents the GraphQL GroupComparison type
+type GroupComparison struct {
+	GroupName  string
+	Comparison *MetricComparison
+}
+
+// PeriodComparison represents the GraphQL PeriodComparison type
+type PeriodComparison struct {
+	Current  *Period
+	Previous *Period
+	Projects []*ProjectComparison
+	Groups   []*GroupComparison
+}
+
+// MetricSummary represents the GraphQL MetricSummary type
+type MetricSummary struct {
+	Deployments       int
+	Failures          int
+	DeploymentsPerDay float64
+	ChangeFailRate    float64
+	Recoveries        int
+	MeanTimeToRecover float64
+	ChangeLeadTime    float64
+}
+
+// MetricBucket represents the GraphQL MetricBucket type
+type MetricBucket struct {
+	Start             time.Time
+	End               time.Time
+	Deployments       int
+	Failures          int
+	ChangeFailRate    float64
+	Recoveries        int
+	MeanTimeToRecover float64
+	ChangeLeadTime    float64
+}
+
+// ProjectRollup represents the GraphQL ProjectRollup type
+type ProjectRollup struct {
+	ProjectID int
+	Name      string
+	GroupName string
+	Summary   *MetricSummary
+}
+
+// NamespaceRollup represents the GraphQL NamespaceRollup type
+type NamespaceRollup struct {
+	Path     string
+	Name     string
+	Summary  *MetricSummary
+	Groups   []*NamespaceRollup
+	Projects []*ProjectRollup
+}
+
+// Classification represents the GraphQL Classification type
+type Classification struct {
+	Version             string
+	DeploymentFrequency metrics.Band
+	ChangeLeadTime      metrics.Band
+	MeanTimeToRecover   metrics.Band
+	ChangeFailRate      metrics.Band
+	Overall             metrics.Band
+}
+
+// ProjectClassification represents the GraphQL ProjectClassification type
+type ProjectClassification struct {
+	ProjectID      int
+	Name           string
+	GroupName      string
+	Classification *Classification
+}
+
+// GroupClassification represents the GraphQL GroupClassification type
+type GroupClassification struct {
+	GroupName      string
+	Classification *Classification
+}
+
+// Classifications represents the GraphQL Classifications type
+type Classifications struct {
+	Projects []*ProjectClassification
+	Groups   []*GroupClassification
+}
+
+// CollectionRun represents the GraphQL CollectionRun type
+type CollectionRun struct {
+	ID               string
+	ProjectID        *int
+	Since            *time.Time
+	FullResync       bool
+	StartedAt        time.Time
+	FinishedAt       *time.Time
+	Running          bool
+	Projects         int
+	ProjectsDone     int
+	DeploymentsSaved int
+	Errors           []*ProjectRunError
+	Error            *string
+}
+
+// APIToken represents the GraphQL APIToken type
+type APIToken struct {
+	ID        string
+	Name      string
+	Scopes    []string
+	CreatedAt time.Time
+	ExpiresAt *time.Time
+}
+
+// CreatedAPIToken represents the GraphQL CreatedAPIToken type
+type CreatedAPIToken struct {
+	Token    string
+	APIToken *APIToken
+}
+
+// ProjectRunError represents the GraphQL ProjectRunError type
+type ProjectRunError struct {
+	ProjectID   int
+	ProjectName string
+	Message     string
+}
+
+func newProject(p *listing.Project) *Project {
+	return &Project{
+		ID:        p.ID,
+		ProjectID: strconv.Itoa(p.ProjectID),
+		Name:      p.Name,
+		GroupName: p.Namespace,
+	}
+}
+
+func newDeployment(d *listing.Deployment) *Deployment {
+	return &Deployment{
+		ID:               d.ID,
+		DeploymentID:     d.DeploymentID,
+		Status:           d.Status,
+		EnvironmentName:  d.EnvironmentName,
+		ProjectID:        d.ProjectID,
+		ProjectName:      d.ProjectName,
+		ProjectGroupName: d.ProjectNamespace,
+		FinishedAt:       d.FinishedAt,
+		Duration:         int(math.Round(d.Duration)),
+	}
+}
+
+func newConnection(nodes []interface{}, cursors []string, hasNext, hasPrevious bool) *Connection {
+
+	c := &Connection{
+		Edges: []*Edge{},
+		PageInfo: &PageInfo{
+			HasNextPage:     hasNext,
+			HasPreviousPage: hasPrevious,
+		},
+	}
+
+	for i, n := range nodes {
+		c.Edges = append(c.Edges, &Edge{Cursor: cursors[i], Node: n})
+	}
+
+	if len(cursors) > 0 {
+		c.PageInfo.StartCursor = &cursors[0]
+		c.PageInfo.EndCursor = &cursors[len(cursors)-1]
+	}
+
+	return c
+}
+
+func newDistribution(d *metrics.Distribution) *Distribution {
+
+	dist := &Distribution{
+		Count:     d.Count,
+		Min:       d.Min.Seconds(),
+		Max:       d.Max.Seconds(),
+		Mean:      d.Mean.Seconds(),
+		P50:       d.P50.Seconds(),
+		P75:       d.P75.Seconds(),
+		P90:       d.P90.Seconds(),
+		P95:       d.P95.Seconds(),
+		Histogram: []*HistogramBucket{},
+	}
+
+	for _, b := range d.Histogram {
+		hb := &HistogramBucket{LowerBound: b.LowerBound.Seconds(), Count: b.Count}
+		if b.UpperBound != nil {
+			u := b.UpperBound.Seconds()
+			hb.UpperBound = &u
+		}
+		dist.Histogram = append(dist.Histogram, hb)
+	}
+
+	return dist
+}
+
+// newMetricDelta converts a delta into GraphQL units by multiplying by scale
+func newMetricDelta(d *metrics.Delta, scale float64) *MetricDelta {
+	return &MetricDelta{
+		Current:   d.Current * scale,
+		Previous:  d.Previous * scale,
+		Absolute:  d.Absolute * scale,
+		Percent:   d.Percent,
+		Direction: d.Direction,
+	}
+}
+
+func newMetricComparison(c *metrics.Comparison) *MetricComparison {
+	return &MetricComparison{
+		DeploymentFrequency: newMetricDelta(c.DeploymentFrequency, 1),
+		ChangeFailRate:      newMetricDelta(c.ChangeFailureRate, 100),
+		MeanTimeToRecover:   newMetricDelta(c.MeanTimeToRecover, 3600),
+		ChangeLeadTime:      newMetricDelta(c.ChangeLeadTime, 3600),
+	}
+}
+
+func newPeriodComparison(c *metrics.Comparisons) *PeriodComparison {
+
+	pc := &PeriodComparison{
+		Current:  &Period{Start: c.Current.Start, End: c.Current.End},
+		Previous: &Period{Start: c.Previous.Start, End: c.Previous.End},
+		Projects: []*ProjectComparison{},
+		Groups:   []*GroupComparison{},
+	}
+
+	for _, p := range c.Projects {
+		pc.Projects = append(pc.Projects, &ProjectComparison{
+			ProjectID:  p.ProjectID,
+			Name:       p.ProjectName,
+			GroupName:  p.Namespace,
+			Comparison: newMetricComparison(p.Comparison),
+		})
+	}
+
+	for _, g := range c.Groups {
+		pc.Groups = append(pc.Groups, &GroupComparison{
+			GroupName:  g.Namespace,
+			Comparison: newMetricComparison(g.Comparison),
+		})
+	}
+
+	return pc
+}
+
+func newMetricSummary(s *metrics.Summary) *MetricSummary {
+	return &MetricSummary{
+		Deployments:       s.Deployments,
+		Failures:          s.Failures,
+		DeploymentsPerDay: s.DeploymentsPerDay,
+		ChangeFailRate:    s.ChangeFailureRate * 100,
+		Recoveries:        s.Recoveries,
+		MeanTimeToRecover: s.MeanTimeToRecover.Seconds(),
+		ChangeLeadTime:    s.MeanLeadTime.Seconds(),
+	}
+}
+
+func newMetricBucket(b *metrics.Bucket) *MetricBucket {
+	return &MetricBucket{
+		Start:             b.Start,
+		End:               b.End,
+		Deployments:       b.Deployments,
+		Failures:          b.Failures,
+		ChangeFailRate:    b.ChangeFailureRate * 100,
+		Recoveries:        len(b.Recoveries),
+		MeanTimeToRecover: b.MeanTimeToRecover.Seconds(),
+		ChangeLeadTime:    b.MeanLeadTime.Seconds(),
+	}
+}
+
+func newClassification(c *metrics.Classification) *Classification {
+	return &Classification{
+		Version:             c.Version,
+		DeploymentFrequency: c.DeploymentFrequency,
+		ChangeLeadTime:      c.LeadTime,
+		MeanTimeToRecover:   c.TimeToRecover,
+		ChangeFailRate:      c.ChangeFailureRate,
+		Overall:             c.Overall,
+	}
+}
+
+func newClassifications(c *metrics.Classifications) *Classifications {
+
+	cs := &Classifications{
+		Projects: []*ProjectClassification{},
+		Groups:   []*GroupClassification{},
+	}
+
+	for _, p := range c.Projects {
+		cs.Projects = append(cs.Projects, &ProjectClassification{
+			ProjectID:      p.ProjectID,
+			Name:           p.ProjectName,
+			GroupName:      p.Namespace,
+			Classification: newClassification(p.Classification),
+		})
+	}
+
+	for _, g := range c.Groups {
+		cs.Groups = append(cs.Groups, &GroupClassification{
+			GroupName:      g.Namespace,
+			Classification: newClassification(g.Classification),
+		})
+	}
+
+	return cs
+}
+
+func newNamespaceRollup(r *metrics.Rollup) *NamespaceRollup {
+
+	nr := &NamespaceRollup{
+		Path:     r.Path,
+		Name:     r.Name,
+		Summary:  newMetricSummary(r.Summary),
+		Groups:   []*NamespaceRollup{},
+		Projects: []*ProjectRollup{},
+	}
+
+	for _, g := range r.Groups {
+		nr.Groups = append(nr.Groups, newNamespaceRollup(g))
+	}
+
+	for _, p := range r.Projects {
+		nr.Projects = append(nr.Projects, &ProjectRollup{
+			ProjectID: p.ProjectID,
+			Name:      p.ProjectName,
+			GroupName: p.Namespace,
+			Summary:   newMetricSummary(p.Summary),
+		})
+	}
+
+	return nr
+}
+
+func newCollectionRun(r *collector.Run) *CollectionRun {
+
+	cr := &CollectionRun{
+		ID:               r.ID,
+		FullResync:       r.Options.FullResync,
+		StartedAt:        r.StartedAt,
+		FinishedAt:       r.FinishedAt,
+		Running:          r.FinishedAt == nil,
+		Projects:         r.Projects,
+		ProjectsDone:     r.ProjectsDone,
+		DeploymentsSaved: r.DeploymentsSaved,
+		Errors:           []*ProjectRunError{},
+	}
+
+	if r.Options.ProjectID != 0 {
+		cr.ProjectID = &r.Options.ProjectID
+	}
+	if !r.Options.Since.IsZero() {
+		cr.Since = &r.Options.Since
+	}
+	if r.Error != "" {
+		cr.Error = &r.Error
+	}
+
+	for _, e := range r.ProjectErrors {
+		cr.Errors = append(cr.Errors, &ProjectRunError{
+			ProjectID:   e.ProjectID,
+			ProjectName: e.ProjectName,
+			Message:     e.Message,
+		})
+	}
+
+	return cr
+}
+
+func newAPIToken(t *tokens.Token) *APIToken {
+
+	at := &APIToken{
+		ID:        t.ID,
+		Name:      t.Name,
+		Scopes:    []string{},
+		CreatedAt: t.CreatedAt,
+		ExpiresAt: t.ExpiresAt,
+	}
+	for _, s := range t.Scopes {
+		at.Scopes = append(at.Scopes, strings.ToUpper(string(s)))
+	}
+
+	return at
+}
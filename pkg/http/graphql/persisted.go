@@ -0,0 +1,203 @@
+package graphql
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"github.com/vektah/gqlparser/v2/parser"
+)
+
+// maxCachedQueries is the number of automatic persisted queries kept in memory
+const maxCachedQueries = 1000
+
+// Error codes reported in the extensions of persisted query errors
+const (
+	PersistedQueryNotFound     = "PERSISTED_QUERY_NOT_FOUND"
+	PersistedQueryHashMismatch = "PERSISTED_QUERY_HASH_MISMATCH"
+	QueryNotAllowed            = "QUERY_NOT_ALLOWED"
+)
+
+// Extensions represents the extensions of a GraphQL request
+type Extensions struct {
+	PersistedQuery *PersistedQuery `json:"persistedQuery,omitempty"`
+}
+
+// PersistedQuery identifies a query by the SHA-256 hash of its text
+type PersistedQuery struct {
+	Version    int    `json:"version"`
+	SHA256Hash string `json:"sha256Hash"`
+}
+
+// PersistedQueries stores queries by hash, so clients can send the hash of a
+// query instead of its text. Queries are registered at startup or cached when
+// a client first sends the text with its hash. In strict mode only registered
+// queries may run and no queries are cached.
+type PersistedQueries struct {
+	Strict bool
+
+	mu         sync.RWMutex
+	registered map[string]string
+	names      map[string]string
+	cache      map[string]string
+	order      []string
+}
+
+// NewPersistedQueries creates a store with the queries registered alongside
+// the schema
+func NewPersistedQueries() (*PersistedQueries, error) {
+
+	p := &PersistedQueries{
+		registered: map[string]string{},
+		names:      map[string]string{},
+		cache:      map[string]string{},
+	}
+
+	if err := p.LoadDir(registeredQueries, "queries"); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Register adds a query which may run in strict mode and returns its hash
+func (p *PersistedQueries) Register(query string) string {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// clients may hash the query as written or without surrounding whitespace
+	p.registered[hash(query)] = query
+	p.registered[hash(strings.TrimSpace(query))] = query
+
+	if doc, err := parser.ParseQuery(&ast.Source{Input: query}); err == nil {
+		for _, op := range doc.Operations {
+			if op.Name != "" {
+				p.names[op.Name] = hash(query)
+			}
+		}
+	}
+
+	return hash(query)
+}
+
+// Hash returns the hash of the registered query containing the named operation
+func (p *PersistedQueries) Hash(operationName string) (string, bool) {
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	h, ok := p.names[operationName]
+	return h, ok
+}
+
+// LoadDir registers the query in each .graphql file in the directory
+func (p *PersistedQueries) LoadDir(fsys fs.FS, dir string) error {
+
+	files, err := fs.Glob(fsys, path.Join(dir, "*.graphql"))
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		b, err := fs.ReadFile(fsys, f)
+		if err != nil {
+			return err
+		}
+		p.Register(string(b))
+	}
+
+	return nil
+}
+
+// resolve sets the query text of a request from its persisted query hash,
+// caching new queries, and rejects unregistered queries in strict mode
+func (p *PersistedQueries) resolve(req *Request) *gqlerror.Error {
+
+	var h string
+	if req.Extensions.PersistedQuery != nil {
+		h = req.Extensions.PersistedQuery.SHA256Hash
+	}
+
+	if req.Query == "" && h != "" {
+		q, ok := p.lookup(h)
+		if !ok {
+			return persistedQueryError(PersistedQueryNotFound, "PersistedQueryNotFound")
+		}
+		req.Query = q
+		return nil
+	}
+
+	if h != "" && h != hash(req.Query) {
+		return persistedQueryError(PersistedQueryHashMismatch, "provided sha256Hash does not match query")
+	}
+
+	if p.Strict {
+		p.mu.RLock()
+		_, ok := p.registered[hash(strings.TrimSpace(req.Query))]
+		p.mu.RUnlock()
+
+		if !ok {
+			return persistedQueryError(QueryNotAllowed, "query is not registered")
+		}
+		return nil
+	}
+
+	if h != "" {
+		p.store(h, req.Query)
+	}
+
+	return nil
+}
+
+func (p *PersistedQueries) lookup(h string) (string, bool) {
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if q, ok := p.registered[h]; ok {
+		return q, true
+	}
+	if p.Strict {
+		return "", false
+	}
+
+	q, ok := p.cache[h]
+	return q, ok
+}
+
+// store caches a query, evicting the oldest query once the cache is full
+func (p *PersistedQueries) store(h, query string) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.cache[h]; ok {
+		return
+	}
+
+	if len(p.order) >= maxCachedQueries {
+		delete(p.cache, p.order[0])
+		p.order = p.order[1:]
+	}
+
+	p.cache[h] = query
+	p.order = append(p.order, h)
+}
+
+func persistedQueryError(code, message string) *gqlerror.Error {
+	return &gqlerror.Error{
+		Message:    message,
+		Extensions: map[string]interface{}{"code": code},
+	}
+}
+
+func hash(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,756 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sk000f/metrix/pkg/access"
+	"github.com/sk000f/metrix/pkg/collector"
+	"github.com/sk000f/metrix/pkg/events"
+	"github.com/sk000f/metrix/pkg/listing"
+	"github.com/sk000f/metrix/pkg/metrics"
+	"github.com/sk000f/metrix/pkg/tokens"
+)
+
+// Resolver resolves the queries in the metrix schema
+type Resolver struct {
+	Metrics     *metrics.Service
+	Listing     *listing.Service
+	FailureMode metrics.FailureMode
+	// Thresholds classify the metrics into DORA bands, or the default
+	// thresholds if nil
+	Thresholds *metrics.Thresholds
+	Events     *events.Broker
+	Collector  *collector.Service
+	// Tokens enables scope checks for mutations and token management. Requests
+	// are authenticated before they reach the resolver.
+	Tokens *tokens.Service
+}
+
+// register adds the resolvers for each query to the executor
+func (r *Resolver) register(e *Executor) {
+	e.Resolve("Query", "allProjectNames", r.allProjectNames)
+	e.Resolve("Query", "allProjectGroupNames", r.allProjectGroupNames)
+	e.Resolve("Query", "projects", r.projects)
+	e.Resolve("Query", "deployments", r.deployments)
+	e.Resolve("Query", "deploymentFrequency", r.deploymentFrequency)
+	e.Resolve("Query", "changeFailRate", r.changeFailRate)
+	e.Resolve("Query", "meanTimeToRecover", r.meanTimeToRecover)
+	e.Resolve("Query", "changeLeadTime", r.changeLeadTime)
+	e.Resolve("Query", "leadTimeDistribution", r.leadTimeDistribution)
+	e.Resolve("Query", "timeToRecoverDistribution", r.timeToRecoverDistribution)
+	e.Resolve("Query", "deploymentDurationDistribution", r.deploymentDurationDistribution)
+	e.Resolve("Query", "compareMetrics", r.compareMetrics)
+	e.Resolve("Query", "namespaceRollup", r.namespaceRollup)
+	e.Resolve("Query", "timeSeries", r.timeSeries)
+	e.Resolve("Query", "classification", r.classification)
+	e.Resolve("Query", "classifications", r.classifications)
+	e.Resolve("Query", "deployment", r.deployment)
+	e.Resolve("Project", "deployments", r.projectDeployments)
+	e.Resolve("Deployment", "project", r.deploymentProject)
+
+	if r.Collector != nil {
+		e.Resolve("Query", "collectionRun", r.collectionRun)
+		e.Resolve("Mutation", "refreshData", r.refreshData)
+	}
+
+	if r.Tokens != nil {
+		e.Resolve("Query", "apiTokens", r.apiTokens)
+		e.Resolve("Mutation", "createAPIToken", r.createAPIToken)
+		e.Resolve("Mutation", "revokeAPIToken", r.revokeAPIToken)
+	}
+
+	if r.Events != nil {
+		e.Subscribe("deploymentSaved", r.deploymentSaved)
+		e.Subscribe("metricsUpdated", r.metricsUpdated)
+	}
+}
+
+func (r *Resolver) allProjectNames(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+	return r.listing(ctx).ProjectNames()
+}
+
+func (r *Resolver) allProjectGroupNames(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+	return r.listing(ctx).GroupNames()
+}
+
+func (r *Resolver) projects(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+
+	first, err := intArg(args["first"])
+	if err != nil {
+		return nil, err
+	}
+
+	after, _ := args["after"].(string)
+	group, _ := args["groupName"].(string)
+
+	p, err := r.listing(ctx).ProjectsPage(group, first, after)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := []interface{}{}
+	for _, proj := range p.Projects {
+		nodes = append(nodes, newProject(proj))
+	}
+
+	return newConnection(nodes, p.Cursors, p.HasNextPage, p.HasPreviousPage), nil
+}
+
+func (r *Resolver) deployments(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+
+	first, err := intArg(args["first"])
+	if err != nil {
+		return nil, err
+	}
+
+	after, _ := args["after"].(string)
+
+	f, err := deploymentFilter(args["filter"])
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := r.listing(ctx).DeploymentsPage(f, first, after)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := []interface{}{}
+	for _, dep := range p.Deployments {
+		nodes = append(nodes, newDeployment(dep))
+	}
+
+	return newConnection(nodes, p.Cursors, p.HasNextPage, p.HasPreviousPage), nil
+}
+
+func (r *Resolver) deployment(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+
+	id, err := intArg(args["deploymentID"])
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := r.loaders(ctx).deployments.Load(id)
+	if err != nil || d == nil {
+		return nil, err
+	}
+
+	return newDeployment(d.(*listing.Deployment)), nil
+}
+
+func (r *Resolver) projectDeployments(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+
+	id, err := strconv.Atoi(obj.(*Project).ProjectID)
+	if err != nil {
+		return nil, err
+	}
+
+	first, err := intArg(args["first"])
+	if err != nil {
+		return nil, err
+	}
+	if first < 1 || first > listing.MaxPageSize {
+		return nil, fmt.Errorf("first must be between 1 and %v", listing.MaxPageSize)
+	}
+
+	dr, err := dateRangeArg(args["dateRange"])
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := r.loaders(ctx).projectDeploymentsLoader(dr, first).Load(id)
+	if err != nil {
+		return nil, err
+	}
+
+	d, _ := v.([]*listing.Deployment)
+
+	deployments := []*Deployment{}
+	for _, dep := range d {
+		deployments = append(deployments, newDeployment(dep))
+	}
+
+	return deployments, nil
+}
+
+func (r *Resolver) deploymentProject(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+
+	p, err := r.loaders(ctx).projects.Load(obj.(*Deployment).ProjectID)
+	if err != nil || p == nil {
+		return nil, err
+	}
+
+	return newProject(p.(*listing.Project)), nil
+}
+
+func (r *Resolver) deploymentFrequency(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+
+	f, err := metricsFilter(args)
+	if err != nil {
+		return nil, err
+	}
+
+	fr, err := r.metrics(ctx).DeploymentFrequency(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return fr.Deployments, nil
+}
+
+func (r *Resolver) changeFailRate(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+
+	f, err := metricsFilter(args)
+	if err != nil {
+		return nil, err
+	}
+
+	cfr, err := r.metrics(ctx).ChangeFailureRate(f, r.FailureMode)
+	if err != nil {
+		return nil, err
+	}
+
+	return metrics.Percent(cfr.Rate), nil
+}
+
+func (r *Resolver) meanTimeToRecover(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+
+	f, err := metricsFilter(args)
+	if err != nil {
+		return nil, err
+	}
+
+	ttr, err := r.metrics(ctx).MeanTimeToRecover(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return metrics.Seconds(ttr.Mean), nil
+}
+
+func (r *Resolver) changeLeadTime(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+
+	f, err := metricsFilter(args)
+	if err != nil {
+		return nil, err
+	}
+
+	lt, err := r.metrics(ctx).ChangeLeadTime(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return metrics.Seconds(lt.Mean), nil
+}
+
+func (r *Resolver) leadTimeDistribution(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+	return r.distribution(args, r.metrics(ctx).LeadTimeDistribution)
+}
+
+func (r *Resolver) timeToRecoverDistribution(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+	return r.distribution(args, r.metrics(ctx).TimeToRecoverDistribution)
+}
+
+func (r *Resolver) deploymentDurationDistribution(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+	return r.distribution(args, r.metrics(ctx).DurationDistribution)
+}
+
+func (r *Resolver) distribution(args map[string]interface{}, calc func(metrics.Filter) (*metrics.Distribution, error)) (interface{}, error) {
+
+	f, err := metricsFilter(args)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := calc(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return newDistribution(d), nil
+}
+
+func (r *Resolver) compareMetrics(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+
+	dr, err := dateRangeArg(args["dateRange"])
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := r.metrics(ctx).CompareAll(dr, r.FailureMode)
+	if err != nil {
+		return nil, err
+	}
+
+	return newPeriodComparison(c), nil
+}
+
+func (r *Resolver) namespaceRollup(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+
+	f, err := metricsFilter(args)
+	if err != nil {
+		return nil, err
+	}
+
+	nr, err := r.metrics(ctx).NamespaceRollup(f.DateRange, f.GroupName, r.FailureMode)
+	if err != nil || nr == nil {
+		return nil, err
+	}
+
+	return newNamespaceRollup(nr), nil
+}
+
+func (r *Resolver) timeSeries(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+
+	f, err := metricsFilter(args)
+	if err != nil {
+		return nil, err
+	}
+
+	s, _ := args["interval"].(string)
+	i, err := metrics.ParseInterval(strings.ToLower(s))
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := r.metrics(ctx).TimeSeries(f, i, r.FailureMode)
+	if err != nil {
+		return nil, err
+	}
+
+	buckets := []*MetricBucket{}
+	for _, bucket := range b {
+		buckets = append(buckets, newMetricBucket(bucket))
+	}
+
+	return buckets, nil
+}
+
+func (r *Resolver) classification(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+
+	f, err := metricsFilter(args)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := r.thresholds()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := r.metrics(ctx).Classify(f, r.FailureMode, t)
+	if err != nil {
+		return nil, err
+	}
+
+	return newClassification(c), nil
+}
+
+func (r *Resolver) classifications(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+
+	dr, err := dateRangeArg(args["dateRange"])
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := r.thresholds()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := r.metrics(ctx).ClassifyAll(dr, r.FailureMode, t)
+	if err != nil {
+		return nil, err
+	}
+
+	return newClassifications(c), nil
+}
+
+// thresholds returns the configured thresholds, or the default thresholds
+func (r *Resolver) thresholds() (*metrics.Thresholds, error) {
+	if r.Thresholds != nil {
+		return r.Thresholds, nil
+	}
+	return metrics.LookupThresholds(metrics.DefaultThresholdsVersion)
+}
+
+func (r *Resolver) collectionRun(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+
+	id, _ := args["id"].(string)
+
+	run := r.Collector.Run(id)
+	if ids, ok := access.FromContext(ctx); ok && run != nil {
+		run = run.Visible(ids)
+	}
+	if run == nil {
+		return nil, nil
+	}
+
+	return newCollectionRun(run), nil
+}
+
+func (r *Resolver) refreshData(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+
+	if err := r.authorize(ctx, tokens.TriggerRefresh); err != nil {
+		return nil, err
+	}
+
+	opt := collector.Options{}
+
+	var err error
+	if opt.ProjectID, err = intArg(args["projectID"]); err != nil {
+		return nil, err
+	}
+
+	if args["since"] != nil {
+		if opt.Since, err = parseDateTime(args["since"]); err != nil {
+			return nil, err
+		}
+	}
+
+	opt.FullResync, _ = args["fullResync"].(bool)
+
+	return r.Collector.Start(opt)
+}
+
+func (r *Resolver) apiTokens(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+
+	if err := r.authorize(ctx, tokens.Admin); err != nil {
+		return nil, err
+	}
+
+	t, err := r.Tokens.Tokens()
+	if err != nil {
+		return nil, err
+	}
+
+	apiTokens := []*APIToken{}
+	for _, tok := range t {
+		apiTokens = append(apiTokens, newAPIToken(tok))
+	}
+
+	return apiTokens, nil
+}
+
+func (r *Resolver) createAPIToken(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+
+	if err := r.authorize(ctx, tokens.Admin); err != nil {
+		return nil, err
+	}
+
+	name, _ := args["name"].(string)
+
+	scopes := []tokens.Scope{}
+	list, _ := args["scopes"].([]interface{})
+	for _, v := range list {
+		s, _ := v.(string)
+		sc, err := tokens.ParseScope(strings.ToLower(s))
+		if err != nil {
+			return nil, err
+		}
+		scopes = append(scopes, sc)
+	}
+
+	var expiresAt *time.Time
+	if args["expiresAt"] != nil {
+		t, err := parseDateTime(args["expiresAt"])
+		if err != nil {
+			return nil, err
+		}
+		expiresAt = &t
+	}
+
+	secret, t, err := r.Tokens.Create(name, scopes, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CreatedAPIToken{Token: secret, APIToken: newAPIToken(t)}, nil
+}
+
+func (r *Resolver) revokeAPIToken(ctx context.Context, obj interface{}, args map[string]interface{}) (interface{}, error) {
+
+	if err := r.authorize(ctx, tokens.Admin); err != nil {
+		return nil, err
+	}
+
+	id, _ := args["id"].(string)
+
+	return r.Tokens.Revoke(id)
+}
+
+// authorize fails unless the token the request was authenticated with has
+// the scope. Every request is allowed when authentication is disabled.
+func (r *Resolver) authorize(ctx context.Context, scope tokens.Scope) error {
+
+	if r.Tokens == nil {
+		return nil
+	}
+
+	if t := tokens.FromContext(ctx); t == nil || !t.Allows(scope) {
+		return fmt.Errorf("API token is missing the %s scope", strings.ToUpper(string(scope)))
+	}
+
+	return nil
+}
+
+// metrics returns the metrics service for the request, restricted to the
+// projects the user can access when they logged in with GitLab
+func (r *Resolver) metrics(ctx context.Context) *metrics.Service {
+	if ids, ok := access.FromContext(ctx); ok {
+		return r.Metrics.Visible(ids)
+	}
+	return r.Metrics
+}
+
+// listing returns the listing service for the request, restricted to the
+// projects the user can access when they logged in with GitLab
+func (r *Resolver) listing(ctx context.Context) *listing.Service {
+	if ids, ok := access.FromContext(ctx); ok {
+		return r.Listing.Visible(ids)
+	}
+	return r.Listing
+}
+
+func (r *Resolver) deploymentSaved(ctx context.Context, args map[string]interface{}) (<-chan interface{}, error) {
+
+	p, _ := args["projectName"].(string)
+	g, _ := args["groupName"].(string)
+
+	in, unsubscribe := r.Events.Subscribe()
+	out := make(chan interface{})
+
+	go func() {
+		defer close(out)
+		defer unsubscribe()
+
+		for {
+			select {
+			case d := <-in:
+				if !events.Matches(d, p, g) {
+					continue
+				}
+
+				dep, err := r.listing(ctx).Deployment(d.ID)
+				if err != nil {
+					fmt.Printf("Error: %v", err.Error())
+					continue
+				}
+				if dep == nil {
+					continue
+				}
+
+				select {
+				case out <- newDeployment(dep):
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+func (r *Resolver) metricsUpdated(ctx context.Context, args map[string]interface{}) (<-chan interface{}, error) {
+
+	p, _ := args["projectName"].(string)
+	g, _ := args["groupName"].(string)
+
+	days, err := intArg(args["days"])
+	if err != nil {
+		return nil, err
+	}
+	if days < 1 {
+		return nil, fmt.Errorf("days must be at least 1")
+	}
+
+	summary := func() (interface{}, error) {
+		end := time.Now()
+		f := metrics.Filter{
+			DateRange:   metrics.DateRange{Start: end.AddDate(0, 0, -days), End: end},
+			ProjectName: p,
+			GroupName:   g,
+		}
+
+		s, err := r.metrics(ctx).Summarise(f, r.FailureMode)
+		if err != nil {
+			return nil, err
+		}
+
+		return newMetricSummary(s), nil
+	}
+
+	// fail the subscription immediately if the metrics cannot be calculated
+	current, err := summary()
+	if err != nil {
+		return nil, err
+	}
+
+	in, unsubscribe := r.Events.Subscribe()
+	out := make(chan interface{})
+
+	go func() {
+		defer close(out)
+		defer unsubscribe()
+
+		next := current
+
+		for {
+			// send the latest summary once the subscriber is ready, so events
+			// which arrive in the meantime replace the pending summary
+			var send chan interface{}
+			if next != nil {
+				send = out
+			}
+
+			select {
+			case send <- next:
+				next = nil
+			case d := <-in:
+				if !events.Matches(d, p, g) {
+					continue
+				}
+				s, err := summary()
+				if err != nil {
+					fmt.Printf("Error: %v", err.Error())
+					continue
+				}
+				next = s
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// metricsFilter converts the dateRange, projectName and groupName arguments into a filter
+func metricsFilter(args map[string]interface{}) (metrics.Filter, error) {
+
+	dr, err := dateRangeArg(args["dateRange"])
+	if err != nil {
+		return metrics.Filter{}, err
+	}
+
+	p, _ := args["projectName"].(string)
+	g, _ := args["groupName"].(string)
+
+	return metrics.Filter{DateRange: dr, ProjectName: p, GroupName: g}, nil
+}
+
+// deploymentFilter converts a DeploymentFilter input value into a listing filter
+func deploymentFilter(v interface{}) (listing.Filter, error) {
+
+	f := listing.Filter{}
+
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return f, nil
+	}
+
+	dr, err := dateRangeArg(m["dateRange"])
+	if err != nil {
+		return f, err
+	}
+
+	if f.ProjectID, err = intArg(m["projectID"]); err != nil {
+		return f, err
+	}
+
+	f.Start = dr.Start
+	f.End = dr.End
+	f.Status, _ = m["status"].(string)
+	f.Environment, _ = m["environment"].(string)
+	f.ProjectName, _ = m["projectName"].(string)
+	f.GroupName, _ = m["groupName"].(string)
+
+	return f, nil
+}
+
+// intArg converts an Int argument from a query literal or JSON variable
+func intArg(v interface{}) (int, error) {
+	switch i := v.(type) {
+	case nil:
+		return 0, nil
+	case int64:
+		return int(i), nil
+	case int:
+		return i, nil
+	case json.Number:
+		n, err := i.Int64()
+		return int(n), err
+	case string:
+		return strconv.Atoi(i)
+	}
+	return 0, fmt.Errorf("invalid Int %v", v)
+}
+
+// dateRangeArg converts a DateRange input value into a date range
+func dateRangeArg(v interface{}) (metrics.DateRange, error) {
+
+	dr := metrics.DateRange{}
+
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return dr, nil
+	}
+
+	var err error
+	if dr.Start, err = parseDateTime(m["start"]); err != nil {
+		return dr, err
+	}
+	if dr.End, err = parseEndDateTime(m["end"]); err != nil {
+		return dr, err
+	}
+
+	if dr.End.Before(dr.Start) {
+		return dr, fmt.Errorf("dateRange end must not be before start")
+	}
+
+	return dr, nil
+}
+
+// parseDateTime converts a DateTime value in RFC 3339 or YYYY-MM-DD format into a time
+func parseDateTime(v interface{}) (time.Time, error) {
+
+	s, ok := v.(string)
+	if !ok {
+		return time.Time{}, fmt.Errorf("invalid DateTime %v", v)
+	}
+
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid DateTime %q, expected RFC 3339 or YYYY-MM-DD", s)
+	}
+
+	return t, nil
+}
+
+// parseEndDateTime converts the end of a date range like parseDateTime, where
+// a date without a time includes the whole of that day
+func parseEndDateTime(v interface{}) (time.Time, error) {
+
+	t, err := parseDateTime(v)
+	if err != nil {
+		return t, err
+	}
+
+	if s, _ := v.(string); len(s) == len("2006-01-02") {
+		t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+
+	return t, nil
+}
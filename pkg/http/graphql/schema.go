@@ -0,0 +1,25 @@
+package graphql
+
+import (
+	"embed"
+
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+//go:embed schema.graphql
+var schemaSource string
+
+//go:embed queries/*.graphql
+var registeredQueries embed.FS // registered as persisted queries at startup
+
+// LoadSchema parses and validates the metrix GraphQL schema
+func LoadSchema() (*ast.Schema, error) {
+
+	s, err := gqlparser.LoadSchema(&ast.Source{Name: "schema.graphql", Input: schemaSource})
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
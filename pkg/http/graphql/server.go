@@ -0,0 +1,113 @@
+package graphql
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+// Server serves the metrix GraphQL API over HTTP
+type Server struct {
+	// Queries holds the persisted queries clients may send by hash
+	Queries *PersistedQueries
+
+	e *Executor
+	r *Resolver
+}
+
+// NewServer creates a GraphQL server for the metrix schema using the resolver,
+// rejecting queries which exceed the limits
+func NewServer(r *Resolver, l Limits) (*Server, error) {
+
+	s, err := LoadSchema()
+	if err != nil {
+		return nil, err
+	}
+
+	e := NewExecutor(s)
+	e.Limits = l
+	r.register(e)
+
+	pq, err := NewPersistedQueries()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Server{Queries: pq, e: e, r: r}, nil
+}
+
+// ServeHTTP executes GraphQL requests sent as JSON in a POST body, or as
+// query, operationName and variables parameters of a GET request, which may
+// only contain queries. Websocket
+// upgrade requests are served subscriptions using the graphql-ws protocol.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		s.subscriptionHandler().ServeHTTP(w, r)
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	req, err := decodeRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.Queries.resolve(req); err != nil {
+		writeJSON(w, &Response{Errors: gqlerror.List{err}})
+		return
+	}
+
+	writeJSON(w, s.e.Execute(s.r.withLoaders(r.Context()), req))
+}
+
+func decodeRequest(r *http.Request) (*Request, error) {
+
+	req := new(Request)
+
+	if r.Method == http.MethodGet {
+		req.queryOnly = true
+		req.Query = r.URL.Query().Get("query")
+		req.OperationName = r.URL.Query().Get("operationName")
+		if v := r.URL.Query().Get("variables"); v != "" {
+			if err := decodeJSON(strings.NewReader(v), &req.Variables); err != nil {
+				return nil, err
+			}
+		}
+		if v := r.URL.Query().Get("extensions"); v != "" {
+			if err := decodeJSON(strings.NewReader(v), &req.Extensions); err != nil {
+				return nil, err
+			}
+		}
+		return req, nil
+	}
+
+	if err := decodeJSON(r.Body, req); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// decodeJSON decodes numbers as json.Number so Int variables pass validation
+func decodeJSON(r io.Reader, v interface{}) error {
+	d := json.NewDecoder(r)
+	d.UseNumber()
+	return d.Decode(v)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,179 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"golang.org/x/net/websocket"
+)
+
+// subscriptionProtocol is the websocket subprotocol used by Apollo and
+// GraphiQL clients for subscriptions
+const subscriptionProtocol = "graphql-ws"
+
+// graphql-ws message types
+const (
+	connectionInit      = "connection_init"
+	connectionAck       = "connection_ack"
+	connectionError     = "connection_error"
+	connectionTerminate = "connection_terminate"
+	start               = "start"
+	stop                = "stop"
+	data                = "data"
+	errorMessage        = "error"
+	complete            = "complete"
+)
+
+type message struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// subscriptionHandler serves GraphQL subscriptions over a websocket
+func (s *Server) subscriptionHandler() http.Handler {
+	return websocket.Server{
+		Handshake: func(cfg *websocket.Config, r *http.Request) error {
+			for _, p := range cfg.Protocol {
+				if p == subscriptionProtocol {
+					cfg.Protocol = []string{subscriptionProtocol}
+					return nil
+				}
+			}
+			return websocket.ErrBadWebSocketProtocol
+		},
+		Handler: s.serveSubscriptions,
+	}
+}
+
+// connection holds the running subscriptions of a websocket connection
+type connection struct {
+	s       *Server
+	ws      *websocket.Conn
+	ctx     context.Context
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	subs    map[string]*subscription
+	wg      sync.WaitGroup
+}
+
+type subscription struct {
+	cancel context.CancelFunc
+}
+
+func (s *Server) serveSubscriptions(ws *websocket.Conn) {
+
+	ctx, cancel := context.WithCancel(ws.Request().Context())
+
+	c := &connection{s: s, ws: ws, ctx: ctx, subs: map[string]*subscription{}}
+
+	// stop every subscription once the connection closes
+	defer c.wg.Wait()
+	defer cancel()
+
+	for {
+		var msg message
+		if err := websocket.JSON.Receive(ws, &msg); err != nil {
+			return
+		}
+
+		switch msg.Type {
+		case connectionInit:
+			c.send(&message{Type: connectionAck})
+		case start:
+			c.start(&msg)
+		case stop:
+			c.stop(msg.ID)
+		case connectionTerminate:
+			return
+		default:
+			c.send(&message{Type: connectionError, Payload: payload(gqlerror.Errorf("unexpected message type %q", msg.Type))})
+		}
+	}
+}
+
+func (c *connection) start(msg *message) {
+
+	req := new(Request)
+	if err := decodeJSON(bytes.NewReader(msg.Payload), req); err != nil {
+		c.send(&message{ID: msg.ID, Type: errorMessage, Payload: payload(gqlerror.List{gqlerror.Errorf("%v", err)})})
+		return
+	}
+
+	if err := c.s.Queries.resolve(req); err != nil {
+		c.send(&message{ID: msg.ID, Type: errorMessage, Payload: payload(gqlerror.List{err})})
+		return
+	}
+
+	ctx, cancel := context.WithCancel(c.ctx)
+
+	// a repeated id replaces the running subscription
+	c.stop(msg.ID)
+
+	results, errs := c.s.e.ExecuteSubscription(ctx, req)
+	if errs != nil {
+		cancel()
+		c.send(&message{ID: msg.ID, Type: errorMessage, Payload: payload(errs)})
+		return
+	}
+
+	sub := &subscription{cancel}
+
+	c.mu.Lock()
+	c.subs[msg.ID] = sub
+	c.mu.Unlock()
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+
+		for resp := range results {
+			c.send(&message{ID: msg.ID, Type: data, Payload: payload(resp)})
+		}
+
+		// only report completion if the client did not stop the subscription
+		c.mu.Lock()
+		running := c.subs[msg.ID] == sub
+		if running {
+			delete(c.subs, msg.ID)
+		}
+		c.mu.Unlock()
+
+		if running && c.ctx.Err() == nil {
+			c.send(&message{ID: msg.ID, Type: complete})
+		}
+	}()
+}
+
+func (c *connection) stop(id string) {
+
+	c.mu.Lock()
+	sub, ok := c.subs[id]
+	delete(c.subs, id)
+	c.mu.Unlock()
+
+	if ok {
+		sub.cancel()
+	}
+}
+
+// send writes a message, serialising writes from concurrent subscriptions
+func (c *connection) send(msg *message) {
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	websocket.JSON.Send(c.ws, msg)
+}
+
+func payload(v interface{}) json.RawMessage {
+	b, err := json.Marshal(v)
+	if err != nil {
+		b, _ = json.Marshal(gqlerror.List{gqlerror.Errorf("%v", err)})
+	}
+	return b
+}
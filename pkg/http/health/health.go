@@ -0,0 +1,169 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sk000f/metrix/pkg/access"
+	"github.com/sk000f/metrix/pkg/collector"
+)
+
+// pingTimeout is how long the readiness check waits for the database
+const pingTimeout = 2 * time.Second
+
+// Database is the storage the server depends on
+type Database interface {
+	// Ping checks the database can be reached
+	Ping(ctx context.Context) error
+}
+
+// CIServer names the collector of a CI server in the status
+type CIServer struct {
+	Name      string
+	URL       string
+	Collector *collector.Service
+}
+
+// Handler serves /healthz, which reports the server is running, /readyz, which
+// checks the database, and /status, which reports the recent collection runs of
+// each CI server
+type Handler struct {
+	db      Database
+	servers []*CIServer
+}
+
+// check is the result of a health or readiness check
+type check struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// status is the body of /status
+type status struct {
+	CIServers []*serverStatus `json:"ciServers"`
+}
+
+type serverStatus struct {
+	Name           string        `json:"name"`
+	URL            string        `json:"url,omitempty"`
+	Running        int           `json:"running"`
+	LastCollection *collection   `json:"lastCollection"`
+	RecentErrors   []*errorEntry `json:"recentErrors"`
+}
+
+type collection struct {
+	RunID            string    `json:"runID"`
+	StartedAt        time.Time `json:"startedAt"`
+	FinishedAt       time.Time `json:"finishedAt"`
+	DurationSeconds  float64   `json:"durationSeconds"`
+	Projects         int       `json:"projects"`
+	ProjectsDone     int       `json:"projectsDone"`
+	DeploymentsSaved int       `json:"deploymentsSaved"`
+	Failed           bool      `json:"failed"`
+}
+
+type errorEntry struct {
+	RunID       string    `json:"runID"`
+	At          time.Time `json:"at"`
+	ProjectID   int       `json:"projectID,omitempty"`
+	ProjectName string    `json:"projectName,omitempty"`
+	Message     string    `json:"message"`
+}
+
+// NewHandler creates a handler checking the database and reporting the status
+// of the CI servers
+func NewHandler(db Database, servers ...*CIServer) *Handler {
+	return &Handler{db, servers}
+}
+
+// ServeHTTP serves the check or status for the path
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/healthz":
+		// the server is live while it can respond, so a database outage does
+		// not get it restarted
+		h.checkResult(w, nil)
+	case "/readyz":
+		ctx, cancel := context.WithTimeout(r.Context(), pingTimeout)
+		defer cancel()
+		h.checkResult(w, h.db.Ping(ctx))
+	case "/status":
+		writeJSON(w, http.StatusOK, h.status(r.Context()))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// checkResult reports the database as ok, or unavailable with the error
+func (h *Handler) checkResult(w http.ResponseWriter, err error) {
+
+	if err != nil {
+		fmt.Printf("Error: %v", err.Error())
+		writeJSON(w, http.StatusServiceUnavailable, &check{Status: "unavailable", Error: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &check{Status: "ok"})
+}
+
+// status reports the runs of each CI server, with only the errors of the
+// projects the user can access when they logged in with GitLab
+func (h *Handler) status(ctx context.Context) *status {
+
+	st := &status{CIServers: []*serverStatus{}}
+
+	for _, s := range h.servers {
+		cs := s.Collector.Status()
+		if ids, ok := access.FromContext(ctx); ok {
+			cs = cs.Visible(ids)
+		}
+
+		ss := &serverStatus{
+			Name:         s.Name,
+			URL:          s.URL,
+			Running:      cs.Running,
+			RecentErrors: []*errorEntry{},
+		}
+
+		if r := cs.LastRun; r != nil {
+			ss.LastCollection = &collection{
+				RunID:            r.ID,
+				StartedAt:        r.StartedAt,
+				FinishedAt:       *r.FinishedAt,
+				DurationSeconds:  r.Duration().Seconds(),
+				Projects:         r.Projects,
+				ProjectsDone:     r.ProjectsDone,
+				DeploymentsSaved: r.DeploymentsSaved,
+				Failed:           r.Error != "",
+			}
+		}
+
+		for _, e := range cs.RecentErrors {
+			ss.RecentErrors = append(ss.RecentErrors, &errorEntry{
+				RunID:       e.RunID,
+				At:          e.At,
+				ProjectID:   e.ProjectID,
+				ProjectName: e.ProjectName,
+				Message:     e.Message,
+			})
+		}
+
+		st.CIServers = append(st.CIServers, ss)
+	}
+
+	return st
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Printf("Error: %v", err.Error())
+	}
+}
@@ -0,0 +1,181 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sk000f/metrix/pkg/access"
+	"github.com/sk000f/metrix/pkg/collector"
+	"github.com/sk000f/metrix/pkg/http/health"
+)
+
+func TestHealth(t *testing.T) {
+
+	t.Run("report ok when the database can be reached", func(t *testing.T) {
+
+		h := health.NewHandler(&mockDB{})
+
+		for _, p := range []string{"/healthz", "/readyz"} {
+			rec := get(h, p)
+			if rec.Code != http.StatusOK {
+				t.Errorf("got status %v for %v; wanted %v", rec.Code, p, http.StatusOK)
+			}
+			if got, want := rec.Body.String(), "{\"status\":\"ok\"}\n"; got != want {
+				t.Errorf("got %v for %v; wanted %v", got, p, want)
+			}
+		}
+	})
+
+	t.Run("report live but not ready when the database cannot be pinged", func(t *testing.T) {
+
+		h := health.NewHandler(&mockDB{pingErr: errors.New("server selection timeout")})
+
+		if rec := get(h, "/healthz"); rec.Code != http.StatusOK {
+			t.Errorf("got status %v for /healthz; wanted %v", rec.Code, http.StatusOK)
+		}
+
+		rec := get(h, "/readyz")
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("got status %v; wanted %v", rec.Code, http.StatusServiceUnavailable)
+		}
+		want := "{\"status\":\"unavailable\",\"error\":\"server selection timeout\"}\n"
+		if got := rec.Body.String(); got != want {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+	})
+}
+
+func TestStatus(t *testing.T) {
+
+	t.Run("report the last collection and errors of each CI server", func(t *testing.T) {
+
+		c := collector.NewService(&mockCI{}, new(mockRepo))
+		id, err := c.Start(collector.Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Wait(id)
+
+		h := health.NewHandler(&mockDB{}, &health.CIServer{Name: "gitlab", URL: "https://gitlab.example.com", Collector: c})
+
+		rec := get(h, "/status")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("got status %v; wanted %v", rec.Code, http.StatusOK)
+		}
+
+		var got struct {
+			CIServers []struct {
+				Name           string
+				Running        int
+				LastCollection *struct {
+					Projects         int
+					DeploymentsSaved int
+					Failed           bool
+				}
+				RecentErrors []struct {
+					ProjectName string
+					Message     string
+				}
+			}
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(got.CIServers) != 1 || got.CIServers[0].Name != "gitlab" {
+			t.Fatalf("got %+v; wanted the gitlab server", got)
+		}
+
+		s := got.CIServers[0]
+		if s.LastCollection == nil || s.LastCollection.Projects != 2 || s.LastCollection.DeploymentsSaved != 3 || s.LastCollection.Failed {
+			t.Errorf("got last collection %+v; wanted 2 projects and 3 deployments", s.LastCollection)
+		}
+		if len(s.RecentErrors) != 1 || s.RecentErrors[0].ProjectName != "site" || s.RecentErrors[0].Message != "not found" {
+			t.Errorf("got errors %+v; wanted not found for site", s.RecentErrors)
+		}
+	})
+
+	t.Run("only report errors of projects the user can access", func(t *testing.T) {
+
+		c := collector.NewService(&mockCI{}, new(mockRepo))
+		id, err := c.Start(collector.Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Wait(id)
+
+		h := health.NewHandler(&mockDB{}, &health.CIServer{Name: "gitlab", Collector: c})
+
+		// the user logged in with GitLab can only access the api project
+		req := httptest.NewRequest(http.MethodGet, "/status", nil)
+		req = req.WithContext(access.NewContext(req.Context(), []int{1}))
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		want := `"recentErrors":[]`
+		if got := rec.Body.String(); !strings.Contains(got, want) {
+			t.Errorf("got %v; wanted no errors of the site project", got)
+		}
+	})
+
+	t.Run("report no collection before the first run", func(t *testing.T) {
+
+		h := health.NewHandler(&mockDB{}, &health.CIServer{Name: "gitlab", Collector: collector.NewService(&mockCI{}, new(mockRepo))})
+
+		want := "{\"ciServers\":[{\"name\":\"gitlab\",\"running\":0,\"lastCollection\":null,\"recentErrors\":[]}]}\n"
+		if got := get(h, "/status").Body.String(); got != want {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+	})
+}
+
+func get(h http.Handler, path string) *httptest.ResponseRecorder {
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+	return rec
+}
+
+type mockDB struct {
+	pingErr error
+}
+
+func (m *mockDB) Ping(ctx context.Context) error {
+	return m.pingErr
+}
+
+type mockCI struct{}
+
+func (m *mockCI) Refresh(r collector.Repository, opt collector.Options, p collector.Progress) error {
+
+	p.ProjectsFound(2)
+	p.ProjectDone(&collector.Project{ID: 1, Name: "api"}, 3, nil)
+	p.ProjectDone(&collector.Project{ID: 2, Name: "site"}, 0, errors.New("not found"))
+
+	return nil
+}
+
+type mockRepo struct{}
+
+func (m *mockRepo) SaveProjects(p []*collector.Project) error {
+	return nil
+}
+
+func (m *mockRepo) SaveDeployment(d *collector.Deployment) error {
+	return nil
+}
+
+func (m *mockRepo) GetSyncCursor(projectID int) (*collector.SyncCursor, error) {
+	return nil, nil
+}
+
+func (m *mockRepo) SaveSyncCursor(c *collector.SyncCursor) error {
+	return nil
+}
@@ -0,0 +1,139 @@
+package login
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/sk000f/metrix/pkg/access"
+	"github.com/sk000f/metrix/pkg/http/auth"
+)
+
+// stateCookie holds the state sent to GitLab while a user is logging in,
+// which GitLab must send back for the login to be accepted
+const stateCookie = "metrix_oauth_state"
+
+// Handler logs users in with their GitLab account. /login sends the user to
+// GitLab to authorise metrix, /login/callback starts a session when GitLab
+// sends them back and /logout ends it.
+type Handler struct {
+	c *oauth2.Config
+	a *access.Service
+}
+
+// NewHandler creates a handler logging users in with the OAuth application
+func NewHandler(c *oauth2.Config, a *access.Service) *Handler {
+	return &Handler{c, a}
+}
+
+// ServeHTTP serves the login, callback and logout routes
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	switch r.URL.Path {
+	case "/login":
+		h.login(w, r)
+	case "/login/callback":
+		h.callback(w, r)
+	case "/logout":
+		h.logout(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// login redirects the user to GitLab to authorise metrix
+func (h *Handler) login(w http.ResponseWriter, r *http.Request) {
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		fmt.Printf("Error: %v", err.Error())
+		http.Error(w, "could not start login", http.StatusInternalServerError)
+		return
+	}
+	state := hex.EncodeToString(b)
+
+	h.setCookie(w, &http.Cookie{
+		Name:    stateCookie,
+		Value:   state,
+		Path:    "/login",
+		Expires: time.Now().Add(10 * time.Minute),
+	})
+
+	http.Redirect(w, r, h.c.AuthCodeURL(state), http.StatusFound)
+}
+
+// callback exchanges the code GitLab sent back for an access token and starts
+// a session for its user
+func (h *Handler) callback(w http.ResponseWriter, r *http.Request) {
+
+	q := r.URL.Query()
+
+	if e := q.Get("error"); e != "" {
+		http.Error(w, fmt.Sprintf("GitLab login failed: %v", e), http.StatusUnauthorized)
+		return
+	}
+
+	c, err := r.Cookie(stateCookie)
+	if err != nil || c.Value == "" || c.Value != q.Get("state") {
+		http.Error(w, "login expired or did not start here, please try again", http.StatusBadRequest)
+		return
+	}
+	h.setCookie(w, &http.Cookie{Name: stateCookie, Path: "/login", MaxAge: -1})
+
+	t, err := h.c.Exchange(r.Context(), q.Get("code"))
+	if err != nil {
+		fmt.Printf("Error: %v", err.Error())
+		http.Error(w, "could not complete GitLab login", http.StatusUnauthorized)
+		return
+	}
+
+	// the session outlives the request, so the token is refreshed without it
+	ss, err := h.a.Login(h.c.TokenSource(context.Background(), t))
+	if err != nil {
+		fmt.Printf("Error: %v", err.Error())
+		http.Error(w, "could not find your GitLab account", http.StatusBadGateway)
+		return
+	}
+
+	h.setCookie(w, &http.Cookie{
+		Name:    auth.SessionCookie,
+		Value:   ss.ID,
+		Path:    "/",
+		Expires: ss.ExpiresAt,
+	})
+
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// logout ends the session and forgets its cookie
+func (h *Handler) logout(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "logout must be posted", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if c, err := r.Cookie(auth.SessionCookie); err == nil {
+		h.a.Logout(c.Value)
+	}
+
+	h.setCookie(w, &http.Cookie{Name: auth.SessionCookie, Path: "/", MaxAge: -1})
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// setCookie sets a cookie scripts cannot read, which is only sent over HTTPS
+// when metrix is served over HTTPS
+func (h *Handler) setCookie(w http.ResponseWriter, c *http.Cookie) {
+	c.HttpOnly = true
+	c.SameSite = http.SameSiteLaxMode
+	c.Secure = strings.HasPrefix(h.c.RedirectURL, "https://")
+	http.SetCookie(w, c)
+}
@@ -0,0 +1,157 @@
+package login_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"golang.org/x/oauth2"
+
+	"github.com/sk000f/metrix/pkg/access"
+	"github.com/sk000f/metrix/pkg/http/auth"
+	"github.com/sk000f/metrix/pkg/http/login"
+)
+
+func TestLogin(t *testing.T) {
+
+	// gitlab exchanges any code for the access token of the user
+	gitlab := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/oauth/token" || r.FormValue("code") != "abc" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"alice-token","token_type":"Bearer"}`))
+	}))
+	defer gitlab.Close()
+
+	c := &oauth2.Config{
+		ClientID:     "metrix",
+		ClientSecret: "secret",
+		RedirectURL:  "https://metrix.example.com/login/callback",
+		Scopes:       []string{"read_api"},
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  gitlab.URL + "/oauth/authorize",
+			TokenURL: gitlab.URL + "/oauth/token",
+		},
+	}
+
+	a := access.NewService(new(mockRepo), new(mockRepo))
+	h := login.NewHandler(c, a)
+
+	t.Run("log in with GitLab and out again", func(t *testing.T) {
+
+		rec := serve(h, http.MethodGet, "/login")
+		if rec.Code != http.StatusFound {
+			t.Fatalf("got status %v; wanted %v", rec.Code, http.StatusFound)
+		}
+
+		loc, err := url.Parse(rec.Header().Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		state := loc.Query().Get("state")
+		if loc.Path != "/oauth/authorize" || state == "" || loc.Query().Get("client_id") != "metrix" {
+			t.Errorf("got redirect to %v; wanted the GitLab authorize page", loc)
+		}
+
+		sc := cookie(rec, "metrix_oauth_state")
+		if sc == nil || sc.Value != state || !sc.HttpOnly || !sc.Secure {
+			t.Fatalf("got state cookie %+v; wanted a secure cookie with the state", sc)
+		}
+
+		rec = serve(h, http.MethodGet, "/login/callback?code=abc&state="+state, sc)
+		if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/" {
+			t.Fatalf("got status %v to %v; wanted a redirect to /", rec.Code, rec.Header().Get("Location"))
+		}
+
+		session := cookie(rec, auth.SessionCookie)
+		if session == nil || session.Value == "" {
+			t.Fatalf("got no session cookie")
+		}
+
+		ss, err := a.Session(session.Value)
+		if err != nil || ss.User.Username != "alice" {
+			t.Fatalf("got %+v, %v; wanted a session for alice", ss, err)
+		}
+
+		if rec := serve(h, http.MethodGet, "/logout", session); rec.Code != http.StatusMethodNotAllowed {
+			t.Errorf("got status %v; wanted %v", rec.Code, http.StatusMethodNotAllowed)
+		}
+
+		rec = serve(h, http.MethodPost, "/logout", session)
+		if rec.Code != http.StatusSeeOther {
+			t.Errorf("got status %v; wanted %v", rec.Code, http.StatusSeeOther)
+		}
+
+		if c := cookie(rec, auth.SessionCookie); c == nil || c.MaxAge >= 0 {
+			t.Errorf("got session cookie %+v; wanted it removed", c)
+		}
+
+		if _, err := a.Session(session.Value); err != access.ErrInvalidSession {
+			t.Errorf("got %v; wanted %v", err, access.ErrInvalidSession)
+		}
+	})
+
+	t.Run("reject callbacks which did not start here", func(t *testing.T) {
+
+		state := &http.Cookie{Name: "metrix_oauth_state", Value: "expected"}
+
+		for _, tc := range []struct {
+			name    string
+			path    string
+			cookies []*http.Cookie
+			code    int
+		}{
+			{"no state cookie", "/login/callback?code=abc&state=expected", nil, http.StatusBadRequest},
+			{"wrong state", "/login/callback?code=abc&state=forged", []*http.Cookie{state}, http.StatusBadRequest},
+			{"denied by user", "/login/callback?error=access_denied", []*http.Cookie{state}, http.StatusUnauthorized},
+			{"invalid code", "/login/callback?code=xyz&state=expected", []*http.Cookie{state}, http.StatusUnauthorized},
+		} {
+			if rec := serve(h, http.MethodGet, tc.path, tc.cookies...); rec.Code != tc.code {
+				t.Errorf("got status %v for %v; wanted %v", rec.Code, tc.name, tc.code)
+			}
+		}
+	})
+}
+
+func serve(h http.Handler, method, path string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+
+	req := httptest.NewRequest(method, path, nil)
+	for _, c := range cookies {
+		req.AddCookie(&http.Cookie{Name: c.Name, Value: c.Value})
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func cookie(rec *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+type mockRepo struct{}
+
+func (m *mockRepo) CurrentUser(token string) (*access.User, error) {
+	if token != "alice-token" {
+		return nil, errors.New("401 Unauthorized")
+	}
+	return &access.User{ID: 1, Username: "alice"}, nil
+}
+
+func (m *mockRepo) MemberProjectIDs(token string) ([]int, error) {
+	return []int{1}, nil
+}
+
+func (m *mockRepo) PublicProjectIDs() ([]int, error) {
+	return []int{}, nil
+}
@@ -0,0 +1,219 @@
+package prometheus
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sk000f/metrix/pkg/access"
+	"github.com/sk000f/metrix/pkg/collector"
+	"github.com/sk000f/metrix/pkg/metrics"
+)
+
+// Content types of the exposition formats
+const (
+	openMetricsType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+	textType        = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// Handler serves the DORA metrics of each project and environment for
+// Prometheus to scrape. With a collector, the metrics of every project are
+// calculated once and served until the next collection run finishes, rather
+// than loading every deployment on each scrape.
+type Handler struct {
+	s *metrics.Service
+	m metrics.FailureMode
+	c *collector.Service
+
+	mu       sync.Mutex
+	cached   []*metrics.EnvironmentMetrics
+	finished int
+}
+
+// family describes a metric and how to read its value for a project environment
+type family struct {
+	name  string
+	kind  string
+	unit  string
+	help  string
+	value func(e *metrics.EnvironmentMetrics) (float64, bool)
+}
+
+var families = []family{
+	{
+		name: "metrix_deployments",
+		kind: "counter",
+		help: "Deployments which succeeded or failed.",
+		value: func(e *metrics.EnvironmentMetrics) (float64, bool) {
+			return float64(e.Deployments), true
+		},
+	},
+	{
+		name: "metrix_deployment_failures",
+		kind: "counter",
+		help: "Deployments counted as failed changes.",
+		value: func(e *metrics.EnvironmentMetrics) (float64, bool) {
+			return float64(e.Failures), true
+		},
+	},
+	{
+		name: "metrix_change_failure_rate",
+		kind: "gauge",
+		help: "Fraction of deployments which failed.",
+		value: func(e *metrics.EnvironmentMetrics) (float64, bool) {
+			return e.ChangeFailureRate, true
+		},
+	},
+	{
+		name: "metrix_last_time_to_recover_seconds",
+		kind: "gauge",
+		unit: "seconds",
+		help: "Time from the most recent resolved failure to the next successful deployment.",
+		value: func(e *metrics.EnvironmentMetrics) (float64, bool) {
+			if e.LastTimeToRecover == nil {
+				return 0, false
+			}
+			return e.LastTimeToRecover.Seconds(), true
+		},
+	},
+	{
+		name: "metrix_last_lead_time_seconds",
+		kind: "gauge",
+		unit: "seconds",
+		help: "Mean time from commit to the most recent successful deployment.",
+		value: func(e *metrics.EnvironmentMetrics) (float64, bool) {
+			if e.LastLeadTime == nil {
+				return 0, false
+			}
+			return e.LastLeadTime.Seconds(), true
+		},
+	},
+}
+
+// NewHandler creates a handler serving the metrics calculated by the service,
+// which are cached between the runs of the collector unless it is nil
+func NewHandler(s *metrics.Service, m metrics.FailureMode, c *collector.Service) *Handler {
+	return &Handler{s: s, m: m, c: c}
+}
+
+// ServeHTTP writes the metrics in the OpenMetrics format if the client accepts
+// it, otherwise in the Prometheus text format
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	em, err := h.metrics()
+	if err != nil {
+		fmt.Printf("Error: %v", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if ids, ok := access.FromContext(r.Context()); ok {
+		em = visible(em, ids)
+	}
+
+	openMetrics := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+
+	var b bytes.Buffer
+	write(&b, em, openMetrics)
+
+	if openMetrics {
+		w.Header().Set("Content-Type", openMetricsType)
+	} else {
+		w.Header().Set("Content-Type", textType)
+	}
+
+	b.WriteTo(w)
+}
+
+// metrics returns the metrics of every project, calculating them again once
+// a collection run has finished since they were cached
+func (h *Handler) metrics() ([]*metrics.EnvironmentMetrics, error) {
+
+	if h.c == nil {
+		return h.s.ByEnvironment(h.m)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// read before calculating, so a run finishing meanwhile is not missed
+	finished := h.c.Finished()
+	if h.cached != nil && finished == h.finished {
+		return h.cached, nil
+	}
+
+	em, err := h.s.ByEnvironment(h.m)
+	if err != nil {
+		return nil, err
+	}
+
+	h.cached, h.finished = em, finished
+
+	return em, nil
+}
+
+// visible returns the metrics of the projects with the IDs
+func visible(em []*metrics.EnvironmentMetrics, ids []int) []*metrics.EnvironmentMetrics {
+
+	allowed := map[int]bool{}
+	for _, id := range ids {
+		allowed[id] = true
+	}
+
+	v := []*metrics.EnvironmentMetrics{}
+	for _, e := range em {
+		if allowed[e.ProjectID] {
+			v = append(v, e)
+		}
+	}
+
+	return v
+}
+
+func write(b *bytes.Buffer, em []*metrics.EnvironmentMetrics, openMetrics bool) {
+
+	for _, f := range families {
+
+		// the text format names counters by their samples
+		name := f.name
+		if f.kind == "counter" && !openMetrics {
+			name += "_total"
+		}
+
+		fmt.Fprintf(b, "# HELP %s %s\n", name, f.help)
+		fmt.Fprintf(b, "# TYPE %s %s\n", name, f.kind)
+		if openMetrics && f.unit != "" {
+			fmt.Fprintf(b, "# UNIT %s %s\n", name, f.unit)
+		}
+
+		sample := f.name
+		if f.kind == "counter" {
+			sample += "_total"
+		}
+
+		for _, e := range em {
+			v, ok := f.value(e)
+			if !ok {
+				continue
+			}
+			fmt.Fprintf(b, "%s{project=%s,namespace=%s,environment=%s} %s\n",
+				sample, label(e.ProjectName), label(e.Namespace), label(e.Environment),
+				strconv.FormatFloat(v, 'g', -1, 64))
+		}
+	}
+
+	if openMetrics {
+		b.WriteString("# EOF\n")
+	}
+}
+
+// label quotes and escapes a label value
+func label(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, "\n", `\n`)
+	v = strings.ReplaceAll(v, `"`, `\"`)
+	return `"` + v + `"`
+}
@@ -0,0 +1,194 @@
+package prometheus_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sk000f/metrix/pkg/access"
+	"github.com/sk000f/metrix/pkg/collector"
+	"github.com/sk000f/metrix/pkg/http/prometheus"
+	"github.com/sk000f/metrix/pkg/metrics"
+)
+
+func TestPrometheus(t *testing.T) {
+
+	t.Run("write metrics in the Prometheus text format", func(t *testing.T) {
+
+		rec := scrape(t, "")
+
+		want := `# HELP metrix_deployments_total Deployments which succeeded or failed.
+# TYPE metrix_deployments_total counter
+metrix_deployments_total{project="api",namespace="org/platform",environment="production"} 2
+# HELP metrix_deployment_failures_total Deployments counted as failed changes.
+# TYPE metrix_deployment_failures_total counter
+metrix_deployment_failures_total{project="api",namespace="org/platform",environment="production"} 1
+# HELP metrix_change_failure_rate Fraction of deployments which failed.
+# TYPE metrix_change_failure_rate gauge
+metrix_change_failure_rate{project="api",namespace="org/platform",environment="production"} 0.5
+# HELP metrix_last_time_to_recover_seconds Time from the most recent resolved failure to the next successful deployment.
+# TYPE metrix_last_time_to_recover_seconds gauge
+metrix_last_time_to_recover_seconds{project="api",namespace="org/platform",environment="production"} 3600
+# HELP metrix_last_lead_time_seconds Mean time from commit to the most recent successful deployment.
+# TYPE metrix_last_lead_time_seconds gauge
+metrix_last_lead_time_seconds{project="api",namespace="org/platform",environment="production"} 1800
+`
+
+		if got := rec.Body.String(); got != want {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+
+		if got := rec.Header().Get("Content-Type"); got != "text/plain; version=0.0.4; charset=utf-8" {
+			t.Errorf("got content type %v", got)
+		}
+	})
+
+	t.Run("write metrics in the OpenMetrics format when accepted", func(t *testing.T) {
+
+		rec := scrape(t, "application/openmetrics-text; version=1.0.0")
+		got := rec.Body.Bytes()
+
+		for _, want := range []string{
+			"# TYPE metrix_deployments counter\n",
+			"metrix_deployments_total{project=\"api\",namespace=\"org/platform\",environment=\"production\"} 2\n",
+			"# UNIT metrix_last_lead_time_seconds seconds\n",
+		} {
+			if !bytes.Contains(got, []byte(want)) {
+				t.Errorf("got %s; wanted it to contain %q", got, want)
+			}
+		}
+
+		if !bytes.HasSuffix(got, []byte("# EOF\n")) {
+			t.Errorf("got %s; wanted it to end with # EOF", got)
+		}
+
+		if got := rec.Header().Get("Content-Type"); got != "application/openmetrics-text; version=1.0.0; charset=utf-8" {
+			t.Errorf("got content type %v", got)
+		}
+	})
+
+	t.Run("serve cached metrics until a collection run finishes", func(t *testing.T) {
+
+		r := newMockRepo(t)
+		c := collector.NewService(new(mockCI), new(mockCollectorRepo))
+		h := prometheus.NewHandler(metrics.NewService(r), metrics.CountEveryDeployment, c)
+
+		want := `metrix_deployments_total{project="api",namespace="org/platform",environment="production"} 2`
+		if got := get(h, "").Body.String(); !strings.Contains(got, want) {
+			t.Errorf("got %v; wanted it to contain %v", got, want)
+		}
+
+		r.DeploymentData = r.DeploymentData[:1]
+
+		if got := get(h, "").Body.String(); !strings.Contains(got, want) {
+			t.Errorf("got %v; wanted the cached metrics", got)
+		}
+
+		id, err := c.Start(collector.Options{})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err.Error())
+		}
+		c.Wait(id)
+
+		want = `metrix_deployments_total{project="api",namespace="org/platform",environment="production"} 1`
+		if got := get(h, "").Body.String(); !strings.Contains(got, want) {
+			t.Errorf("got %v; wanted it to contain %v", got, want)
+		}
+	})
+
+	t.Run("only serve metrics of projects the user can access", func(t *testing.T) {
+
+		c := collector.NewService(new(mockCI), new(mockCollectorRepo))
+		h := prometheus.NewHandler(metrics.NewService(newMockRepo(t)), metrics.CountEveryDeployment, c)
+
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req = req.WithContext(access.NewContext(req.Context(), []int{2}))
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if got := rec.Body.String(); strings.Contains(got, `project="api"`) {
+			t.Errorf("got %v; wanted no metrics of other projects", got)
+		}
+	})
+}
+
+func scrape(t *testing.T, accept string) *httptest.ResponseRecorder {
+	return get(prometheus.NewHandler(metrics.NewService(newMockRepo(t)), metrics.CountEveryDeployment, nil), accept)
+}
+
+func get(h http.Handler, accept string) *httptest.ResponseRecorder {
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func newMockRepo(t *testing.T) *mockRepo {
+
+	ts, err := time.Parse(time.RFC3339, "2020-10-01T09:00:00Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recovered := ts.Add(time.Hour)
+
+	r := &mockRepo{DeploymentData: []*metrics.Deployment{
+		{
+			ID: 1, Status: "failed", EnvironmentName: "production", ProjectID: 1, ProjectName: "api",
+			ProjectNamespace: "org/platform", PipelineID: 1, FinishedAt: &ts,
+		},
+		{
+			ID: 2, Status: "success", EnvironmentName: "production", ProjectID: 1, ProjectName: "api",
+			ProjectNamespace: "org/platform", PipelineID: 2, FinishedAt: &recovered,
+			Commits: []*metrics.Commit{{SHA: "a", CommittedAt: recovered.Add(-30 * time.Minute)}},
+		},
+	}}
+
+	return r
+}
+
+type mockRepo struct {
+	ProjectData    []*metrics.Project
+	DeploymentData []*metrics.Deployment
+}
+
+func (m *mockRepo) GetProjects() ([]*metrics.Project, error) {
+	return m.ProjectData, nil
+}
+
+func (m *mockRepo) GetDeployments(f metrics.Filter) ([]*metrics.Deployment, error) {
+	return m.DeploymentData, nil
+}
+
+type mockCI struct{}
+
+func (m *mockCI) Refresh(r collector.Repository, opt collector.Options, p collector.Progress) error {
+	return nil
+}
+
+type mockCollectorRepo struct{}
+
+func (m *mockCollectorRepo) SaveProjects(p []*collector.Project) error {
+	return nil
+}
+
+func (m *mockCollectorRepo) SaveDeployment(d *collector.Deployment) error {
+	return nil
+}
+
+func (m *mockCollectorRepo) GetSyncCursor(projectID int) (*collector.SyncCursor, error) {
+	return nil, nil
+}
+
+func (m *mockCollectorRepo) SaveSyncCursor(c *collector.SyncCursor) error {
+	return nil
+}
@@ -0,0 +1,273 @@
+package rest
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// route is an operation of the API. The OpenAPI document is generated from
+// the routes, so it always describes what is served.
+type route struct {
+	id       string
+	path     string
+	summary  string
+	params   []*param
+	response interface{}
+	serve    func(h *Handler, r *http.Request, vars map[string]string) (interface{}, error)
+}
+
+// param is a path or query parameter of a route
+type param struct {
+	name        string
+	in          string
+	description string
+	schema      map[string]interface{}
+}
+
+var (
+	stringSchema   = map[string]interface{}{"type": "string"}
+	integerSchema  = map[string]interface{}{"type": "integer"}
+	dateTimeSchema = map[string]interface{}{
+		"type":        "string",
+		"description": "RFC 3339 date and time, or YYYY-MM-DD for midnight UTC",
+	}
+)
+
+// filters shared with the GraphQL metric queries
+var metricFilters = []*param{
+	{"start", "query", "Start of the date range, set with end. All deployments are used when neither is set.", dateTimeSchema},
+	{"end", "query", "End of the date range, set with start. A date includes the whole day", dateTimeSchema},
+	{"projectName", "query", "Only use deployments of projects with this name", stringSchema},
+	{"groupName", "query", "Only use deployments of projects in this group or its subgroups", stringSchema},
+}
+
+// paging parameters of the listing routes
+var pageParams = []*param{
+	{"first", "query", "Number of items in the page, from 1 to 100, defaulting to 20", integerSchema},
+	{"after", "query", "endCursor of the previous page", stringSchema},
+}
+
+var routes = []*route{
+	{
+		id:       "listMetrics",
+		path:     "/metrics",
+		summary:  "Every DORA metric",
+		params:   metricFilters,
+		response: &MetricList{},
+		serve:    (*Handler).allMetrics,
+	},
+	{
+		id:      "getMetric",
+		path:    "/metrics/{metric}",
+		summary: "One DORA metric, the same as the matching GraphQL query",
+		params: append([]*param{
+			{"metric", "path", "Deployments counted, mean lead time and time to recover in seconds, or change failure rate as a percentage", map[string]interface{}{
+				"type": "string",
+				"enum": metricNames,
+			}},
+		}, metricFilters...),
+		response: &Metric{},
+		serve:    (*Handler).metric,
+	},
+	{
+		id:      "listProjects",
+		path:    "/projects",
+		summary: "Stored projects, ordered by ID",
+		params: append([]*param{
+			{"groupName", "query", "Only list projects in this group or its subgroups", stringSchema},
+		}, pageParams...),
+		response: &ProjectList{},
+		serve:    (*Handler).projects,
+	},
+	{
+		id:      "listDeployments",
+		path:    "/deployments",
+		summary: "Stored deployments, most recently finished first",
+		params: append([]*param{
+			{"start", "query", "Only list deployments finished from this time, set with end", dateTimeSchema},
+			{"end", "query", "Only list deployments finished until this time, or the end of this date, set with start", dateTimeSchema},
+			{"status", "query", "Only list deployments with this status", stringSchema},
+			{"environment", "query", "Only list deployments to this environment", stringSchema},
+			{"projectID", "query", "Only list deployments of the project with this GitLab ID", integerSchema},
+			{"projectName", "query", "Only list deployments of projects with this name", stringSchema},
+			{"groupName", "query", "Only list deployments of projects in this group or its subgroups", stringSchema},
+		}, pageParams...),
+		response: &DeploymentList{},
+		serve:    (*Handler).deployments,
+	},
+	{
+		id:       "getOpenAPI",
+		path:     "/openapi.json",
+		summary:  "This OpenAPI document",
+		response: map[string]interface{}{},
+		serve:    (*Handler).document,
+	},
+}
+
+// openAPI generates the OpenAPI document from the routes and the types they respond with
+func openAPI() map[string]interface{} {
+
+	schemas := map[string]interface{}{}
+	paths := map[string]interface{}{}
+
+	errorResponse := func(description string) map[string]interface{} {
+		return map[string]interface{}{
+			"description": description,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": schemaOf(reflect.TypeOf(&Error{}), schemas)},
+			},
+		}
+	}
+
+	for _, rt := range routes {
+
+		params := []interface{}{}
+		for _, p := range rt.params {
+			params = append(params, map[string]interface{}{
+				"name":        p.name,
+				"in":          p.in,
+				"description": p.description,
+				"required":    p.in == "path",
+				"schema":      p.schema,
+			})
+		}
+
+		responses := map[string]interface{}{
+			"200": map[string]interface{}{
+				"description": rt.summary,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": schemaOf(reflect.TypeOf(rt.response), schemas)},
+				},
+			},
+		}
+		if len(rt.params) > 0 {
+			responses["400"] = errorResponse("Invalid parameters")
+		}
+		if strings.Contains(rt.path, "{") {
+			responses["404"] = errorResponse("Not found")
+		}
+		responses["500"] = errorResponse("The data could not be read")
+
+		paths[Prefix+rt.path] = map[string]interface{}{
+			"get": map[string]interface{}{
+				"operationId": rt.id,
+				"summary":     rt.summary,
+				"parameters":  params,
+				"responses":   responses,
+			},
+		}
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "metrix",
+			"version":     "1.0.0",
+			"description": "DORA metrics from GitLab CI. Durations are in seconds and rates are percentages.",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"token":       map[string]interface{}{"type": "http", "scheme": "bearer"},
+				"accessToken": map[string]interface{}{"type": "apiKey", "in": "query", "name": "access_token"},
+				"session":     map[string]interface{}{"type": "apiKey", "in": "cookie", "name": "metrix_session"},
+			},
+		},
+		"security": []interface{}{
+			map[string]interface{}{"token": []string{}},
+			map[string]interface{}{"accessToken": []string{}},
+			map[string]interface{}{"session": []string{}},
+		},
+	}
+}
+
+// schemaOf returns the JSON schema of a type, adding named struct types to
+// schemas and referring to them. Pointers to other types may be null.
+func schemaOf(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+
+	nullable := false
+	for t.Kind() == reflect.Ptr {
+		t, nullable = t.Elem(), true
+	}
+
+	var s map[string]interface{}
+
+	switch {
+	case t == reflect.TypeOf(time.Time{}):
+		s = map[string]interface{}{"type": "string", "format": "date-time"}
+
+	case t.Kind() == reflect.Struct:
+		if _, ok := schemas[t.Name()]; !ok {
+			schemas[t.Name()] = structSchema(t, schemas)
+		}
+		// responses never hold null objects, so pointers to structs are
+		// referenced the same as structs
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+
+	case t.Kind() == reflect.Slice:
+		s = map[string]interface{}{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+
+	case t.Kind() == reflect.Map:
+		s = map[string]interface{}{"type": "object"}
+
+	case t.Kind() == reflect.String:
+		s = map[string]interface{}{"type": "string"}
+
+	case t.Kind() == reflect.Bool:
+		s = map[string]interface{}{"type": "boolean"}
+
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		s = map[string]interface{}{"type": "number"}
+
+	default:
+		s = map[string]interface{}{"type": "integer"}
+	}
+
+	if nullable {
+		s["nullable"] = true
+	}
+
+	return s
+}
+
+// structSchema returns the object schema of a struct from its JSON field names.
+// Fields are required unless they are omitted when empty.
+func structSchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+
+	props := map[string]interface{}{}
+	required := []string{}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		tag := strings.Split(f.Tag.Get("json"), ",")
+		if tag[0] == "-" || f.PkgPath != "" {
+			continue
+		}
+
+		name := tag[0]
+		if name == "" {
+			name = f.Name
+		}
+
+		props[name] = schemaOf(f.Type, schemas)
+
+		omitEmpty := false
+		for _, o := range tag[1:] {
+			omitEmpty = omitEmpty || o == "omitempty"
+		}
+		if !omitEmpty {
+			required = append(required, name)
+		}
+	}
+
+	s := map[string]interface{}{"type": "object", "properties": props}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+
+	return s
+}
@@ -0,0 +1,501 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sk000f/metrix/pkg/access"
+	"github.com/sk000f/metrix/pkg/listing"
+	"github.com/sk000f/metrix/pkg/metrics"
+)
+
+// Prefix is the path every route of version 1 of the API is served under
+const Prefix = "/api/v1"
+
+// errNotFound is returned by routes for resources which do not exist
+var errNotFound = errors.New("not found")
+
+// Handler serves the DORA metrics and the stored projects and deployments as
+// JSON under /api/v1, for clients which cannot send GraphQL queries. Like the
+// GraphQL API it reports durations in seconds and rates as percentages.
+type Handler struct {
+	m   *metrics.Service
+	l   *listing.Service
+	fm  metrics.FailureMode
+	doc map[string]interface{}
+}
+
+// NewHandler creates a handler serving the metrics calculated by the metrics
+// service and the projects and deployments listed by the listing service
+func NewHandler(m *metrics.Service, l *listing.Service, fm metrics.FailureMode) *Handler {
+	return &Handler{m, l, fm, openAPI()}
+}
+
+// Metric represents the value of a metric over the filtered deployments
+type Metric struct {
+	Metric      string     `json:"metric"`
+	Value       int        `json:"value"`
+	Unit        string     `json:"unit"`
+	Start       *time.Time `json:"start,omitempty"`
+	End         *time.Time `json:"end,omitempty"`
+	ProjectName string     `json:"projectName,omitempty"`
+	GroupName   string     `json:"groupName,omitempty"`
+}
+
+// MetricList represents the value of every metric
+type MetricList struct {
+	Metrics []*Metric `json:"metrics"`
+}
+
+// Project represents a stored project
+type Project struct {
+	ID                int    `json:"id"`
+	Name              string `json:"name"`
+	Path              string `json:"path"`
+	PathWithNamespace string `json:"pathWithNamespace"`
+	Namespace         string `json:"namespace"`
+	WebURL            string `json:"webURL"`
+}
+
+// ProjectList represents a page of projects
+type ProjectList struct {
+	Projects []*Project `json:"projects"`
+	PageInfo *PageInfo  `json:"pageInfo"`
+}
+
+// Deployment represents a stored deployment
+type Deployment struct {
+	ID               int        `json:"id"`
+	Status           string     `json:"status"`
+	Environment      string     `json:"environment"`
+	ProjectID        int        `json:"projectID"`
+	ProjectName      string     `json:"projectName"`
+	ProjectNamespace string     `json:"projectNamespace"`
+	PipelineID       int        `json:"pipelineID"`
+	SHA              string     `json:"sha"`
+	FinishedAt       *time.Time `json:"finishedAt"`
+	Duration         int        `json:"duration"`
+}
+
+// DeploymentList represents a page of deployments
+type DeploymentList struct {
+	Deployments []*Deployment `json:"deployments"`
+	PageInfo    *PageInfo     `json:"pageInfo"`
+}
+
+// PageInfo tells clients whether there is another page and the cursor to
+// request it with in the after parameter
+type PageInfo struct {
+	HasNextPage bool    `json:"hasNextPage"`
+	EndCursor   *string `json:"endCursor"`
+}
+
+// Error represents a request which failed
+type Error struct {
+	Error string `json:"error"`
+}
+
+// metric calculates one metric from the filtered deployments
+type metric struct {
+	unit      string
+	calculate func(s *metrics.Service, f metrics.Filter, fm metrics.FailureMode) (int, error)
+}
+
+// metricNames are the metrics served, in the order they are listed
+var metricNames = []string{"deployment-frequency", "lead-time", "time-to-recover", "change-failure-rate"}
+
+// metricsByName calculate each metric in the units of the GraphQL API
+var metricsByName = map[string]*metric{
+	"deployment-frequency": {
+		unit: "deployments",
+		calculate: func(s *metrics.Service, f metrics.Filter, fm metrics.FailureMode) (int, error) {
+			fr, err := s.DeploymentFrequency(f)
+			if err != nil {
+				return 0, err
+			}
+			return fr.Deployments, nil
+		},
+	},
+	"lead-time": {
+		unit: "seconds",
+		calculate: func(s *metrics.Service, f metrics.Filter, fm metrics.FailureMode) (int, error) {
+			lt, err := s.ChangeLeadTime(f)
+			if err != nil {
+				return 0, err
+			}
+			return metrics.Seconds(lt.Mean), nil
+		},
+	},
+	"time-to-recover": {
+		unit: "seconds",
+		calculate: func(s *metrics.Service, f metrics.Filter, fm metrics.FailureMode) (int, error) {
+			ttr, err := s.MeanTimeToRecover(f)
+			if err != nil {
+				return 0, err
+			}
+			return metrics.Seconds(ttr.Mean), nil
+		},
+	},
+	"change-failure-rate": {
+		unit: "percent",
+		calculate: func(s *metrics.Service, f metrics.Filter, fm metrics.FailureMode) (int, error) {
+			cfr, err := s.ChangeFailureRate(f, fm)
+			if err != nil {
+				return 0, err
+			}
+			return metrics.Percent(cfr.Rate), nil
+		},
+	},
+}
+
+// ServeHTTP serves the route matching the request path
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	for _, rt := range routes {
+
+		vars, ok := rt.match(r.URL.Path)
+		if !ok {
+			continue
+		}
+
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeJSON(w, http.StatusMethodNotAllowed, &Error{"only GET requests are supported"})
+			return
+		}
+
+		v, err := rt.serve(h, r, vars)
+		switch {
+		case err == errNotFound:
+			writeJSON(w, http.StatusNotFound, &Error{fmt.Sprintf("%v not found", r.URL.Path)})
+		case errors.As(err, new(*paramError)), err == listing.ErrInvalidCursor:
+			writeJSON(w, http.StatusBadRequest, &Error{err.Error()})
+		case err != nil:
+			fmt.Printf("Error: %v", err.Error())
+			writeJSON(w, http.StatusInternalServerError, &Error{err.Error()})
+		default:
+			writeJSON(w, http.StatusOK, v)
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusNotFound, &Error{fmt.Sprintf("%v not found", r.URL.Path)})
+}
+
+// metrics returns the metrics service for the request, restricted to the
+// projects the user can access when they logged in with GitLab
+func (h *Handler) metrics(r *http.Request) *metrics.Service {
+	if ids, ok := access.FromContext(r.Context()); ok {
+		return h.m.Visible(ids)
+	}
+	return h.m
+}
+
+// listing returns the listing service for the request, restricted to the
+// projects the user can access when they logged in with GitLab
+func (h *Handler) listing(r *http.Request) *listing.Service {
+	if ids, ok := access.FromContext(r.Context()); ok {
+		return h.l.Visible(ids)
+	}
+	return h.l
+}
+
+func (h *Handler) metric(r *http.Request, vars map[string]string) (interface{}, error) {
+
+	name := vars["metric"]
+	if _, ok := metricsByName[name]; !ok {
+		return nil, errNotFound
+	}
+
+	f, err := metricsFilter(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.calculate(h.metrics(r), name, f)
+}
+
+func (h *Handler) allMetrics(r *http.Request, vars map[string]string) (interface{}, error) {
+
+	f, err := metricsFilter(r)
+	if err != nil {
+		return nil, err
+	}
+
+	s := h.metrics(r)
+	l := &MetricList{Metrics: []*Metric{}}
+
+	for _, name := range metricNames {
+		m, err := h.calculate(s, name, f)
+		if err != nil {
+			return nil, err
+		}
+		l.Metrics = append(l.Metrics, m)
+	}
+
+	return l, nil
+}
+
+func (h *Handler) calculate(s *metrics.Service, name string, f metrics.Filter) (*Metric, error) {
+
+	mt := metricsByName[name]
+
+	v, err := mt.calculate(s, f, h.fm)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Metric{
+		Metric:      name,
+		Value:       v,
+		Unit:        mt.unit,
+		ProjectName: f.ProjectName,
+		GroupName:   f.GroupName,
+	}
+	if !f.DateRange.IsZero() {
+		m.Start = &f.DateRange.Start
+		m.End = &f.DateRange.End
+	}
+
+	return m, nil
+}
+
+func (h *Handler) projects(r *http.Request, vars map[string]string) (interface{}, error) {
+
+	q := r.URL.Query()
+
+	first, err := firstParam(q.Get("first"))
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := h.listing(r).ProjectsPage(q.Get("groupName"), first, q.Get("after"))
+	if err != nil {
+		return nil, err
+	}
+
+	l := &ProjectList{Projects: []*Project{}, PageInfo: pageInfo(p.Cursors, p.HasNextPage)}
+	for _, proj := range p.Projects {
+		l.Projects = append(l.Projects, &Project{
+			ID:                proj.ProjectID,
+			Name:              proj.Name,
+			Path:              proj.Path,
+			PathWithNamespace: proj.PathWithNamespace,
+			Namespace:         proj.Namespace,
+			WebURL:            proj.WebURL,
+		})
+	}
+
+	return l, nil
+}
+
+func (h *Handler) deployments(r *http.Request, vars map[string]string) (interface{}, error) {
+
+	q := r.URL.Query()
+
+	dr, err := dateRange(r)
+	if err != nil {
+		return nil, err
+	}
+
+	f := listing.Filter{
+		Start:       dr.Start,
+		End:         dr.End,
+		Status:      q.Get("status"),
+		Environment: q.Get("environment"),
+		ProjectName: q.Get("projectName"),
+		GroupName:   q.Get("groupName"),
+	}
+	if f.ProjectID, err = intParam(q.Get("projectID"), "projectID"); err != nil {
+		return nil, err
+	}
+
+	first, err := firstParam(q.Get("first"))
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := h.listing(r).DeploymentsPage(f, first, q.Get("after"))
+	if err != nil {
+		return nil, err
+	}
+
+	l := &DeploymentList{Deployments: []*Deployment{}, PageInfo: pageInfo(p.Cursors, p.HasNextPage)}
+	for _, d := range p.Deployments {
+		l.Deployments = append(l.Deployments, &Deployment{
+			ID:               d.DeploymentID,
+			Status:           d.Status,
+			Environment:      d.EnvironmentName,
+			ProjectID:        d.ProjectID,
+			ProjectName:      d.ProjectName,
+			ProjectNamespace: d.ProjectNamespace,
+			PipelineID:       d.PipelineID,
+			SHA:              d.SHA,
+			FinishedAt:       d.FinishedAt,
+			Duration:         int(math.Round(d.Duration)),
+		})
+	}
+
+	return l, nil
+}
+
+func (h *Handler) document(r *http.Request, vars map[string]string) (interface{}, error) {
+	return h.doc, nil
+}
+
+// paramError is returned for query parameters which are not valid
+type paramError struct {
+	msg string
+}
+
+func (e *paramError) Error() string {
+	return e.msg
+}
+
+// metricsFilter reads the dateRange, projectName and groupName filters shared
+// with the GraphQL metric queries from the query parameters
+func metricsFilter(r *http.Request) (metrics.Filter, error) {
+
+	dr, err := dateRange(r)
+	if err != nil {
+		return metrics.Filter{}, err
+	}
+
+	q := r.URL.Query()
+
+	return metrics.Filter{DateRange: dr, ProjectName: q.Get("projectName"), GroupName: q.Get("groupName")}, nil
+}
+
+// dateRange reads the start and end query parameters, which must be set
+// together like the fields of the GraphQL DateRange input
+func dateRange(r *http.Request) (metrics.DateRange, error) {
+
+	q := r.URL.Query()
+	start, end := q.Get("start"), q.Get("end")
+
+	dr := metrics.DateRange{}
+
+	if start == "" && end == "" {
+		return dr, nil
+	}
+	if start == "" || end == "" {
+		return dr, &paramError{"start and end must be set together"}
+	}
+
+	var err error
+	if dr.Start, err = parseDateTime(start, "start"); err != nil {
+		return dr, err
+	}
+	if dr.End, err = parseDateTime(end, "end"); err != nil {
+		return dr, err
+	}
+
+	// a date without a time includes the whole of that day
+	if len(end) == len("2006-01-02") {
+		dr.End = dr.End.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+
+	if dr.End.Before(dr.Start) {
+		return dr, &paramError{"end must not be before start"}
+	}
+
+	return dr, nil
+}
+
+// parseDateTime converts a parameter in RFC 3339 or YYYY-MM-DD format into a time
+func parseDateTime(s, name string) (time.Time, error) {
+
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, &paramError{fmt.Sprintf("invalid %v %q, expected RFC 3339 or YYYY-MM-DD", name, s)}
+	}
+
+	return t, nil
+}
+
+func intParam(s, name string) (int, error) {
+
+	if s == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, &paramError{fmt.Sprintf("invalid %v %q, expected a number", name, s)}
+	}
+
+	return n, nil
+}
+
+// firstParam reads the first parameter, the size of a page, or 0 for the
+// default page size when it is not given
+func firstParam(s string) (int, error) {
+
+	n, err := intParam(s, "first")
+	if err != nil {
+		return 0, err
+	}
+
+	if s != "" && (n < 1 || n > listing.MaxPageSize) {
+		return 0, &paramError{fmt.Sprintf("first must be between 1 and %d", listing.MaxPageSize)}
+	}
+
+	return n, nil
+}
+
+func pageInfo(cursors []string, hasNextPage bool) *PageInfo {
+
+	p := &PageInfo{HasNextPage: hasNextPage}
+	if len(cursors) > 0 {
+		p.EndCursor = &cursors[len(cursors)-1]
+	}
+
+	return p
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Printf("Error: %v", err.Error())
+	}
+}
+
+// match reports whether the path matches the route, with the values of its
+// path parameters
+func (rt *route) match(path string) (map[string]string, bool) {
+
+	want := strings.Split(Prefix+rt.path, "/")
+	got := strings.Split(path, "/")
+
+	if len(want) != len(got) {
+		return nil, false
+	}
+
+	vars := map[string]string{}
+	for i, seg := range want {
+		switch {
+		case strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}"):
+			if got[i] == "" {
+				return nil, false
+			}
+			vars[strings.Trim(seg, "{}")] = got[i]
+		case seg != got[i]:
+			return nil, false
+		}
+	}
+
+	return vars, true
+}
@@ -0,0 +1,355 @@
+package rest_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sk000f/metrix/pkg/access"
+	"github.com/sk000f/metrix/pkg/http/rest"
+	"github.com/sk000f/metrix/pkg/listing"
+	"github.com/sk000f/metrix/pkg/metrics"
+)
+
+func TestMetrics(t *testing.T) {
+
+	h := newHandler(t)
+
+	tests := []struct {
+		name string
+		path string
+		code int
+		want string
+	}{
+		{
+			name: "calculate a metric over a date range",
+			path: "/api/v1/metrics/deployment-frequency?start=2020-10-01&end=2020-10-31T00:00:00Z&projectName=api",
+			code: http.StatusOK,
+			want: `{"metric":"deployment-frequency","value":3,"unit":"deployments","start":"2020-10-01T00:00:00Z","end":"2020-10-31T00:00:00Z","projectName":"api"}`,
+		},
+		{
+			name: "report rates as percentages",
+			path: "/api/v1/metrics/change-failure-rate",
+			code: http.StatusOK,
+			want: `{"metric":"change-failure-rate","value":33,"unit":"percent"}`,
+		},
+		{
+			name: "report durations in seconds",
+			path: "/api/v1/metrics/time-to-recover",
+			code: http.StatusOK,
+			want: `{"metric":"time-to-recover","value":3600,"unit":"seconds"}`,
+		},
+		{
+			name: "calculate every metric",
+			path: "/api/v1/metrics?groupName=org",
+			code: http.StatusOK,
+			want: `{"metrics":[{"metric":"deployment-frequency","value":3,"unit":"deployments","groupName":"org"},` +
+				`{"metric":"lead-time","value":60,"unit":"seconds","groupName":"org"},` +
+				`{"metric":"time-to-recover","value":3600,"unit":"seconds","groupName":"org"},` +
+				`{"metric":"change-failure-rate","value":33,"unit":"percent","groupName":"org"}]}`,
+		},
+		{
+			name: "reject unknown metrics",
+			path: "/api/v1/metrics/velocity",
+			code: http.StatusNotFound,
+			want: `{"error":"/api/v1/metrics/velocity not found"}`,
+		},
+		{
+			name: "reject half a date range",
+			path: "/api/v1/metrics/lead-time?start=2020-10-01",
+			code: http.StatusBadRequest,
+			want: `{"error":"start and end must be set together"}`,
+		},
+		{
+			name: "reject invalid dates",
+			path: "/api/v1/metrics/lead-time?start=yesterday&end=2020-10-01",
+			code: http.StatusBadRequest,
+			want: `{"error":"invalid start \"yesterday\", expected RFC 3339 or YYYY-MM-DD"}`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+
+			rec := get(h, tc.path)
+
+			if rec.Code != tc.code {
+				t.Errorf("got status %v; wanted %v", rec.Code, tc.code)
+			}
+			if got := strings.TrimSpace(rec.Body.String()); got != tc.want {
+				t.Errorf("got %v; wanted %v", got, tc.want)
+			}
+		})
+	}
+
+	t.Run("reject other methods", func(t *testing.T) {
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/metrics", nil))
+
+		if rec.Code != http.StatusMethodNotAllowed {
+			t.Errorf("got status %v; wanted %v", rec.Code, http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func TestListing(t *testing.T) {
+
+	t.Run("list pages of projects", func(t *testing.T) {
+
+		h := newHandler(t)
+
+		var got rest.ProjectList
+		decode(t, get(h, "/api/v1/projects?first=1"), &got)
+
+		if len(got.Projects) != 1 || got.Projects[0].Name != "api" || !got.PageInfo.HasNextPage || got.PageInfo.EndCursor == nil {
+			t.Fatalf("got %+v; wanted the first project with a next page", got)
+		}
+
+		after := *got.PageInfo.EndCursor
+		got = rest.ProjectList{}
+		decode(t, get(h, "/api/v1/projects?first=1&after="+after), &got)
+
+		if len(got.Projects) != 1 || got.Projects[0].Name != "site" || got.PageInfo.HasNextPage {
+			t.Errorf("got %+v; wanted the last project", got)
+		}
+	})
+
+	t.Run("list deployments with filters", func(t *testing.T) {
+
+		r := newMockRepo(t)
+		h := rest.NewHandler(metrics.NewService(r), listing.NewService(r), metrics.CountEveryDeployment)
+
+		var got rest.DeploymentList
+		decode(t, get(h, "/api/v1/deployments?status=failed&projectID=1&start=2020-10-01&end=2020-10-02"), &got)
+
+		want := listing.Filter{
+			Start:     time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC),
+			End:       time.Date(2020, 10, 2, 23, 59, 59, 999999999, time.UTC),
+			Status:    "failed",
+			ProjectID: 1,
+		}
+		if !reflect.DeepEqual(r.filter, want) {
+			t.Errorf("got filter %+v; wanted %+v", r.filter, want)
+		}
+
+		if len(got.Deployments) != 3 || got.Deployments[0].ID != 1 || got.Deployments[0].Duration != 60 {
+			t.Errorf("got %+v; wanted three deployments", got.Deployments)
+		}
+	})
+
+	t.Run("reject invalid pages", func(t *testing.T) {
+
+		h := newHandler(t)
+
+		for _, p := range []string{"/api/v1/projects?first=1000", "/api/v1/projects?first=0", "/api/v1/deployments?first=ten", "/api/v1/deployments?after=nonsense"} {
+			if rec := get(h, p); rec.Code != http.StatusBadRequest {
+				t.Errorf("got status %v for %v; wanted %v", rec.Code, p, http.StatusBadRequest)
+			}
+		}
+	})
+
+	t.Run("only list projects the user can access", func(t *testing.T) {
+
+		h := newHandler(t)
+
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/projects", nil)
+		req = req.WithContext(access.NewContext(req.Context(), []int{2}))
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		var got rest.ProjectList
+		decode(t, rec, &got)
+
+		if len(got.Projects) != 1 || got.Projects[0].ID != 2 {
+			t.Errorf("got %+v; wanted only project 2", got.Projects)
+		}
+	})
+}
+
+func TestOpenAPI(t *testing.T) {
+
+	h := newHandler(t)
+
+	var doc struct {
+		OpenAPI string
+		Paths   map[string]map[string]struct {
+			OperationID string
+			Parameters  []struct{ Name, In string }
+			Responses   map[string]interface{}
+		}
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]interface{}
+				Required   []string
+			}
+		}
+	}
+	rec := get(h, "/api/v1/openapi.json")
+	decode(t, rec, &doc)
+
+	t.Run("describe every route", func(t *testing.T) {
+
+		if doc.OpenAPI != "3.0.3" {
+			t.Errorf("got version %v; wanted 3.0.3", doc.OpenAPI)
+		}
+
+		for _, p := range []string{"/api/v1/metrics", "/api/v1/metrics/{metric}", "/api/v1/projects", "/api/v1/deployments", "/api/v1/openapi.json"} {
+			if _, ok := doc.Paths[p]["get"]; !ok {
+				t.Errorf("got no GET operation for %v", p)
+			}
+		}
+
+		params := []string{}
+		for _, p := range doc.Paths["/api/v1/metrics/{metric}"]["get"].Parameters {
+			params = append(params, p.In+":"+p.Name)
+		}
+		want := []string{"path:metric", "query:start", "query:end", "query:projectName", "query:groupName"}
+		if !reflect.DeepEqual(params, want) {
+			t.Errorf("got parameters %v; wanted %v", params, want)
+		}
+	})
+
+	t.Run("generate schemas from the responses", func(t *testing.T) {
+
+		d, ok := doc.Components.Schemas["Deployment"]
+		if !ok {
+			t.Fatalf("got no Deployment schema")
+		}
+
+		for _, f := range []string{"id", "status", "environment", "projectID", "finishedAt", "duration"} {
+			if _, ok := d.Properties[f]; !ok {
+				t.Errorf("got no %v property in the Deployment schema", f)
+			}
+		}
+
+		m := doc.Components.Schemas["Metric"]
+		if want := []string{"metric", "value", "unit"}; !reflect.DeepEqual(m.Required, want) {
+			t.Errorf("got required %v; wanted %v", m.Required, want)
+		}
+	})
+
+	t.Run("only refer to defined schemas", func(t *testing.T) {
+
+		for _, ref := range strings.Split(rec.Body.String(), `"$ref":"#/components/schemas/`)[1:] {
+			name := ref[:strings.Index(ref, `"`)]
+			if _, ok := doc.Components.Schemas[name]; !ok {
+				t.Errorf("got a reference to undefined schema %v", name)
+			}
+		}
+	})
+}
+
+func newHandler(t *testing.T) *rest.Handler {
+	r := newMockRepo(t)
+	return rest.NewHandler(metrics.NewService(r), listing.NewService(r), metrics.CountEveryDeployment)
+}
+
+func get(h http.Handler, path string) *httptest.ResponseRecorder {
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+	return rec
+}
+
+func decode(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %v: %v", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("got content type %v; wanted application/json", ct)
+	}
+
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type mockRepo struct {
+	projects    []*listing.Project
+	deployments []*metrics.Deployment
+	filter      listing.Filter
+}
+
+func newMockRepo(t *testing.T) *mockRepo {
+
+	r := &mockRepo{
+		projects: []*listing.Project{
+			{ProjectID: 1, Name: "api", Namespace: "org/platform"},
+			{ProjectID: 2, Name: "site", Namespace: "org/marketing"},
+		},
+	}
+
+	// a failed deployment recovered an hour later by the next one
+	ts := time.Date(2020, 10, 1, 9, 0, 0, 0, time.UTC)
+	for i, s := range []string{"success", "failed", "success"} {
+		finished := ts.Add(time.Duration(i) * time.Hour)
+		r.deployments = append(r.deployments, &metrics.Deployment{
+			ID:               i + 1,
+			Status:           s,
+			EnvironmentName:  "production",
+			ProjectID:        1,
+			ProjectName:      "api",
+			ProjectNamespace: "org/platform",
+			PipelineID:       i + 1,
+			FinishedAt:       &finished,
+			Duration:         60,
+		})
+	}
+
+	return r
+}
+
+func (m *mockRepo) GetDeployments(f metrics.Filter) ([]*metrics.Deployment, error) {
+	return m.deployments, nil
+}
+
+func (m *mockRepo) GetProjects() ([]*metrics.Project, error) {
+	p := []*metrics.Project{}
+	for _, proj := range m.projects {
+		p = append(p, &metrics.Project{ID: proj.ProjectID, Name: proj.Name, Namespace: proj.Namespace})
+	}
+	return p, nil
+}
+
+func (m *mockRepo) ListProjects() ([]*listing.Project, error) {
+	return m.projects, nil
+}
+
+func (m *mockRepo) ListProjectsPage(groupName string, afterID, limit int) ([]*listing.Project, error) {
+	p := []*listing.Project{}
+	for _, proj := range m.projects {
+		if proj.ProjectID > afterID && len(p) < limit {
+			p = append(p, proj)
+		}
+	}
+	return p, nil
+}
+
+func (m *mockRepo) ListProjectsByID(ids []int) ([]*listing.Project, error) {
+	return m.projects, nil
+}
+
+func (m *mockRepo) ListDeployments(f listing.Filter, after *listing.DeploymentCursor, limit int) ([]*listing.Deployment, error) {
+	m.filter = f
+	d := []*listing.Deployment{}
+	for _, dep := range m.deployments {
+		d = append(d, &listing.Deployment{
+			DeploymentID:    dep.ID,
+			Status:          dep.Status,
+			EnvironmentName: dep.EnvironmentName,
+			ProjectID:       dep.ProjectID,
+			ProjectName:     dep.ProjectName,
+			FinishedAt:      dep.FinishedAt,
+			Duration:        dep.Duration,
+		})
+	}
+	return d, nil
+}
@@ -0,0 +1,157 @@
+package listing
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// Service provides functionality for listing stored projects and deployments
+type Service struct {
+	r Repository
+}
+
+// Repository provides access to stored projects and deployments
+type Repository interface {
+	ListProjects() ([]*Project, error)
+	ListProjectsPage(groupName string, afterID, limit int) ([]*Project, error)
+	ListProjectsByID(ids []int) ([]*Project, error)
+	ListDeployments(f Filter, after *DeploymentCursor, limit int) ([]*Deployment, error)
+}
+
+// Filter restricts the deployments which are listed
+type Filter struct {
+	Start         time.Time
+	End           time.Time
+	Status        string
+	Environment   string
+	ProjectID     int
+	ProjectIDs    []int
+	DeploymentIDs []int
+	ProjectName   string
+	GroupName     string
+	// LimitPerProject applies the limit to the deployments of each project,
+	// rather than to all the deployments listed
+	LimitPerProject bool
+}
+
+// Project represents metrix view of a stored project
+type Project struct {
+	ID                string
+	ProjectID         int
+	Name              string
+	Path              string
+	PathWithNamespace string
+	Namespace         string
+	WebURL            string
+}
+
+// Deployment represents metrix view of a stored deployment
+type Deployment struct {
+	ID               string
+	DeploymentID     int
+	Status           string
+	EnvironmentName  string
+	ProjectID        int
+	ProjectName      string
+	ProjectPath      string
+	ProjectNamespace string
+	PipelineID       int
+	SHA              string
+	FinishedAt       *time.Time
+	Duration         float64
+}
+
+// NewService creates a listing service with required dependencies
+func NewService(r Repository) *Service {
+	return &Service{r}
+}
+
+// Projects lists all stored projects
+func (s *Service) Projects() ([]*Project, error) {
+	return s.r.ListProjects()
+}
+
+// Deployment returns the stored deployment with the GitLab deployment ID, or
+// nil if it has not been stored
+func (s *Service) Deployment(id int) (*Deployment, error) {
+
+	d, err := s.DeploymentsByID([]int{id})
+	if err != nil || len(d) == 0 {
+		return nil, err
+	}
+
+	return d[0], nil
+}
+
+// DeploymentsByID lists the stored deployments with any of the GitLab deployment IDs
+func (s *Service) DeploymentsByID(ids []int) ([]*Deployment, error) {
+	return s.r.ListDeployments(Filter{DeploymentIDs: ids}, nil, len(ids))
+}
+
+// ProjectsByID lists the stored projects with any of the GitLab project IDs
+func (s *Service) ProjectsByID(ids []int) ([]*Project, error) {
+	return s.r.ListProjectsByID(ids)
+}
+
+// ProjectDeployments lists up to limit deployments matching the filter for each
+// of the GitLab project IDs, most recently finished first, in a single query
+func (s *Service) ProjectDeployments(ids []int, f Filter, limit int) ([]*Deployment, error) {
+
+	// an empty list of project IDs matches every project
+	if len(ids) == 0 {
+		return []*Deployment{}, nil
+	}
+
+	f.ProjectIDs = ids
+	f.LimitPerProject = true
+
+	return s.r.ListDeployments(f, nil, limit)
+}
+
+// ProjectNames lists the distinct names of all stored projects
+func (s *Service) ProjectNames() ([]string, error) {
+
+	p, err := s.r.ListProjects()
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[string]bool{}
+	for _, proj := range p {
+		names[proj.Name] = true
+	}
+
+	return sortedNames(names), nil
+}
+
+// GroupNames lists every namespace containing a stored project, including
+// the parent groups of nested subgroups
+func (s *Service) GroupNames() ([]string, error) {
+
+	p, err := s.r.ListProjects()
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[string]bool{}
+	for _, proj := range p {
+		parts := strings.Split(proj.Namespace, "/")
+		for i := range parts {
+			names[strings.Join(parts[:i+1], "/")] = true
+		}
+	}
+
+	return sortedNames(names), nil
+}
+
+func sortedNames(m map[string]bool) []string {
+	n := []string{}
+	for name := range m {
+		if name != "" {
+			n = append(n, name)
+		}
+	}
+	sort.Strings(n)
+	return n
+}
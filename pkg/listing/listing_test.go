@@ -0,0 +1,255 @@
+package listing_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sk000f/metrix/pkg/listing"
+)
+
+func TestListing(t *testing.T) {
+	t.Run("list group names including parent groups", func(t *testing.T) {
+
+		r := &mockRepo{ProjectData: []*listing.Project{
+			{ProjectID: 1, Name: "api", Namespace: "org/platform/payments"},
+			{ProjectID: 2, Name: "site", Namespace: "org/marketing"},
+			{ProjectID: 3, Name: "api", Namespace: "other"},
+		}}
+
+		s := listing.NewService(r)
+
+		got, err := s.GroupNames()
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		want := []string{"org", "org/marketing", "org/platform", "org/platform/payments", "other"}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v; wanted %v", got, want)
+		}
+
+		names, err := s.ProjectNames()
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		if !reflect.DeepEqual(names, []string{"api", "site"}) {
+			t.Errorf("got %v; wanted %v", names, []string{"api", "site"})
+		}
+	})
+
+	t.Run("continue deployment pages from cursor", func(t *testing.T) {
+
+		ts := time.Date(2020, 10, 1, 9, 0, 0, 0, time.UTC)
+
+		r := &mockRepo{DeploymentData: []*listing.Deployment{
+			{DeploymentID: 3, FinishedAt: &ts},
+			{DeploymentID: 2, FinishedAt: &ts},
+			{DeploymentID: 1, FinishedAt: &ts},
+		}}
+
+		s := listing.NewService(r)
+
+		first, err := s.DeploymentsPage(listing.Filter{}, 2, "")
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		if len(first.Deployments) != 2 || !first.HasNextPage || first.HasPreviousPage {
+			t.Errorf("got %+v; wanted first page of two with a next page", first)
+		}
+
+		if _, err := s.DeploymentsPage(listing.Filter{}, 2, first.Cursors[1]); err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		want := &listing.DeploymentCursor{FinishedAt: ts, DeploymentID: 2}
+
+		if !reflect.DeepEqual(r.after, want) {
+			t.Errorf("got cursor %+v; wanted %+v", r.after, want)
+		}
+	})
+
+	t.Run("list the first deployments of each project in a batch", func(t *testing.T) {
+
+		ts := time.Now()
+		r := &mockRepo{DeploymentData: []*listing.Deployment{
+			{DeploymentID: 3, ProjectID: 2, FinishedAt: &ts},
+			{DeploymentID: 2, ProjectID: 1, FinishedAt: &ts},
+			{DeploymentID: 1, ProjectID: 1, FinishedAt: &ts},
+		}}
+
+		s := listing.NewService(r)
+
+		got, err := s.ProjectDeployments([]int{1, 2}, listing.Filter{Status: "success"}, 1)
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		if ids := deploymentIDs(got); !reflect.DeepEqual(ids, []int{3, 2}) {
+			t.Errorf("got deployments %v; wanted [3 2]", ids)
+		}
+
+		// the whole batch is listed at once, limited per project by the repository
+		want := listing.Filter{Status: "success", ProjectIDs: []int{1, 2}, LimitPerProject: true}
+		if !reflect.DeepEqual(r.filter, want) || !reflect.DeepEqual(r.limits, []int{1}) {
+			t.Errorf("got filter %+v with limits %v; wanted %+v with limits [1]", r.filter, r.limits, want)
+		}
+	})
+
+	t.Run("only list visible projects and their deployments", func(t *testing.T) {
+
+		r := &mockRepo{}
+		for id := 1; id <= 6; id++ {
+			r.ProjectData = append(r.ProjectData, &listing.Project{ProjectID: id})
+		}
+
+		s := listing.NewService(r).Visible([]int{2, 5, 6})
+
+		first, err := s.ProjectsPage("", 2, "")
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		if ids := projectIDs(first.Projects); !reflect.DeepEqual(ids, []int{2, 5}) || !first.HasNextPage {
+			t.Errorf("got projects %v with next page %v; wanted [2 5] with a next page", ids, first.HasNextPage)
+		}
+
+		next, err := s.ProjectsPage("", 2, first.Cursors[1])
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		if ids := projectIDs(next.Projects); !reflect.DeepEqual(ids, []int{6}) || next.HasNextPage {
+			t.Errorf("got projects %v with next page %v; wanted [6] without a next page", ids, next.HasNextPage)
+		}
+
+		if _, err := s.DeploymentsPage(listing.Filter{}, 10, ""); err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		if !reflect.DeepEqual(r.filter.ProjectIDs, []int{2, 5, 6}) {
+			t.Errorf("got project IDs %v; wanted [2 5 6]", r.filter.ProjectIDs)
+		}
+
+		if _, err := s.ProjectDeployments([]int{1, 2}, listing.Filter{}, 10); err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		if !reflect.DeepEqual(r.filter.ProjectIDs, []int{2}) {
+			t.Errorf("got filter %+v; wanted project 2 of the visible projects", r.filter)
+		}
+
+		r.filter = listing.Filter{}
+
+		d, err := s.DeploymentsPage(listing.Filter{ProjectID: 1}, 10, "")
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		if len(d.Deployments) != 0 || !reflect.DeepEqual(r.filter, listing.Filter{}) {
+			t.Errorf("got %v deployments of a hidden project; wanted none", len(d.Deployments))
+		}
+	})
+
+	t.Run("reject invalid page requests", func(t *testing.T) {
+
+		s := listing.NewService(new(mockRepo))
+
+		if _, err := s.DeploymentsPage(listing.Filter{}, listing.MaxPageSize+1, ""); err == nil {
+			t.Errorf("expected error for page size over maximum")
+		}
+
+		if _, err := s.ProjectsPage("", 0, "ZGVwbG95bWVudDoxOjE"); err != listing.ErrInvalidCursor {
+			t.Errorf("got %v; wanted %v", err, listing.ErrInvalidCursor)
+		}
+	})
+}
+
+func projectIDs(p []*listing.Project) []int {
+	ids := []int{}
+	for _, proj := range p {
+		ids = append(ids, proj.ProjectID)
+	}
+	return ids
+}
+
+func deploymentIDs(d []*listing.Deployment) []int {
+	ids := []int{}
+	for _, dep := range d {
+		ids = append(ids, dep.DeploymentID)
+	}
+	return ids
+}
+
+func containsID(ids []int, id int) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+type mockRepo struct {
+	ProjectData    []*listing.Project
+	DeploymentData []*listing.Deployment
+	after          *listing.DeploymentCursor
+	filter         listing.Filter
+	limits         []int
+}
+
+func (m *mockRepo) ListProjects() ([]*listing.Project, error) {
+	return m.ProjectData, nil
+}
+
+func (m *mockRepo) ListProjectsPage(groupName string, afterID, limit int) ([]*listing.Project, error) {
+	p := []*listing.Project{}
+	for _, proj := range m.ProjectData {
+		if proj.ProjectID > afterID && len(p) < limit {
+			p = append(p, proj)
+		}
+	}
+	return p, nil
+}
+
+func (m *mockRepo) ListProjectsByID(ids []int) ([]*listing.Project, error) {
+	p := []*listing.Project{}
+	for _, proj := range m.ProjectData {
+		for _, id := range ids {
+			if proj.ProjectID == id {
+				p = append(p, proj)
+			}
+		}
+	}
+	return p, nil
+}
+
+func (m *mockRepo) ListDeployments(f listing.Filter, after *listing.DeploymentCursor, limit int) ([]*listing.Deployment, error) {
+	m.after = after
+	m.filter = f
+	m.limits = append(m.limits, limit)
+
+	d := []*listing.Deployment{}
+	perProject := map[int]int{}
+	for _, dep := range m.DeploymentData {
+		if f.ProjectID != 0 && dep.ProjectID != f.ProjectID {
+			continue
+		}
+		if len(f.ProjectIDs) > 0 && !containsID(f.ProjectIDs, dep.ProjectID) {
+			continue
+		}
+
+		n := len(d)
+		if f.LimitPerProject {
+			n = perProject[dep.ProjectID]
+		}
+		if limit == 0 || n < limit {
+			d = append(d, dep)
+			perProject[dep.ProjectID]++
+		}
+	}
+	return d, nil
+}
@@ -0,0 +1,193 @@
+package listing
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultPageSize is the number of items in a page when none is requested
+	DefaultPageSize = 20
+	// MaxPageSize is the largest number of items which can be requested in a page
+	MaxPageSize = 100
+)
+
+// ErrInvalidCursor is returned when a page cursor cannot be decoded
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// DeploymentCursor is the position of a deployment in the listing order of
+// most recently finished first. It matches the deployments index so pages are
+// stable while new deployments are added.
+type DeploymentCursor struct {
+	FinishedAt   time.Time
+	DeploymentID int
+}
+
+// DeploymentPage represents a page of deployments with a cursor for each
+type DeploymentPage struct {
+	Deployments     []*Deployment
+	Cursors         []string
+	HasNextPage     bool
+	HasPreviousPage bool
+}
+
+// ProjectPage represents a page of projects, ordered by project ID, with a cursor for each
+type ProjectPage struct {
+	Projects        []*Project
+	Cursors         []string
+	HasNextPage     bool
+	HasPreviousPage bool
+}
+
+// DeploymentsPage lists a page of deployments matching the filter after the cursor
+func (s *Service) DeploymentsPage(f Filter, first int, after string) (*DeploymentPage, error) {
+
+	limit, err := pageSize(first)
+	if err != nil {
+		return nil, err
+	}
+
+	var c *DeploymentCursor
+	if after != "" {
+		if c, err = decodeDeploymentCursor(after); err != nil {
+			return nil, err
+		}
+	}
+
+	// fetch one extra deployment to find out if there is another page
+	d, err := s.r.ListDeployments(f, c, limit+1)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &DeploymentPage{
+		Deployments:     d,
+		Cursors:         []string{},
+		HasPreviousPage: c != nil,
+	}
+
+	if len(d) > limit {
+		p.Deployments = d[:limit]
+		p.HasNextPage = true
+	}
+
+	for _, dep := range p.Deployments {
+		p.Cursors = append(p.Cursors, encodeDeploymentCursor(dep))
+	}
+
+	return p, nil
+}
+
+// ProjectsPage lists a page of projects in the group after the cursor
+func (s *Service) ProjectsPage(groupName string, first int, after string) (*ProjectPage, error) {
+
+	limit, err := pageSize(first)
+	if err != nil {
+		return nil, err
+	}
+
+	afterID := 0
+	if after != "" {
+		if afterID, err = decodeProjectCursor(after); err != nil {
+			return nil, err
+		}
+	}
+
+	// fetch one extra project to find out if there is another page
+	pr, err := s.r.ListProjectsPage(groupName, afterID, limit+1)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &ProjectPage{
+		Projects:        pr,
+		Cursors:         []string{},
+		HasPreviousPage: after != "",
+	}
+
+	if len(pr) > limit {
+		p.Projects = pr[:limit]
+		p.HasNextPage = true
+	}
+
+	for _, proj := range p.Projects {
+		p.Cursors = append(p.Cursors, encodeCursor("project", strconv.Itoa(proj.ProjectID)))
+	}
+
+	return p, nil
+}
+
+func pageSize(first int) (int, error) {
+	switch {
+	case first == 0:
+		return DefaultPageSize, nil
+	case first < 0 || first > MaxPageSize:
+		return 0, fmt.Errorf("page size must be between 1 and %d", MaxPageSize)
+	}
+	return first, nil
+}
+
+// encodeDeploymentCursor encodes the position of a listed deployment, which
+// always has a finish time as unfinished deployments are left out of the listing
+func encodeDeploymentCursor(d *Deployment) string {
+	return encodeCursor("deployment", strconv.FormatInt(d.FinishedAt.UnixNano(), 10), strconv.Itoa(d.DeploymentID))
+}
+
+func decodeDeploymentCursor(s string) (*DeploymentCursor, error) {
+
+	parts, err := decodeCursor(s, "deployment", 2)
+	if err != nil {
+		return nil, err
+	}
+
+	finishedAt, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &DeploymentCursor{FinishedAt: time.Unix(0, finishedAt).UTC(), DeploymentID: id}, nil
+}
+
+func decodeProjectCursor(s string) (int, error) {
+
+	parts, err := decodeCursor(s, "project", 1)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+
+	return id, nil
+}
+
+// encodeCursor builds an opaque cursor from the kind of item and its sort keys
+func encodeCursor(kind string, keys ...string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(kind + ":" + strings.Join(keys, ":")))
+}
+
+func decodeCursor(s, kind string, n int) ([]string, error) {
+
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	parts := strings.Split(string(b), ":")
+	if len(parts) != n+1 || parts[0] != kind {
+		return nil, ErrInvalidCursor
+	}
+
+	return parts[1:], nil
+}
@@ -0,0 +1,119 @@
+package listing
+
+import "sort"
+
+// visibleRepository only lists the projects, and their deployments, with one
+// of a set of GitLab project IDs
+type visibleRepository struct {
+	r   Repository
+	ids map[int]bool
+}
+
+// Visible returns a service which only lists the projects with the GitLab
+// project IDs and their deployments
+func (s *Service) Visible(ids []int) *Service {
+
+	v := &visibleRepository{r: s.r, ids: map[int]bool{}}
+	for _, id := range ids {
+		v.ids[id] = true
+	}
+
+	return NewService(v)
+}
+
+func (v *visibleRepository) ListProjects() ([]*Project, error) {
+
+	p, err := v.r.ListProjects()
+	if err != nil {
+		return nil, err
+	}
+
+	return v.projects(p), nil
+}
+
+// ListProjectsPage keeps reading pages until it has found limit visible
+// projects or run out of projects, so pages are not cut short by projects
+// which are hidden
+func (v *visibleRepository) ListProjectsPage(groupName string, afterID, limit int) ([]*Project, error) {
+
+	p := []*Project{}
+
+	for len(p) < limit {
+
+		pr, err := v.r.ListProjectsPage(groupName, afterID, limit)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, proj := range v.projects(pr) {
+			if len(p) < limit {
+				p = append(p, proj)
+			}
+		}
+
+		if len(pr) < limit {
+			break
+		}
+		afterID = pr[len(pr)-1].ProjectID
+	}
+
+	return p, nil
+}
+
+func (v *visibleRepository) ListProjectsByID(ids []int) ([]*Project, error) {
+
+	visible := v.visibleIDs(ids)
+	if len(visible) == 0 {
+		return []*Project{}, nil
+	}
+
+	return v.r.ListProjectsByID(visible)
+}
+
+func (v *visibleRepository) ListDeployments(f Filter, after *DeploymentCursor, limit int) ([]*Deployment, error) {
+
+	if f.ProjectID != 0 && !v.ids[f.ProjectID] {
+		return []*Deployment{}, nil
+	}
+
+	// an empty list of project IDs matches every project, so the visible
+	// projects are always listed explicitly
+	if len(f.ProjectIDs) > 0 {
+		f.ProjectIDs = v.visibleIDs(f.ProjectIDs)
+	} else {
+		for id := range v.ids {
+			f.ProjectIDs = append(f.ProjectIDs, id)
+		}
+		sort.Ints(f.ProjectIDs)
+	}
+
+	if len(f.ProjectIDs) == 0 {
+		return []*Deployment{}, nil
+	}
+
+	return v.r.ListDeployments(f, after, limit)
+}
+
+func (v *visibleRepository) projects(p []*Project) []*Project {
+
+	visible := []*Project{}
+	for _, proj := range p {
+		if v.ids[proj.ProjectID] {
+			visible = append(visible, proj)
+		}
+	}
+
+	return visible
+}
+
+func (v *visibleRepository) visibleIDs(ids []int) []int {
+
+	visible := []int{}
+	for _, id := range ids {
+		if v.ids[id] {
+			visible = append(visible, id)
+		}
+	}
+
+	return visible
+}
@@ -0,0 +1,260 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Band represents a DORA performance band
+type Band int
+
+const (
+	// Unknown is used when there is not enough data to classify a metric
+	Unknown Band = iota
+	// Low performers
+	Low
+	// Medium performers
+	Medium
+	// High performers
+	High
+	// Elite performers
+	Elite
+)
+
+// String returns the name of the Band
+func (b Band) String() string {
+	switch b {
+	case Low:
+		return "Low"
+	case Medium:
+		return "Medium"
+	case High:
+		return "High"
+	case Elite:
+		return "Elite"
+	}
+	return "Unknown"
+}
+
+// Limits are the boundaries of the Elite, High and Medium bands for a single metric.
+// Anything outside the Medium boundary is Low.
+type Limits struct {
+	Elite  float64 `json:"elite"`
+	High   float64 `json:"high"`
+	Medium float64 `json:"medium"`
+}
+
+// Thresholds are the band boundaries for all four metrics as published in a DORA report.
+// Deployment frequency is a minimum in deployments per day, lead time and time to
+// recover are maximums in hours and change failure rate is a maximum fraction.
+type Thresholds struct {
+	Version             string `json:"version"`
+	DeploymentFrequency Limits `json:"deployment_frequency"`
+	LeadTime            Limits `json:"lead_time"`
+	TimeToRecover       Limits `json:"time_to_recover"`
+	ChangeFailureRate   Limits `json:"change_failure_rate"`
+}
+
+// DefaultThresholdsVersion is the DORA report year used when none is configured
+const DefaultThresholdsVersion = "2021"
+
+// thresholds are the band boundaries published in each State of DevOps report
+var thresholds = map[string]*Thresholds{
+	"2019": {
+		Version:             "2019",
+		DeploymentFrequency: Limits{Elite: 1, High: 1.0 / 7, Medium: 1.0 / 30},
+		LeadTime:            Limits{Elite: 24, High: 24 * 7, Medium: 24 * 30},
+		TimeToRecover:       Limits{Elite: 1, High: 24, Medium: 24},
+		ChangeFailureRate:   Limits{Elite: 0.15, High: 0.15, Medium: 0.15},
+	},
+	"2021": {
+		Version:             "2021",
+		DeploymentFrequency: Limits{Elite: 1, High: 1.0 / 7, Medium: 1.0 / 30},
+		LeadTime:            Limits{Elite: 1, High: 24 * 7, Medium: 24 * 182},
+		TimeToRecover:       Limits{Elite: 1, High: 24, Medium: 24 * 7},
+		ChangeFailureRate:   Limits{Elite: 0.15, High: 0.3, Medium: 0.3},
+	},
+}
+
+// LookupThresholds returns a copy of the built in thresholds for a DORA report
+// year, or loads custom thresholds from the JSON file at the given path
+func LookupThresholds(s string) (*Thresholds, error) {
+
+	if s == "" {
+		s = DefaultThresholdsVersion
+	}
+
+	if t, ok := thresholds[s]; ok {
+		c := *t
+		return &c, nil
+	}
+
+	f, err := os.Open(s)
+	if err != nil {
+		return nil, fmt.Errorf("unknown thresholds version %q: %v", s, err)
+	}
+	defer f.Close()
+
+	t := new(Thresholds)
+	if err := json.NewDecoder(f).Decode(t); err != nil {
+		return nil, fmt.Errorf("error reading thresholds %q: %v", s, err)
+	}
+
+	return t, nil
+}
+
+// ThresholdsVersions lists the built in threshold versions
+func ThresholdsVersions() []string {
+	v := []string{}
+	for k := range thresholds {
+		v = append(v, k)
+	}
+	sort.Strings(v)
+	return v
+}
+
+// Classification represents the DORA band for each metric and overall
+type Classification struct {
+	Version             string
+	DeploymentFrequency Band
+	LeadTime            Band
+	TimeToRecover       Band
+	ChangeFailureRate   Band
+	Overall             Band
+}
+
+// ProjectClassification represents the DORA bands for a single project
+type ProjectClassification struct {
+	ProjectID      int
+	ProjectName    string
+	Namespace      string
+	Classification *Classification
+}
+
+// GroupClassification represents the DORA bands for a namespace
+type GroupClassification struct {
+	Namespace      string
+	Classification *Classification
+}
+
+// Classifications represents the DORA bands for every project and group
+type Classifications struct {
+	Projects []*ProjectClassification
+	Groups   []*GroupClassification
+}
+
+// Classify calculates the DORA band of each metric for the filter
+func (s *Service) Classify(f Filter, m FailureMode, t *Thresholds) (*Classification, error) {
+
+	d, err := s.r.GetDeployments(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return classify(d, f.DateRange, m, t), nil
+}
+
+// ClassifyAll calculates the DORA bands for every project and group with
+// deployments in the date range
+func (s *Service) ClassifyAll(dr DateRange, m FailureMode, t *Thresholds) (*Classifications, error) {
+
+	d, err := s.r.GetDeployments(Filter{DateRange: dr})
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Classifications{
+		Projects: []*ProjectClassification{},
+		Groups:   []*GroupClassification{},
+	}
+
+	for _, pd := range byProject(d) {
+		c.Projects = append(c.Projects, &ProjectClassification{
+			ProjectID:      pd[0].ProjectID,
+			ProjectName:    pd[0].ProjectName,
+			Namespace:      pd[0].ProjectNamespace,
+			Classification: classify(pd, dr, m, t),
+		})
+	}
+
+	groups := map[string][]*Deployment{}
+	for _, dep := range d {
+		groups[dep.ProjectNamespace] = append(groups[dep.ProjectNamespace], dep)
+	}
+
+	for _, ns := range sortedKeys(groups) {
+		c.Groups = append(c.Groups, &GroupClassification{
+			Namespace:      ns,
+			Classification: classify(groups[ns], dr, m, t),
+		})
+	}
+
+	return c, nil
+}
+
+func classify(d []*Deployment, dr DateRange, m FailureMode, t *Thresholds) *Classification {
+
+	c := &Classification{Version: t.Version}
+
+	if fr := calculateFrequency(d, dr); fr.Deployments > 0 {
+		c.DeploymentFrequency = t.DeploymentFrequency.atLeast(fr.PerDay)
+	}
+
+	if lt := calculateLeadTime(d, dr); len(lt.Deployments) > 0 {
+		c.LeadTime = t.LeadTime.atMost(lt.Mean.Hours())
+	}
+
+	if ttr := calculateTimeToRecover(d, dr); len(ttr.Recoveries) > 0 {
+		c.TimeToRecover = t.TimeToRecover.atMost(ttr.Mean.Hours())
+	}
+
+	if cfr := calculateFailureRate(d, dr, m); cfr.Deployments > 0 {
+		c.ChangeFailureRate = t.ChangeFailureRate.atMost(cfr.Rate)
+	}
+
+	// overall performance is only as good as the weakest known metric
+	for _, b := range []Band{c.DeploymentFrequency, c.LeadTime, c.TimeToRecover, c.ChangeFailureRate} {
+		if b != Unknown && (c.Overall == Unknown || b < c.Overall) {
+			c.Overall = b
+		}
+	}
+
+	return c
+}
+
+// atLeast returns the band for a metric where higher values are better
+func (l Limits) atLeast(v float64) Band {
+	switch {
+	case v >= l.Elite:
+		return Elite
+	case v >= l.High:
+		return High
+	case v >= l.Medium:
+		return Medium
+	}
+	return Low
+}
+
+// atMost returns the band for a metric where lower values are better
+func (l Limits) atMost(v float64) Band {
+	switch {
+	case v <= l.Elite:
+		return Elite
+	case v <= l.High:
+		return High
+	case v <= l.Medium:
+		return Medium
+	}
+	return Low
+}
+
+func sortedKeys(m map[string][]*Deployment) []string {
+	k := []string{}
+	for key := range m {
+		k = append(k, key)
+	}
+	sort.Strings(k)
+	return k
+}
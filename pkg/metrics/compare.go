@@ -0,0 +1,232 @@
+package metrics
+
+import (
+	"errors"
+	"time"
+)
+
+// Direction indicates whether a metric got better or worse between periods
+type Direction int
+
+const (
+	// Unchanged means the metric has the same value in both periods
+	Unchanged Direction = iota
+	// Improved means the metric is better than in the previous period
+	Improved
+	// Worsened means the metric is worse than in the previous period
+	Worsened
+	// UnknownDirection means either period has nothing to measure the metric from, such
+	// as no recoveries or no successful deployments
+	UnknownDirection
+)
+
+// String returns the name of the Direction
+func (d Direction) String() string {
+	switch d {
+	case Improved:
+		return "Improved"
+	case Worsened:
+		return "Worsened"
+	case UnknownDirection:
+		return "Unknown"
+	}
+	return "Unchanged"
+}
+
+// Delta represents the change in a single metric between two periods.
+// Percent is nil when the previous value was zero. When either period has no
+// samples, Absolute is zero, Percent is nil and Direction is
+// UnknownDirection.
+type Delta struct {
+	Current   float64
+	Previous  float64
+	Absolute  float64
+	Percent   *float64
+	Direction Direction
+}
+
+// Comparison represents the change in each DORA metric from the previous period
+// of equal length. Deployment frequency is in deployments per day, change failure
+// rate is a fraction and time to recover and lead time are in hours.
+type Comparison struct {
+	DeploymentFrequency *Delta
+	ChangeFailureRate   *Delta
+	MeanTimeToRecover   *Delta
+	ChangeLeadTime      *Delta
+}
+
+// ProjectComparison represents the period comparison for a single project
+type ProjectComparison struct {
+	ProjectID   int
+	ProjectName string
+	Namespace   string
+	Comparison  *Comparison
+}
+
+// GroupComparison represents the period comparison for a namespace
+type GroupComparison struct {
+	Namespace  string
+	Comparison *Comparison
+}
+
+// Comparisons represents the period comparison for every project and namespace
+type Comparisons struct {
+	Current  DateRange
+	Previous DateRange
+	Projects []*ProjectComparison
+	Groups   []*GroupComparison
+}
+
+// ErrNoDateRange is returned when a comparison is requested without a date range
+var ErrNoDateRange = errors.New("a start and end date are required")
+
+// PreviousPeriod returns the period of equal length immediately before the date
+// range. The length is rounded to the second, so a range ending at the last
+// instant of a day is as long as the whole days it covers.
+func (dr DateRange) PreviousPeriod() DateRange {
+	return DateRange{
+		Start: dr.Start.Add(-dr.End.Sub(dr.Start).Round(time.Second)),
+		End:   dr.Start.Add(-time.Nanosecond),
+	}
+}
+
+// Compare calculates the change in each metric for the filter from the previous period
+func (s *Service) Compare(f Filter, m FailureMode) (*Comparison, error) {
+
+	if f.DateRange.Start.IsZero() || f.DateRange.End.IsZero() {
+		return nil, ErrNoDateRange
+	}
+
+	prev := f.DateRange.PreviousPeriod()
+
+	all := f
+	all.DateRange = DateRange{Start: prev.Start, End: f.DateRange.End}
+
+	d, err := s.r.GetDeployments(all)
+	if err != nil {
+		return nil, err
+	}
+
+	return compare(d, f.DateRange, prev, m), nil
+}
+
+// CompareAll calculates the period comparison for every stored project and namespace
+func (s *Service) CompareAll(dr DateRange, m FailureMode) (*Comparisons, error) {
+
+	if dr.Start.IsZero() || dr.End.IsZero() {
+		return nil, ErrNoDateRange
+	}
+
+	prev := dr.PreviousPeriod()
+
+	p, err := s.r.GetProjects()
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := s.r.GetDeployments(Filter{DateRange: DateRange{Start: prev.Start, End: dr.End}})
+	if err != nil {
+		return nil, err
+	}
+
+	projects := map[int][]*Deployment{}
+	groups := map[string][]*Deployment{}
+	for _, dep := range d {
+		projects[dep.ProjectID] = append(projects[dep.ProjectID], dep)
+		groups[dep.ProjectNamespace] = append(groups[dep.ProjectNamespace], dep)
+	}
+
+	c := &Comparisons{
+		Current:  dr,
+		Previous: prev,
+		Projects: []*ProjectComparison{},
+		Groups:   []*GroupComparison{},
+	}
+
+	for _, proj := range p {
+		c.Projects = append(c.Projects, &ProjectComparison{
+			ProjectID:   proj.ID,
+			ProjectName: proj.Name,
+			Namespace:   proj.Namespace,
+			Comparison:  compare(projects[proj.ID], dr, prev, m),
+		})
+
+		// namespaces without deployments are still compared
+		if _, ok := groups[proj.Namespace]; !ok {
+			groups[proj.Namespace] = nil
+		}
+	}
+
+	for _, ns := range sortedKeys(groups) {
+		c.Groups = append(c.Groups, &GroupComparison{
+			Namespace:  ns,
+			Comparison: compare(groups[ns], dr, prev, m),
+		})
+	}
+
+	return c, nil
+}
+
+func compare(d []*Deployment, cur, prev DateRange, m FailureMode) *Comparison {
+
+	frCur, frPrev := calculateFailureRate(d, cur, m), calculateFailureRate(d, prev, m)
+	ttrCur, ttrPrev := calculateTimeToRecover(d, cur), calculateTimeToRecover(d, prev)
+	ltCur, ltPrev := calculateLeadTime(d, cur), calculateLeadTime(d, prev)
+
+	return &Comparison{
+		DeploymentFrequency: delta(
+			calculateFrequency(d, cur).PerDay,
+			calculateFrequency(d, prev).PerDay,
+			true),
+		ChangeFailureRate: sampledDelta(
+			frCur.Rate, frPrev.Rate,
+			frCur.Deployments, frPrev.Deployments,
+			false),
+		MeanTimeToRecover: sampledDelta(
+			ttrCur.Mean.Hours(), ttrPrev.Mean.Hours(),
+			len(ttrCur.Recoveries), len(ttrPrev.Recoveries),
+			false),
+		ChangeLeadTime: sampledDelta(
+			ltCur.Mean.Hours(), ltPrev.Mean.Hours(),
+			len(ltCur.Deployments), len(ltPrev.Deployments),
+			false),
+	}
+}
+
+// sampledDelta calculates the change of a metric measured from samples, such
+// as recoveries, where a period without samples has no value rather than zero
+func sampledDelta(current, previous float64, currentN, previousN int, higherIsBetter bool) *Delta {
+
+	if currentN == 0 || previousN == 0 {
+		return &Delta{Current: current, Previous: previous, Direction: UnknownDirection}
+	}
+
+	return delta(current, previous, higherIsBetter)
+}
+
+// delta calculates the change from previous to current, where higherIsBetter
+// determines whether an increase is an improvement
+func delta(current, previous float64, higherIsBetter bool) *Delta {
+
+	d := &Delta{
+		Current:  current,
+		Previous: previous,
+		Absolute: current - previous,
+	}
+
+	if previous != 0 {
+		p := d.Absolute / previous * 100
+		d.Percent = &p
+	}
+
+	switch {
+	case d.Absolute == 0:
+		d.Direction = Unchanged
+	case (d.Absolute > 0) == higherIsBetter:
+		d.Direction = Improved
+	default:
+		d.Direction = Worsened
+	}
+
+	return d
+}
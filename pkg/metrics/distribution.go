@@ -0,0 +1,170 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Distribution represents summary statistics for a set of durations
+type Distribution struct {
+	Count     int
+	Min       time.Duration
+	Max       time.Duration
+	Mean      time.Duration
+	P50       time.Duration
+	P75       time.Duration
+	P90       time.Duration
+	P95       time.Duration
+	Histogram []*HistogramBucket
+}
+
+// HistogramBucket represents the number of durations in [LowerBound, UpperBound).
+// UpperBound is nil for the last, unbounded bucket.
+type HistogramBucket struct {
+	LowerBound time.Duration
+	UpperBound *time.Duration
+	Count      int
+}
+
+// histogramBounds are the upper bounds of each histogram bucket, chosen to line
+// up with the DORA band boundaries
+var histogramBounds = []time.Duration{
+	time.Minute,
+	5 * time.Minute,
+	15 * time.Minute,
+	time.Hour,
+	4 * time.Hour,
+	24 * time.Hour,
+	7 * 24 * time.Hour,
+	30 * 24 * time.Hour,
+	182 * 24 * time.Hour,
+}
+
+// LeadTimeDistribution calculates the distribution of commit to deploy lead times
+// for the filter. Each commit is one sample, deployments without commits contribute
+// their pipeline duration.
+func (s *Service) LeadTimeDistribution(f Filter) (*Distribution, error) {
+
+	d, err := s.r.GetDeployments(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return distribution(leadTimeSamples(d, f.DateRange)), nil
+}
+
+// TimeToRecoverDistribution calculates the distribution of recovery times for the filter
+func (s *Service) TimeToRecoverDistribution(f Filter) (*Distribution, error) {
+
+	d, err := s.r.GetDeployments(f)
+	if err != nil {
+		return nil, err
+	}
+
+	ttr := calculateTimeToRecover(d, f.DateRange)
+
+	samples := []time.Duration{}
+	for _, r := range ttr.Recoveries {
+		samples = append(samples, r.Duration)
+	}
+
+	return distribution(samples), nil
+}
+
+// DurationDistribution calculates the distribution of production deployment
+// pipeline durations for the filter
+func (s *Service) DurationDistribution(f Filter) (*Distribution, error) {
+
+	d, err := s.r.GetDeployments(f)
+	if err != nil {
+		return nil, err
+	}
+
+	samples := []time.Duration{}
+	for _, dep := range inRange(d, f.DateRange) {
+		samples = append(samples, time.Duration(dep.Duration*float64(time.Second)))
+	}
+
+	return distribution(samples), nil
+}
+
+// leadTimeSamples returns the lead time of every commit in successful deployments
+func leadTimeSamples(d []*Deployment, dr DateRange) []time.Duration {
+
+	samples := []time.Duration{}
+
+	for _, dep := range inRange(d, dr) {
+		if dep.Status != "success" {
+			continue
+		}
+
+		if len(dep.Commits) == 0 {
+			samples = append(samples, time.Duration(dep.Duration*float64(time.Second)))
+			continue
+		}
+
+		for _, c := range dep.Commits {
+			samples = append(samples, dep.FinishedAt.Sub(c.CommittedAt))
+		}
+	}
+
+	return samples
+}
+
+func distribution(samples []time.Duration) *Distribution {
+
+	dist := &Distribution{Count: len(samples), Histogram: histogram(samples)}
+
+	if len(samples) == 0 {
+		return dist
+	}
+
+	s := append([]time.Duration{}, samples...)
+	sort.Slice(s, func(i, j int) bool { return s[i] < s[j] })
+
+	var total time.Duration
+	for _, v := range s {
+		total += v
+	}
+
+	dist.Min = s[0]
+	dist.Max = s[len(s)-1]
+	dist.Mean = total / time.Duration(len(s))
+	dist.P50 = percentile(s, 50)
+	dist.P75 = percentile(s, 75)
+	dist.P90 = percentile(s, 90)
+	dist.P95 = percentile(s, 95)
+
+	return dist
+}
+
+// percentile returns the nearest rank percentile p of the sorted samples
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func histogram(samples []time.Duration) []*HistogramBucket {
+
+	h := []*HistogramBucket{}
+
+	var lower time.Duration
+	for i := range histogramBounds {
+		h = append(h, &HistogramBucket{LowerBound: lower, UpperBound: &histogramBounds[i]})
+		lower = histogramBounds[i]
+	}
+	h = append(h, &HistogramBucket{LowerBound: lower})
+
+	for _, v := range samples {
+		i := sort.Search(len(histogramBounds), func(i int) bool {
+			return v < histogramBounds[i]
+		})
+		h[i].Count++
+	}
+
+	return h
+}
@@ -0,0 +1,85 @@
+package metrics
+
+import (
+	"sort"
+	"time"
+)
+
+// EnvironmentMetrics represents the metrics for every stored deployment of a
+// project to an environment
+type EnvironmentMetrics struct {
+	ProjectID         int
+	ProjectName       string
+	Namespace         string
+	Environment       string
+	Deployments       int
+	Failures          int
+	ChangeFailureRate float64
+	LastTimeToRecover *time.Duration
+	LastLeadTime      *time.Duration
+}
+
+// ByEnvironment calculates the metrics for each project and environment from
+// all stored deployments, ordered by project ID then environment. The last time
+// to recover is that of the most recent resolved failure and the last lead time
+// is that of the most recent successful deployment.
+func (s *Service) ByEnvironment(m FailureMode) ([]*EnvironmentMetrics, error) {
+
+	d, err := s.r.GetDeployments(Filter{})
+	if err != nil {
+		return nil, err
+	}
+
+	return calculateByEnvironment(d, m), nil
+}
+
+func calculateByEnvironment(d []*Deployment, m FailureMode) []*EnvironmentMetrics {
+
+	em := []*EnvironmentMetrics{}
+
+	for _, pd := range byProject(d) {
+
+		envs := map[string][]*Deployment{}
+		for _, dep := range pd {
+			envs[dep.EnvironmentName] = append(envs[dep.EnvironmentName], dep)
+		}
+
+		names := []string{}
+		for name := range envs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			ed := envs[name]
+			fr := calculateFailureRate(ed, DateRange{}, m)
+
+			e := &EnvironmentMetrics{
+				ProjectID:         ed[0].ProjectID,
+				ProjectName:       ed[0].ProjectName,
+				Namespace:         ed[0].ProjectNamespace,
+				Environment:       name,
+				Deployments:       fr.Deployments,
+				Failures:          fr.Failures,
+				ChangeFailureRate: fr.Rate,
+			}
+
+			// deployments are in the order they finished, so the last is the latest
+			for _, r := range findRecoveries(ed, DateRange{}, time.Now()) {
+				if r.Resolved {
+					ttr := r.Duration
+					e.LastTimeToRecover = &ttr
+				}
+			}
+
+			if lt := calculateLeadTime(ed, DateRange{}); len(lt.Deployments) > 0 {
+				last := lt.Deployments[len(lt.Deployments)-1].Mean
+				e.LastLeadTime = &last
+			}
+
+			em = append(em, e)
+		}
+	}
+
+	return em
+}
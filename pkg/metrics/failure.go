@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"fmt"
+)
+
+// FailureMode determines how redeployments of the same pipeline are counted
+type FailureMode int
+
+const (
+	// CountEveryDeployment counts each deployment attempt separately, so a failed
+	// deployment followed by a successful redeploy of the same pipeline counts
+	// as two deployments, one of which failed
+	CountEveryDeployment FailureMode = iota
+
+	// CountPerPipeline counts all deployment attempts of a pipeline as a single
+	// change, which failed if any of its attempts failed
+	CountPerPipeline
+)
+
+// FailureRate represents the proportion of production deployments which failed
+type FailureRate struct {
+	Deployments int
+	Failures    int
+	Rate        float64
+}
+
+// ParseFailureMode converts a configuration value into a FailureMode
+func ParseFailureMode(s string) (FailureMode, error) {
+	switch s {
+	case "", "deployment":
+		return CountEveryDeployment, nil
+	case "pipeline":
+		return CountPerPipeline, nil
+	}
+	return CountEveryDeployment, fmt.Errorf("unknown failure mode %q", s)
+}
+
+// String returns the configuration value for the FailureMode
+func (m FailureMode) String() string {
+	if m == CountPerPipeline {
+		return "pipeline"
+	}
+	return "deployment"
+}
+
+// ChangeFailureRate calculates failed / total production deployments for the filter
+func (s *Service) ChangeFailureRate(f Filter, m FailureMode) (*FailureRate, error) {
+
+	d, err := s.r.GetDeployments(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return calculateFailureRate(d, f.DateRange, m), nil
+}
+
+func calculateFailureRate(d []*Deployment, dr DateRange, m FailureMode) *FailureRate {
+
+	fr := &FailureRate{}
+
+	d = inRange(d, dr)
+
+	if m == CountPerPipeline {
+
+		type pipeline struct{ projectID, pipelineID int }
+
+		failed := map[pipeline]bool{}
+		for _, dep := range d {
+			p := pipeline{dep.ProjectID, dep.PipelineID}
+			failed[p] = failed[p] || dep.Status == "failed"
+		}
+
+		fr.Deployments = len(failed)
+		for _, f := range failed {
+			if f {
+				fr.Failures++
+			}
+		}
+	} else {
+		fr.Deployments = len(d)
+		for _, dep := range d {
+			if dep.Status == "failed" {
+				fr.Failures++
+			}
+		}
+	}
+
+	if fr.Deployments > 0 {
+		fr.Rate = float64(fr.Failures) / float64(fr.Deployments)
+	}
+
+	return fr
+}
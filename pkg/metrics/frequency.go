@@ -0,0 +1,39 @@
+package metrics
+
+// Frequency represents how often production deployments happened in a period
+type Frequency struct {
+	Deployments int
+	Days        float64
+	PerDay      float64
+	PerWeek     float64
+}
+
+// DeploymentFrequency calculates how often production deployments ran for the filter
+func (s *Service) DeploymentFrequency(f Filter) (*Frequency, error) {
+
+	d, err := s.r.GetDeployments(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return calculateFrequency(d, f.DateRange), nil
+}
+
+func calculateFrequency(d []*Deployment, dr DateRange) *Frequency {
+
+	d = inRange(d, dr)
+
+	fr := &Frequency{Deployments: len(d)}
+
+	start, end := span(d, dr)
+
+	fr.Days = end.Sub(start).Hours() / 24
+	if fr.Days < 1 {
+		fr.Days = 1
+	}
+
+	fr.PerDay = float64(fr.Deployments) / fr.Days
+	fr.PerWeek = fr.PerDay * 7
+
+	return fr
+}
@@ -0,0 +1,100 @@
+package metrics
+
+import (
+	"time"
+)
+
+// DeploymentLeadTime represents how long the changes in a deployment took to reach production
+type DeploymentLeadTime struct {
+	DeploymentID int
+	ProjectID    int
+	ProjectName  string
+	FinishedAt   time.Time
+	Commits      int
+	Mean         time.Duration
+	Max          time.Duration
+	Approximate  bool
+}
+
+// LeadTime represents the lead time for changes and the deployments it was calculated from.
+// Samples is the number of lead times in the mean, one for each commit or approximate deployment.
+type LeadTime struct {
+	Mean        time.Duration
+	Samples     int
+	Deployments []*DeploymentLeadTime
+}
+
+// ChangeLeadTime calculates the time from commit to successful production deployment
+// for the filter. Deployments with no recorded commits fall back to the pipeline
+// duration and are marked as approximate.
+func (s *Service) ChangeLeadTime(f Filter) (*LeadTime, error) {
+
+	d, err := s.r.GetDeployments(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return calculateLeadTime(d, f.DateRange), nil
+}
+
+func calculateLeadTime(d []*Deployment, dr DateRange) *LeadTime {
+
+	lt := &LeadTime{Deployments: []*DeploymentLeadTime{}}
+
+	// every commit is a sample, approximate deployments contribute a single sample
+	var total time.Duration
+
+	for _, dep := range inRange(d, dr) {
+		if dep.Status != "success" {
+			continue
+		}
+
+		dlt := deploymentLeadTime(dep)
+		lt.Deployments = append(lt.Deployments, dlt)
+
+		if dlt.Approximate {
+			total += dlt.Mean
+			lt.Samples++
+		} else {
+			total += dlt.Mean * time.Duration(dlt.Commits)
+			lt.Samples += dlt.Commits
+		}
+	}
+
+	if lt.Samples > 0 {
+		lt.Mean = total / time.Duration(lt.Samples)
+	}
+
+	return lt
+}
+
+// deploymentLeadTime calculates commit to deploy lead time for a single deployment
+func deploymentLeadTime(dep *Deployment) *DeploymentLeadTime {
+
+	dlt := &DeploymentLeadTime{
+		DeploymentID: dep.ID,
+		ProjectID:    dep.ProjectID,
+		ProjectName:  dep.ProjectName,
+		FinishedAt:   *dep.FinishedAt,
+		Commits:      len(dep.Commits),
+	}
+
+	if len(dep.Commits) == 0 {
+		dlt.Mean = time.Duration(dep.Duration * float64(time.Second))
+		dlt.Max = dlt.Mean
+		dlt.Approximate = true
+		return dlt
+	}
+
+	var total time.Duration
+	for _, c := range dep.Commits {
+		l := dep.FinishedAt.Sub(c.CommittedAt)
+		total += l
+		if l > dlt.Max {
+			dlt.Max = l
+		}
+	}
+	dlt.Mean = total / time.Duration(len(dep.Commits))
+
+	return dlt
+}
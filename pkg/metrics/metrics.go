@@ -0,0 +1,117 @@
+package metrics
+
+import (
+	"time"
+)
+
+// Service provides functionality for calculating DORA metrics
+type Service struct {
+	r Repository
+}
+
+// Repository provides access to stored deployment data
+type Repository interface {
+	GetDeployments(f Filter) ([]*Deployment, error)
+	GetProjects() ([]*Project, error)
+}
+
+// Filter restricts the deployments used to calculate a metric
+type Filter struct {
+	DateRange   DateRange
+	ProjectName string
+	GroupName   string
+	// Namespace and ProjectPath match a single project exactly, where
+	// GroupName also matches subgroups
+	Namespace   string
+	ProjectPath string
+	// ProjectIDs restricts the deployments to the projects, unless empty
+	ProjectIDs []int
+}
+
+// DateRange represents the period a metric is calculated over
+type DateRange struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Project represents metrix view of a project metrics are calculated for
+type Project struct {
+	ID        int
+	Name      string
+	Path      string
+	Namespace string
+	// Visibility is the GitLab visibility of the project: public, internal or private
+	Visibility string
+}
+
+// Deployment represents metrix view of a deployment used to calculate metrics
+type Deployment struct {
+	ID               int
+	Status           string
+	EnvironmentName  string
+	ProjectID        int
+	ProjectName      string
+	ProjectPath      string
+	ProjectNamespace string
+	PipelineID       int
+	SHA              string
+	FinishedAt       *time.Time
+	Duration         float64
+	Commits          []*Commit
+}
+
+// Commit represents a commit introduced to production by a deployment
+type Commit struct {
+	SHA         string
+	CommittedAt time.Time
+}
+
+// NewService creates a metrics calculator with required dependencies
+func NewService(r Repository) *Service {
+	return &Service{r}
+}
+
+// IsZero reports whether the date range is unset
+func (dr DateRange) IsZero() bool {
+	return dr.Start.IsZero() && dr.End.IsZero()
+}
+
+// Contains reports whether t falls within the date range
+func (dr DateRange) Contains(t *time.Time) bool {
+	if t == nil {
+		return false
+	}
+	if !dr.Start.IsZero() && t.Before(dr.Start) {
+		return false
+	}
+	if !dr.End.IsZero() && t.After(dr.End) {
+		return false
+	}
+	return true
+}
+
+// inRange returns the deployments which finished within the date range
+func inRange(d []*Deployment, dr DateRange) []*Deployment {
+	r := []*Deployment{}
+	for _, dep := range d {
+		if dr.Contains(dep.FinishedAt) {
+			r = append(r, dep)
+		}
+	}
+	return r
+}
+
+// span returns the date range, using the span of the deployments themselves
+// for any end of the range which is not set
+func span(d []*Deployment, dr DateRange) (time.Time, time.Time) {
+	start, end := dr.Start, dr.End
+	for _, dep := range d {
+		if dr.Start.IsZero() && (start.IsZero() || dep.FinishedAt.Before(start)) {
+			start = *dep.FinishedAt
+		}
+		if dr.End.IsZero() && (end.IsZero() || dep.FinishedAt.After(end)) {
+			end = *dep.FinishedAt
+		}
+	}
+	return start, end
+}
@@ -0,0 +1,1148 @@
+package metrics_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sk000f/metrix/pkg/metrics"
+)
+
+func TestDeploymentFrequency(t *testing.T) {
+	t.Run("count deployments in date range", func(t *testing.T) {
+
+		r := &mockRepo{DeploymentData: []*metrics.Deployment{
+			deployment(t, 1, "success", "2020-10-01T10:00:00Z"),
+			deployment(t, 2, "failed", "2020-10-02T10:00:00Z"),
+			deployment(t, 3, "success", "2020-10-03T10:00:00Z"),
+			deployment(t, 4, "success", "2020-10-20T10:00:00Z"),
+		}}
+
+		s := metrics.NewService(r)
+
+		f := metrics.Filter{DateRange: dateRange(t, "2020-10-01T00:00:00Z", "2020-10-15T00:00:00Z")}
+
+		got, err := s.DeploymentFrequency(f)
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		want := &metrics.Frequency{
+			Deployments: 3,
+			Days:        14,
+			PerDay:      3.0 / 14,
+			PerWeek:     3.0 / 14 * 7,
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v; wanted %+v", got, want)
+		}
+	})
+
+	t.Run("use span of deployments when no date range given", func(t *testing.T) {
+
+		r := &mockRepo{DeploymentData: []*metrics.Deployment{
+			deployment(t, 1, "success", "2020-10-01T10:00:00Z"),
+			deployment(t, 2, "success", "2020-10-03T10:00:00Z"),
+			deployment(t, 3, "success", "2020-10-05T10:00:00Z"),
+			deployment(t, 4, "success", "2020-10-05T12:00:00Z"),
+		}}
+
+		s := metrics.NewService(r)
+
+		got, err := s.DeploymentFrequency(metrics.Filter{})
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		if got.Deployments != 4 {
+			t.Errorf("got %v deployments; wanted %v", got.Deployments, 4)
+		}
+
+		if got.PerDay != 4/got.Days {
+			t.Errorf("got %v per day; wanted %v", got.PerDay, 4/got.Days)
+		}
+	})
+
+	t.Run("no deployments in date range", func(t *testing.T) {
+
+		s := metrics.NewService(new(mockRepo))
+
+		f := metrics.Filter{DateRange: dateRange(t, "2020-10-01T00:00:00Z", "2020-10-08T00:00:00Z")}
+
+		got, err := s.DeploymentFrequency(f)
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		want := &metrics.Frequency{Deployments: 0, Days: 7, PerDay: 0, PerWeek: 0}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v; wanted %+v", got, want)
+		}
+	})
+}
+
+func TestChangeFailureRate(t *testing.T) {
+
+	// pipeline 2 failed to deploy and was then successfully redeployed
+	d := []*metrics.Deployment{
+		deployment(t, 1, "success", "2020-10-01T10:00:00Z"),
+		deployment(t, 2, "failed", "2020-10-02T10:00:00Z"),
+		deployment(t, 3, "success", "2020-10-02T11:00:00Z"),
+		deployment(t, 4, "success", "2020-10-03T10:00:00Z"),
+	}
+	d[2].PipelineID = 2
+
+	t.Run("count every deployment attempt", func(t *testing.T) {
+
+		s := metrics.NewService(&mockRepo{DeploymentData: d})
+
+		got, err := s.ChangeFailureRate(metrics.Filter{}, metrics.CountEveryDeployment)
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		want := &metrics.FailureRate{Deployments: 4, Failures: 1, Rate: 0.25}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v; wanted %+v", got, want)
+		}
+	})
+
+	t.Run("count redeployments of a pipeline once", func(t *testing.T) {
+
+		s := metrics.NewService(&mockRepo{DeploymentData: d})
+
+		got, err := s.ChangeFailureRate(metrics.Filter{}, metrics.CountPerPipeline)
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		want := &metrics.FailureRate{Deployments: 3, Failures: 1, Rate: 1.0 / 3}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v; wanted %+v", got, want)
+		}
+	})
+
+	t.Run("only count deployments in date range", func(t *testing.T) {
+
+		s := metrics.NewService(&mockRepo{DeploymentData: d})
+
+		f := metrics.Filter{DateRange: dateRange(t, "2020-10-02T00:00:00Z", "2020-10-02T23:59:59Z")}
+
+		got, err := s.ChangeFailureRate(f, metrics.CountEveryDeployment)
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		want := &metrics.FailureRate{Deployments: 2, Failures: 1, Rate: 0.5}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v; wanted %+v", got, want)
+		}
+	})
+
+	t.Run("parse failure mode configuration", func(t *testing.T) {
+
+		m, err := metrics.ParseFailureMode("pipeline")
+		if err != nil || m != metrics.CountPerPipeline {
+			t.Errorf("got %v, %v; wanted %v", m, err, metrics.CountPerPipeline)
+		}
+
+		if _, err := metrics.ParseFailureMode("unknown"); err == nil {
+			t.Errorf("expected error for unknown failure mode")
+		}
+	})
+}
+
+func TestMeanTimeToRecover(t *testing.T) {
+	t.Run("measure failure to next successful deployment", func(t *testing.T) {
+
+		// deployments are deliberately out of order to check they are sorted
+		d := []*metrics.Deployment{
+			deployment(t, 3, "failed", "2020-10-01T11:00:00Z"),
+			deployment(t, 1, "success", "2020-10-01T09:00:00Z"),
+			deployment(t, 2, "failed", "2020-10-01T10:00:00Z"),
+			deployment(t, 4, "success", "2020-10-01T12:00:00Z"),
+			deployment(t, 5, "failed", "2020-10-02T10:00:00Z"),
+			deployment(t, 6, "success", "2020-10-02T10:30:00Z"),
+		}
+
+		s := metrics.NewService(&mockRepo{DeploymentData: d})
+
+		f := metrics.Filter{DateRange: dateRange(t, "2020-10-01T00:00:00Z", "2020-10-08T00:00:00Z")}
+
+		got, err := s.MeanTimeToRecover(f)
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		if len(got.Recoveries) != 2 {
+			t.Fatalf("got %v recoveries; wanted %v", len(got.Recoveries), 2)
+		}
+
+		first := got.Recoveries[0]
+		if first.FailedDeploymentID != 2 || first.RecoveredDeploymentID != 4 ||
+			first.Duration != 2*time.Hour || !first.Resolved {
+			t.Errorf("got %+v; wanted recovery from deployment 2 to 4 taking 2h", first)
+		}
+
+		if got.Mean != 75*time.Minute {
+			t.Errorf("got mean %v; wanted %v", got.Mean, 75*time.Minute)
+		}
+	})
+
+	t.Run("measure unresolved failure to end of date range", func(t *testing.T) {
+
+		d := []*metrics.Deployment{
+			deployment(t, 1, "success", "2020-10-01T09:00:00Z"),
+			deployment(t, 2, "failed", "2020-10-01T10:00:00Z"),
+		}
+
+		s := metrics.NewService(&mockRepo{DeploymentData: d})
+
+		f := metrics.Filter{DateRange: dateRange(t, "2020-10-01T00:00:00Z", "2020-10-01T16:00:00Z")}
+
+		got, err := s.MeanTimeToRecover(f)
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		want := &metrics.TimeToRecover{
+			Mean: 6 * time.Hour,
+			Recoveries: []*metrics.Recovery{{
+				ProjectID:          1,
+				ProjectName:        "test",
+				FailedDeploymentID: 2,
+				FailedAt:           *d[1].FinishedAt,
+				Duration:           6 * time.Hour,
+				Resolved:           false,
+			}},
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v; wanted %+v", got, want)
+		}
+	})
+
+	t.Run("measure unresolved failure to now when the date range ends in the future", func(t *testing.T) {
+
+		failed := time.Now().Add(-time.Hour)
+		d := []*metrics.Deployment{deployment(t, 1, "failed", failed.Format(time.RFC3339Nano))}
+
+		s := metrics.NewService(&mockRepo{DeploymentData: d})
+
+		dr := metrics.DateRange{Start: failed.Add(-time.Hour), End: failed.AddDate(0, 0, 30)}
+
+		got, err := s.MeanTimeToRecover(metrics.Filter{DateRange: dr})
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		if got.Mean < time.Hour || got.Mean > time.Hour+time.Minute {
+			t.Errorf("got mean %v; wanted about 1h", got.Mean)
+		}
+
+		b, err := s.TimeSeries(metrics.Filter{DateRange: dr}, metrics.Month, metrics.CountEveryDeployment)
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		for _, bucket := range b {
+			if bucket.MeanTimeToRecover > time.Hour+time.Minute {
+				t.Errorf("got bucket mean %v; wanted at most about 1h", bucket.MeanTimeToRecover)
+			}
+		}
+	})
+
+	t.Run("keep recoveries separate per project", func(t *testing.T) {
+
+		d := []*metrics.Deployment{
+			deployment(t, 1, "failed", "2020-10-01T09:00:00Z"),
+			deployment(t, 2, "success", "2020-10-01T10:00:00Z"),
+			deployment(t, 3, "success", "2020-10-01T12:00:00Z"),
+		}
+		d[1].ProjectID = 2
+
+		s := metrics.NewService(&mockRepo{DeploymentData: d})
+
+		f := metrics.Filter{DateRange: dateRange(t, "2020-10-01T00:00:00Z", "2020-10-02T00:00:00Z")}
+
+		got, err := s.MeanTimeToRecover(f)
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		if len(got.Recoveries) != 1 || got.Recoveries[0].RecoveredDeploymentID != 3 {
+			t.Errorf("got %+v; wanted single recovery by deployment 3", got.Recoveries)
+		}
+	})
+}
+
+func TestChangeLeadTime(t *testing.T) {
+	t.Run("measure commit to deployment lead time", func(t *testing.T) {
+
+		d := []*metrics.Deployment{
+			deployment(t, 1, "success", "2020-10-01T12:00:00Z"),
+			deployment(t, 2, "failed", "2020-10-02T12:00:00Z"),
+		}
+		d[0].Commits = []*metrics.Commit{
+			commit(t, "a", "2020-10-01T08:00:00Z"),
+			commit(t, "b", "2020-10-01T10:00:00Z"),
+		}
+		d[1].Commits = []*metrics.Commit{
+			commit(t, "c", "2020-10-02T08:00:00Z"),
+		}
+
+		s := metrics.NewService(&mockRepo{DeploymentData: d})
+
+		got, err := s.ChangeLeadTime(metrics.Filter{})
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		want := &metrics.LeadTime{
+			Mean:    3 * time.Hour,
+			Samples: 2,
+			Deployments: []*metrics.DeploymentLeadTime{{
+				DeploymentID: 1,
+				ProjectID:    1,
+				ProjectName:  "test",
+				FinishedAt:   *d[0].FinishedAt,
+				Commits:      2,
+				Mean:         3 * time.Hour,
+				Max:          4 * time.Hour,
+			}},
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v; wanted %+v", got, want)
+		}
+	})
+
+	t.Run("weight mean lead time by commit", func(t *testing.T) {
+
+		d := []*metrics.Deployment{
+			deployment(t, 1, "success", "2020-10-01T12:00:00Z"),
+			deployment(t, 2, "success", "2020-10-02T12:00:00Z"),
+		}
+		d[0].Commits = []*metrics.Commit{
+			commit(t, "a", "2020-10-01T11:00:00Z"),
+			commit(t, "b", "2020-10-01T11:00:00Z"),
+			commit(t, "c", "2020-10-01T11:00:00Z"),
+		}
+		d[1].Commits = []*metrics.Commit{
+			commit(t, "d", "2020-10-02T07:00:00Z"),
+		}
+
+		s := metrics.NewService(&mockRepo{DeploymentData: d})
+
+		got, err := s.ChangeLeadTime(metrics.Filter{})
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		if got.Mean != 2*time.Hour {
+			t.Errorf("got mean %v; wanted %v", got.Mean, 2*time.Hour)
+		}
+	})
+
+	t.Run("approximate with pipeline duration when no commits recorded", func(t *testing.T) {
+
+		d := []*metrics.Deployment{
+			deployment(t, 1, "success", "2020-10-01T12:00:00Z"),
+		}
+		d[0].Duration = 90
+
+		s := metrics.NewService(&mockRepo{DeploymentData: d})
+
+		got, err := s.ChangeLeadTime(metrics.Filter{})
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		if got.Mean != 90*time.Second || !got.Deployments[0].Approximate {
+			t.Errorf("got %+v; wanted approximate lead time of 90s", got.Deployments[0])
+		}
+	})
+}
+
+func TestClassify(t *testing.T) {
+
+	th, err := metrics.LookupThresholds("2021")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("classify each metric and overall band", func(t *testing.T) {
+
+		// two deployments a day with one quick recovery
+		d := []*metrics.Deployment{}
+		for i := 0; i < 14; i++ {
+			day := fmt.Sprintf("2020-10-%02d", i+1)
+			d = append(d,
+				deployment(t, i*2+1, "success", day+"T09:00:00Z"),
+				deployment(t, i*2+2, "success", day+"T15:00:00Z"))
+		}
+		d[2].Status = "failed"
+		d[3].FinishedAt = addTime(d[2].FinishedAt, 30*time.Minute)
+		for _, dep := range d {
+			dep.Commits = []*metrics.Commit{{SHA: "a", CommittedAt: dep.FinishedAt.Add(-2 * time.Hour)}}
+		}
+
+		s := metrics.NewService(&mockRepo{DeploymentData: d})
+
+		f := metrics.Filter{DateRange: dateRange(t, "2020-10-01T00:00:00Z", "2020-10-15T00:00:00Z")}
+
+		got, err := s.Classify(f, metrics.CountEveryDeployment, th)
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		want := &metrics.Classification{
+			Version:             "2021",
+			DeploymentFrequency: metrics.Elite,
+			LeadTime:            metrics.High,
+			TimeToRecover:       metrics.Elite,
+			ChangeFailureRate:   metrics.Elite,
+			Overall:             metrics.High,
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v; wanted %+v", got, want)
+		}
+	})
+
+	t.Run("ignore metrics without data in overall band", func(t *testing.T) {
+
+		d := []*metrics.Deployment{
+			deployment(t, 1, "success", "2020-10-01T09:00:00Z"),
+		}
+
+		s := metrics.NewService(&mockRepo{DeploymentData: d})
+
+		f := metrics.Filter{DateRange: dateRange(t, "2020-10-01T00:00:00Z", "2020-10-31T00:00:00Z")}
+
+		got, err := s.Classify(f, metrics.CountEveryDeployment, th)
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		if got.TimeToRecover != metrics.Unknown || got.DeploymentFrequency != metrics.Medium ||
+			got.Overall != metrics.Medium {
+			t.Errorf("got %+v; wanted medium overall with unknown time to recover", got)
+		}
+	})
+
+	t.Run("leave every band unknown without deployments in the date range", func(t *testing.T) {
+
+		d := []*metrics.Deployment{
+			deployment(t, 1, "success", "2020-09-01T09:00:00Z"),
+		}
+
+		s := metrics.NewService(&mockRepo{DeploymentData: d})
+
+		f := metrics.Filter{DateRange: dateRange(t, "2020-10-01T00:00:00Z", "2020-10-31T00:00:00Z")}
+
+		got, err := s.Classify(f, metrics.CountEveryDeployment, th)
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		if got.DeploymentFrequency != metrics.Unknown || got.Overall != metrics.Unknown {
+			t.Errorf("got %+v; wanted unknown deployment frequency and overall", got)
+		}
+	})
+
+	t.Run("classify every project and group", func(t *testing.T) {
+
+		d := []*metrics.Deployment{
+			deployment(t, 1, "success", "2020-10-01T09:00:00Z"),
+			deployment(t, 2, "failed", "2020-10-01T09:00:00Z"),
+		}
+		d[1].ProjectID = 2
+		d[1].ProjectName = "other"
+		d[1].ProjectNamespace = "other"
+
+		s := metrics.NewService(&mockRepo{DeploymentData: d})
+
+		got, err := s.ClassifyAll(metrics.DateRange{}, metrics.CountEveryDeployment, th)
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		if len(got.Projects) != 2 || len(got.Groups) != 2 {
+			t.Fatalf("got %v projects and %v groups; wanted 2 of each", len(got.Projects), len(got.Groups))
+		}
+
+		if got.Groups[0].Namespace != "other" || got.Groups[0].Classification.ChangeFailureRate != metrics.Low {
+			t.Errorf("got %+v; wanted low change failure rate for other", got.Groups[0].Classification)
+		}
+	})
+
+	t.Run("return a copy of the built in thresholds", func(t *testing.T) {
+
+		got, err := metrics.LookupThresholds("2021")
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+		got.LeadTime.Elite = 1000
+
+		if again, _ := metrics.LookupThresholds("2021"); again.LeadTime.Elite != 1 {
+			t.Errorf("got elite lead time %v; wanted 1", again.LeadTime.Elite)
+		}
+	})
+
+	t.Run("load custom thresholds from file", func(t *testing.T) {
+
+		f, err := ioutil.TempFile("", "thresholds*.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(f.Name())
+
+		fmt.Fprint(f, `{
+			"version": "custom",
+			"deployment_frequency": {"elite": 2, "high": 1, "medium": 0.5},
+			"lead_time": {"elite": 1, "high": 2, "medium": 3},
+			"time_to_recover": {"elite": 1, "high": 2, "medium": 3},
+			"change_failure_rate": {"elite": 0.1, "high": 0.2, "medium": 0.3}
+		}`)
+		f.Close()
+
+		got, err := metrics.LookupThresholds(f.Name())
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		if got.Version != "custom" || got.DeploymentFrequency.Elite != 2 {
+			t.Errorf("got %+v; wanted custom thresholds", got)
+		}
+
+		if _, err := metrics.LookupThresholds("1999"); err == nil {
+			t.Errorf("expected error for unknown thresholds version")
+		}
+	})
+}
+
+func TestTimeSeries(t *testing.T) {
+	t.Run("bucket deployments by week", func(t *testing.T) {
+
+		// 2020-10-05 is a Monday
+		d := []*metrics.Deployment{
+			deployment(t, 1, "success", "2020-10-01T09:00:00Z"),
+			deployment(t, 2, "failed", "2020-10-04T09:00:00Z"),
+			deployment(t, 3, "success", "2020-10-05T09:00:00Z"),
+			deployment(t, 4, "success", "2020-10-06T09:00:00Z"),
+			deployment(t, 5, "success", "2020-10-20T09:00:00Z"),
+		}
+
+		s := metrics.NewService(&mockRepo{DeploymentData: d})
+
+		f := metrics.Filter{DateRange: dateRange(t, "2020-10-01T00:00:00Z", "2020-10-21T00:00:00Z")}
+
+		got, err := s.TimeSeries(f, metrics.Week, metrics.CountEveryDeployment)
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		if len(got) != 4 {
+			t.Fatalf("got %v buckets; wanted %v", len(got), 4)
+		}
+
+		wantStart := dateRange(t, "2020-09-28T00:00:00Z", "2020-10-05T00:00:00Z")
+		if !got[0].Start.Equal(wantStart.Start) || !got[1].Start.Equal(wantStart.End) {
+			t.Errorf("got buckets starting %v and %v; wanted %v and %v",
+				got[0].Start, got[1].Start, wantStart.Start, wantStart.End)
+		}
+
+		deployments := []int{}
+		failures := []int{}
+		for _, b := range got {
+			deployments = append(deployments, b.Deployments)
+			failures = append(failures, b.Failures)
+		}
+
+		if !reflect.DeepEqual(deployments, []int{2, 2, 0, 1}) || !reflect.DeepEqual(failures, []int{1, 0, 0, 0}) {
+			t.Errorf("got deployments %v and failures %v", deployments, failures)
+		}
+
+		// the recovery belongs to the week the failure happened in
+		if len(got[0].Recoveries) != 1 || got[0].MeanTimeToRecover != 24*time.Hour {
+			t.Errorf("got recoveries %+v; wanted one taking 24h", got[0].Recoveries)
+		}
+
+		if len(got[1].LeadTimes) != 2 {
+			t.Errorf("got %v lead times; wanted %v", len(got[1].LeadTimes), 2)
+		}
+	})
+
+	t.Run("bucket deployments by month", func(t *testing.T) {
+
+		d := []*metrics.Deployment{
+			deployment(t, 1, "success", "2020-10-31T23:59:59Z"),
+			deployment(t, 2, "success", "2020-11-01T00:00:00Z"),
+		}
+
+		s := metrics.NewService(&mockRepo{DeploymentData: d})
+
+		got, err := s.TimeSeries(metrics.Filter{}, metrics.Month, metrics.CountEveryDeployment)
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		if len(got) != 2 || got[0].Deployments != 1 || got[1].Deployments != 1 {
+			t.Errorf("got %+v; wanted one deployment in each of two months", got)
+		}
+	})
+
+	t.Run("bucket deployments by the UTC day", func(t *testing.T) {
+
+		d := []*metrics.Deployment{
+			deployment(t, 1, "success", "2020-10-02T01:00:00+02:00"),
+			deployment(t, 2, "success", "2020-10-02T09:00:00Z"),
+			deployment(t, 3, "success", "2020-10-03T00:00:00Z"),
+		}
+
+		s := metrics.NewService(&mockRepo{DeploymentData: d})
+
+		got, err := s.TimeSeries(metrics.Filter{}, metrics.Day, metrics.CountEveryDeployment)
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		deployments := []int{}
+		for _, b := range got {
+			deployments = append(deployments, b.Deployments)
+		}
+
+		if !reflect.DeepEqual(deployments, []int{1, 1, 1}) {
+			t.Errorf("got deployments %v; wanted one on each day", deployments)
+		}
+	})
+}
+
+func TestDistribution(t *testing.T) {
+	t.Run("calculate percentiles of deployment durations", func(t *testing.T) {
+
+		d := []*metrics.Deployment{}
+		for i := 1; i <= 20; i++ {
+			dep := deployment(t, i, "success", "2020-10-01T09:00:00Z")
+			dep.Duration = float64(i * 60)
+			d = append(d, dep)
+		}
+
+		s := metrics.NewService(&mockRepo{DeploymentData: d})
+
+		got, err := s.DurationDistribution(metrics.Filter{})
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		want := []time.Duration{
+			time.Minute, 20 * time.Minute, 630 * time.Second,
+			10 * time.Minute, 15 * time.Minute, 18 * time.Minute, 19 * time.Minute,
+		}
+		gotValues := []time.Duration{got.Min, got.Max, got.Mean, got.P50, got.P75, got.P90, got.P95}
+
+		if got.Count != 20 || !reflect.DeepEqual(gotValues, want) {
+			t.Errorf("got min, max, mean, p50, p75, p90, p95 %v; wanted %v", gotValues, want)
+		}
+
+		// 1m is the lower bound of the second bucket, 5m of the third and 15m of the fourth
+		counts := []int{}
+		for _, b := range got.Histogram[:4] {
+			counts = append(counts, b.Count)
+		}
+
+		if !reflect.DeepEqual(counts, []int{0, 4, 10, 6}) {
+			t.Errorf("got histogram counts %v; wanted %v", counts, []int{0, 4, 10, 6})
+		}
+
+		if got.Histogram[len(got.Histogram)-1].UpperBound != nil {
+			t.Errorf("wanted last histogram bucket to be unbounded")
+		}
+	})
+
+	t.Run("calculate distribution of lead times and recovery times", func(t *testing.T) {
+
+		d := []*metrics.Deployment{
+			deployment(t, 1, "failed", "2020-10-01T09:00:00Z"),
+			deployment(t, 2, "success", "2020-10-01T10:00:00Z"),
+		}
+		d[1].Commits = []*metrics.Commit{
+			commit(t, "a", "2020-10-01T08:00:00Z"),
+			commit(t, "b", "2020-10-01T09:30:00Z"),
+		}
+
+		s := metrics.NewService(&mockRepo{DeploymentData: d})
+
+		lt, err := s.LeadTimeDistribution(metrics.Filter{})
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		if lt.Count != 2 || lt.Min != 30*time.Minute || lt.Max != 2*time.Hour {
+			t.Errorf("got %+v; wanted two lead times between 30m and 2h", lt)
+		}
+
+		ttr, err := s.TimeToRecoverDistribution(metrics.Filter{})
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		if ttr.Count != 1 || ttr.P95 != time.Hour {
+			t.Errorf("got %+v; wanted single recovery of 1h", ttr)
+		}
+	})
+
+	t.Run("empty distribution", func(t *testing.T) {
+
+		s := metrics.NewService(new(mockRepo))
+
+		got, err := s.LeadTimeDistribution(metrics.Filter{})
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		if got.Count != 0 || got.P50 != 0 || len(got.Histogram) == 0 {
+			t.Errorf("got %+v; wanted empty distribution with histogram buckets", got)
+		}
+	})
+}
+
+func TestCompare(t *testing.T) {
+
+	// one deployment in the previous week, three in the current week
+	d := []*metrics.Deployment{
+		deployment(t, 1, "failed", "2020-10-02T09:00:00Z"),
+		deployment(t, 2, "success", "2020-10-09T09:00:00Z"),
+		deployment(t, 3, "success", "2020-10-10T09:00:00Z"),
+		deployment(t, 4, "success", "2020-10-11T09:00:00Z"),
+	}
+
+	dr := dateRange(t, "2020-10-08T00:00:00Z", "2020-10-15T00:00:00Z")
+
+	t.Run("compare with previous period of equal length", func(t *testing.T) {
+
+		s := metrics.NewService(&mockRepo{DeploymentData: d})
+
+		got, err := s.Compare(metrics.Filter{DateRange: dr}, metrics.CountEveryDeployment)
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		fr := got.DeploymentFrequency
+		if fr.Current != 3.0/7 || math.Abs(fr.Previous-1.0/7) > 1e-6 || fr.Direction != metrics.Improved ||
+			fr.Percent == nil || math.Abs(*fr.Percent-200) > 1e-6 {
+			t.Errorf("got deployment frequency %+v; wanted tripled and improved", fr)
+		}
+
+		cfr := got.ChangeFailureRate
+		if cfr.Current != 0 || cfr.Previous != 1 || cfr.Absolute != -1 || cfr.Direction != metrics.Improved {
+			t.Errorf("got change failure rate %+v; wanted fall from 1 to 0", cfr)
+		}
+
+		if got.ChangeLeadTime.Percent != nil {
+			t.Errorf("wanted no percentage change when previous value is zero")
+		}
+
+		if lt := got.ChangeLeadTime; lt.Direction != metrics.UnknownDirection || lt.Absolute != 0 {
+			t.Errorf("got lead time %+v; wanted unknown without successful deployments in the previous period", lt)
+		}
+
+		if ttr := got.MeanTimeToRecover; ttr.Direction != metrics.UnknownDirection {
+			t.Errorf("got time to recover %v; wanted %v without recoveries", ttr.Direction, metrics.UnknownDirection)
+		}
+	})
+
+	t.Run("require a date range", func(t *testing.T) {
+
+		s := metrics.NewService(&mockRepo{DeploymentData: d})
+
+		if _, err := s.Compare(metrics.Filter{}, metrics.CountEveryDeployment); err != metrics.ErrNoDateRange {
+			t.Errorf("got %v; wanted %v", err, metrics.ErrNoDateRange)
+		}
+	})
+
+	t.Run("compare every project and namespace", func(t *testing.T) {
+
+		r := &mockRepo{
+			DeploymentData: d,
+			ProjectData: []*metrics.Project{
+				{ID: 1, Name: "test", Namespace: "test/test"},
+				{ID: 2, Name: "idle", Namespace: "idle"},
+			},
+		}
+
+		s := metrics.NewService(r)
+
+		got, err := s.CompareAll(dr, metrics.CountEveryDeployment)
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		if len(got.Projects) != 2 || len(got.Groups) != 2 {
+			t.Fatalf("got %v projects and %v groups; wanted 2 of each", len(got.Projects), len(got.Groups))
+		}
+
+		if got.Projects[1].Comparison.DeploymentFrequency.Direction != metrics.Unchanged {
+			t.Errorf("wanted idle project to be unchanged")
+		}
+
+		if !got.Previous.Start.Equal(dateRange(t, "2020-10-01T00:00:00Z", "2020-10-08T00:00:00Z").Start) {
+			t.Errorf("got previous period starting %v; wanted 2020-10-01", got.Previous.Start)
+		}
+	})
+}
+
+func TestNamespaceRollup(t *testing.T) {
+
+	p := []*metrics.Project{
+		{ID: 1, Name: "api", Namespace: "org/platform/payments"},
+		{ID: 2, Name: "web", Namespace: "org/platform/payments"},
+		{ID: 3, Name: "docs", Namespace: "org/platform"},
+		{ID: 4, Name: "site", Namespace: "org/marketing"},
+	}
+
+	// api deploys three times with one failure, docs deploys once and fails
+	d := []*metrics.Deployment{
+		deployment(t, 1, "success", "2020-10-01T09:00:00Z"),
+		deployment(t, 2, "failed", "2020-10-02T09:00:00Z"),
+		deployment(t, 3, "success", "2020-10-02T10:00:00Z"),
+		deployment(t, 4, "failed", "2020-10-03T09:00:00Z"),
+	}
+	d[3].ProjectID = 3
+	d[3].ProjectName = "docs"
+	d[3].ProjectNamespace = "org/platform"
+
+	dr := dateRange(t, "2020-10-01T00:00:00Z", "2020-10-04T00:00:00Z")
+
+	t.Run("roll up metrics through every level of the namespace", func(t *testing.T) {
+
+		s := metrics.NewService(&mockRepo{ProjectData: p, DeploymentData: d})
+
+		got, err := s.NamespaceRollup(dr, "", metrics.CountEveryDeployment)
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		if len(got.Groups) != 1 || got.Groups[0].Path != "org" {
+			t.Fatalf("got %+v; wanted single top level group org", got.Groups)
+		}
+
+		org := got.Groups[0]
+		if org.Summary.Deployments != 4 || org.Summary.Failures != 2 || org.Summary.ChangeFailureRate != 0.5 {
+			t.Errorf("got %+v; wanted 4 deployments with 2 failures", org.Summary)
+		}
+
+		names := []string{}
+		for _, g := range org.Groups {
+			names = append(names, g.Path)
+		}
+
+		if !reflect.DeepEqual(names, []string{"org/marketing", "org/platform"}) {
+			t.Errorf("got subgroups %v", names)
+		}
+	})
+
+	t.Run("drill down to a subgroup", func(t *testing.T) {
+
+		s := metrics.NewService(&mockRepo{ProjectData: p, DeploymentData: d})
+
+		got, err := s.NamespaceRollup(dr, "org/platform/payments", metrics.CountEveryDeployment)
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		if got == nil || len(got.Projects) != 2 || len(got.Groups) != 0 {
+			t.Fatalf("got %+v; wanted payments group with two projects", got)
+		}
+
+		// web has no deployments so the rate is weighted towards api
+		if got.Summary.Deployments != 3 || got.Summary.ChangeFailureRate != 1.0/3 {
+			t.Errorf("got %+v; wanted 3 deployments with rate 1/3", got.Summary)
+		}
+
+		if got.Summary.Recoveries != 1 || got.Summary.MeanTimeToRecover != time.Hour {
+			t.Errorf("got %+v; wanted single recovery of 1h", got.Summary)
+		}
+	})
+
+	t.Run("weight lead time by commit across projects", func(t *testing.T) {
+
+		// api has three commits each taking 1h, web a single commit taking 5h
+		ld := []*metrics.Deployment{
+			deployment(t, 1, "success", "2020-10-01T12:00:00Z"),
+			deployment(t, 2, "success", "2020-10-02T12:00:00Z"),
+		}
+		ld[0].ProjectNamespace = "org/platform/payments"
+		ld[0].Commits = []*metrics.Commit{
+			commit(t, "a", "2020-10-01T11:00:00Z"),
+			commit(t, "b", "2020-10-01T11:00:00Z"),
+			commit(t, "c", "2020-10-01T11:00:00Z"),
+		}
+		ld[1].ProjectID = 2
+		ld[1].ProjectName = "web"
+		ld[1].ProjectNamespace = "org/platform/payments"
+		ld[1].Commits = []*metrics.Commit{
+			commit(t, "d", "2020-10-02T07:00:00Z"),
+		}
+
+		s := metrics.NewService(&mockRepo{ProjectData: p, DeploymentData: ld})
+
+		got, err := s.NamespaceRollup(dr, "org/platform/payments", metrics.CountEveryDeployment)
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		if got.Summary.MeanLeadTime != 2*time.Hour || got.Summary.LeadTimeDeployments != 2 {
+			t.Errorf("got %+v; wanted mean lead time of 2h from 2 deployments", got.Summary)
+		}
+	})
+
+	t.Run("attach projects without a namespace to the root", func(t *testing.T) {
+
+		r := &mockRepo{ProjectData: []*metrics.Project{{ID: 1, Name: "test"}}}
+
+		s := metrics.NewService(r)
+
+		got, err := s.NamespaceRollup(dr, "", metrics.CountEveryDeployment)
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		if len(got.Groups) != 0 || len(got.Projects) != 1 {
+			t.Errorf("got %v groups and %v projects; wanted the project at the root", len(got.Groups), len(got.Projects))
+		}
+	})
+
+	t.Run("unknown namespace", func(t *testing.T) {
+
+		s := metrics.NewService(&mockRepo{ProjectData: p, DeploymentData: d})
+
+		got, err := s.NamespaceRollup(dr, "org/plat", metrics.CountEveryDeployment)
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		if got != nil {
+			t.Errorf("got %+v; wanted nil", got)
+		}
+	})
+}
+
+func TestByEnvironment(t *testing.T) {
+	t.Run("calculate metrics for each project environment", func(t *testing.T) {
+
+		d := []*metrics.Deployment{
+			deployment(t, 3, "success", "2020-10-01T12:00:00Z"),
+			deployment(t, 1, "success", "2020-10-01T09:00:00Z"),
+			deployment(t, 2, "failed", "2020-10-01T10:00:00Z"),
+			deployment(t, 4, "success", "2020-10-01T13:00:00Z"),
+		}
+		d[0].Commits = []*metrics.Commit{commit(t, "a", "2020-10-01T11:30:00Z")}
+		d[3].EnvironmentName = "staging"
+
+		r := &mockRepo{DeploymentData: d}
+		s := metrics.NewService(r)
+
+		got, err := s.ByEnvironment(metrics.CountEveryDeployment)
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		ttr := 2 * time.Hour
+		lt := 30 * time.Minute
+		approx := time.Duration(123.45 * float64(time.Second))
+
+		want := []*metrics.EnvironmentMetrics{
+			{
+				ProjectID:         1,
+				ProjectName:       "test",
+				Namespace:         "test/test",
+				Environment:       "production",
+				Deployments:       3,
+				Failures:          1,
+				ChangeFailureRate: 1.0 / 3,
+				LastTimeToRecover: &ttr,
+				LastLeadTime:      &lt,
+			},
+			{
+				ProjectID:    1,
+				ProjectName:  "test",
+				Namespace:    "test/test",
+				Environment:  "staging",
+				Deployments:  1,
+				LastLeadTime: &approx,
+			},
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v; wanted %+v", got, want)
+		}
+	})
+}
+
+func TestVisible(t *testing.T) {
+	t.Run("only use deployments of visible projects", func(t *testing.T) {
+
+		d := []*metrics.Deployment{
+			deployment(t, 1, "success", "2020-10-01T09:00:00Z"),
+			deployment(t, 2, "success", "2020-10-01T10:00:00Z"),
+			deployment(t, 3, "failed", "2020-10-01T11:00:00Z"),
+		}
+		d[1].ProjectID = 2
+
+		r := &mockRepo{
+			ProjectData:    []*metrics.Project{{ID: 1, Name: "test", Namespace: "org"}, {ID: 2, Name: "other", Namespace: "other"}},
+			DeploymentData: d,
+		}
+		s := metrics.NewService(r).Visible([]int{2})
+
+		f := metrics.Filter{DateRange: dateRange(t, "2020-10-01T00:00:00Z", "2020-10-02T00:00:00Z")}
+
+		got, err := s.DeploymentFrequency(f)
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		if got.Deployments != 1 || !reflect.DeepEqual(r.Filter.ProjectIDs, []int{2}) {
+			t.Errorf("got %v deployments of projects %v; wanted 1 of [2]", got.Deployments, r.Filter.ProjectIDs)
+		}
+
+		nr, err := s.NamespaceRollup(f.DateRange, "", metrics.CountEveryDeployment)
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+
+		if len(nr.Groups) != 1 || nr.Groups[0].Path != "other" {
+			t.Errorf("got groups %+v; wanted only other", nr.Groups)
+		}
+	})
+}
+
+func TestPublicProject(t *testing.T) {
+	t.Run("only find projects which are public", func(t *testing.T) {
+
+		r := &mockRepo{ProjectData: []*metrics.Project{
+			{ID: 1, Name: "api", Path: "api", Namespace: "org", Visibility: "private"},
+			{ID: 2, Name: "web", Path: "web", Namespace: "org", Visibility: "public"},
+		}}
+		s := metrics.NewService(r)
+
+		got, err := s.PublicProject("org", "web")
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err.Error())
+		}
+		if got == nil || got.ID != 2 {
+			t.Errorf("got %+v; wanted project 2", got)
+		}
+
+		for _, path := range []string{"api", "site"} {
+			if got, _ := s.PublicProject("org", path); got != nil {
+				t.Errorf("got %+v for %v; wanted nil", got, path)
+			}
+		}
+	})
+}
+
+func TestUnits(t *testing.T) {
+	t.Run("round durations to seconds and rates to percentages", func(t *testing.T) {
+
+		if got := metrics.Seconds(90*time.Minute + 500*time.Millisecond); got != 5401 {
+			t.Errorf("got %v; wanted %v", got, 5401)
+		}
+
+		if got := metrics.Percent(1.0 / 3); got != 33 {
+			t.Errorf("got %v; wanted %v", got, 33)
+		}
+	})
+}
+
+func deployment(t *testing.T, id int, status, finishedAt string) *metrics.Deployment {
+
+	ts, err := time.Parse(time.RFC3339, finishedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &metrics.Deployment{
+		ID:               id,
+		Status:           status,
+		EnvironmentName:  "production",
+		ProjectID:        1,
+		ProjectName:      "test",
+		ProjectPath:      "test",
+		ProjectNamespace: "test/test",
+		PipelineID:       id,
+		FinishedAt:       &ts,
+		Duration:         123.45,
+	}
+}
+
+func commit(t *testing.T, sha, committedAt string) *metrics.Commit {
+
+	ts, err := time.Parse(time.RFC3339, committedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &metrics.Commit{SHA: sha, CommittedAt: ts}
+}
+
+func addTime(t *time.Time, d time.Duration) *time.Time {
+	ts := t.Add(d)
+	return &ts
+}
+
+func dateRange(t *testing.T, start, end string) metrics.DateRange {
+
+	s, err := time.Parse(time.RFC3339, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := time.Parse(time.RFC3339, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return metrics.DateRange{Start: s, End: e}
+}
+
+type mockRepo struct {
+	ProjectData    []*metrics.Project
+	DeploymentData []*metrics.Deployment
+	Filter         metrics.Filter
+}
+
+func (m *mockRepo) GetDeployments(f metrics.Filter) ([]*metrics.Deployment, error) {
+
+	m.Filter = f
+
+	if len(f.ProjectIDs) == 0 {
+		return m.DeploymentData, nil
+	}
+
+	d := []*metrics.Deployment{}
+	for _, dep := range m.DeploymentData {
+		for _, id := range f.ProjectIDs {
+			if dep.ProjectID == id {
+				d = append(d, dep)
+			}
+		}
+	}
+
+	return d, nil
+}
+
+func (m *mockRepo) GetProjects() ([]*metrics.Project, error) {
+	return m.ProjectData, nil
+}
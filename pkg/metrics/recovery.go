@@ -0,0 +1,132 @@
+package metrics
+
+import (
+	"sort"
+	"time"
+)
+
+// Recovery represents the interval between a failed production deployment
+// and the next successful production deployment of the same project
+type Recovery struct {
+	ProjectID             int
+	ProjectName           string
+	FailedDeploymentID    int
+	RecoveredDeploymentID int
+	FailedAt              time.Time
+	RecoveredAt           *time.Time
+	Duration              time.Duration
+	Resolved              bool
+}
+
+// TimeToRecover represents the mean time to recover and the intervals it was calculated from
+type TimeToRecover struct {
+	Mean       time.Duration
+	Recoveries []*Recovery
+}
+
+// MeanTimeToRecover calculates the average time from a failed production deployment
+// to the next successful one for the filter. Failures which are still unresolved at
+// the end of the date range are measured up to the end of the range, or up to now
+// if the range ends in the future.
+func (s *Service) MeanTimeToRecover(f Filter) (*TimeToRecover, error) {
+
+	d, err := s.r.GetDeployments(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return calculateTimeToRecover(d, f.DateRange), nil
+}
+
+func calculateTimeToRecover(d []*Deployment, dr DateRange) *TimeToRecover {
+
+	ttr := &TimeToRecover{Recoveries: findRecoveries(d, dr, recoveryEnd(dr))}
+
+	if len(ttr.Recoveries) > 0 {
+		var total time.Duration
+		for _, r := range ttr.Recoveries {
+			total += r.Duration
+		}
+		ttr.Mean = total / time.Duration(len(ttr.Recoveries))
+	}
+
+	return ttr
+}
+
+// recoveryEnd returns when unresolved failures in the date range stop being
+// measured, which is the end of the range unless it is unset or in the future
+func recoveryEnd(dr DateRange) time.Time {
+	now := time.Now()
+	if dr.End.IsZero() || dr.End.After(now) {
+		return now
+	}
+	return dr.End
+}
+
+// findRecoveries walks each project's deployments in the order they finished and
+// returns the interval from the first failure in a run of failures to the next success.
+// Unresolved failures are measured up to end.
+func findRecoveries(d []*Deployment, dr DateRange, end time.Time) []*Recovery {
+
+	r := []*Recovery{}
+
+	for _, pd := range byProject(inRange(d, dr)) {
+
+		var open *Recovery
+
+		for _, dep := range pd {
+			if dep.Status == "failed" && open == nil {
+				open = &Recovery{
+					ProjectID:          dep.ProjectID,
+					ProjectName:        dep.ProjectName,
+					FailedDeploymentID: dep.ID,
+					FailedAt:           *dep.FinishedAt,
+				}
+			}
+
+			if dep.Status == "success" && open != nil {
+				open.RecoveredDeploymentID = dep.ID
+				open.RecoveredAt = dep.FinishedAt
+				open.Duration = dep.FinishedAt.Sub(open.FailedAt)
+				open.Resolved = true
+				r = append(r, open)
+				open = nil
+			}
+		}
+
+		if open != nil {
+			open.Duration = end.Sub(open.FailedAt)
+			r = append(r, open)
+		}
+	}
+
+	return r
+}
+
+// byProject groups deployments by project, each group sorted by finish time.
+// Groups are returned in order of project ID so results are stable.
+func byProject(d []*Deployment) [][]*Deployment {
+
+	groups := map[int][]*Deployment{}
+	ids := []int{}
+
+	for _, dep := range d {
+		if _, ok := groups[dep.ProjectID]; !ok {
+			ids = append(ids, dep.ProjectID)
+		}
+		groups[dep.ProjectID] = append(groups[dep.ProjectID], dep)
+	}
+
+	sort.Ints(ids)
+
+	p := [][]*Deployment{}
+	for _, id := range ids {
+		g := groups[id]
+		sort.SliceStable(g, func(i, j int) bool {
+			return g[i].FinishedAt.Before(*g[j].FinishedAt)
+		})
+		p = append(p, g)
+	}
+
+	return p
+}
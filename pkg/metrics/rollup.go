@@ -0,0 +1,222 @@
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// Summary represents the DORA metrics for a project or namespace in a form
+// which can be combined with other summaries. LeadTimeSamples is the number of
+// lead times in MeanLeadTime, as counted by ChangeLeadTime.
+type Summary struct {
+	Deployments         int
+	Failures            int
+	DeploymentsPerDay   float64
+	ChangeFailureRate   float64
+	Recoveries          int
+	MeanTimeToRecover   time.Duration
+	LeadTimeDeployments int
+	LeadTimeSamples     int
+	MeanLeadTime        time.Duration
+}
+
+// ProjectRollup represents the metrics for a single project in a namespace rollup
+type ProjectRollup struct {
+	ProjectID   int
+	ProjectName string
+	Namespace   string
+	Summary     *Summary
+}
+
+// Rollup represents the metrics for a namespace, including all of its subgroups,
+// along with the subgroups and projects it contains for drill down
+type Rollup struct {
+	Path     string
+	Name     string
+	Summary  *Summary
+	Groups   []*Rollup
+	Projects []*ProjectRollup
+}
+
+// NamespaceRollup calculates the metrics for every level of the namespace tree
+// and returns the node at path. An empty path returns a root node containing
+// all top level groups. It returns nil if the path does not exist.
+func (s *Service) NamespaceRollup(dr DateRange, path string, m FailureMode) (*Rollup, error) {
+
+	p, err := s.r.GetProjects()
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := s.r.GetDeployments(Filter{DateRange: dr})
+	if err != nil {
+		return nil, err
+	}
+
+	return buildRollup(p, d, dr, m).find(path), nil
+}
+
+func buildRollup(p []*Project, d []*Deployment, dr DateRange, m FailureMode) *Rollup {
+
+	deployments := map[int][]*Deployment{}
+	for _, dep := range d {
+		deployments[dep.ProjectID] = append(deployments[dep.ProjectID], dep)
+	}
+
+	// projects which only appear in deployments are still included
+	known := map[int]bool{}
+	for _, proj := range p {
+		known[proj.ID] = true
+	}
+	for _, pd := range byProject(d) {
+		if !known[pd[0].ProjectID] {
+			p = append(p, &Project{
+				ID:        pd[0].ProjectID,
+				Name:      pd[0].ProjectName,
+				Namespace: pd[0].ProjectNamespace,
+			})
+		}
+	}
+
+	root := &Rollup{Summary: &Summary{}}
+
+	for _, proj := range p {
+		pr := &ProjectRollup{
+			ProjectID:   proj.ID,
+			ProjectName: proj.Name,
+			Namespace:   proj.Namespace,
+			Summary:     summarise(deployments[proj.ID], dr, m),
+		}
+
+		// add the project to every namespace on its path, projects without a
+		// namespace belong to the root
+		node := root
+		node.Summary.add(pr.Summary)
+		if proj.Namespace != "" {
+			for _, name := range strings.Split(proj.Namespace, "/") {
+				node = node.child(name)
+				node.Summary.add(pr.Summary)
+			}
+		}
+		node.Projects = append(node.Projects, pr)
+	}
+
+	root.sort()
+
+	return root
+}
+
+// Summarise calculates the metrics matching the filter as a single summary
+func (s *Service) Summarise(f Filter, m FailureMode) (*Summary, error) {
+
+	d, err := s.r.GetDeployments(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return summarise(d, f.DateRange, m), nil
+}
+
+func summarise(d []*Deployment, dr DateRange, m FailureMode) *Summary {
+
+	fr := calculateFailureRate(d, dr, m)
+	ttr := calculateTimeToRecover(d, dr)
+	lt := calculateLeadTime(d, dr)
+
+	return &Summary{
+		Deployments:         fr.Deployments,
+		Failures:            fr.Failures,
+		DeploymentsPerDay:   calculateFrequency(d, dr).PerDay,
+		ChangeFailureRate:   fr.Rate,
+		Recoveries:          len(ttr.Recoveries),
+		MeanTimeToRecover:   ttr.Mean,
+		LeadTimeDeployments: len(lt.Deployments),
+		LeadTimeSamples:     lt.Samples,
+		MeanLeadTime:        lt.Mean,
+	}
+}
+
+// add combines another summary into this one. Counts and frequencies are summed,
+// rates and means are weighted by the number of deployments, recoveries or lead
+// time samples they were calculated from.
+func (s *Summary) add(o *Summary) {
+
+	deployments := s.Deployments + o.Deployments
+	recoveries := s.Recoveries + o.Recoveries
+	leadTimes := s.LeadTimeSamples + o.LeadTimeSamples
+
+	if deployments > 0 {
+		s.ChangeFailureRate = (s.ChangeFailureRate*float64(s.Deployments) +
+			o.ChangeFailureRate*float64(o.Deployments)) / float64(deployments)
+	}
+
+	if recoveries > 0 {
+		s.MeanTimeToRecover = (s.MeanTimeToRecover*time.Duration(s.Recoveries) +
+			o.MeanTimeToRecover*time.Duration(o.Recoveries)) / time.Duration(recoveries)
+	}
+
+	if leadTimes > 0 {
+		s.MeanLeadTime = (s.MeanLeadTime*time.Duration(s.LeadTimeSamples) +
+			o.MeanLeadTime*time.Duration(o.LeadTimeSamples)) / time.Duration(leadTimes)
+	}
+
+	s.Deployments = deployments
+	s.Failures += o.Failures
+	s.DeploymentsPerDay += o.DeploymentsPerDay
+	s.Recoveries = recoveries
+	s.LeadTimeDeployments += o.LeadTimeDeployments
+	s.LeadTimeSamples = leadTimes
+}
+
+// child returns the subgroup with the given name, creating it if required
+func (r *Rollup) child(name string) *Rollup {
+
+	for _, g := range r.Groups {
+		if g.Name == name {
+			return g
+		}
+	}
+
+	path := name
+	if r.Path != "" {
+		path = r.Path + "/" + name
+	}
+
+	g := &Rollup{Path: path, Name: name, Summary: &Summary{}}
+	r.Groups = append(r.Groups, g)
+
+	return g
+}
+
+// find returns the node in the tree with the given path
+func (r *Rollup) find(path string) *Rollup {
+
+	if r.Path == path {
+		return r
+	}
+
+	for _, g := range r.Groups {
+		if g.Path == path || strings.HasPrefix(path, g.Path+"/") {
+			return g.find(path)
+		}
+	}
+
+	return nil
+}
+
+// sort orders subgroups by name and projects by ID throughout the tree
+func (r *Rollup) sort() {
+
+	sort.Slice(r.Groups, func(i, j int) bool {
+		return r.Groups[i].Name < r.Groups[j].Name
+	})
+
+	sort.Slice(r.Projects, func(i, j int) bool {
+		return r.Projects[i].ProjectID < r.Projects[j].ProjectID
+	})
+
+	for _, g := range r.Groups {
+		g.sort()
+	}
+}
@@ -0,0 +1,154 @@
+package metrics
+
+import (
+	"fmt"
+	"time"
+)
+
+// Interval is the size of the buckets in a time series
+type Interval int
+
+const (
+	// Day buckets start at midnight UTC
+	Day Interval = iota
+	// Week buckets start on Monday
+	Week
+	// Month buckets start on the first day of the month
+	Month
+)
+
+// ParseInterval converts a configuration value into an Interval
+func ParseInterval(s string) (Interval, error) {
+	switch s {
+	case "day":
+		return Day, nil
+	case "week":
+		return Week, nil
+	case "month":
+		return Month, nil
+	}
+	return Day, fmt.Errorf("unknown interval %q", s)
+}
+
+// String returns the configuration value for the Interval
+func (i Interval) String() string {
+	switch i {
+	case Week:
+		return "week"
+	case Month:
+		return "month"
+	}
+	return "day"
+}
+
+// Bucket represents all four DORA metrics for one interval of a time series
+type Bucket struct {
+	Start             time.Time
+	End               time.Time
+	Deployments       int
+	Failures          int
+	ChangeFailureRate float64
+	MeanTimeToRecover time.Duration
+	Recoveries        []*Recovery
+	MeanLeadTime      time.Duration
+	LeadTimes         []*DeploymentLeadTime
+}
+
+// TimeSeries calculates the DORA metrics for the filter, bucketed by interval.
+// Recoveries are placed in the bucket the failure happened in, and lead times
+// in the bucket the deployment finished in.
+func (s *Service) TimeSeries(f Filter, i Interval, m FailureMode) ([]*Bucket, error) {
+
+	d, err := s.r.GetDeployments(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return calculateTimeSeries(d, f.DateRange, i, m), nil
+}
+
+func calculateTimeSeries(d []*Deployment, dr DateRange, i Interval, m FailureMode) []*Bucket {
+
+	d = inRange(d, dr)
+
+	start, end := span(d, dr)
+
+	b := []*Bucket{}
+	if start.IsZero() || end.IsZero() {
+		return b
+	}
+
+	recoveries := findRecoveries(d, dr, recoveryEnd(dr))
+
+	// assign each deployment and recovery to its bucket in a single pass, rather
+	// than scanning all of them for every bucket
+	deployments := map[int64][]*Deployment{}
+	for _, dep := range d {
+		k := i.truncate(*dep.FinishedAt).Unix()
+		deployments[k] = append(deployments[k], dep)
+	}
+
+	failed := map[int64][]*Recovery{}
+	for _, r := range recoveries {
+		k := i.truncate(r.FailedAt).Unix()
+		failed[k] = append(failed[k], r)
+	}
+
+	for bs := i.truncate(start); !bs.After(end); bs = i.next(bs) {
+
+		// buckets are half open so a deployment on a boundary is only counted once
+		br := DateRange{Start: bs, End: i.next(bs).Add(-time.Nanosecond)}
+
+		bd := deployments[bs.Unix()]
+		fr := calculateFailureRate(bd, br, m)
+		lt := calculateLeadTime(bd, br)
+
+		bucket := &Bucket{
+			Start:             br.Start,
+			End:               br.End,
+			Deployments:       fr.Deployments,
+			Failures:          fr.Failures,
+			ChangeFailureRate: fr.Rate,
+			Recoveries:        []*Recovery{},
+			MeanLeadTime:      lt.Mean,
+			LeadTimes:         lt.Deployments,
+		}
+
+		var total time.Duration
+		for _, r := range failed[bs.Unix()] {
+			bucket.Recoveries = append(bucket.Recoveries, r)
+			total += r.Duration
+		}
+		if len(bucket.Recoveries) > 0 {
+			bucket.MeanTimeToRecover = total / time.Duration(len(bucket.Recoveries))
+		}
+
+		b = append(b, bucket)
+	}
+
+	return b
+}
+
+// truncate returns the start of the bucket containing t
+func (i Interval) truncate(t time.Time) time.Time {
+	t = t.UTC()
+	d := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch i {
+	case Week:
+		return d.AddDate(0, 0, -(int(d.Weekday())+6)%7)
+	case Month:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return d
+}
+
+// next returns the start of the bucket after the one starting at t
+func (i Interval) next(t time.Time) time.Time {
+	switch i {
+	case Week:
+		return t.AddDate(0, 0, 7)
+	case Month:
+		return t.AddDate(0, 1, 0)
+	}
+	return t.AddDate(0, 0, 1)
+}
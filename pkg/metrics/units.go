@@ -0,0 +1,18 @@
+package metrics
+
+import (
+	"math"
+	"time"
+)
+
+// Seconds converts a duration into the whole seconds the APIs report
+// durations in
+func Seconds(d time.Duration) int {
+	return int(math.Round(d.Seconds()))
+}
+
+// Percent converts a fraction, such as a change failure rate, into the whole
+// percentage the APIs report rates as
+func Percent(rate float64) int {
+	return int(math.Round(rate * 100))
+}
@@ -0,0 +1,89 @@
+package metrics
+
+import "sort"
+
+// visibleRepository only returns the projects, and their deployments, with
+// one of a set of GitLab project IDs
+type visibleRepository struct {
+	r   Repository
+	ids map[int]bool
+}
+
+// Visible returns a service which only calculates metrics from the projects
+// with the GitLab project IDs
+func (s *Service) Visible(ids []int) *Service {
+
+	v := &visibleRepository{r: s.r, ids: map[int]bool{}}
+	for _, id := range ids {
+		v.ids[id] = true
+	}
+
+	return NewService(v)
+}
+
+func (v *visibleRepository) GetDeployments(f Filter) ([]*Deployment, error) {
+
+	// an empty list of project IDs matches every project, so the visible
+	// projects are always listed explicitly
+	if len(f.ProjectIDs) > 0 {
+		f.ProjectIDs = v.visibleIDs(f.ProjectIDs)
+	} else {
+		for id := range v.ids {
+			f.ProjectIDs = append(f.ProjectIDs, id)
+		}
+		sort.Ints(f.ProjectIDs)
+	}
+
+	if len(f.ProjectIDs) == 0 {
+		return []*Deployment{}, nil
+	}
+
+	return v.r.GetDeployments(f)
+}
+
+func (v *visibleRepository) GetProjects() ([]*Project, error) {
+
+	p, err := v.r.GetProjects()
+	if err != nil {
+		return nil, err
+	}
+
+	visible := []*Project{}
+	for _, proj := range p {
+		if v.ids[proj.ID] {
+			visible = append(visible, proj)
+		}
+	}
+
+	return visible, nil
+}
+
+// PublicProject returns the stored project with the namespace and path if it
+// is public in GitLab, or nil if there is no such public project
+func (s *Service) PublicProject(namespace, path string) (*Project, error) {
+
+	p, err := s.r.GetProjects()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, proj := range p {
+		if proj.Namespace == namespace && proj.Path == path && proj.Visibility == "public" {
+			return proj, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (v *visibleRepository) visibleIDs(ids []int) []int {
+
+	visible := []int{}
+	for _, id := range ids {
+		if v.ids[id] {
+			visible = append(visible, id)
+		}
+	}
+
+	return visible
+}
@@ -1,41 +1,336 @@
 package metrix
 
 import (
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
+	"golang.org/x/oauth2"
 
+	"github.com/sk000f/metrix/pkg/access"
 	"github.com/sk000f/metrix/pkg/collector"
 	"github.com/sk000f/metrix/pkg/collector/gitlab"
+	"github.com/sk000f/metrix/pkg/events"
+	"github.com/sk000f/metrix/pkg/exporting"
+	"github.com/sk000f/metrix/pkg/http/auth"
+	"github.com/sk000f/metrix/pkg/http/badge"
+	"github.com/sk000f/metrix/pkg/http/dashboard"
+	"github.com/sk000f/metrix/pkg/http/export"
+	"github.com/sk000f/metrix/pkg/http/graphql"
+	"github.com/sk000f/metrix/pkg/http/health"
+	"github.com/sk000f/metrix/pkg/http/login"
+	"github.com/sk000f/metrix/pkg/http/prometheus"
+	"github.com/sk000f/metrix/pkg/http/rest"
+	"github.com/sk000f/metrix/pkg/listing"
+	"github.com/sk000f/metrix/pkg/metrics"
 	"github.com/sk000f/metrix/pkg/storage/mongo"
+	"github.com/sk000f/metrix/pkg/tokens"
 )
 
-// Start initialises and configures the application
-func Start() error {
+// defaultHTTPAddr is the address the HTTP server listens on when none is configured
+const defaultHTTPAddr = ":8080"
+
+// defaultRefreshInterval is how often every project is collected when no
+// interval is configured
+const defaultRefreshInterval = 15 * time.Minute
+
+// defaultGraphQLLimits restrict GraphQL queries when no limits are configured
+var defaultGraphQLLimits = graphql.Limits{
+	MaxDepth:      10,
+	MaxCost:       10000,
+	MaxResultSize: 10000,
+}
+
+// serveUsage describes the flags of the server
+const serveUsage = `usage:
+  [-full-resync]
+  tokens create|list|revoke`
+
+// Serve collects the latest CI data in the background and serves the HTTP API.
+// Only deployments updated since the last run are collected unless the
+// -full-resync flag is set.
+func Serve(args []string) error {
+
+	fs := flag.NewFlagSet("metrix", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fullResync := fs.Bool("full-resync", false, "collect every deployment, ignoring the sync cursors")
+
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%v\n%v", err, serveUsage)
+	}
 
 	cfg := SetupConfig()
 
-	gl := &gitlab.GitLab{
-		Token: cfg.GitLabToken,
-		URL:   cfg.GitLabURL,
+	r := newRepository(cfg)
+
+	// deployments saved by the collector are published to subscribers
+	b := events.NewBroker()
+	p := events.NewRepository(r, b)
+	c := newCollector(cfg, p)
+
+	if err := r.EnsureIndexes(); err != nil {
+		return err
 	}
 
-	r := new(mongo.DB)
-	r.ConnStr = cfg.DBConnString
+	interval, err := refreshInterval(cfg)
+	if err != nil {
+		return err
+	}
+
+	id, err := c.Start(collector.Options{FullResync: *fullResync})
+	if err != nil {
+		return err
+	}
 
-	c := collector.NewService(gl, r)
+	// the first run catches up with deployments saved before the restart, so
+	// they are not published to subscribers as if they had just landed
+	go func() {
+		c.Wait(id)
+		p.StartPublishing()
+	}()
 
-	err := c.RefreshData()
+	if interval > 0 {
+		c.Schedule(interval)
+	}
+
+	fm, err := metrics.ParseFailureMode(cfg.FailureMode)
 	if err != nil {
-		fmt.Printf("Error: %v", err.Error())
 		return err
 	}
 
+	l, err := graphQLLimits(cfg)
+	if err != nil {
+		return err
+	}
+
+	tk, err := tokenService(cfg, r)
+	if err != nil {
+		return err
+	}
+
+	oc, err := oauthConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	// users logged in with GitLab only see the projects they can access there
+	var a *access.Service
+	switch {
+	case oc != nil && tk == nil:
+		log.Printf("GitLab login is ignored while authentication is disabled")
+	case oc != nil:
+		a = access.NewService(newGitLab(cfg), r)
+	}
+
+	// thresholds are a DORA report year, or a JSON file of custom thresholds
+	t, err := metrics.LookupThresholds(cfg.ThresholdsVersion)
+	if err != nil {
+		return err
+	}
+
+	gql, err := graphql.NewServer(&graphql.Resolver{
+		Metrics:     metrics.NewService(r),
+		Listing:     listing.NewService(r),
+		FailureMode: fm,
+		Thresholds:  t,
+		Events:      b,
+		Collector:   c,
+		Tokens:      tk,
+	}, l)
+	if err != nil {
+		return err
+	}
+
+	if err := configureQueries(cfg, gql.Queries); err != nil {
+		return err
+	}
+
+	dash, err := dashboard.NewHandler(gql.Queries, a != nil)
+	if err != nil {
+		return err
+	}
+
+	// every route serving data needs a token with the read scope, unless
+	// authentication is disabled
+	read := func(h http.Handler) http.Handler {
+		if tk == nil {
+			return h
+		}
+		return auth.NewAuthenticator(tk, a).Require(tokens.ReadMetrics, h)
+	}
+
+	h := health.NewHandler(r, &health.CIServer{Name: "gitlab", URL: cfg.GitLabURL, Collector: c})
+
+	mux := http.NewServeMux()
+	mux.Handle("/", dash)
+	mux.Handle("/graphql", read(gql))
+	mux.Handle("/metrics", read(prometheus.NewHandler(metrics.NewService(r), fm, c)))
+	mux.Handle("/export/", read(export.NewHandler(exporting.NewService(r))))
+
+	// the OpenAPI document is public so tools can be generated from it
+	api := rest.NewHandler(metrics.NewService(r), listing.NewService(r), fm)
+	mux.Handle(rest.Prefix+"/", read(api))
+	mux.Handle(rest.Prefix+"/openapi.json", api)
+
+	// badges are embedded in public READMEs, so they are public rather than
+	// asking for a token which would also unlock the API
+	mux.Handle("/badge/", badge.NewHandler(metrics.NewService(r), fm, t))
+
+	mux.Handle("/healthz", h)
+	mux.Handle("/readyz", h)
+	mux.Handle("/status", read(h))
+
+	if a != nil {
+		lh := login.NewHandler(oc, a)
+		mux.Handle("/login", lh)
+		mux.Handle("/login/", lh)
+		mux.Handle("/logout", lh)
+	}
+
+	addr := cfg.HTTPAddr
+	if addr == "" {
+		addr = defaultHTTPAddr
+	}
+
+	log.Printf("Listening on %v", addr)
+
+	return http.ListenAndServe(addr, mux)
+}
+
+// graphQLLimits returns the configured GraphQL limits, using the default for
+// each limit which is not set
+func graphQLLimits(cfg *Config) (graphql.Limits, error) {
+
+	l := defaultGraphQLLimits
+
+	for _, v := range []struct {
+		name  string
+		value string
+		limit *int
+	}{
+		{"METRIX_GRAPHQL_MAX_DEPTH", cfg.GraphQLMaxDepth, &l.MaxDepth},
+		{"METRIX_GRAPHQL_MAX_COST", cfg.GraphQLMaxCost, &l.MaxCost},
+		{"METRIX_GRAPHQL_MAX_RESULT_SIZE", cfg.GraphQLMaxResultSize, &l.MaxResultSize},
+	} {
+		if v.value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v.value)
+		if err != nil || n < 0 {
+			return l, fmt.Errorf("invalid %v %q, expected a number of 0 or more", v.name, v.value)
+		}
+		*v.limit = n
+	}
+
+	return l, nil
+}
+
+// configureQueries registers the queries in the configured directory and
+// enables strict mode, where only registered queries may run
+func configureQueries(cfg *Config, q *graphql.PersistedQueries) error {
+
+	if cfg.GraphQLQueriesDir != "" {
+		if err := q.LoadDir(os.DirFS(cfg.GraphQLQueriesDir), "."); err != nil {
+			return err
+		}
+	}
+
+	if cfg.GraphQLStrictQueries != "" {
+		strict, err := strconv.ParseBool(cfg.GraphQLStrictQueries)
+		if err != nil {
+			return fmt.Errorf("invalid METRIX_GRAPHQL_STRICT_QUERIES %q, expected true or false", cfg.GraphQLStrictQueries)
+		}
+		q.Strict = strict
+	}
+
 	return nil
 }
 
+// refreshInterval returns how often every project is collected, or zero if
+// projects are only collected on startup and when a refresh is requested
+func refreshInterval(cfg *Config) (time.Duration, error) {
+
+	if cfg.RefreshInterval == "" {
+		return defaultRefreshInterval, nil
+	}
+
+	d, err := time.ParseDuration(cfg.RefreshInterval)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid METRIX_REFRESH_INTERVAL %q, expected a duration such as 15m, or 0", cfg.RefreshInterval)
+	}
+
+	return d, nil
+}
+
+// tokenService returns the service checking API tokens, or nil if
+// authentication is disabled
+func tokenService(cfg *Config, r tokens.Repository) (*tokens.Service, error) {
+
+	if cfg.AuthDisabled != "" {
+		disabled, err := strconv.ParseBool(cfg.AuthDisabled)
+		if err != nil {
+			return nil, fmt.Errorf("invalid METRIX_AUTH_DISABLED %q, expected true or false", cfg.AuthDisabled)
+		}
+		if disabled {
+			log.Printf("Authentication is disabled, the API is open to anyone who can reach it")
+			return nil, nil
+		}
+	}
+
+	return tokens.NewService(r), nil
+}
+
+// oauthConfig returns the GitLab OAuth application users log in with, or nil
+// if none is configured
+func oauthConfig(cfg *Config) (*oauth2.Config, error) {
+
+	if cfg.GitLabOAuthClientID == "" {
+		return nil, nil
+	}
+
+	if cfg.GitLabOAuthClientSecret == "" || cfg.GitLabOAuthRedirectURL == "" {
+		return nil, fmt.Errorf("METRIX_GITLAB_OAUTH_CLIENT_SECRET and METRIX_GITLAB_OAUTH_REDIRECT_URL must be set with METRIX_GITLAB_OAUTH_CLIENT_ID")
+	}
+
+	// the OAuth endpoints are on the server, not under its API
+	server := strings.TrimSuffix(strings.TrimSuffix(cfg.GitLabURL, "/"), "/api/v4")
+
+	return &oauth2.Config{
+		ClientID:     cfg.GitLabOAuthClientID,
+		ClientSecret: cfg.GitLabOAuthClientSecret,
+		RedirectURL:  cfg.GitLabOAuthRedirectURL,
+		Scopes:       []string{"read_api"},
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  server + "/oauth/authorize",
+			TokenURL: server + "/oauth/token",
+		},
+	}, nil
+}
+
+func newRepository(cfg *Config) *mongo.DB {
+	r := new(mongo.DB)
+	r.ConnStr = cfg.DBConnString
+	return r
+}
+
+func newCollector(cfg *Config, r collector.Repository) *collector.Service {
+	return collector.NewService(newGitLab(cfg), r)
+}
+
+func newGitLab(cfg *Config) *gitlab.GitLab {
+	return &gitlab.GitLab{
+		Token: cfg.GitLabToken,
+		URL:   cfg.GitLabURL,
+	}
+}
+
 // SetupConfig configures application based on environment variables
 func SetupConfig() *Config {
 
@@ -51,13 +346,39 @@ func SetupConfig() *Config {
 	cfg.GitLabURL = os.Getenv("METRIX_GITLAB_URL")
 	cfg.GitLabToken = os.Getenv("METRIX_GITLAB_TOKEN")
 	cfg.DBConnString = os.Getenv("METRIX_DB_CONN_STRING")
+	cfg.HTTPAddr = os.Getenv("METRIX_HTTP_ADDR")
+	cfg.FailureMode = os.Getenv("METRIX_FAILURE_MODE")
+	cfg.RefreshInterval = os.Getenv("METRIX_REFRESH_INTERVAL")
+	cfg.ThresholdsVersion = os.Getenv("METRIX_THRESHOLDS_VERSION")
+	cfg.GraphQLMaxDepth = os.Getenv("METRIX_GRAPHQL_MAX_DEPTH")
+	cfg.GraphQLMaxCost = os.Getenv("METRIX_GRAPHQL_MAX_COST")
+	cfg.GraphQLMaxResultSize = os.Getenv("METRIX_GRAPHQL_MAX_RESULT_SIZE")
+	cfg.GraphQLQueriesDir = os.Getenv("METRIX_GRAPHQL_QUERIES_DIR")
+	cfg.GraphQLStrictQueries = os.Getenv("METRIX_GRAPHQL_STRICT_QUERIES")
+	cfg.AuthDisabled = os.Getenv("METRIX_AUTH_DISABLED")
+	cfg.GitLabOAuthClientID = os.Getenv("METRIX_GITLAB_OAUTH_CLIENT_ID")
+	cfg.GitLabOAuthClientSecret = os.Getenv("METRIX_GITLAB_OAUTH_CLIENT_SECRET")
+	cfg.GitLabOAuthRedirectURL = os.Getenv("METRIX_GITLAB_OAUTH_REDIRECT_URL")
 
 	return cfg
 }
 
 // Config stores configuration values
 type Config struct {
-	GitLabURL    string
-	GitLabToken  string
-	DBConnString string
+	GitLabURL               string
+	GitLabToken             string
+	DBConnString            string
+	HTTPAddr                string
+	FailureMode             string
+	RefreshInterval         string
+	ThresholdsVersion       string
+	GraphQLMaxDepth         string
+	GraphQLMaxCost          string
+	GraphQLMaxResultSize    string
+	GraphQLQueriesDir       string
+	GraphQLStrictQueries    string
+	AuthDisabled            string
+	GitLabOAuthClientID     string
+	GitLabOAuthClientSecret string
+	GitLabOAuthRedirectURL  string
 }
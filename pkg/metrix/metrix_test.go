@@ -1,12 +1,16 @@
 package metrix_test
 
 import (
+	"bytes"
 	"os"
 	"reflect"
+	"regexp"
+	"strings"
 	"testing"
 
 	"github.com/sk000f/metrix/pkg/collector"
 	"github.com/sk000f/metrix/pkg/metrix"
+	"github.com/sk000f/metrix/pkg/tokens"
 )
 
 func TestMetrix(t *testing.T) {
@@ -27,36 +31,117 @@ func TestMetrix(t *testing.T) {
 		os.Unsetenv("METRIX_GITLAB_URL")
 		os.Unsetenv("METRIX_GITLAB_TOKEN")
 	})
+}
 
-	t.Run("application starts and executes correctly", func(t *testing.T) {
+func TestTokenCommand(t *testing.T) {
 
-		os.Setenv("METRIX_ENV", "dev")
-		os.Setenv("METRIX_GITLAB_URL", "https://example.com")
-		os.Setenv("METRIX_GITLAB_TOKEN", "1234567890")
+	t.Run("create, list and revoke tokens", func(t *testing.T) {
+
+		s := tokens.NewService(new(mockTokenRepo))
 
-		err := metrix.Start()
+		var out bytes.Buffer
+		if err := metrix.TokenCommand(s, []string{"create", "-name", "ci", "-scopes", "read,refresh", "-expires", "720h"}, &out); err != nil {
+			t.Fatal(err)
+		}
 
+		secret := regexp.MustCompile(`mtx_[0-9a-f]+`).FindString(out.String())
+		tok, err := s.Authenticate(secret)
 		if err != nil {
-			t.Errorf("Unexpected error thrown: %v", err.Error())
+			t.Fatalf("got %v from output %v; wanted a valid token", err, out.String())
+		}
+		if tok.Name != "ci" || !tok.Allows(tokens.TriggerRefresh) || tok.Allows(tokens.Admin) || tok.ExpiresAt == nil {
+			t.Errorf("got %+v; wanted an expiring read and refresh token", tok)
 		}
 
-		os.Unsetenv("METRIX_ENV")
-		os.Unsetenv("METRIX_GITLAB_URL")
-		os.Unsetenv("METRIX_GITLAB_TOKEN")
+		out.Reset()
+		if err := metrix.TokenCommand(s, []string{"list"}, &out); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out.String(), tok.ID) || !strings.Contains(out.String(), "read,refresh") {
+			t.Errorf("got %v; wanted the token listed", out.String())
+		}
+
+		out.Reset()
+		if err := metrix.TokenCommand(s, []string{"revoke", tok.ID}, &out); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Authenticate(secret); err != tokens.ErrInvalidToken {
+			t.Errorf("got %v; wanted %v", err, tokens.ErrInvalidToken)
+		}
+	})
+
+	t.Run("reject invalid commands", func(t *testing.T) {
+
+		s := tokens.NewService(new(mockTokenRepo))
+
+		for _, args := range [][]string{
+			{},
+			{"rotate"},
+			{"create", "-scopes", "read"},
+			{"create", "-name", "ci", "-scopes", "write"},
+			{"revoke"},
+			{"revoke", "unknown"},
+		} {
+			if err := metrix.TokenCommand(s, args, new(bytes.Buffer)); err == nil {
+				t.Errorf("got no error for %v; wanted an error", args)
+			}
+		}
 	})
 }
 
+type mockTokenRepo struct {
+	tokens []*tokens.Token
+}
+
+func (m *mockTokenRepo) SaveToken(t *tokens.Token) error {
+	m.tokens = append(m.tokens, t)
+	return nil
+}
+
+func (m *mockTokenRepo) GetTokenByHash(hash string) (*tokens.Token, error) {
+	for _, t := range m.tokens {
+		if t.Hash == hash {
+			return t, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *mockTokenRepo) ListTokens() ([]*tokens.Token, error) {
+	return m.tokens, nil
+}
+
+func (m *mockTokenRepo) DeleteToken(id string) (bool, error) {
+	for i, t := range m.tokens {
+		if t.ID == id {
+			m.tokens = append(m.tokens[:i], m.tokens[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 type mockRepo struct {
 	ProjectData    []*collector.Project
 	DeploymentData []*collector.Deployment
 }
 
-func (m *mockRepo) SaveProjects(p []*collector.Project) {
+func (m *mockRepo) SaveProjects(p []*collector.Project) error {
 	for _, proj := range p {
 		m.ProjectData = append(m.ProjectData, proj)
 	}
+	return nil
 }
 
-func (m *mockRepo) SaveDeployment(d *collector.Deployment) {
+func (m *mockRepo) SaveDeployment(d *collector.Deployment) error {
 	m.DeploymentData = append(m.DeploymentData, d)
+	return nil
+}
+
+func (m *mockRepo) GetSyncCursor(projectID int) (*collector.SyncCursor, error) {
+	return nil, nil
+}
+
+func (m *mockRepo) SaveSyncCursor(c *collector.SyncCursor) error {
+	return nil
 }
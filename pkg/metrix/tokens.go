@@ -0,0 +1,126 @@
+package metrix
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sk000f/metrix/pkg/tokens"
+)
+
+// tokensUsage describes the tokens subcommand
+const tokensUsage = `usage:
+  tokens create -name NAME -scopes read,refresh,admin [-expires DURATION]
+  tokens list
+  tokens revoke ID`
+
+// Tokens manages the API tokens stored in the configured database
+func Tokens(args []string) error {
+
+	cfg := SetupConfig()
+
+	r := newRepository(cfg)
+	if err := r.EnsureIndexes(); err != nil {
+		return err
+	}
+
+	return TokenCommand(tokens.NewService(r), args, os.Stdout)
+}
+
+// TokenCommand runs a tokens subcommand with the service, writing its output to out
+func TokenCommand(s *tokens.Service, args []string, out io.Writer) error {
+
+	if len(args) == 0 {
+		return errors.New(tokensUsage)
+	}
+
+	switch args[0] {
+	case "create":
+		return createToken(s, args[1:], out)
+	case "list":
+		return listTokens(s, out)
+	case "revoke":
+		if len(args) != 2 {
+			return errors.New(tokensUsage)
+		}
+		ok, err := s.Revoke(args[1])
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("no token with ID %v", args[1])
+		}
+		fmt.Fprintf(out, "Revoked token %v\n", args[1])
+		return nil
+	}
+
+	return errors.New(tokensUsage)
+}
+
+func createToken(s *tokens.Service, args []string, out io.Writer) error {
+
+	fs := flag.NewFlagSet("tokens create", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	name := fs.String("name", "", "name describing who uses the token")
+	scopeList := fs.String("scopes", string(tokens.ReadMetrics), "comma separated scopes")
+	expires := fs.Duration("expires", 0, "how long until the token expires, or never if 0")
+
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%v\n%v", err, tokensUsage)
+	}
+
+	scopes := []tokens.Scope{}
+	for _, v := range strings.Split(*scopeList, ",") {
+		sc, err := tokens.ParseScope(strings.TrimSpace(v))
+		if err != nil {
+			return err
+		}
+		scopes = append(scopes, sc)
+	}
+
+	var expiresAt *time.Time
+	if *expires > 0 {
+		t := time.Now().Add(*expires).UTC()
+		expiresAt = &t
+	}
+
+	secret, t, err := s.Create(*name, scopes, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Created token %v (%v). Store it now, it cannot be shown again:\n%v\n", t.ID, t.Name, secret)
+	return nil
+}
+
+func listTokens(s *tokens.Service, out io.Writer) error {
+
+	t, err := s.Tokens()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tSCOPES\tCREATED\tEXPIRES")
+
+	for _, tok := range t {
+		scopes := []string{}
+		for _, sc := range tok.Scopes {
+			scopes = append(scopes, string(sc))
+		}
+
+		expires := "never"
+		if tok.ExpiresAt != nil {
+			expires = tok.ExpiresAt.Format(time.RFC3339)
+		}
+
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", tok.ID, tok.Name, strings.Join(scopes, ","), tok.CreatedAt.Format(time.RFC3339), expires)
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,42 @@
+package mongo
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// PublicProjectIDs returns the IDs of the stored public and internal projects,
+// which every logged in user can see
+func (m *DB) PublicProjectIDs() ([]int, error) {
+
+	c, err := m.GetMongoClient()
+	if err != nil {
+		return nil, err
+	}
+
+	collection := c.Database("metrix").Collection("projects")
+
+	filter := bson.M{"visibility": bson.M{"$in": []string{"public", "internal"}}}
+	findOpts := options.Find().SetProjection(bson.M{"project_id": 1})
+
+	cur, err := collection.Find(context.TODO(), filter, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(context.TODO())
+
+	ids := []int{}
+
+	for cur.Next(context.TODO()) {
+		var mP Project
+		if err := cur.Decode(&mP); err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, mP.ProjectID)
+	}
+
+	return ids, cur.Err()
+}
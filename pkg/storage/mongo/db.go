@@ -2,7 +2,7 @@ package mongo
 
 import (
 	"context"
-	"log"
+	"fmt"
 	"sync"
 	"time"
 
@@ -10,6 +10,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 
 	"github.com/sk000f/metrix/pkg/collector"
 )
@@ -24,7 +25,7 @@ type DB struct {
 }
 
 // SaveProjects saves Projects into the MongoDB database
-func (m *DB) SaveProjects(p []*collector.Project) {
+func (m *DB) SaveProjects(p []*collector.Project) error {
 	for _, proj := range p {
 		mP := Project{
 			ProjectID:         proj.ID,
@@ -33,14 +34,18 @@ func (m *DB) SaveProjects(p []*collector.Project) {
 			PathWithNamespace: proj.PathWithNamespace,
 			Namespace:         proj.Namespace,
 			WebURL:            proj.WebURL,
+			Visibility:        proj.Visibility,
 		}
 
-		m.UpdateProject(mP)
+		if err := m.UpdateProject(mP); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
 // SaveDeployment saves a Deployment into the MongoDB database
-func (m *DB) SaveDeployment(d *collector.Deployment) {
+func (m *DB) SaveDeployment(d *collector.Deployment) error {
 	mD := Deployment{
 		DeploymentID:     d.ID,
 		Status:           d.Status,
@@ -50,10 +55,22 @@ func (m *DB) SaveDeployment(d *collector.Deployment) {
 		ProjectPath:      d.ProjectPath,
 		ProjectNamespace: d.ProjectNamespace,
 		PipelineID:       d.PipelineID,
+		SHA:              d.SHA,
 		FinishedAt:       d.FinishedAt,
 		Duration:         d.Duration,
 	}
-	m.UpdateDeployment(mD)
+	// deployments without commits keep the commits they were saved with
+	if d.Commits != nil {
+		mD.Commits = []Commit{}
+	}
+	for _, c := range d.Commits {
+		mD.Commits = append(mD.Commits, Commit{
+			SHA:         c.SHA,
+			Title:       c.Title,
+			CommittedAt: c.CommittedAt,
+		})
+	}
+	return m.UpdateDeployment(mD)
 }
 
 // Project represents metrix view of a project object
@@ -65,6 +82,7 @@ type Project struct {
 	PathWithNamespace string             `bson:"path_with_namespace"`
 	Namespace         string             `bson:"namespace"`
 	WebURL            string             `bson:"web_url"`
+	Visibility        string             `bson:"visibility"`
 	GroupName         string             `bson:"group_name"`
 }
 
@@ -73,22 +91,31 @@ type Deployment struct {
 	ID               primitive.ObjectID `bson:"_id"`
 	DeploymentID     int                `bson:"deployment_id"`
 	Status           string             `bson:"status"`
-	EnvironmentName  string             `bson:"envrionment_name"`
+	EnvironmentName  string             `bson:"environment_name"`
 	ProjectID        int                `bson:"project_id"`
 	ProjectName      string             `bson:"project_name"`
 	ProjectPath      string             `bson:"project_path"`
 	ProjectNamespace string             `bson:"project_namespace"`
 	PipelineID       int                `bson:"pipeline_id"`
+	SHA              string             `bson:"sha"`
 	FinishedAt       *time.Time         `bson:"finished_at"`
 	Duration         float64            `bson:"duration"`
+	Commits          []Commit           `bson:"commits"`
+}
+
+// Commit represents metrix view of a commit introduced by a deployment
+type Commit struct {
+	SHA         string     `bson:"sha"`
+	Title       string     `bson:"title"`
+	CommittedAt *time.Time `bson:"committed_at"`
 }
 
 // UpdateProject adds or updates the specified project in the MongoDB database
-func (m *DB) UpdateProject(p Project) {
+func (m *DB) UpdateProject(p Project) error {
 
 	c, err := m.GetMongoClient()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	collection := c.Database("metrix").Collection("projects")
@@ -104,20 +131,22 @@ func (m *DB) UpdateProject(p Project) {
 			"path_with_namespace": p.PathWithNamespace,
 			"namespace":           p.Namespace,
 			"web_url":             p.WebURL,
+			"visibility":          p.Visibility,
 		},
 	}
 	_, err = collection.UpdateOne(context.TODO(), filter, update, updateOpts)
 	if err != nil {
-		log.Fatalf("Error updating Project: %v", err.Error())
+		return fmt.Errorf("updating project %v: %v", p.ProjectID, err)
 	}
+	return nil
 }
 
 // UpdateDeployment adds or updates the specified deployment in the MongoDB database
-func (m *DB) UpdateDeployment(d Deployment) {
+func (m *DB) UpdateDeployment(d Deployment) error {
 
 	c, err := m.GetMongoClient()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	collection := c.Database("metrix").Collection("deployments")
@@ -125,24 +154,28 @@ func (m *DB) UpdateDeployment(d Deployment) {
 	filter := bson.M{"deployment_id": d.DeploymentID}
 	updateOpts := options.Update().SetUpsert(true)
 
-	update := bson.M{
-		"$set": bson.M{
-			"deployment_id":     d.DeploymentID,
-			"status":            d.Status,
-			"environment_name":  d.EnvironmentName,
-			"project_id":        d.ProjectID,
-			"project_name":      d.ProjectName,
-			"project_path":      d.ProjectPath,
-			"project_namespace": d.ProjectNamespace,
-			"pipeline_id":       d.PipelineID,
-			"finished_at":       d.FinishedAt,
-			"duration":          d.Duration,
-		},
+	set := bson.M{
+		"deployment_id":     d.DeploymentID,
+		"status":            d.Status,
+		"environment_name":  d.EnvironmentName,
+		"project_id":        d.ProjectID,
+		"project_name":      d.ProjectName,
+		"project_path":      d.ProjectPath,
+		"project_namespace": d.ProjectNamespace,
+		"pipeline_id":       d.PipelineID,
+		"sha":               d.SHA,
+		"finished_at":       d.FinishedAt,
+		"duration":          d.Duration,
 	}
-	_, err = collection.UpdateOne(context.TODO(), filter, update, updateOpts)
+	if d.Commits != nil {
+		set["commits"] = d.Commits
+	}
+
+	_, err = collection.UpdateOne(context.TODO(), filter, bson.M{"$set": set}, updateOpts)
 	if err != nil {
-		log.Fatalf("Error updating Deployment: %v", err.Error())
+		return fmt.Errorf("updating deployment %v: %v", d.DeploymentID, err)
 	}
+	return nil
 }
 
 // GetMongoClient creates or returns existing MongoDB client
@@ -154,7 +187,6 @@ func (m *DB) GetMongoClient() (*mongo.Client, error) {
 
 		client, err := mongo.Connect(context.TODO(), clientOptions)
 		if err != nil {
-			log.Fatal(err)
 			clientInstanceError = err
 		}
 
@@ -163,3 +195,14 @@ func (m *DB) GetMongoClient() (*mongo.Client, error) {
 
 	return clientInstance, clientInstanceError
 }
+
+// Ping checks the MongoDB primary can be reached
+func (m *DB) Ping(ctx context.Context) error {
+
+	c, err := m.GetMongoClient()
+	if err != nil {
+		return err
+	}
+
+	return c.Ping(ctx, readpref.Primary())
+}
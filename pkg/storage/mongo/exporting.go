@@ -0,0 +1,112 @@
+package mongo
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/sk000f/metrix/pkg/exporting"
+)
+
+// exportBatchSize is the number of deployments fetched from the cursor at once
+const exportBatchSize = 1000
+
+// exportDeployment is a deployment with its project joined in and its commits counted
+type exportDeployment struct {
+	Deployment  `bson:",inline"`
+	CommitCount int      `bson:"commit_count"`
+	Project     *Project `bson:"project"`
+}
+
+// ExportDeployments calls fn for each deployment matching the export filter,
+// most recently finished first, decoding one deployment at a time from the cursor
+func (m *DB) ExportDeployments(f exporting.Filter, fn func(*exporting.Row) error) error {
+
+	c, err := m.GetMongoClient()
+	if err != nil {
+		return err
+	}
+
+	collection := c.Database("metrix").Collection("deployments")
+
+	filter := deploymentFilter(f.Start, f.End, f.ProjectName, f.GroupName)
+	if f.Status != "" {
+		filter["status"] = f.Status
+	}
+	if f.Environment != "" {
+		filter["environment_name"] = f.Environment
+	}
+	projectID := bson.M{}
+	if f.ProjectID != 0 {
+		projectID["$eq"] = f.ProjectID
+	}
+	if len(f.ProjectIDs) > 0 {
+		projectID["$in"] = f.ProjectIDs
+	}
+	if len(projectID) > 0 {
+		filter["project_id"] = projectID
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$sort", Value: bson.D{{Key: "finished_at", Value: -1}, {Key: "deployment_id", Value: -1}}}},
+		{{Key: "$addFields", Value: bson.M{"commit_count": bson.M{"$size": bson.M{"$ifNull": bson.A{"$commits", bson.A{}}}}}}},
+		{{Key: "$project", Value: bson.M{"commits": 0}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         "projects",
+			"localField":   "project_id",
+			"foreignField": "project_id",
+			"as":           "project",
+		}}},
+		{{Key: "$unwind", Value: bson.M{"path": "$project", "preserveNullAndEmptyArrays": true}}},
+	}
+
+	aggOpts := options.Aggregate().
+		SetAllowDiskUse(true).
+		SetBatchSize(exportBatchSize)
+
+	cur, err := collection.Aggregate(context.TODO(), pipeline, aggOpts)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(context.TODO())
+
+	for cur.Next(context.TODO()) {
+		var eD exportDeployment
+		if err := cur.Decode(&eD); err != nil {
+			return err
+		}
+		if err := fn(exportRow(eD)); err != nil {
+			return err
+		}
+	}
+
+	return cur.Err()
+}
+
+func exportRow(eD exportDeployment) *exporting.Row {
+
+	r := &exporting.Row{
+		DeploymentID:     eD.DeploymentID,
+		Status:           eD.Status,
+		EnvironmentName:  eD.EnvironmentName,
+		ProjectID:        eD.ProjectID,
+		ProjectName:      eD.ProjectName,
+		ProjectPath:      eD.ProjectPath,
+		ProjectNamespace: eD.ProjectNamespace,
+		PipelineID:       eD.PipelineID,
+		SHA:              eD.SHA,
+		FinishedAt:       eD.FinishedAt,
+		Duration:         eD.Duration,
+		Commits:          eD.CommitCount,
+	}
+
+	if eD.Project != nil {
+		r.ProjectPathWithNamespace = eD.Project.PathWithNamespace
+		r.ProjectWebURL = eD.Project.WebURL
+	}
+
+	return r
+}
@@ -0,0 +1,50 @@
+package mongo
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// indexes are the indexes required by each collection. Deployment listings are
+// sorted by finished_at and deployment_id, so every deployment index ends with them.
+var indexes = map[string][]mongo.IndexModel{
+	"projects": {
+		{Keys: bson.D{{Key: "project_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "namespace", Value: 1}, {Key: "project_id", Value: 1}}},
+	},
+	"deployments": {
+		{Keys: bson.D{{Key: "deployment_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "finished_at", Value: -1}, {Key: "deployment_id", Value: -1}}},
+		{Keys: bson.D{{Key: "project_id", Value: 1}, {Key: "finished_at", Value: -1}, {Key: "deployment_id", Value: -1}}},
+		{Keys: bson.D{{Key: "project_namespace", Value: 1}, {Key: "finished_at", Value: -1}, {Key: "deployment_id", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "finished_at", Value: -1}, {Key: "deployment_id", Value: -1}}},
+	},
+	"sync_cursors": {
+		{Keys: bson.D{{Key: "project_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+	},
+	"tokens": {
+		{Keys: bson.D{{Key: "hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "token_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+	},
+}
+
+// EnsureIndexes creates any missing indexes in the MongoDB database
+func (m *DB) EnsureIndexes() error {
+
+	c, err := m.GetMongoClient()
+	if err != nil {
+		return err
+	}
+
+	for name, models := range indexes {
+		_, err := c.Database("metrix").Collection(name).Indexes().CreateMany(context.TODO(), models)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
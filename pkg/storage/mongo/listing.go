@@ -0,0 +1,245 @@
+package mongo
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/sk000f/metrix/pkg/listing"
+)
+
+// ListProjects returns all stored projects
+func (m *DB) ListProjects() ([]*listing.Project, error) {
+
+	c, err := m.GetMongoClient()
+	if err != nil {
+		return nil, err
+	}
+
+	collection := c.Database("metrix").Collection("projects")
+
+	findOpts := options.Find().SetSort(bson.D{{Key: "project_id", Value: 1}})
+
+	cur, err := collection.Find(context.TODO(), bson.M{}, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(context.TODO())
+
+	p := []*listing.Project{}
+
+	for cur.Next(context.TODO()) {
+		var mP Project
+		if err := cur.Decode(&mP); err != nil {
+			return nil, err
+		}
+
+		p = append(p, listingProject(mP))
+	}
+
+	return p, cur.Err()
+}
+
+// ListProjectsPage returns up to limit projects in the group with an ID after afterID
+func (m *DB) ListProjectsPage(groupName string, afterID, limit int) ([]*listing.Project, error) {
+
+	c, err := m.GetMongoClient()
+	if err != nil {
+		return nil, err
+	}
+
+	collection := c.Database("metrix").Collection("projects")
+
+	filter := bson.M{"project_id": bson.M{"$gt": afterID}}
+	if groupName != "" {
+		filter["namespace"] = namespaceRegex(groupName)
+	}
+
+	findOpts := options.Find().
+		SetSort(bson.D{{Key: "project_id", Value: 1}}).
+		SetLimit(int64(limit))
+
+	cur, err := collection.Find(context.TODO(), filter, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(context.TODO())
+
+	p := []*listing.Project{}
+
+	for cur.Next(context.TODO()) {
+		var mP Project
+		if err := cur.Decode(&mP); err != nil {
+			return nil, err
+		}
+		p = append(p, listingProject(mP))
+	}
+
+	return p, cur.Err()
+}
+
+// ListProjectsByID returns the stored projects with any of the project IDs
+func (m *DB) ListProjectsByID(ids []int) ([]*listing.Project, error) {
+
+	c, err := m.GetMongoClient()
+	if err != nil {
+		return nil, err
+	}
+
+	collection := c.Database("metrix").Collection("projects")
+
+	filter := bson.M{"project_id": bson.M{"$in": ids}}
+
+	findOpts := options.Find().SetSort(bson.D{{Key: "project_id", Value: 1}})
+
+	cur, err := collection.Find(context.TODO(), filter, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(context.TODO())
+
+	p := []*listing.Project{}
+
+	for cur.Next(context.TODO()) {
+		var mP Project
+		if err := cur.Decode(&mP); err != nil {
+			return nil, err
+		}
+		p = append(p, listingProject(mP))
+	}
+
+	return p, cur.Err()
+}
+
+// ListDeployments returns up to limit deployments matching the listing filter,
+// most recently finished first, starting after the cursor. Deployments without
+// a finish time are only returned when listed by ID. A limit of 0 returns every
+// matching deployment, and the limit applies to each project when the filter
+// limits per project.
+func (m *DB) ListDeployments(f listing.Filter, after *listing.DeploymentCursor, limit int) ([]*listing.Deployment, error) {
+
+	c, err := m.GetMongoClient()
+	if err != nil {
+		return nil, err
+	}
+
+	collection := c.Database("metrix").Collection("deployments")
+
+	filter := deploymentFilter(f.Start, f.End, f.ProjectName, f.GroupName)
+	if f.Status != "" {
+		filter["status"] = f.Status
+	}
+	if f.Environment != "" {
+		filter["environment_name"] = f.Environment
+	}
+
+	projectID := bson.M{}
+	if f.ProjectID != 0 {
+		projectID["$eq"] = f.ProjectID
+	}
+	if len(f.ProjectIDs) > 0 {
+		projectID["$in"] = f.ProjectIDs
+	}
+	if len(projectID) > 0 {
+		filter["project_id"] = projectID
+	}
+
+	if len(f.DeploymentIDs) > 0 {
+		filter["deployment_id"] = bson.M{"$in": f.DeploymentIDs}
+	} else {
+		// deployments which have not finished have no place in the sort order,
+		// so they are left out of the listing rather than given a cursor
+		finishedAt, ok := filter["finished_at"].(bson.M)
+		if !ok {
+			finishedAt = bson.M{}
+		}
+		finishedAt["$ne"] = nil
+		filter["finished_at"] = finishedAt
+	}
+
+	// continue from the cursor using the same keys the results are sorted by
+	if after != nil {
+		filter["$or"] = bson.A{
+			bson.M{"finished_at": bson.M{"$lt": after.FinishedAt}},
+			bson.M{"finished_at": after.FinishedAt, "deployment_id": bson.M{"$lt": after.DeploymentID}},
+		}
+	}
+
+	sort := bson.D{{Key: "finished_at", Value: -1}, {Key: "deployment_id", Value: -1}}
+
+	var cur *mongo.Cursor
+	if f.LimitPerProject {
+		cur, err = collection.Aggregate(context.TODO(), perProjectPipeline(filter, sort, limit))
+	} else {
+		cur, err = collection.Find(context.TODO(), filter, options.Find().SetSort(sort).SetLimit(int64(limit)))
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(context.TODO())
+
+	d := []*listing.Deployment{}
+
+	for cur.Next(context.TODO()) {
+		var mD Deployment
+		if err := cur.Decode(&mD); err != nil {
+			return nil, err
+		}
+		d = append(d, listingDeployment(mD))
+	}
+
+	return d, cur.Err()
+}
+
+// perProjectPipeline lists the deployments matching the filter, keeping the
+// first limit of each project in the sort order
+func perProjectPipeline(filter bson.M, sort bson.D, limit int) mongo.Pipeline {
+
+	// deployments are pushed in the sort order, so each group starts with the
+	// first deployments of its project
+	deployments := interface{}("$deployments")
+	if limit > 0 {
+		deployments = bson.M{"$slice": bson.A{"$deployments", limit}}
+	}
+
+	return mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$sort", Value: sort}},
+		{{Key: "$group", Value: bson.M{"_id": "$project_id", "deployments": bson.M{"$push": "$$ROOT"}}}},
+		{{Key: "$project", Value: bson.M{"deployments": deployments}}},
+		{{Key: "$unwind", Value: "$deployments"}},
+		{{Key: "$replaceRoot", Value: bson.M{"newRoot": "$deployments"}}},
+		{{Key: "$sort", Value: sort}},
+	}
+}
+
+func listingProject(mP Project) *listing.Project {
+	return &listing.Project{
+		ID:                mP.ID.Hex(),
+		ProjectID:         mP.ProjectID,
+		Name:              mP.Name,
+		Path:              mP.Path,
+		PathWithNamespace: mP.PathWithNamespace,
+		Namespace:         mP.Namespace,
+		WebURL:            mP.WebURL,
+	}
+}
+
+func listingDeployment(mD Deployment) *listing.Deployment {
+	return &listing.Deployment{
+		ID:               mD.ID.Hex(),
+		DeploymentID:     mD.DeploymentID,
+		Status:           mD.Status,
+		EnvironmentName:  mD.EnvironmentName,
+		ProjectID:        mD.ProjectID,
+		ProjectName:      mD.ProjectName,
+		ProjectPath:      mD.ProjectPath,
+		ProjectNamespace: mD.ProjectNamespace,
+		PipelineID:       mD.PipelineID,
+		SHA:              mD.SHA,
+		FinishedAt:       mD.FinishedAt,
+		Duration:         mD.Duration,
+	}
+}
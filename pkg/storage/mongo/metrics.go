@@ -0,0 +1,158 @@
+package mongo
+
+import (
+	"context"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/sk000f/metrix/pkg/metrics"
+)
+
+// GetDeployments returns stored deployments matching the metrics filter
+func (m *DB) GetDeployments(f metrics.Filter) ([]*metrics.Deployment, error) {
+
+	c, err := m.GetMongoClient()
+	if err != nil {
+		return nil, err
+	}
+
+	collection := c.Database("metrix").Collection("deployments")
+
+	findOpts := options.Find().SetSort(bson.D{{Key: "finished_at", Value: 1}})
+
+	filter := deploymentFilter(f.DateRange.Start, f.DateRange.End, f.ProjectName, f.GroupName)
+	if f.Namespace != "" {
+		filter["project_namespace"] = f.Namespace
+	}
+	if f.ProjectPath != "" {
+		filter["project_path"] = f.ProjectPath
+	}
+	if len(f.ProjectIDs) > 0 {
+		filter["project_id"] = bson.M{"$in": f.ProjectIDs}
+	}
+
+	cur, err := collection.Find(context.TODO(), filter, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(context.TODO())
+
+	d := []*metrics.Deployment{}
+
+	for cur.Next(context.TODO()) {
+		var mD Deployment
+		if err := cur.Decode(&mD); err != nil {
+			return nil, err
+		}
+
+		d = append(d, &metrics.Deployment{
+			ID:               mD.DeploymentID,
+			Status:           mD.Status,
+			EnvironmentName:  mD.EnvironmentName,
+			ProjectID:        mD.ProjectID,
+			ProjectName:      mD.ProjectName,
+			ProjectPath:      mD.ProjectPath,
+			ProjectNamespace: mD.ProjectNamespace,
+			PipelineID:       mD.PipelineID,
+			SHA:              mD.SHA,
+			FinishedAt:       mD.FinishedAt,
+			Duration:         mD.Duration,
+			Commits:          commits(mD.Commits),
+		})
+	}
+
+	return d, cur.Err()
+}
+
+// GetProjects returns all stored projects
+func (m *DB) GetProjects() ([]*metrics.Project, error) {
+
+	c, err := m.GetMongoClient()
+	if err != nil {
+		return nil, err
+	}
+
+	collection := c.Database("metrix").Collection("projects")
+
+	findOpts := options.Find().SetSort(bson.D{{Key: "project_id", Value: 1}})
+
+	cur, err := collection.Find(context.TODO(), bson.M{}, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(context.TODO())
+
+	p := []*metrics.Project{}
+
+	for cur.Next(context.TODO()) {
+		var mP Project
+		if err := cur.Decode(&mP); err != nil {
+			return nil, err
+		}
+
+		p = append(p, &metrics.Project{
+			ID:         mP.ProjectID,
+			Name:       mP.Name,
+			Path:       mP.Path,
+			Namespace:  mP.Namespace,
+			Visibility: mP.Visibility,
+		})
+	}
+
+	return p, cur.Err()
+}
+
+// deploymentFilter builds a MongoDB query for deployments finished within the
+// date range for the project or group. Zero values are not filtered on.
+func deploymentFilter(start, end time.Time, projectName, groupName string) bson.M {
+
+	filter := bson.M{}
+
+	finishedAt := bson.M{}
+	if !start.IsZero() {
+		finishedAt["$gte"] = start
+	}
+	if !end.IsZero() {
+		finishedAt["$lte"] = end
+	}
+	if len(finishedAt) > 0 {
+		filter["finished_at"] = finishedAt
+	}
+
+	if projectName != "" {
+		filter["project_name"] = projectName
+	}
+
+	// a group includes the projects in all of its subgroups
+	if groupName != "" {
+		filter["project_namespace"] = namespaceRegex(groupName)
+	}
+
+	return filter
+}
+
+// namespaceRegex matches a namespace and all of its subgroups
+func namespaceRegex(ns string) primitive.Regex {
+	return primitive.Regex{Pattern: "^" + regexp.QuoteMeta(ns) + "(/|$)"}
+}
+
+// commits converts stored commits into the metrics representation
+func commits(c []Commit) []*metrics.Commit {
+
+	mc := []*metrics.Commit{}
+	for _, commit := range c {
+		if commit.CommittedAt == nil {
+			continue
+		}
+		mc = append(mc, &metrics.Commit{
+			SHA:         commit.SHA,
+			CommittedAt: *commit.CommittedAt,
+		})
+	}
+
+	return mc
+}
@@ -0,0 +1,76 @@
+package mongo
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/sk000f/metrix/pkg/collector"
+)
+
+// SyncCursor represents how far the deployments of a project have been collected
+type SyncCursor struct {
+	ProjectID      int       `bson:"project_id"`
+	UpdatedAt      time.Time `bson:"updated_at"`
+	UpdatedIDs     []int     `bson:"updated_ids"`
+	LastSuccessID  int       `bson:"last_success_id"`
+	LastSuccessSHA string    `bson:"last_success_sha"`
+}
+
+// GetSyncCursor returns the sync cursor of the project, or nil if it has not been collected
+func (m *DB) GetSyncCursor(projectID int) (*collector.SyncCursor, error) {
+
+	c, err := m.GetMongoClient()
+	if err != nil {
+		return nil, err
+	}
+
+	collection := c.Database("metrix").Collection("sync_cursors")
+
+	var mC SyncCursor
+	err = collection.FindOne(context.TODO(), bson.M{"project_id": projectID}).Decode(&mC)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &collector.SyncCursor{
+		ProjectID:      mC.ProjectID,
+		UpdatedAt:      mC.UpdatedAt,
+		UpdatedIDs:     mC.UpdatedIDs,
+		LastSuccessID:  mC.LastSuccessID,
+		LastSuccessSHA: mC.LastSuccessSHA,
+	}, nil
+}
+
+// SaveSyncCursor adds or updates the sync cursor of a project
+func (m *DB) SaveSyncCursor(sc *collector.SyncCursor) error {
+
+	c, err := m.GetMongoClient()
+	if err != nil {
+		return err
+	}
+
+	collection := c.Database("metrix").Collection("sync_cursors")
+
+	filter := bson.M{"project_id": sc.ProjectID}
+	updateOpts := options.Update().SetUpsert(true)
+
+	update := bson.M{
+		"$set": bson.M{
+			"project_id":       sc.ProjectID,
+			"updated_at":       sc.UpdatedAt,
+			"updated_ids":      sc.UpdatedIDs,
+			"last_success_id":  sc.LastSuccessID,
+			"last_success_sha": sc.LastSuccessSHA,
+		},
+	}
+
+	_, err = collection.UpdateOne(context.TODO(), filter, update, updateOpts)
+	return err
+}
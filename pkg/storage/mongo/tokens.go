@@ -0,0 +1,135 @@
+package mongo
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/sk000f/metrix/pkg/tokens"
+)
+
+// Token represents a stored API token, identified by the hash of its secret
+type Token struct {
+	TokenID   string     `bson:"token_id"`
+	Name      string     `bson:"name"`
+	Hash      string     `bson:"hash"`
+	Scopes    []string   `bson:"scopes"`
+	CreatedAt time.Time  `bson:"created_at"`
+	ExpiresAt *time.Time `bson:"expires_at"`
+}
+
+// SaveToken stores a new API token
+func (m *DB) SaveToken(t *tokens.Token) error {
+
+	c, err := m.GetMongoClient()
+	if err != nil {
+		return err
+	}
+
+	collection := c.Database("metrix").Collection("tokens")
+
+	mT := Token{
+		TokenID:   t.ID,
+		Name:      t.Name,
+		Hash:      t.Hash,
+		CreatedAt: t.CreatedAt,
+		ExpiresAt: t.ExpiresAt,
+	}
+	for _, s := range t.Scopes {
+		mT.Scopes = append(mT.Scopes, string(s))
+	}
+
+	_, err = collection.InsertOne(context.TODO(), mT)
+	return err
+}
+
+// GetTokenByHash returns the stored token with the hash, or nil if there is none
+func (m *DB) GetTokenByHash(hash string) (*tokens.Token, error) {
+
+	c, err := m.GetMongoClient()
+	if err != nil {
+		return nil, err
+	}
+
+	collection := c.Database("metrix").Collection("tokens")
+
+	var mT Token
+	err = collection.FindOne(context.TODO(), bson.M{"hash": hash}).Decode(&mT)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return token(mT), nil
+}
+
+// ListTokens returns all stored tokens, oldest first
+func (m *DB) ListTokens() ([]*tokens.Token, error) {
+
+	c, err := m.GetMongoClient()
+	if err != nil {
+		return nil, err
+	}
+
+	collection := c.Database("metrix").Collection("tokens")
+
+	findOpts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+
+	cur, err := collection.Find(context.TODO(), bson.M{}, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(context.TODO())
+
+	t := []*tokens.Token{}
+
+	for cur.Next(context.TODO()) {
+		var mT Token
+		if err := cur.Decode(&mT); err != nil {
+			return nil, err
+		}
+		t = append(t, token(mT))
+	}
+
+	return t, cur.Err()
+}
+
+// DeleteToken removes the token with the ID, reporting whether it existed
+func (m *DB) DeleteToken(id string) (bool, error) {
+
+	c, err := m.GetMongoClient()
+	if err != nil {
+		return false, err
+	}
+
+	collection := c.Database("metrix").Collection("tokens")
+
+	res, err := collection.DeleteOne(context.TODO(), bson.M{"token_id": id})
+	if err != nil {
+		return false, err
+	}
+
+	return res.DeletedCount > 0, nil
+}
+
+func token(mT Token) *tokens.Token {
+
+	t := &tokens.Token{
+		ID:        mT.TokenID,
+		Name:      mT.Name,
+		Hash:      mT.Hash,
+		Scopes:    []tokens.Scope{},
+		CreatedAt: mT.CreatedAt,
+		ExpiresAt: mT.ExpiresAt,
+	}
+	for _, s := range mT.Scopes {
+		t.Scopes = append(t.Scopes, tokens.Scope(s))
+	}
+
+	return t
+}
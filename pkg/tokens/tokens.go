@@ -0,0 +1,186 @@
+package tokens
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// prefix starts every token so leaked tokens are easy to recognise
+const prefix = "mtx_"
+
+// Service provides functionality for issuing and checking API tokens
+type Service struct {
+	r Repository
+}
+
+// Repository provides access to stored tokens. Only the hash of a token is
+// stored, never the token itself.
+type Repository interface {
+	SaveToken(t *Token) error
+	GetTokenByHash(hash string) (*Token, error)
+	ListTokens() ([]*Token, error)
+	DeleteToken(id string) (bool, error)
+}
+
+// Scope is a permission granted to a token
+type Scope string
+
+const (
+	// ReadMetrics allows reading metrics, deployments and exports
+	ReadMetrics Scope = "read"
+	// TriggerRefresh allows starting collection runs
+	TriggerRefresh Scope = "refresh"
+	// Admin allows managing tokens, and everything else
+	Admin Scope = "admin"
+)
+
+// Scopes are all the scopes a token may be granted
+var Scopes = []Scope{ReadMetrics, TriggerRefresh, Admin}
+
+// Token represents a stored API token
+type Token struct {
+	ID        string
+	Name      string
+	Hash      string
+	Scopes    []Scope
+	CreatedAt time.Time
+	ExpiresAt *time.Time
+}
+
+var (
+	// ErrInvalidToken is returned for tokens which were never issued or have been revoked
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrExpiredToken is returned for tokens past their expiry
+	ErrExpiredToken = errors.New("token has expired")
+)
+
+type tokenKey struct{}
+
+// NewService creates a token service with required dependencies
+func NewService(r Repository) *Service {
+	return &Service{r}
+}
+
+// ParseScope converts a configuration value into a Scope
+func ParseScope(s string) (Scope, error) {
+	for _, sc := range Scopes {
+		if string(sc) == s {
+			return sc, nil
+		}
+	}
+	return "", fmt.Errorf("unknown scope %q", s)
+}
+
+// Create issues a token with the scopes, which never expires if expiresAt is
+// nil. The returned secret is the only copy of the token.
+func (s *Service) Create(name string, scopes []Scope, expiresAt *time.Time) (string, *Token, error) {
+
+	if name == "" {
+		return "", nil, errors.New("token name is required")
+	}
+	if len(scopes) == 0 {
+		return "", nil, errors.New("token needs at least one scope")
+	}
+	for _, sc := range scopes {
+		if _, err := ParseScope(string(sc)); err != nil {
+			return "", nil, err
+		}
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return "", nil, errors.New("token expiry must be in the future")
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	secret := prefix + hex.EncodeToString(b)
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", nil, err
+	}
+
+	t := &Token{
+		ID:        hex.EncodeToString(id),
+		Name:      name,
+		Hash:      Hash(secret),
+		Scopes:    scopes,
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: expiresAt,
+	}
+
+	if err := s.r.SaveToken(t); err != nil {
+		return "", nil, err
+	}
+
+	return secret, t, nil
+}
+
+// Authenticate returns the stored token for a secret, unless it is unknown or
+// has expired
+func (s *Service) Authenticate(secret string) (*Token, error) {
+
+	if !strings.HasPrefix(secret, prefix) {
+		return nil, ErrInvalidToken
+	}
+
+	t, err := s.r.GetTokenByHash(Hash(secret))
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return nil, ErrInvalidToken
+	}
+
+	if t.ExpiresAt != nil && !t.ExpiresAt.After(time.Now()) {
+		return nil, ErrExpiredToken
+	}
+
+	return t, nil
+}
+
+// Tokens lists the stored tokens
+func (s *Service) Tokens() ([]*Token, error) {
+	return s.r.ListTokens()
+}
+
+// Revoke deletes a token so it can no longer be used, reporting whether it existed
+func (s *Service) Revoke(id string) (bool, error) {
+	return s.r.DeleteToken(id)
+}
+
+// Allows reports whether the token has been granted the scope. Admin tokens
+// are granted every scope.
+func (t *Token) Allows(scope Scope) bool {
+	for _, sc := range t.Scopes {
+		if sc == scope || sc == Admin {
+			return true
+		}
+	}
+	return false
+}
+
+// Hash returns the SHA-256 hash of a token, which is what is stored. Tokens
+// are random so a fast hash is enough to stop a database leak exposing them.
+func Hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewContext returns a context carrying the authenticated token
+func NewContext(ctx context.Context, t *Token) context.Context {
+	return context.WithValue(ctx, tokenKey{}, t)
+}
+
+// FromContext returns the authenticated token, or nil if there is none
+func FromContext(ctx context.Context) *Token {
+	t, _ := ctx.Value(tokenKey{}).(*Token)
+	return t
+}
@@ -0,0 +1,150 @@
+package tokens_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sk000f/metrix/pkg/tokens"
+)
+
+func TestTokens(t *testing.T) {
+
+	t.Run("store only the hash of created tokens", func(t *testing.T) {
+
+		r := newMockRepo()
+		s := tokens.NewService(r)
+
+		secret, tok, err := s.Create("ci", []tokens.Scope{tokens.ReadMetrics}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.HasPrefix(secret, "mtx_") {
+			t.Errorf("got token %v; wanted the mtx_ prefix", secret)
+		}
+
+		stored := r.tokens[tok.ID]
+		if stored == nil || stored.Hash != tokens.Hash(secret) || strings.Contains(stored.Hash, secret) {
+			t.Errorf("got stored token %+v; wanted the hash of the token", stored)
+		}
+	})
+
+	t.Run("authenticate tokens until they expire or are revoked", func(t *testing.T) {
+
+		r := newMockRepo()
+		s := tokens.NewService(r)
+
+		expiresAt := time.Now().Add(time.Hour)
+		secret, tok, err := s.Create("ci", []tokens.Scope{tokens.ReadMetrics}, &expiresAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := s.Authenticate(secret)
+		if err != nil || got.ID != tok.ID {
+			t.Fatalf("got %+v, %v; wanted token %v", got, err, tok.ID)
+		}
+
+		expired := time.Now().Add(-time.Minute)
+		r.tokens[tok.ID].ExpiresAt = &expired
+
+		if _, err := s.Authenticate(secret); err != tokens.ErrExpiredToken {
+			t.Errorf("got %v; wanted %v", err, tokens.ErrExpiredToken)
+		}
+
+		if ok, err := s.Revoke(tok.ID); !ok || err != nil {
+			t.Fatalf("got %v, %v; wanted the token revoked", ok, err)
+		}
+
+		if _, err := s.Authenticate(secret); err != tokens.ErrInvalidToken {
+			t.Errorf("got %v; wanted %v", err, tokens.ErrInvalidToken)
+		}
+	})
+
+	t.Run("reject unknown tokens", func(t *testing.T) {
+
+		s := tokens.NewService(newMockRepo())
+
+		for _, secret := range []string{"", "password", "mtx_0000"} {
+			if _, err := s.Authenticate(secret); err != tokens.ErrInvalidToken {
+				t.Errorf("got %v for %q; wanted %v", err, secret, tokens.ErrInvalidToken)
+			}
+		}
+	})
+
+	t.Run("validate new tokens", func(t *testing.T) {
+
+		s := tokens.NewService(newMockRepo())
+		past := time.Now().Add(-time.Hour)
+
+		tests := []struct {
+			name      string
+			scopes    []tokens.Scope
+			expiresAt *time.Time
+		}{
+			{"", []tokens.Scope{tokens.ReadMetrics}, nil},
+			{"ci", nil, nil},
+			{"ci", []tokens.Scope{"write"}, nil},
+			{"ci", []tokens.Scope{tokens.ReadMetrics}, &past},
+		}
+
+		for _, tc := range tests {
+			if _, _, err := s.Create(tc.name, tc.scopes, tc.expiresAt); err == nil {
+				t.Errorf("got no error for %+v; wanted an error", tc)
+			}
+		}
+	})
+
+	t.Run("grant admin tokens every scope", func(t *testing.T) {
+
+		read := &tokens.Token{Scopes: []tokens.Scope{tokens.ReadMetrics}}
+		admin := &tokens.Token{Scopes: []tokens.Scope{tokens.Admin}}
+
+		if !read.Allows(tokens.ReadMetrics) || read.Allows(tokens.TriggerRefresh) || read.Allows(tokens.Admin) {
+			t.Errorf("got wrong scopes for read token")
+		}
+
+		for _, sc := range tokens.Scopes {
+			if !admin.Allows(sc) {
+				t.Errorf("got admin token without %v", sc)
+			}
+		}
+	})
+}
+
+type mockRepo struct {
+	tokens map[string]*tokens.Token
+}
+
+func newMockRepo() *mockRepo {
+	return &mockRepo{tokens: map[string]*tokens.Token{}}
+}
+
+func (m *mockRepo) SaveToken(t *tokens.Token) error {
+	m.tokens[t.ID] = t
+	return nil
+}
+
+func (m *mockRepo) GetTokenByHash(hash string) (*tokens.Token, error) {
+	for _, t := range m.tokens {
+		if t.Hash == hash {
+			return t, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *mockRepo) ListTokens() ([]*tokens.Token, error) {
+	t := []*tokens.Token{}
+	for _, tok := range m.tokens {
+		t = append(t, tok)
+	}
+	return t, nil
+}
+
+func (m *mockRepo) DeleteToken(id string) (bool, error) {
+	_, ok := m.tokens[id]
+	delete(m.tokens, id)
+	return ok, nil
+}